@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"erp/db"
 	"erp/models"
@@ -36,18 +37,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	now := time.Now()
 	user := models.User{
-		Username: "admin",
-		Email:    "admin@jasontech.com",
-		Password: string(hashedPassword),
-		Level:    "super_admin", // 明確設置為超級管理員等級
+		Username:        "admin",
+		Email:           "admin@jasontech.com",
+		Password:        string(hashedPassword),
+		Level:           "super_admin", // 明確設置為超級管理員等級
+		EmailVerifiedAt: &now,
 	}
 
 	// 使用 GORM 的 FirstOrCreate 來避免重複建立
 	result := database.Where(models.User{Username: user.Username}).Assign(models.User{
-		Email:    user.Email,
-		Password: user.Password,
-		Level:    user.Level, // 確保等級始終被更新
+		Email:           user.Email,
+		Password:        user.Password,
+		Level:           user.Level, // 確保等級始終被更新
+		EmailVerifiedAt: user.EmailVerifiedAt,
 	}).FirstOrCreate(&user)
 	if result.Error != nil {
 		fmt.Fprintf(os.Stderr, "建立測試使用者失敗: %v\n", err)
@@ -80,10 +84,11 @@ func main() {
 	}
 
 	sampleUser := models.User{
-		Username: "sampleuser",
-		Email:    "sample@jasontech.com",
-		Password: string(sampleHashedPassword),
-		Level:    "user", // 一般使用者等級
+		Username:        "sampleuser",
+		Email:           "sample@jasontech.com",
+		Password:        string(sampleHashedPassword),
+		Level:           "user", // 一般使用者等級
+		EmailVerifiedAt: &now,
 	}
 
 	// 使用 GORM 的 FirstOrCreate 來避免重複建立
@@ -126,6 +131,8 @@ func main() {
 	}
 
 	// 建立範例權限
+	// 路由透過 middleware.RequirePermission 宣告的權限會在伺服器啟動時自動註冊，
+	// 這裡的清單提供預設的顯示名稱與描述，並涵蓋尚未有對應路由的模組權限
 	permissions := []models.Permission{
 		// 人力資源模組
 		{ModuleName: "hr", Resource: "employees", Action: "view", Code: "hr.employees.view", DisplayName: "查看員工資訊", Description: "查看員工基本資訊和聯絡方式"},
@@ -162,6 +169,7 @@ func main() {
 		{ModuleName: "system", Resource: "roles", Action: "manage", Code: "system.roles.manage", DisplayName: "管理角色和權限", Description: "管理角色和權限設定"},
 		{ModuleName: "system", Resource: "logs", Action: "view", Code: "system.logs.view", DisplayName: "查看系統日誌", Description: "查看系統操作日誌"},
 		{ModuleName: "system", Resource: "settings", Action: "manage", Code: "system.settings.manage", DisplayName: "管理系統設定", Description: "管理系統配置設定"},
+		{ModuleName: "system", Resource: "api_keys", Action: "manage", Code: "system.api_keys.manage", DisplayName: "管理服務帳號與 API 金鑰", Description: "建立服務帳號並簽發、輪替或撤銷 API 金鑰"},
 	}
 
 	for _, perm := range permissions {
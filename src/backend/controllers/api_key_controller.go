@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"erp/middleware"
+	"erp/models"
+	"erp/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateServiceAccount 建立服務帳號 (只能以 API 金鑰存取，不可登入)
+func CreateServiceAccount(c *gin.Context) {
+	var input models.CreateServiceAccountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := GetAPIKeyService().CreateServiceAccount(input.Username, input.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法建立服務帳號"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, user.ToResponse())
+}
+
+// GetServiceAccounts 取得所有服務帳號
+func GetServiceAccounts(c *gin.Context) {
+	users, err := GetUserRepo().GetServiceAccounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取服務帳號列表"})
+		return
+	}
+
+	userResponses := []models.UserResponse{}
+	for _, user := range users {
+		userResponses = append(userResponses, user.ToResponse())
+	}
+
+	c.JSON(http.StatusOK, userResponses)
+}
+
+// CreateAPIKey 為服務帳號建立 API 金鑰，明文金鑰只在此時回傳一次
+func CreateAPIKey(c *gin.Context) {
+	serviceAccount, ok := serviceAccountParam(c)
+	if !ok {
+		return
+	}
+
+	var input models.CreateAPIKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	creatorID, _ := middleware.CurrentUserID(c)
+	creatorKey, _ := middleware.CurrentAPIKey(c)
+	key, rawKey, err := GetAPIKeyService().Create(serviceAccount, creatorID, c.ClientIP(), creatorKey, input.Name, input.Permissions, input.ExpiresAt)
+	if respondAPIKeyError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": rawKey,
+		"key":     key.ToResponse(),
+	})
+}
+
+// GetAPIKeys 取得服務帳號的所有 API 金鑰 (不包含金鑰本身)
+func GetAPIKeys(c *gin.Context) {
+	serviceAccount, ok := serviceAccountParam(c)
+	if !ok {
+		return
+	}
+
+	keys, err := GetAPIKeyRepo().GetByUserID(serviceAccount.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取 API 金鑰列表"})
+		return
+	}
+
+	keyResponses := []models.APIKeyResponse{}
+	for _, key := range keys {
+		keyResponses = append(keyResponses, key.ToResponse())
+	}
+
+	c.JSON(http.StatusOK, keyResponses)
+}
+
+// RotateAPIKey 輪替 API 金鑰：建立相同權限的新金鑰，舊金鑰在寬限期後失效
+func RotateAPIKey(c *gin.Context) {
+	serviceAccount, ok := serviceAccountParam(c)
+	if !ok {
+		return
+	}
+	key, ok := apiKeyParam(c, serviceAccount)
+	if !ok {
+		return
+	}
+
+	var input models.RotateAPIKeyInput
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	creatorID, _ := middleware.CurrentUserID(c)
+	creatorKey, _ := middleware.CurrentAPIKey(c)
+	gracePeriod := time.Duration(input.GracePeriodSeconds) * time.Second
+	rotated, rawKey, err := GetAPIKeyService().Rotate(key, creatorID, c.ClientIP(), creatorKey, gracePeriod)
+	if respondAPIKeyError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_key": rawKey,
+		"key":     rotated.ToResponse(),
+	})
+}
+
+// RevokeAPIKey 立即撤銷 API 金鑰
+func RevokeAPIKey(c *gin.Context) {
+	serviceAccount, ok := serviceAccountParam(c)
+	if !ok {
+		return
+	}
+	key, ok := apiKeyParam(c, serviceAccount)
+	if !ok {
+		return
+	}
+
+	if err := GetAPIKeyService().Revoke(key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法撤銷 API 金鑰"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API 金鑰已撤銷"})
+}
+
+// serviceAccountParam 取得路徑參數 id 對應的服務帳號，失敗時直接寫入錯誤響應
+func serviceAccountParam(c *gin.Context) (*models.User, bool) {
+	var userID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的使用者 ID"})
+		return nil, false
+	}
+
+	user, err := GetUserRepo().GetByID(userID)
+	if err != nil || !user.ServiceAccount {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到服務帳號"})
+		return nil, false
+	}
+	return user, true
+}
+
+// apiKeyParam 取得路徑參數 keyId 對應且屬於該服務帳號的 API 金鑰
+func apiKeyParam(c *gin.Context, serviceAccount *models.User) (*models.APIKey, bool) {
+	var keyID uint
+	if _, err := fmt.Sscanf(c.Param("keyId"), "%d", &keyID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的 API 金鑰 ID"})
+		return nil, false
+	}
+
+	key, err := GetAPIKeyRepo().GetByID(keyID)
+	if err != nil || key.UserID != serviceAccount.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到 API 金鑰"})
+		return nil, false
+	}
+	return key, true
+}
+
+// respondAPIKeyError 回應建立或輪替 API 金鑰的錯誤，沒有錯誤時回傳 false
+func respondAPIKeyError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrUnknownPermission), errors.Is(err, services.ErrInvalidAPIKeyExpiry), errors.Is(err, services.ErrNotServiceAccount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPermissionNotGrantable):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidAPIKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "API 金鑰已撤銷或已過期"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法產生 API 金鑰"})
+	}
+	return true
+}
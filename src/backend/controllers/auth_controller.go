@@ -2,14 +2,16 @@ package controllers
 
 import (
 	"erp/models"
+	"erp/services"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 	"net/http"
 )
 
@@ -20,60 +22,372 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// 使用 Repository 查詢使用者
+	clientIP := c.ClientIP()
+	attempt := models.LoginEvent{Step: models.LoginStepPassword, Username: credentials.Username}
+
+	// 同一來源 IP 失敗過多時暫時封鎖
+	if err := GetLoginGuardService().CheckIP(clientIP); err != nil {
+		recordLoginBlocked(c, nil, attempt, err)
+		respondLoginBlocked(c, err)
+		return
+	}
+
+	// 使用 Repository 查詢使用者；不存在時仍交由驗證後端處理 (例如 LDAP 自動建立帳號)
 	user, err := GetUserRepo().GetByUsername(credentials.Username)
-	if err != nil {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user = nil
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load user"})
+		return
+	}
+
+	// 服務帳號只能使用 API 金鑰，不可以密碼登入
+	if user != nil && user.ServiceAccount {
+		recordLoginEvent(c, user, attempt, models.LoginReasonServiceAccount)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
 
-	// 驗證密碼
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(credentials.Password))
-	if err != nil {
+	// 帳號鎖定或仍在漸進延遲中
+	if user != nil {
+		if err := GetLoginGuardService().CheckUser(user); err != nil {
+			recordLoginBlocked(c, user, attempt, err)
+			respondLoginBlocked(c, err)
+			return
+		}
+	}
+
+	// 依使用者設定的順序嘗試驗證後端 (本地密碼、LDAP)
+	authenticated, _, err := GetAuthenticationService().Authenticate(user, credentials.Username, credentials.Password)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		recordLoginEvent(c, user, attempt, models.LoginReasonInvalidCredentials)
+		if err := GetLoginGuardService().RecordFailure(user, clientIP); err != nil {
+			respondLoginBlocked(c, err)
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
+	if errors.Is(err, services.ErrLDAPUserNotProvisioned) {
+		recordLoginEvent(c, user, attempt, models.LoginReasonNotProvisioned)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		recordLoginEvent(c, user, attempt, models.LoginReasonBackendUnavailable)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authentication service unavailable"})
+		return
+	}
+	user = authenticated
 
-	// 更新最後登入時間
+	beginLogin(c, user, services.AuthMethodPassword)
+}
+
+// beginLogin 第一步驗證 (密碼或單一登入) 通過後繼續登入流程
+// 已啟用兩步驟驗證 (或等級強制通行金鑰且已綁定) 時，先回傳暫時令牌，待第二步驟通過後才簽發正式令牌
+func beginLogin(c *gin.Context, user *models.User, authMethod string) {
+	attempt := models.LoginEvent{Step: authMethod}
+	if !checkAccountStatus(c, user, attempt) || !checkEmailVerified(c, user, attempt) || !checkNetworkAllowed(c, user, attempt) {
+		return
+	}
+
+	methods, err := mfaMethods(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load passkeys"})
+		return
+	}
+	if len(methods) > 0 {
+		mfaToken, ttl, err := GetTokenService().GenerateMFAChallenge(user, services.TokenOptions{AuthMethod: authMethod})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+		recordLoginEvent(c, user, models.LoginEvent{Step: authMethod}, models.LoginReasonMFARequired)
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "MFA required",
+			"mfa_required": true,
+			"mfa_methods":  methods,
+			"mfa_token":    mfaToken,
+			"expires_in":   int64(ttl.Seconds()),
+		})
+		return
+	}
+
+	completeLogin(c, user, services.TokenOptions{AuthMethod: authMethod})
+}
+
+// checkAccountStatus 停權、鎖定或封存的帳號不可登入，失敗時直接寫入錯誤響應
+func checkAccountStatus(c *gin.Context, user *models.User, attempt models.LoginEvent) bool {
+	status := user.EffectiveStatus(time.Now())
+	if status == models.UserStatusActive {
+		return true
+	}
+	attempt.Detail = status
+	recordLoginEvent(c, user, attempt, models.LoginReasonAccountInactive)
+	c.JSON(http.StatusForbidden, gin.H{"error": "Account is not active", "account_status": status})
+	return false
+}
+
+// checkEmailVerified 尚未接受邀請 (電子郵件未驗證) 的帳號不可登入，失敗時直接寫入錯誤響應
+func checkEmailVerified(c *gin.Context, user *models.User, attempt models.LoginEvent) bool {
+	if user.IsEmailVerified() {
+		return true
+	}
+	recordLoginEvent(c, user, attempt, models.LoginReasonEmailUnverified)
+	c.JSON(http.StatusForbidden, gin.H{"error": "Email not verified", "email_verification_required": true})
+	return false
+}
+
+// checkNetworkAllowed 使用者設有來源網路限制時，只能從允許的網段登入，失敗時直接寫入錯誤響應
+func checkNetworkAllowed(c *gin.Context, user *models.User, attempt models.LoginEvent) bool {
+	if user.AllowedNetworks.Allows(c.ClientIP()) {
+		return true
+	}
+	recordLoginEvent(c, user, attempt, models.LoginReasonNetworkNotAllowed)
+	c.JSON(http.StatusForbidden, gin.H{"error": "Login not allowed from this network", "network_not_allowed": true})
+	return false
+}
+
+// mfaMethods 回傳使用者登入第二步驟可用的方式 (totp、passkey)，空值表示不需要第二步驟
+// 等級強制通行金鑰且已綁定時只接受通行金鑰；尚未綁定時先以 TOTP (若有) 驗證，登入後需綁定通行金鑰
+func mfaMethods(user *models.User) ([]string, error) {
+	hasPasskeys, err := GetWebAuthnService().HasPasskeys(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if hasPasskeys && GetWebAuthnService().RequiredForLevel(user.Level) {
+		return []string{"passkey"}, nil
+	}
+
+	var methods []string
+	if user.MFAEnabled {
+		methods = append(methods, "totp")
+		// 已啟用兩步驟驗證的使用者也可以改用已綁定的通行金鑰
+		if hasPasskeys {
+			methods = append(methods, "passkey")
+		}
+	}
+	return methods, nil
+}
+
+// completeLogin 更新最後登入時間、簽發令牌組並回傳登入成功響應
+func completeLogin(c *gin.Context, user *models.User, opts services.TokenOptions) {
+	attempt := models.LoginEvent{Step: opts.AuthMethod}
+	if opts.MFAVerified && opts.AuthMethod != services.AuthMethodPasskey {
+		attempt.Step = models.LoginStepMFA
+	}
+
+	// 清除連續登入失敗紀錄；檢查後帳號又被鎖定或有其他登入失敗時拒絕本次登入
+	if err := GetLoginGuardService().RecordSuccess(user); err != nil {
+		recordLoginBlocked(c, user, attempt, err)
+		respondLoginBlocked(c, err)
+		return
+	}
+
+	// 以密碼登入且密碼已超過有效期限時不簽發令牌，改發一次性重設令牌要求先變更密碼
 	now := time.Now()
+	if opts.AuthMethod == services.AuthMethodPassword && GetPasswordPolicyService().IsExpired(user, now) {
+		resetToken, err := GetPasswordResetService().IssueResetToken(user, c.ClientIP())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+		recordLoginEvent(c, user, attempt, models.LoginReasonPasswordExpired)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":                    "Password expired",
+			"password_change_required": true,
+			"reset_token":              resetToken,
+			"expires_in":               int64(GetPasswordResetService().TTL().Seconds()),
+		})
+		return
+	}
+
+	// 更新最後登入時間
 	user.LastLoginAt = &now
-	err = GetUserRepo().Update(user)
+	err := GetUserRepo().Update(user, "last_login_at")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update last login time"})
 		return
 	}
 
-	// 生成 JWT 令牌
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  user.ID,
-		"username": user.Username,
-		"level":    user.Level, // 添加等級信息到 token
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
-	})
-
-	// 使用密鑰簽署令牌
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	// 簽發短效 access token 與 refresh token
+	tokens, err := GetTokenService().IssueTokenPair(user, c.ClientIP(), c.Request.UserAgent(), opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
 		return
 	}
 
+	// 等級強制兩步驟驗證 (或通行金鑰) 但尚未設定時，令牌只能用於設定兩步驟驗證或綁定通行金鑰
+	passkeySetupRequired := !opts.MFAVerified && GetWebAuthnService().RequiredForLevel(user.Level)
+	mfaSetupRequired := passkeySetupRequired || (!opts.MFAVerified && GetMFAService().RequiredForLevel(user.Level))
+
+	recordLoginEvent(c, user, attempt, models.LoginReasonSuccess)
+
 	// 返回成功響應和令牌
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Login successful",
-		"token":   tokenString,
-		"user": models.UserResponse{
-			ID:          user.ID,
-			Username:    user.Username,
-			Email:       user.Email,
-			Level:       user.Level,
-			LastLoginAt: user.LastLoginAt,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.UpdatedAt,
-		},
+		"message":                "Login successful",
+		"token":                  tokens.AccessToken,
+		"refresh_token":          tokens.RefreshToken,
+		"expires_in":             tokens.ExpiresIn,
+		"refresh_expires_at":     tokens.RefreshExpiresAt,
+		"mfa_setup_required":     mfaSetupRequired,
+		"passkey_setup_required": passkeySetupRequired,
+		"user":                   user.ToResponse(),
+	})
+}
+
+// respondLoginBlocked 回應登入被鎖定或限流的錯誤
+func respondLoginBlocked(c *gin.Context, err error) {
+	var blocked *services.LoginBlockedError
+	if !errors.As(err, &blocked) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not record login attempt"})
+		return
+	}
+
+	c.Header("Retry-After", strconv.Itoa(blocked.RetryAfter(time.Now())))
+	if blocked.Locked {
+		c.JSON(http.StatusLocked, gin.H{"error": blocked.Error(), "locked_until": blocked.Until})
+		return
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": blocked.Error()})
+}
+
+// recordLoginEvent 記錄登入事件，補上使用者、來源 IP 與 User-Agent
+// 記錄失敗不影響登入流程，只輸出錯誤訊息
+func recordLoginEvent(c *gin.Context, user *models.User, event models.LoginEvent, reason string) {
+	event.Reason = reason
+	event.Success = reason == models.LoginReasonSuccess || reason == models.LoginReasonMFARequired
+	if user != nil {
+		event.UserID = &user.ID
+		if event.Username == "" {
+			event.Username = user.Username
+		}
+	}
+	event.IPAddress = c.ClientIP()
+	event.UserAgent = c.Request.UserAgent()
+
+	if err := GetLoginEventService().Record(&event); err != nil {
+		fmt.Fprintf(os.Stderr, "無法記錄登入事件: %v\n", err)
+	}
+}
+
+// recordLoginBlocked 記錄因帳號鎖定或來源限流而拒絕的登入
+func recordLoginBlocked(c *gin.Context, user *models.User, event models.LoginEvent, err error) {
+	var blocked *services.LoginBlockedError
+	if !errors.As(err, &blocked) {
+		return
+	}
+	reason := models.LoginReasonRateLimited
+	if blocked.Locked {
+		reason = models.LoginReasonLocked
+	}
+	recordLoginEvent(c, user, event, reason)
+}
+
+// RefreshToken 以 refresh token 換發新的令牌組
+func RefreshToken(c *gin.Context) {
+	var input models.RefreshTokenInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	user, tokens, err := GetTokenService().Refresh(input.RefreshToken, c.ClientIP(), c.Request.UserAgent())
+	if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":            "Token refreshed",
+		"token":              tokens.AccessToken,
+		"refresh_token":      tokens.RefreshToken,
+		"expires_in":         tokens.ExpiresIn,
+		"refresh_expires_at": tokens.RefreshExpiresAt,
+		"user":               user.ToResponse(),
 	})
 }
 
+// Logout 撤銷 refresh token 所屬的令牌家族
+func Logout(c *gin.Context) {
+	var input models.RefreshTokenInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	err := GetTokenService().Revoke(input.RefreshToken)
+	if err != nil && !errors.Is(err, services.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke token"})
+		return
+	}
+
+	// 若同時提供了仍有效的 access token，一併加入撤銷清單
+	authHeader := c.GetHeader("Authorization")
+	if tokenString := strings.TrimPrefix(authHeader, "Bearer "); tokenString != authHeader {
+		if claims, err := GetTokenService().ParseAccessToken(tokenString); err == nil {
+			if err := GetTokenService().RevokeAccessToken(claims, "logout"); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revoke token"})
+				return
+			}
+		}
+	}
+
+	// 令牌不存在時同樣回傳成功，避免洩漏令牌是否有效
+	c.JSON(http.StatusOK, gin.H{"message": "Logout successful"})
+}
+
+// ForgotPassword 申請重設密碼，寄出含一次性令牌的重設連結
+func ForgotPassword(c *gin.Context) {
+	var input models.ForgotPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	// 寄送失敗只記錄在伺服器端，回應一律相同以避免洩漏帳號是否存在
+	if err := GetPasswordResetService().RequestReset(input.Email, c.ClientIP()); err != nil {
+		fmt.Fprintf(os.Stderr, "密碼重設申請處理失敗: %v\n", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the email exists, a password reset link has been sent"})
+}
+
+// ResetPassword 以一次性令牌設定新密碼
+func ResetPassword(c *gin.Context) {
+	var input models.ResetPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := GetPasswordResetService().ResetPassword(input.Token, input.Password)
+	if errors.Is(err, services.ErrInvalidResetToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var policyErr *services.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密碼不符合安全政策", "violations": policyErr.Violations})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+// GetPasswordPolicy 回傳目前的密碼政策，供前端顯示密碼規則
+func GetPasswordPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, GetPasswordPolicyService().Policy())
+}
+
 // VerifyToken 驗證 JWT token 並回傳使用者資訊
 func VerifyToken(c *gin.Context) {
 	// 從中間件獲取使用者資訊（中間件已經驗證過 token）
@@ -90,17 +404,13 @@ func VerifyToken(c *gin.Context) {
 		return
 	}
 
-	// 回傳使用者資訊
-	c.JSON(http.StatusOK, gin.H{
+	// 回傳使用者資訊，模擬登入時一併回傳實際操作者供前端顯示
+	response := gin.H{
 		"message": "Token valid",
-		"user": models.UserResponse{
-			ID:          user.ID,
-			Username:    user.Username,
-			Email:       user.Email,
-			Level:       user.Level,
-			LastLoginAt: user.LastLoginAt,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.UpdatedAt,
-		},
-	})
+		"user":    user.ToResponse(),
+	}
+	if claims, ok := currentClaims(c); ok && claims.Actor != nil {
+		response["impersonator"] = claims.Actor
+	}
+	c.JSON(http.StatusOK, response)
 }
@@ -2,6 +2,9 @@ package controllers
 
 import (
 	"erp/db"
+	"erp/mail"
+	"erp/services"
+	"erp/storage"
 )
 
 // 全局資料庫實例 (依賴注入)
@@ -13,6 +16,47 @@ var roleRepo db.RoleRepository
 var permissionRepo db.PermissionRepository
 var rolePermissionRepo db.RolePermissionRepository
 var userRoleRepo db.UserRoleRepository
+var refreshTokenRepo db.RefreshTokenRepository
+var revokedTokenRepo db.RevokedTokenRepository
+var signingKeyRepo db.SigningKeyRepository
+var sessionRepo db.SessionRepository
+var impersonationAuditRepo db.ImpersonationAuditRepository
+var passwordResetTokenRepo db.PasswordResetTokenRepository
+var userInvitationRepo db.UserInvitationRepository
+var userMFARepo db.UserMFARepository
+var userProfileRepo db.UserProfileRepository
+var mfaRecoveryCodeRepo db.MFARecoveryCodeRepository
+var webAuthnCredentialRepo db.WebAuthnCredentialRepository
+var webAuthnCeremonyRepo db.WebAuthnCeremonyRepository
+var passwordHistoryRepo db.PasswordHistoryRepository
+var userIdentityRepo db.UserIdentityRepository
+var oidcLoginStateRepo db.OIDCLoginStateRepository
+var oidcLoginCodeRepo db.OIDCLoginCodeRepository
+var apiKeyRepo db.APIKeyRepository
+var loginEventRepo db.LoginEventRepository
+var departmentRepo db.DepartmentRepository
+var userDepartmentRepo db.UserDepartmentRepository
+
+// Service 實例
+var permissionService *services.PermissionService
+var keyRingService *services.KeyRingService
+var tokenService *services.TokenService
+var impersonationService *services.ImpersonationService
+var passwordResetService *services.PasswordResetService
+var invitationService *services.InvitationService
+var mfaService *services.MFAService
+var webAuthnService *services.WebAuthnService
+var loginGuardService *services.LoginGuardService
+var passwordPolicyService *services.PasswordPolicyService
+var oidcService *services.OIDCService
+var authenticationService *services.AuthenticationService
+var apiKeyService *services.APIKeyService
+var userPolicyService *services.UserPolicyService
+var userImportService *services.UserImportService
+var userStatusService *services.UserStatusService
+var userProfileService *services.UserProfileService
+var loginEventService *services.LoginEventService
+var departmentService *services.DepartmentService
 
 // SetDB 設定資料庫依賴 (依賴注入)
 func SetDB(dbInstance *db.DB) {
@@ -22,6 +66,59 @@ func SetDB(dbInstance *db.DB) {
 	permissionRepo = db.NewPermissionRepository(dbInstance)
 	rolePermissionRepo = db.NewRolePermissionRepository(dbInstance)
 	userRoleRepo = db.NewUserRoleRepository(dbInstance)
+	refreshTokenRepo = db.NewRefreshTokenRepository(dbInstance)
+	revokedTokenRepo = db.NewRevokedTokenRepository(dbInstance)
+	signingKeyRepo = db.NewSigningKeyRepository(dbInstance)
+	sessionRepo = db.NewSessionRepository(dbInstance)
+	impersonationAuditRepo = db.NewImpersonationAuditRepository(dbInstance)
+	passwordResetTokenRepo = db.NewPasswordResetTokenRepository(dbInstance)
+	userInvitationRepo = db.NewUserInvitationRepository(dbInstance)
+	userMFARepo = db.NewUserMFARepository(dbInstance)
+	userProfileRepo = db.NewUserProfileRepository(dbInstance)
+	mfaRecoveryCodeRepo = db.NewMFARecoveryCodeRepository(dbInstance)
+	webAuthnCredentialRepo = db.NewWebAuthnCredentialRepository(dbInstance)
+	webAuthnCeremonyRepo = db.NewWebAuthnCeremonyRepository(dbInstance)
+	passwordHistoryRepo = db.NewPasswordHistoryRepository(dbInstance)
+	userIdentityRepo = db.NewUserIdentityRepository(dbInstance)
+	oidcLoginStateRepo = db.NewOIDCLoginStateRepository(dbInstance)
+	oidcLoginCodeRepo = db.NewOIDCLoginCodeRepository(dbInstance)
+	apiKeyRepo = db.NewAPIKeyRepository(dbInstance)
+	loginEventRepo = db.NewLoginEventRepository(dbInstance)
+	departmentRepo = db.NewDepartmentRepository(dbInstance)
+	userDepartmentRepo = db.NewUserDepartmentRepository(dbInstance)
+	permissionService = services.NewPermissionService(userRepo, userRoleRepo, rolePermissionRepo)
+	userPolicyService = services.NewUserPolicyService(userRepo)
+	keyRingService = services.NewKeyRingService(signingKeyRepo)
+	tokenService = services.NewTokenService(userRepo, refreshTokenRepo, revokedTokenRepo, sessionRepo, keyRingService)
+	impersonationService = services.NewImpersonationService(impersonationAuditRepo, tokenService)
+	userStatusService = services.NewUserStatusService(userRepo, userPolicyService, tokenService)
+	mfaService = services.NewMFAService(userRepo, userMFARepo, mfaRecoveryCodeRepo)
+	webAuthnService = services.NewWebAuthnService(userRepo, webAuthnCredentialRepo, webAuthnCeremonyRepo)
+	loginGuardService = services.NewLoginGuardService(userRepo)
+	passwordPolicyService = services.NewPasswordPolicyService(passwordHistoryRepo)
+	oidcService = services.NewOIDCService(userRepo, userIdentityRepo, oidcLoginStateRepo, oidcLoginCodeRepo, roleRepo, permissionService)
+	apiKeyService = services.NewAPIKeyService(userRepo, apiKeyRepo, permissionRepo, permissionService)
+	loginEventService = services.NewLoginEventService(loginEventRepo)
+	departmentService = services.NewDepartmentService(departmentRepo, userDepartmentRepo, userRepo)
+
+	// 驗證後端：本地密碼一律啟用，設定 LDAP_URL 時啟用 LDAP
+	authenticators := []services.Authenticator{services.NewLocalAuthenticator()}
+	if ldapConfig, ok := services.LDAPConfigFromEnv(); ok {
+		authenticators = append(authenticators, services.NewLDAPAuthenticator(ldapConfig, userRepo, roleRepo, permissionService))
+	}
+	authenticationService = services.NewAuthenticationService(authenticators...)
+}
+
+// SetMailSender 設定郵件寄送依賴，需在 SetDB 之後呼叫
+func SetMailSender(sender mail.Sender) {
+	passwordResetService = services.NewPasswordResetService(userRepo, passwordResetTokenRepo, tokenService, passwordPolicyService, sender)
+	invitationService = services.NewInvitationService(userRepo, userInvitationRepo, roleRepo, permissionService, passwordPolicyService, sender)
+	userImportService = services.NewUserImportService(database, userRepo, roleRepo, userRoleRepo, userPolicyService, invitationService)
+}
+
+// SetFileStorage 設定檔案儲存依賴 (頭像等上傳檔案)，需在 SetDB 之後呼叫
+func SetFileStorage(store storage.Storage) {
+	userProfileService = services.NewUserProfileService(userRepo, userProfileRepo, store)
 }
 
 // GetUserRepo 獲取使用者 repository
@@ -48,3 +145,103 @@ func GetRolePermissionRepo() db.RolePermissionRepository {
 func GetUserRoleRepo() db.UserRoleRepository {
 	return userRoleRepo
 }
+
+// GetAPIKeyRepo 獲取 API 金鑰 repository
+func GetAPIKeyRepo() db.APIKeyRepository {
+	return apiKeyRepo
+}
+
+// GetPermissionService 獲取權限服務
+func GetPermissionService() *services.PermissionService {
+	return permissionService
+}
+
+// GetUserPolicyService 獲取使用者管理政策服務
+func GetUserPolicyService() *services.UserPolicyService {
+	return userPolicyService
+}
+
+// GetKeyRingService 獲取 JWT 簽章金鑰環服務
+func GetKeyRingService() *services.KeyRingService {
+	return keyRingService
+}
+
+// GetTokenService 獲取令牌服務
+func GetTokenService() *services.TokenService {
+	return tokenService
+}
+
+// GetPasswordResetService 獲取密碼重設服務
+func GetPasswordResetService() *services.PasswordResetService {
+	return passwordResetService
+}
+
+// GetInvitationService 獲取使用者邀請服務
+func GetInvitationService() *services.InvitationService {
+	return invitationService
+}
+
+// GetUserImportService 獲取使用者匯入匯出服務
+func GetUserImportService() *services.UserImportService {
+	return userImportService
+}
+
+// GetUserStatusService 獲取使用者帳號狀態服務
+func GetUserStatusService() *services.UserStatusService {
+	return userStatusService
+}
+
+// GetUserProfileService 獲取使用者個人資料服務
+func GetUserProfileService() *services.UserProfileService {
+	return userProfileService
+}
+
+// GetDepartmentService 獲取部門服務
+func GetDepartmentService() *services.DepartmentService {
+	return departmentService
+}
+
+// GetMFAService 獲取兩步驟驗證服務
+func GetMFAService() *services.MFAService {
+	return mfaService
+}
+
+// GetWebAuthnService 獲取通行金鑰服務
+func GetWebAuthnService() *services.WebAuthnService {
+	return webAuthnService
+}
+
+// GetLoginGuardService 獲取登入防護服務
+func GetLoginGuardService() *services.LoginGuardService {
+	return loginGuardService
+}
+
+// GetPasswordPolicyService 獲取密碼政策服務
+func GetPasswordPolicyService() *services.PasswordPolicyService {
+	return passwordPolicyService
+}
+
+// GetOIDCService 獲取單一登入服務
+func GetOIDCService() *services.OIDCService {
+	return oidcService
+}
+
+// GetAuthenticationService 獲取帳號密碼驗證服務
+func GetAuthenticationService() *services.AuthenticationService {
+	return authenticationService
+}
+
+// GetAPIKeyService 獲取 API 金鑰服務
+func GetAPIKeyService() *services.APIKeyService {
+	return apiKeyService
+}
+
+// GetImpersonationService 獲取模擬登入服務
+func GetImpersonationService() *services.ImpersonationService {
+	return impersonationService
+}
+
+// GetLoginEventService 獲取登入事件服務
+func GetLoginEventService() *services.LoginEventService {
+	return loginEventService
+}
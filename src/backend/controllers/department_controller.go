@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"erp/models"
+	"erp/services"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDepartments 取得所有部門 (依路徑排序的平面清單)
+func GetDepartments(c *gin.Context) {
+	departments, err := GetDepartmentService().List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取部門列表"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"departments": departments})
+}
+
+// GetDepartmentTree 取得部門樹，可用 root_id 只取某部門底下的子樹
+func GetDepartmentTree(c *gin.Context) {
+	var query struct {
+		RootID *uint `form:"root_id"`
+	}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tree, err := GetDepartmentService().Tree(query.RootID)
+	if err != nil {
+		respondDepartmentError(c, err, "無法獲取部門樹")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"departments": tree})
+}
+
+// CreateDepartment 建立部門
+func CreateDepartment(c *gin.Context) {
+	var input models.CreateDepartmentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	department, err := GetDepartmentService().Create(input)
+	if err != nil {
+		respondDepartmentError(c, err, "無法建立部門")
+		return
+	}
+	c.JSON(http.StatusCreated, department)
+}
+
+// GetDepartmentByID 根據 ID 取得部門
+func GetDepartmentByID(c *gin.Context) {
+	department, ok := departmentParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, department)
+}
+
+// UpdateDepartment 更新部門名稱、主管與成本中心代碼
+func UpdateDepartment(c *gin.Context) {
+	department, ok := departmentParam(c)
+	if !ok {
+		return
+	}
+
+	var input models.UpdateDepartmentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := GetDepartmentService().Update(department, input); err != nil {
+		respondDepartmentError(c, err, "無法更新部門")
+		return
+	}
+	c.JSON(http.StatusOK, department)
+}
+
+// MoveDepartment 將部門連同所有子部門移到另一個上層部門底下
+func MoveDepartment(c *gin.Context) {
+	department, ok := departmentParam(c)
+	if !ok {
+		return
+	}
+
+	var input models.MoveDepartmentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := GetDepartmentService().Move(department, input.ParentID); err != nil {
+		respondDepartmentError(c, err, "無法移動部門")
+		return
+	}
+	c.JSON(http.StatusOK, department)
+}
+
+// DeleteDepartment 刪除部門，部門仍有子部門或成員時無法刪除
+func DeleteDepartment(c *gin.Context) {
+	department, ok := departmentParam(c)
+	if !ok {
+		return
+	}
+
+	if err := GetDepartmentService().Delete(department); err != nil {
+		respondDepartmentError(c, err, "無法刪除部門")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "部門已刪除"})
+}
+
+// GetDepartmentUsers 分頁查詢部門的成員，sub_departments=true 時包含所有子部門的成員
+// 其餘查詢參數與使用者列表相同
+func GetDepartmentUsers(c *gin.Context) {
+	department, ok := departmentParam(c)
+	if !ok {
+		return
+	}
+
+	var query models.UserListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorizeUserListQuery(c, query) {
+		return
+	}
+	query.DepartmentID = &department.ID
+
+	users, page, err := GetUserRepo().List(query)
+	if respondListError(c, err, "無法獲取部門成員") {
+		return
+	}
+
+	userResponses, err := userResponsesWithProfiles(users)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取個人資料"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":      userResponses,
+		"pagination": page,
+	})
+}
+
+// GetMyDepartments 取得自己所屬的部門
+func GetMyDepartments(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	respondUserDepartments(c, user)
+}
+
+// GetUserDepartments 取得使用者所屬的部門
+func GetUserDepartments(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+	respondUserDepartments(c, user)
+}
+
+// SetUserDepartments 設定使用者的主要部門與兼任部門
+func SetUserDepartments(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+
+	var input models.SetUserDepartmentsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	departments, err := GetDepartmentService().SetUserDepartments(user.ID, input)
+	if err != nil {
+		respondDepartmentError(c, err, "無法設定使用者的部門")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"departments": departments})
+}
+
+// respondUserDepartments 回應使用者所屬的部門
+func respondUserDepartments(c *gin.Context, user *models.User) {
+	departments, err := GetDepartmentService().UserDepartments(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取使用者的部門"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"departments": departments})
+}
+
+// departmentParam 解析路徑參數 :id 並取得部門，失敗時直接回應錯誤
+func departmentParam(c *gin.Context) (*models.Department, bool) {
+	var departmentID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &departmentID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的部門 ID"})
+		return nil, false
+	}
+
+	department, err := GetDepartmentService().Get(departmentID)
+	if err != nil {
+		respondDepartmentError(c, err, "無法獲取部門")
+		return nil, false
+	}
+	return department, true
+}
+
+// respondDepartmentError 將部門錯誤轉換為 HTTP 響應
+func respondDepartmentError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrDepartmentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDepartmentNameTaken), errors.Is(err, services.ErrDepartmentNotEmpty):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDepartmentCycle), errors.Is(err, services.ErrInvalidDepartmentHead),
+		errors.Is(err, services.ErrPrimaryDepartmentUnset):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
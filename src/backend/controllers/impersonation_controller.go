@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"erp/models"
+	"erp/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 稽核紀錄查詢筆數
+const (
+	defaultImpersonationLogLimit = 100
+	maxImpersonationLogLimit     = 500
+)
+
+// ImpersonateUser 超級管理員以指定使用者的身分取得短效令牌，期間所有請求都會記錄
+func ImpersonateUser(c *gin.Context) {
+	actor, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	target, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+
+	var input models.ImpersonateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actorClaims, _ := currentClaims(c)
+	token, claims, err := GetImpersonationService().Start(actor, target, actorClaims, input.Reason, c.ClientIP(), c.Request.UserAgent())
+	if errors.Is(err, services.ErrImpersonationNotAllowed) || errors.Is(err, services.ErrAlreadyImpersonating) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法產生模擬登入令牌"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "已開始模擬登入",
+		"token":         token,
+		"expires_in":    int64(GetImpersonationService().TTL().Seconds()),
+		"expires_at":    claims.ExpiresAt.Time,
+		"user":          target.ToResponse(),
+		"impersonator":  claims.Actor,
+		"impersonation": true,
+	})
+}
+
+// EndImpersonation 提前結束模擬登入，目前的模擬令牌立即失效
+func EndImpersonation(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrNotImpersonating.Error()})
+		return
+	}
+
+	err := GetImpersonationService().End(claims, c.ClientIP(), c.Request.UserAgent())
+	if errors.Is(err, services.ErrNotImpersonating) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法結束模擬登入"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已結束模擬登入"})
+}
+
+// GetImpersonationLogs 取得使用者作為被模擬者或操作者的模擬登入稽核紀錄
+func GetImpersonationLogs(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+
+	limit := defaultImpersonationLogLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無效的 limit"})
+			return
+		}
+		limit = min(parsed, maxImpersonationLogLimit)
+	}
+
+	logs, err := GetImpersonationService().Logs(user.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取模擬登入稽核紀錄"})
+		return
+	}
+
+	c.JSON(http.StatusOK, logs)
+}
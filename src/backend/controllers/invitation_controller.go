@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"erp/middleware"
+	"erp/models"
+	"erp/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InviteUser 建立待啟用的使用者並寄出邀請連結，受邀者自行設定密碼
+func InviteUser(c *gin.Context) {
+	var input models.InviteUserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 指定初始角色與分配角色需要相同的權限
+	if len(input.RoleIDs) > 0 && !middleware.HasPermission(c, "system.roles.manage") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "權限不足", "permission": "system.roles.manage"})
+		return
+	}
+
+	inviter, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if input.Level == "" {
+		input.Level = "user"
+	}
+	if err := GetUserPolicyService().CheckAssignableLevel(inviter, input.Level); err != nil {
+		respondUserPolicyError(c, err)
+		return
+	}
+
+	user, invitation, err := GetInvitationService().Invite(input, inviter)
+	if errors.Is(err, services.ErrInvitationRoleNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil && !errors.Is(err, services.ErrInvitationMailFailed) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法建立使用者"})
+		return
+	}
+
+	response := gin.H{
+		"user":       user.ToResponse(),
+		"invitation": invitation,
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "邀請郵件寄送失敗: %v\n", err)
+		response["warning"] = "使用者已建立，但邀請郵件寄送失敗，請重新寄送邀請"
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// ResendInvitation 重新寄送邀請，先前的邀請連結立即失效
+func ResendInvitation(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok || !authorizeUserManagement(c, user) {
+		return
+	}
+	inviter, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	invitation, err := GetInvitationService().Resend(user, inviter)
+	switch {
+	case errors.Is(err, services.ErrUserAlreadyVerified):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrInvitationMailFailed):
+		fmt.Fprintf(os.Stderr, "邀請郵件寄送失敗: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "邀請郵件寄送失敗"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法重新寄送邀請"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "邀請已重新寄送", "invitation": invitation})
+}
+
+// RevokeInvitation 撤銷尚未接受的邀請，使用者保留為待啟用狀態
+func RevokeInvitation(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok || !authorizeUserManagement(c, user) {
+		return
+	}
+
+	err := GetInvitationService().Revoke(user)
+	switch {
+	case errors.Is(err, services.ErrUserAlreadyVerified):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrInvalidInvitation):
+		c.JSON(http.StatusNotFound, gin.H{"error": "沒有尚未接受的邀請"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法撤銷邀請"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "邀請已撤銷"})
+}
+
+// GetInvitation 驗證邀請令牌，回傳受邀帳號資訊供設定密碼頁面顯示
+func GetInvitation(c *gin.Context) {
+	var input models.InvitationTokenInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	user, invitation, err := GetInvitationService().Lookup(input.Token)
+	if errors.Is(err, services.ErrInvalidInvitation) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法驗證邀請"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"username":   user.Username,
+		"email":      user.Email,
+		"expires_at": invitation.ExpiresAt,
+	})
+}
+
+// AcceptInvitation 接受邀請並設定密碼，完成後電子郵件標記為已驗證，可正常登入
+func AcceptInvitation(c *gin.Context) {
+	var input models.AcceptInvitationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := GetInvitationService().Accept(input.Token, input.Password)
+	if errors.Is(err, services.ErrInvalidInvitation) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var policyErr *services.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密碼不符合安全政策", "violations": policyErr.Violations})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法接受邀請"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "帳號已啟用，請以新密碼登入", "user": user.ToResponse()})
+}
+
+// VerifyEmail 以變更電子郵件後寄出的驗證連結完成驗證，不需要重新設定密碼
+func VerifyEmail(c *gin.Context) {
+	var input models.InvitationTokenInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	user, err := GetInvitationService().VerifyEmail(input.Token)
+	if errors.Is(err, services.ErrInvalidInvitation) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrPendingEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法驗證電子郵件"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "電子郵件已驗證", "user": user.ToResponse()})
+}
+
+// changeEmail 變更使用者的電子郵件，回傳要寫入的欄位與是否需要寄出驗證連結
+// 已驗證的電子郵件在新的電子郵件驗證前繼續使用 (PendingEmail)；尚未接受邀請的帳號直接變更，重新寄送邀請即可
+func changeEmail(user *models.User, email string) (columns []string, verify bool) {
+	switch {
+	case email == user.Email:
+		// 改回目前的電子郵件時取消尚未驗證的變更，已寄出的驗證連結隨之失效
+		user.PendingEmail = ""
+		return []string{"pending_email"}, false
+	case !user.IsEmailVerified():
+		user.Email = email
+		return []string{"email"}, false
+	}
+	user.PendingEmail = email
+	return []string{"pending_email"}, true
+}
+
+// sendEmailVerification 申請變更電子郵件後寄出驗證連結，寄送失敗時只記錄錯誤 (可重新申請變更)
+func sendEmailVerification(user, requester *models.User) {
+	if _, err := GetInvitationService().SendEmailVerification(user, requester); err != nil {
+		fmt.Fprintf(os.Stderr, "電子郵件驗證信寄送失敗: %v\n", err)
+	}
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"erp/middleware"
+	"erp/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserLoginHistory 取得使用者的登入紀錄，本人或擁有 system.logs.view 權限者可查看
+func GetUserLoginHistory(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+
+	currentID, _ := middleware.CurrentUserID(c)
+	if currentID != user.ID && !middleware.HasPermission(c, "system.logs.view") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "權限不足", "permission": "system.logs.view"})
+		return
+	}
+
+	var query models.LoginEventQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query.UserID = &user.ID
+	query.Username = ""
+
+	respondLoginEvents(c, query)
+}
+
+// GetLoginEvents 查詢全系統的登入事件，可依使用者、帳號、結果、來源 IP 與時間篩選
+func GetLoginEvents(c *gin.Context) {
+	var query models.LoginEventQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondLoginEvents(c, query)
+}
+
+// respondLoginEvents 查詢登入事件並回傳分頁結果
+func respondLoginEvents(c *gin.Context, query models.LoginEventQuery) {
+	events, total, err := GetLoginEventService().List(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取登入紀錄"})
+		return
+	}
+	if events == nil {
+		events = []models.LoginEvent{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  total,
+	})
+}
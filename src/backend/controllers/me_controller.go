@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"erp/middleware"
+	"erp/models"
+	"erp/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// GetMe 取得目前登入使用者的資料
+func GetMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	responses, err := userResponsesWithProfiles([]models.User{*user})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取個人資料"})
+		return
+	}
+	c.JSON(http.StatusOK, responses[0])
+}
+
+// UpdateMe 更新自己的使用者名稱或電子郵件 (等級與登入驗證方式只能由管理員修改)
+func UpdateMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.UpdateProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 模擬登入期間不可變更登入憑證相關欄位
+	if _, impersonating := middleware.CurrentImpersonatorID(c); impersonating && input.Email != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "模擬登入期間無法變更電子郵件"})
+		return
+	}
+
+	emailChanged := false
+	if input.Username != nil && *input.Username != user.Username {
+		if taken, err := userFieldTaken(GetUserRepo().GetByUsername(*input.Username)); err != nil || taken {
+			respondFieldTaken(c, err, "使用者名稱已被使用")
+			return
+		}
+		user.Username = *input.Username
+	}
+	if input.Email != nil && *input.Email != user.Email {
+		if taken, err := userFieldTaken(GetUserRepo().GetByEmail(*input.Email)); err != nil || taken {
+			respondFieldTaken(c, err, "電子郵件已被使用")
+			return
+		}
+	}
+	if input.Email != nil {
+		// 新的電子郵件驗證後才生效
+		_, emailChanged = changeEmail(user, *input.Email)
+	}
+
+	if err := GetUserRepo().Update(user, "username", "email", "pending_email"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法更新使用者"})
+		return
+	}
+	if emailChanged {
+		sendEmailVerification(user, user)
+	}
+
+	c.JSON(http.StatusOK, user.ToResponse())
+}
+
+// ChangeMyPassword 變更自己的密碼，需要提供目前的密碼
+// 變更後其他裝置的工作階段全部結束，目前的工作階段保持登入
+func ChangeMyPassword(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.ChangePasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.CurrentPassword)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "目前的密碼錯誤"})
+		return
+	}
+
+	if err := GetPasswordPolicyService().SetPassword(user, input.NewPassword); err != nil {
+		respondPasswordError(c, err)
+		return
+	}
+	if err := GetUserRepo().Update(user, "password", "password_changed_at"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法更新密碼"})
+		return
+	}
+	if err := GetPasswordPolicyService().RecordHistory(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法記錄密碼歷史"})
+		return
+	}
+
+	if sessionID := currentSessionID(c); sessionID != "" {
+		_, err := GetTokenService().TerminateOtherSessions(user.ID, sessionID, services.SessionEndedInvalidated)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "無法結束其他工作階段"})
+			return
+		}
+	} else if err := GetTokenService().InvalidateUserTokens(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法撤銷使用者令牌"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "密碼已變更"})
+}
+
+// GetMyRoles 取得自己被分配的角色 (僅包含 active 角色)
+func GetMyRoles(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認證失敗"})
+		return
+	}
+
+	roles, err := GetPermissionService().GetUserRoles(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取角色列表"})
+		return
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+// GetMyPermissions 取得自己實際可使用的權限，包含等級帶來的權限；以 API 金鑰存取時只列出金鑰授予的權限
+func GetMyPermissions(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認證失敗"})
+		return
+	}
+
+	all, err := GetPermissionRepo().GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取權限列表"})
+		return
+	}
+
+	// API 金鑰的權限只由金鑰範圍決定 (與 RequirePermission 相同)，不看服務帳號的角色
+	var permissions []models.Permission
+	if key, ok := middleware.CurrentAPIKey(c); ok {
+		permissions = []models.Permission{}
+		for _, permission := range all {
+			if key.Allows(permission.Code) {
+				permissions = append(permissions, permission)
+			}
+		}
+	} else {
+		permissions, err = GetPermissionService().GetEffectivePermissions(userID, c.ClientIP(), all)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取權限列表"})
+			return
+		}
+	}
+
+	codes := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		codes = append(codes, permission.Code)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"codes":       codes,
+		"permissions": permissions,
+	})
+}
+
+// GetMyPreferences 取得自己的偏好設定
+func GetMyPreferences(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.UserPreferences{Language: user.Language, Timezone: user.Timezone})
+}
+
+// UpdateMyPreferences 更新自己的語言與時區設定
+func UpdateMyPreferences(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.UpdatePreferencesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Language != nil {
+		if !models.ValidLanguageTag(*input.Language) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無效的語言代碼，例如 zh-TW 或 en"})
+			return
+		}
+		user.Language = *input.Language
+	}
+	if input.Timezone != nil {
+		if !models.ValidTimezone(*input.Timezone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無效的時區，例如 Asia/Taipei"})
+			return
+		}
+		user.Timezone = *input.Timezone
+	}
+
+	if err := GetUserRepo().Update(user, "language", "timezone"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法更新偏好設定"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.UserPreferences{Language: user.Language, Timezone: user.Timezone})
+}
+
+// userFieldTaken 依查詢結果判斷使用者名稱或電子郵件是否已被其他帳號使用
+func userFieldTaken(_ *models.User, err error) (bool, error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// respondFieldTaken 回應欄位已被使用 (409) 或查詢失敗 (500)
+func respondFieldTaken(c *gin.Context, err error, message string) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法更新使用者"})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": message})
+}
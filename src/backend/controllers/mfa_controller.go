@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"erp/middleware"
+	"erp/models"
+	"erp/services"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// EnrollMFA 開始綁定兩步驟驗證，回傳 TOTP 金鑰與 otpauth URI
+func EnrollMFA(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := GetMFAService().Enroll(user)
+	if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法產生兩步驟驗證金鑰"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	})
+}
+
+// ConfirmMFA 以驗證碼完成綁定，回傳備用碼與通過兩步驟驗證的新令牌組
+func ConfirmMFA(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.MFACodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recoveryCodes, err := GetMFAService().ConfirmEnrollment(user, input.Code)
+	if errors.Is(err, services.ErrMFANotEnrolled) || errors.Is(err, services.ErrMFAAlreadyEnabled) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidMFACode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法啟用兩步驟驗證"})
+		return
+	}
+
+	// 等級強制通行金鑰時，TOTP 不算完成第二步驟，仍需綁定通行金鑰
+	verified := !GetWebAuthnService().RequiredForLevel(user.Level)
+	if claims, ok := currentClaims(c); ok && claims.MFAVerified {
+		verified = true
+	}
+	tokens, ok := reissueTokens(c, user, verified)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":                "兩步驟驗證已啟用",
+		"recovery_codes":         recoveryCodes,
+		"token":                  tokens.AccessToken,
+		"refresh_token":          tokens.RefreshToken,
+		"expires_in":             tokens.ExpiresIn,
+		"refresh_expires_at":     tokens.RefreshExpiresAt,
+		"passkey_setup_required": !verified,
+	})
+}
+
+// reissueTokens 完成兩步驟驗證設定後換發新的令牌組 (沿用原登入方式) 並結束原工作階段，失敗時直接寫入錯誤響應
+func reissueTokens(c *gin.Context, user *models.User, mfaVerified bool) (*services.TokenPair, bool) {
+	opts := services.TokenOptions{MFAVerified: mfaVerified}
+	if claims, ok := currentClaims(c); ok {
+		opts.AuthMethod = claims.AuthMethod
+	}
+	tokens, err := GetTokenService().IssueTokenPair(user, c.ClientIP(), c.Request.UserAgent(), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法產生令牌"})
+		return nil, false
+	}
+
+	// 新令牌組取代原工作階段
+	if sessionID := currentSessionID(c); sessionID != "" {
+		err := GetTokenService().TerminateSession(user.ID, sessionID, services.SessionEndedLogout)
+		if err != nil && !errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "無法結束原工作階段"})
+			return nil, false
+		}
+	}
+	return tokens, true
+}
+
+// DisableMFA 停用自己的兩步驟驗證，需要再次輸入密碼
+func DisableMFA(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.MFADisableInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "密碼錯誤"})
+		return
+	}
+
+	err := GetMFAService().Disable(user, false)
+	if errors.Is(err, services.ErrMFARequired) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法停用兩步驟驗證"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "兩步驟驗證已停用"})
+}
+
+// RegenerateRecoveryCodes 以目前的驗證碼重新產生備用碼
+func RegenerateRecoveryCodes(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.MFACodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := GetMFAService().Verify(user.ID, input.Code)
+	if errors.Is(err, services.ErrMFANotEnrolled) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidMFACode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法驗證驗證碼"})
+		return
+	}
+
+	recoveryCodes, err := GetMFAService().RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法產生備用碼"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+// CompleteMFALogin 登入第二步驟：以暫時令牌加上驗證碼或備用碼完成登入
+func CompleteMFALogin(c *gin.Context) {
+	var input models.MFAChallengeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if input.Code == "" && input.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "需要提供驗證碼或備用碼"})
+		return
+	}
+
+	attempt := models.LoginEvent{Step: models.LoginStepMFA}
+	claims, err := GetTokenService().ParseMFAChallenge(input.MFAToken)
+	if errors.Is(err, services.ErrInvalidAccessToken) {
+		recordLoginEvent(c, nil, attempt, models.LoginReasonMFAExpired)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "兩步驟驗證已逾時，請重新登入"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法驗證令牌"})
+		return
+	}
+
+	user, err := GetUserRepo().GetByID(claims.UserID)
+	if err != nil || user.TokenVersion != claims.TokenVersion || !user.MFAEnabled {
+		if err != nil {
+			user = nil
+		}
+		recordLoginEvent(c, user, attempt, models.LoginReasonMFAExpired)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "兩步驟驗證已逾時，請重新登入"})
+		return
+	}
+
+	// 帳號鎖定或仍在漸進延遲中時不接受驗證碼，驗證碼錯誤同樣計入登入失敗次數
+	if err := GetLoginGuardService().CheckUser(user); err != nil {
+		recordLoginBlocked(c, user, attempt, err)
+		respondLoginBlocked(c, err)
+		return
+	}
+
+	// 等級強制通行金鑰時：已綁定者只能以通行金鑰完成，尚未綁定者登入後需先綁定
+	passkeyRequired := GetWebAuthnService().RequiredForLevel(user.Level)
+	if passkeyRequired {
+		hasPasskeys, err := GetWebAuthnService().HasPasskeys(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "無法載入通行金鑰"})
+			return
+		}
+		if hasPasskeys {
+			c.JSON(http.StatusForbidden, gin.H{"error": "此等級的使用者必須以通行金鑰完成兩步驟驗證", "mfa_methods": []string{"passkey"}})
+			return
+		}
+	}
+
+	if input.Code != "" {
+		err = GetMFAService().Verify(user.ID, input.Code)
+	} else {
+		err = GetMFAService().VerifyRecoveryCode(user.ID, input.RecoveryCode)
+	}
+	if errors.Is(err, services.ErrInvalidMFACode) || errors.Is(err, services.ErrMFANotEnrolled) {
+		recordLoginEvent(c, user, attempt, models.LoginReasonInvalidMFACode)
+		if err := GetLoginGuardService().RecordFailure(user, c.ClientIP()); err != nil {
+			respondLoginBlocked(c, err)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": services.ErrInvalidMFACode.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法驗證驗證碼"})
+		return
+	}
+
+	// 暫時令牌只能使用一次
+	if err := GetTokenService().RevokeAccessToken(claims, "mfa_challenge_used"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法完成登入"})
+		return
+	}
+
+	completeLogin(c, user, services.TokenOptions{MFAVerified: !passkeyRequired, AuthMethod: claims.AuthMethod})
+}
+
+// ResetUserMFA 管理員重設使用者的兩步驟驗證與通行金鑰 (例如遺失裝置與備用碼)
+func ResetUserMFA(c *gin.Context) {
+	id := c.Param("id")
+
+	// 將 string ID 轉換為 uint
+	var userID uint
+	if _, err := fmt.Sscanf(id, "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的使用者 ID"})
+		return
+	}
+
+	user, err := GetUserRepo().GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到使用者"})
+		return
+	}
+	if !authorizeUserManagement(c, user) {
+		return
+	}
+
+	if err := GetMFAService().Disable(user, true); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法重設兩步驟驗證"})
+		return
+	}
+	if err := GetWebAuthnService().DeleteAll(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除通行金鑰"})
+		return
+	}
+
+	// 既有令牌全部失效，使用者需重新登入並重新綁定
+	if err := GetTokenService().InvalidateUserTokens(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法撤銷使用者令牌"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已重設使用者的兩步驟驗證"})
+}
+
+// currentUser 取得目前登入的使用者，失敗時直接寫入錯誤響應
+func currentUser(c *gin.Context) (*models.User, bool) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認證失敗"})
+		return nil, false
+	}
+
+	user, err := GetUserRepo().GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認證失敗"})
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"erp/models"
+	"erp/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetOIDCProviders 回傳已設定的單一登入提供者，供前端顯示登入按鈕
+func GetOIDCProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": GetOIDCService().Providers()})
+}
+
+// oidcStateCookie 保存授權流程 state 的 cookie，回呼時比對以確認由同一個瀏覽器發起登入
+const oidcStateCookie = "oidc_state"
+
+// OIDCLogin 開始單一登入，導向身分提供者的登入頁
+// 帶 format=json 時改為回傳登入網址，方便前端自行導向 (需以同源且帶 cookie 的請求呼叫，才能保存 state cookie)
+func OIDCLogin(c *gin.Context) {
+	authURL, state, err := GetOIDCService().AuthorizationURL(c.Request.Context(), c.Param("provider"))
+	if errors.Is(err, services.ErrOIDCProviderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "單一登入初始化失敗: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "無法連線至身分提供者"})
+		return
+	}
+
+	setOIDCStateCookie(c, state, int(GetOIDCService().StateTTL().Seconds()))
+
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback 身分提供者回呼 (瀏覽器頂層跳轉)，結果一律導回前端 (OIDC_FRONTEND_URL)
+// 驗證通過時帶一次性交換碼，前端再以 OIDCExchange 取得與帳號密碼登入相同的回應；失敗時帶錯誤代碼
+func OIDCCallback(c *gin.Context) {
+	attempt := models.LoginEvent{Step: models.LoginStepOIDC, Detail: c.Param("provider")}
+	if errCode := c.Query("error"); errCode != "" {
+		setOIDCStateCookie(c, "", -1)
+		attempt.Detail += ": " + errCode
+		recordLoginEvent(c, nil, attempt, models.LoginReasonSSOFailed)
+		redirectOIDCResult(c, url.Values{
+			"error":             {"provider_error"},
+			"provider_error":    {errCode},
+			"error_description": {c.Query("error_description")},
+		})
+		return
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		redirectOIDCError(c, "invalid_request", "Invalid request")
+		return
+	}
+
+	// state cookie 只能使用一次
+	browserState, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+
+	user, err := GetOIDCService().Authenticate(c.Request.Context(), c.Param("provider"), state, browserState, code)
+	switch {
+	case errors.Is(err, services.ErrOIDCProviderNotFound):
+		redirectOIDCError(c, "provider_not_found", err.Error())
+		return
+	case errors.Is(err, services.ErrOIDCInvalidState):
+		recordLoginEvent(c, nil, attempt, models.LoginReasonInvalidState)
+		redirectOIDCError(c, "invalid_state", err.Error())
+		return
+	case errors.Is(err, services.ErrOIDCAuthFailed):
+		fmt.Fprintf(os.Stderr, "單一登入驗證失敗: %v\n", err)
+		recordLoginEvent(c, nil, attempt, models.LoginReasonSSOFailed)
+		redirectOIDCError(c, "auth_failed", services.ErrOIDCAuthFailed.Error())
+		return
+	case errors.Is(err, services.ErrOIDCServiceAccount):
+		recordLoginEvent(c, nil, attempt, models.LoginReasonServiceAccount)
+		redirectOIDCError(c, "auth_failed", services.ErrOIDCAuthFailed.Error())
+		return
+	case errors.Is(err, services.ErrOIDCUserNotProvisioned), errors.Is(err, services.ErrOIDCEmailRequired):
+		recordLoginEvent(c, nil, attempt, models.LoginReasonNotProvisioned)
+		redirectOIDCError(c, "not_provisioned", err.Error())
+		return
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "單一登入處理失敗: %v\n", err)
+		recordLoginEvent(c, nil, attempt, models.LoginReasonBackendUnavailable)
+		redirectOIDCError(c, "unavailable", "無法完成單一登入")
+		return
+	}
+
+	// 管理員鎖定的帳號同樣不可透過單一登入登入
+	if user.IsLocked(time.Now()) {
+		recordLoginEvent(c, user, attempt, models.LoginReasonLocked)
+		blocked := &services.LoginBlockedError{Locked: true, Until: *user.LockedUntil}
+		redirectOIDCError(c, "account_locked", blocked.Error())
+		return
+	}
+
+	loginCode, err := GetOIDCService().IssueLoginCode(user, c.Param("provider"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "單一登入交換碼建立失敗: %v\n", err)
+		redirectOIDCError(c, "unavailable", "無法完成單一登入")
+		return
+	}
+	redirectOIDCResult(c, url.Values{"code": {loginCode}})
+}
+
+// OIDCExchange 以回呼導回前端時取得的交換碼完成登入，回應與帳號密碼登入相同 (令牌或兩步驟驗證挑戰)
+func OIDCExchange(c *gin.Context) {
+	var input models.OIDCLoginCodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	user, err := GetOIDCService().ExchangeLoginCode(input.Code)
+	if errors.Is(err, services.ErrOIDCInvalidLoginCode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrOIDCServiceAccount) {
+		recordLoginEvent(c, nil, models.LoginEvent{Step: models.LoginStepOIDC}, models.LoginReasonServiceAccount)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": services.ErrOIDCAuthFailed.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load user"})
+		return
+	}
+
+	// 交換碼簽發後帳號才被鎖定的情況
+	if user.IsLocked(time.Now()) {
+		recordLoginEvent(c, user, models.LoginEvent{Step: models.LoginStepOIDC}, models.LoginReasonLocked)
+		respondLoginBlocked(c, &services.LoginBlockedError{Locked: true, Until: *user.LockedUntil})
+		return
+	}
+
+	beginLogin(c, user, services.AuthMethodOIDC)
+}
+
+// redirectOIDCError 導回前端並帶上錯誤代碼與說明
+func redirectOIDCError(c *gin.Context, code, description string) {
+	redirectOIDCResult(c, url.Values{"error": {code}, "error_description": {description}})
+}
+
+// redirectOIDCResult 導回前端的單一登入結果頁
+func redirectOIDCResult(c *gin.Context, params url.Values) {
+	c.Redirect(http.StatusFound, GetOIDCService().FrontendURL(params))
+}
+
+// setOIDCStateCookie 設定 (maxAge < 0 時清除) state cookie
+// 只送往此提供者的回呼路徑；SameSite=Lax 讓身分提供者導回的頂層 GET 請求仍會帶上 cookie
+func setOIDCStateCookie(c *gin.Context, state string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, path.Dir(c.Request.URL.Path), "", secure, true)
+}
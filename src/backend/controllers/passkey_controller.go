@@ -0,0 +1,325 @@
+package controllers
+
+import (
+	"erp/models"
+	"erp/services"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMyPasskeys 列出目前使用者的通行金鑰
+func GetMyPasskeys(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	passkeys, err := GetWebAuthnService().List(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法載入通行金鑰"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"passkeys": passkeys})
+}
+
+// BeginPasskeyRegistration 開始綁定通行金鑰，回傳 navigator.credentials.create() 的參數
+func BeginPasskeyRegistration(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	options, ceremonyToken, err := GetWebAuthnService().BeginRegistration(user)
+	if respondPasskeyError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"options":        options,
+		"ceremony_token": ceremonyToken,
+		"expires_in":     int64(GetWebAuthnService().TTL().Seconds()),
+	})
+}
+
+// FinishPasskeyRegistration 完成綁定通行金鑰
+// 等級強制通行金鑰且目前令牌尚未通過第二步驟時，換發通過驗證的新令牌組
+func FinishPasskeyRegistration(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.PasskeyRegistrationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	passkey, err := GetWebAuthnService().FinishRegistration(user, input.CeremonyToken, input.Name, input.Credential)
+	if respondPasskeyError(c, err) {
+		return
+	}
+
+	claims, ok := currentClaims(c)
+	if !ok || claims.MFAVerified || !GetWebAuthnService().RequiredForLevel(user.Level) {
+		c.JSON(http.StatusCreated, gin.H{"passkey": passkey})
+		return
+	}
+
+	tokens, ok := reissueTokens(c, user, true)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"passkey":            passkey,
+		"token":              tokens.AccessToken,
+		"refresh_token":      tokens.RefreshToken,
+		"expires_in":         tokens.ExpiresIn,
+		"refresh_expires_at": tokens.RefreshExpiresAt,
+	})
+}
+
+// RenameMyPasskey 修改通行金鑰的顯示名稱
+func RenameMyPasskey(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	passkeyID, ok := passkeyIDParam(c)
+	if !ok {
+		return
+	}
+
+	var input models.RenamePasskeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if respondPasskeyError(c, GetWebAuthnService().Rename(user.ID, passkeyID, input.Name)) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "通行金鑰名稱已更新"})
+}
+
+// DeleteMyPasskey 刪除自己的通行金鑰
+func DeleteMyPasskey(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	passkeyID, ok := passkeyIDParam(c)
+	if !ok {
+		return
+	}
+
+	if respondPasskeyError(c, GetWebAuthnService().Delete(user, passkeyID)) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "通行金鑰已刪除"})
+}
+
+// BeginPasskeyLogin 開始免密碼登入，回傳 navigator.credentials.get() 的參數
+func BeginPasskeyLogin(c *gin.Context) {
+	options, ceremonyToken, err := GetWebAuthnService().BeginLogin()
+	if respondPasskeyError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"options":        options,
+		"ceremony_token": ceremonyToken,
+		"expires_in":     int64(GetWebAuthnService().TTL().Seconds()),
+	})
+}
+
+// FinishPasskeyLogin 以通行金鑰完成免密碼登入 (驗證器已驗證使用者本人，視同通過兩步驟驗證)
+func FinishPasskeyLogin(c *gin.Context) {
+	var input models.PasskeyAssertionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	clientIP := c.ClientIP()
+	attempt := models.LoginEvent{Step: models.LoginStepPasskey}
+
+	// 同一來源 IP 失敗過多時暫時封鎖
+	if err := GetLoginGuardService().CheckIP(clientIP); err != nil {
+		recordLoginBlocked(c, nil, attempt, err)
+		respondLoginBlocked(c, err)
+		return
+	}
+
+	user, err := GetWebAuthnService().FinishLogin(input.CeremonyToken, input.Credential)
+	if err != nil {
+		respondPasskeyLoginError(c, user, attempt, err)
+		return
+	}
+
+	// 服務帳號只能使用 API 金鑰
+	if user.ServiceAccount {
+		recordLoginEvent(c, user, attempt, models.LoginReasonServiceAccount)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": services.ErrInvalidPasskey.Error()})
+		return
+	}
+
+	// 帳號鎖定或仍在漸進延遲中
+	if err := GetLoginGuardService().CheckUser(user); err != nil {
+		recordLoginBlocked(c, user, attempt, err)
+		respondLoginBlocked(c, err)
+		return
+	}
+
+	if !checkAccountStatus(c, user, attempt) || !checkEmailVerified(c, user, attempt) || !checkNetworkAllowed(c, user, attempt) {
+		return
+	}
+
+	completeLogin(c, user, services.TokenOptions{AuthMethod: services.AuthMethodPasskey, MFAVerified: true})
+}
+
+// BeginPasskeyMFA 登入第二步驟：以 Login 回傳的暫時令牌開始通行金鑰驗證
+func BeginPasskeyMFA(c *gin.Context) {
+	var input models.PasskeyMFABeginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	_, user, ok := passkeyChallengeUser(c, input.MFAToken)
+	if !ok {
+		return
+	}
+
+	options, ceremonyToken, err := GetWebAuthnService().BeginMFA(user)
+	if respondPasskeyError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"options":        options,
+		"ceremony_token": ceremonyToken,
+		"expires_in":     int64(GetWebAuthnService().TTL().Seconds()),
+	})
+}
+
+// FinishPasskeyMFA 登入第二步驟：驗證通行金鑰簽章後完成登入
+func FinishPasskeyMFA(c *gin.Context) {
+	var input models.PasskeyMFAFinishInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	claims, user, ok := passkeyChallengeUser(c, input.MFAToken)
+	if !ok {
+		return
+	}
+
+	attempt := models.LoginEvent{Step: models.LoginStepMFA, Detail: "passkey"}
+
+	// 帳號鎖定或仍在漸進延遲中時不接受驗證，驗證失敗同樣計入登入失敗次數
+	if err := GetLoginGuardService().CheckUser(user); err != nil {
+		recordLoginBlocked(c, user, attempt, err)
+		respondLoginBlocked(c, err)
+		return
+	}
+
+	if err := GetWebAuthnService().FinishMFA(user, input.CeremonyToken, input.Credential); err != nil {
+		respondPasskeyLoginError(c, user, attempt, err)
+		return
+	}
+
+	// 暫時令牌只能使用一次
+	if err := GetTokenService().RevokeAccessToken(claims, "mfa_challenge_used"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法完成登入"})
+		return
+	}
+
+	completeLogin(c, user, services.TokenOptions{MFAVerified: true, AuthMethod: claims.AuthMethod})
+}
+
+// passkeyChallengeUser 驗證登入第二步驟的暫時令牌並載入使用者，失敗時直接寫入錯誤響應
+func passkeyChallengeUser(c *gin.Context, mfaToken string) (*services.AccessClaims, *models.User, bool) {
+	attempt := models.LoginEvent{Step: models.LoginStepMFA, Detail: "passkey"}
+	claims, err := GetTokenService().ParseMFAChallenge(mfaToken)
+	if errors.Is(err, services.ErrInvalidAccessToken) {
+		recordLoginEvent(c, nil, attempt, models.LoginReasonMFAExpired)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "兩步驟驗證已逾時，請重新登入"})
+		return nil, nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法驗證令牌"})
+		return nil, nil, false
+	}
+
+	user, err := GetUserRepo().GetByID(claims.UserID)
+	if err != nil || user.TokenVersion != claims.TokenVersion {
+		if err != nil {
+			user = nil
+		}
+		recordLoginEvent(c, user, attempt, models.LoginReasonMFAExpired)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "兩步驟驗證已逾時，請重新登入"})
+		return nil, nil, false
+	}
+	return claims, user, true
+}
+
+// respondPasskeyLoginError 記錄並回應通行金鑰登入失敗，簽章驗證失敗計入登入失敗次數
+func respondPasskeyLoginError(c *gin.Context, user *models.User, attempt models.LoginEvent, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidPasskeyCeremony):
+		recordLoginEvent(c, user, attempt, models.LoginReasonMFAExpired)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPasskeyCloneDetected):
+		recordLoginEvent(c, user, attempt, models.LoginReasonPasskeyCloned)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPasskey):
+		recordLoginEvent(c, user, attempt, models.LoginReasonInvalidPasskey)
+		if err := GetLoginGuardService().RecordFailure(user, c.ClientIP()); err != nil {
+			respondLoginBlocked(c, err)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": services.ErrInvalidPasskey.Error()})
+	default:
+		respondPasskeyError(c, err)
+	}
+}
+
+// respondPasskeyError 將通行金鑰服務錯誤轉換為 HTTP 響應，回傳是否已寫入響應
+func respondPasskeyError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrPasskeyUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPasskeyCeremony), errors.Is(err, services.ErrInvalidPasskey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPasskeyAlreadyRegistered):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPasskeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPasskeyRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "通行金鑰處理失敗"})
+	}
+	return true
+}
+
+// passkeyIDParam 解析路徑中的通行金鑰 ID，失敗時直接寫入錯誤響應
+func passkeyIDParam(c *gin.Context) (uint, bool) {
+	var passkeyID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &passkeyID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的通行金鑰 ID"})
+		return 0, false
+	}
+	return passkeyID, true
+}
@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"erp/models"
+	"erp/services"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -42,15 +44,23 @@ func CreatePermission(c *gin.Context) {
 	c.JSON(http.StatusCreated, permission)
 }
 
-// GetPermissions 取得所有權限
+// GetPermissions 分頁查詢權限，可依模組、資源、狀態、建立時間與關鍵字 (代碼、顯示名稱、描述) 篩選
 func GetPermissions(c *gin.Context) {
-	permissions, err := GetPermissionRepo().GetAll()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取權限列表"})
+	var query models.PermissionListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	permissions, page, err := GetPermissionRepo().List(query)
+	if respondListError(c, err, "無法獲取權限列表") {
 		return
 	}
 
-	c.JSON(http.StatusOK, permissions)
+	c.JSON(http.StatusOK, gin.H{
+		"permissions": permissions,
+		"pagination":  page,
+	})
 }
 
 // GetPermissionByID 根據 ID 取得特定權限
@@ -141,8 +151,8 @@ func DeletePermission(c *gin.Context) {
 
 // AssignPermissionToRole 為角色分配權限
 func AssignPermissionToRole(c *gin.Context) {
+	permissionIDStr := c.Param("id")
 	roleIDStr := c.Param("roleId")
-	permissionIDStr := c.Param("permissionId")
 
 	roleID, err := strconv.ParseUint(roleIDStr, 10, 32)
 	if err != nil {
@@ -156,38 +166,22 @@ func AssignPermissionToRole(c *gin.Context) {
 		return
 	}
 
-	// 檢查使用者等級權限
-	userLevel, exists := c.Get("level")
-	if !exists {
-		c.JSON(http.StatusForbidden, gin.H{"error": "無法獲取使用者等級"})
+	// 確認角色與權限存在
+	if _, err := GetRoleRepo().GetByID(uint(roleID)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到角色"})
 		return
 	}
-
-	// 根據使用者等級檢查權限
-	userLevelStr, ok := userLevel.(string)
-	if !ok {
-		c.JSON(http.StatusForbidden, gin.H{"error": "使用者等級格式錯誤"})
+	if _, err := GetPermissionRepo().GetByID(uint(permissionID)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到權限"})
 		return
 	}
 
-	if userLevelStr == "user" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "一般使用者無法編輯權限"})
+	// 實作角色權限分配（權限已由 RequirePermission 中間件檢查）
+	err = GetPermissionService().AssignPermissionToRole(uint(roleID), uint(permissionID))
+	if errors.Is(err, services.ErrPermissionAlreadyAssigned) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		return
 	}
-
-	// 如果是 admin，需要檢查使用者是否擁有該權限模組的管理權限
-	if userLevelStr == "admin" {
-		// 目前暫時允許所有 admin 編輯，但實際上應該檢查具體權限
-		// 這裡需要實作：檢查使用者是否擁有該模組的管理權限
-		// 例如：檢查使用者的角色是否包含 "manage_" + module 的權限
-	}
-
-	// 如果是 super_admin，可以編輯所有權限
-	// 不需要額外檢查
-
-	// 實作角色權限分配
-	rolePermission := models.RolePermission{RoleID: uint(roleID), PermissionID: uint(permissionID)}
-	err = GetRolePermissionRepo().Create(&rolePermission)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法分配權限"})
 		return
@@ -198,8 +192,8 @@ func AssignPermissionToRole(c *gin.Context) {
 
 // RemovePermissionFromRole 從角色移除權限
 func RemovePermissionFromRole(c *gin.Context) {
+	permissionIDStr := c.Param("id")
 	roleIDStr := c.Param("roleId")
-	permissionIDStr := c.Param("permissionId")
 
 	roleID, err := strconv.ParseUint(roleIDStr, 10, 32)
 	if err != nil {
@@ -213,35 +207,8 @@ func RemovePermissionFromRole(c *gin.Context) {
 		return
 	}
 
-	// 檢查使用者等級權限
-	userLevel, exists := c.Get("level")
-	if !exists {
-		c.JSON(http.StatusForbidden, gin.H{"error": "無法獲取使用者等級"})
-		return
-	}
-
-	// 根據使用者等級檢查權限
-	userLevelStr, ok := userLevel.(string)
-	if !ok {
-		c.JSON(http.StatusForbidden, gin.H{"error": "使用者等級格式錯誤"})
-		return
-	}
-
-	if userLevelStr == "user" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "一般使用者無法編輯權限"})
-		return
-	}
-
-	// 如果是 admin，需要檢查使用者是否擁有該權限模組的管理權限
-	if userLevelStr == "admin" {
-		// 目前暫時允許所有 admin 編輯，但實際上應該檢查具體權限
-		// 這裡需要實作：檢查使用者是否擁有該模組的管理權限
-	}
-
-	// 如果是 super_admin，可以編輯所有權限
-
-	// 實作角色權限移除
-	err = GetRolePermissionRepo().Delete(uint(roleID), uint(permissionID))
+	// 實作角色權限移除（權限已由 RequirePermission 中間件檢查）
+	err = GetPermissionService().RemovePermissionFromRole(uint(roleID), uint(permissionID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法移除權限"})
 		return
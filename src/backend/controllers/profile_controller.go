@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"erp/models"
+	"erp/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMyProfile 取得自己的個人資料
+func GetMyProfile(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	respondProfile(c, user)
+}
+
+// UpdateMyProfile 更新自己的個人資料；職稱、主管與員工編號只有管理員可以修改
+func UpdateMyProfile(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	canEditEmployee := GetUserPolicyService().CanManage(user, user) == nil
+	updateProfile(c, user, canEditEmployee)
+}
+
+// UploadMyAvatar 上傳自己的頭像 (multipart 表單的 file 欄位)
+func UploadMyAvatar(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	uploadAvatar(c, user)
+}
+
+// DeleteMyAvatar 移除自己的頭像
+func DeleteMyAvatar(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	deleteAvatar(c, user)
+}
+
+// GetUserProfile 取得指定使用者的個人資料
+func GetUserProfile(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+	respondProfile(c, user)
+}
+
+// UpdateUserProfile 管理員更新使用者的個人資料 (包含員工資料)
+func UpdateUserProfile(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+	if !authorizeUserManagement(c, user) {
+		return
+	}
+	updateProfile(c, user, true)
+}
+
+// UploadUserAvatar 管理員為使用者上傳頭像
+func UploadUserAvatar(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+	if !authorizeUserManagement(c, user) {
+		return
+	}
+	uploadAvatar(c, user)
+}
+
+// DeleteUserAvatar 管理員移除使用者的頭像
+func DeleteUserAvatar(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+	if !authorizeUserManagement(c, user) {
+		return
+	}
+	deleteAvatar(c, user)
+}
+
+// GetUserAvatar 取得使用者的頭像圖片
+func GetUserAvatar(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+
+	file, contentType, err := GetUserProfileService().OpenAvatar(user.ID)
+	if errors.Is(err, services.ErrAvatarNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法讀取頭像"})
+		return
+	}
+	defer file.Close()
+
+	// 頭像網址帶有版本參數，內容變更時網址也會變更
+	c.DataFromReader(http.StatusOK, -1, contentType, file, map[string]string{
+		"Cache-Control":          "private, max-age=86400",
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// userResponsesWithProfiles 轉換為回傳給前端的使用者資訊並附帶個人資料
+func userResponsesWithProfiles(users []models.User) ([]models.UserResponse, error) {
+	ids := make([]uint, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	profiles, err := GetUserProfileService().GetMany(ids)
+	if err != nil {
+		return nil, err
+	}
+	departments, err := GetDepartmentService().PrimaryDepartments(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]models.UserResponse, 0, len(users))
+	for i := range users {
+		profile, ok := profiles[users[i].ID]
+		if !ok {
+			profile = &models.UserProfile{UserID: users[i].ID}
+		}
+		response := users[i].ToResponse()
+		profileResponse := profile.ToResponse(&users[i])
+		if department, ok := departments[users[i].ID]; ok {
+			profileResponse.Department = &department
+		}
+		response.Profile = &profileResponse
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+// respondProfile 回應使用者的個人資料
+func respondProfile(c *gin.Context, user *models.User) {
+	profile, err := GetUserProfileService().Get(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取個人資料"})
+		return
+	}
+	writeProfile(c, user, profile)
+}
+
+// writeProfile 回應個人資料並附帶使用者的主要部門
+func writeProfile(c *gin.Context, user *models.User, profile *models.UserProfile) {
+	departments, err := GetDepartmentService().PrimaryDepartments([]uint{user.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取使用者的部門"})
+		return
+	}
+
+	response := profile.ToResponse(user)
+	if department, ok := departments[user.ID]; ok {
+		response.Department = &department
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// updateProfile 綁定輸入並更新使用者的個人資料
+func updateProfile(c *gin.Context, user *models.User, canEditEmployee bool) {
+	var input models.UpdateUserProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := GetUserProfileService().Update(user, input, canEditEmployee)
+	if err != nil {
+		respondProfileError(c, err, "無法更新個人資料")
+		return
+	}
+	writeProfile(c, user, profile)
+}
+
+// uploadAvatar 讀取上傳的頭像檔案並儲存
+func uploadAvatar(c *gin.Context, user *models.User) {
+	// 保留 multipart 表頭的空間
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxAvatarSize+64<<10)
+
+	fileHeader, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrAvatarTooLarge.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "請上傳頭像檔案 (file)"})
+		return
+	}
+	if fileHeader.Size > services.MaxAvatarSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrAvatarTooLarge.Error()})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無法讀取頭像檔案"})
+		return
+	}
+	defer file.Close()
+
+	profile, err := GetUserProfileService().SetAvatar(user.ID, file)
+	if err != nil {
+		respondProfileError(c, err, "無法儲存頭像")
+		return
+	}
+	writeProfile(c, user, profile)
+}
+
+// deleteAvatar 移除使用者的頭像
+func deleteAvatar(c *gin.Context, user *models.User) {
+	profile, err := GetUserProfileService().DeleteAvatar(user.ID)
+	if err != nil {
+		respondProfileError(c, err, "無法移除頭像")
+		return
+	}
+	writeProfile(c, user, profile)
+}
+
+// respondProfileError 將個人資料錯誤轉換為 HTTP 響應
+func respondProfileError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrEmployeeFieldsForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEmployeeNumberTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAvatarTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPhone), errors.Is(err, services.ErrInvalidLocale), errors.Is(err, services.ErrInvalidTimezone),
+		errors.Is(err, services.ErrInvalidManager), errors.Is(err, services.ErrUnsupportedAvatar):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"erp/models"
+	"erp/services"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -12,20 +14,27 @@ import (
 // CreateRole 建立新角色
 func CreateRole(c *gin.Context) {
 	var input struct {
-		Name        string `json:"name" binding:"required"`
-		Description string `json:"description"`
+		Name            string   `json:"name" binding:"required"`
+		Description     string   `json:"description"`
+		AllowedNetworks []string `json:"allowed_networks" binding:"max=100"` // 角色可使用的來源網段 (CIDR)
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	networks, err := models.ParseNetworkList(input.AllowedNetworks)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	role := models.Role{
-		Name:        input.Name,
-		Description: input.Description,
+		Name:            input.Name,
+		Description:     input.Description,
+		AllowedNetworks: networks,
 	}
 
-	err := GetRoleRepo().Create(&role)
+	err = GetRoleRepo().Create(&role)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法建立角色"})
 		return
@@ -34,15 +43,23 @@ func CreateRole(c *gin.Context) {
 	c.JSON(http.StatusCreated, role)
 }
 
-// GetRoles 取得所有角色
+// GetRoles 分頁查詢角色，可依狀態、是否為系統角色、建立時間與關鍵字 (名稱、顯示名稱、描述) 篩選
 func GetRoles(c *gin.Context) {
-	roles, err := GetRoleRepo().GetAll()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取角色列表"})
+	var query models.RoleListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, roles)
+	roles, page, err := GetRoleRepo().List(query)
+	if respondListError(c, err, "無法獲取角色列表") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles":      roles,
+		"pagination": page,
+	})
 }
 
 // GetRoleByID 根據 ID 取得特定角色
@@ -73,8 +90,9 @@ func UpdateRole(c *gin.Context) {
 	}
 
 	var input struct {
-		Name        *string `json:"name"`
-		Description *string `json:"description"`
+		Name            *string   `json:"name"`
+		Description     *string   `json:"description"`
+		AllowedNetworks *[]string `json:"allowed_networks" binding:"omitempty,max=100"` // 空陣列代表取消限制
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -94,6 +112,14 @@ func UpdateRole(c *gin.Context) {
 	if input.Description != nil {
 		role.Description = *input.Description
 	}
+	if input.AllowedNetworks != nil {
+		networks, err := models.ParseNetworkList(*input.AllowedNetworks)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		role.AllowedNetworks = networks
+	}
 
 	err = GetRoleRepo().Update(role)
 	if err != nil {
@@ -124,8 +150,8 @@ func DeleteRole(c *gin.Context) {
 
 // AssignRoleToUser 為使用者分配角色
 func AssignRoleToUser(c *gin.Context) {
+	roleIDStr := c.Param("id")
 	userIDStr := c.Param("userId")
-	roleIDStr := c.Param("roleId")
 
 	userID, err := strconv.ParseUint(userIDStr, 10, 32)
 	if err != nil {
@@ -139,21 +165,22 @@ func AssignRoleToUser(c *gin.Context) {
 		return
 	}
 
-	// 檢查使用者等級權限
-	userLevel, exists := c.Get("level")
-	if !exists {
-		c.JSON(http.StatusForbidden, gin.H{"error": "無法獲取使用者等級"})
+	// 確認使用者與角色存在
+	if _, err := GetUserRepo().GetByID(uint(userID)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到使用者"})
 		return
 	}
-
-	if userLevel != "admin" && userLevel != "super_admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "只有管理員可以分配角色"})
+	if _, err := GetRoleRepo().GetByID(uint(roleID)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到角色"})
 		return
 	}
 
-	// 實作使用者角色分配
-	userRole := models.UserRole{UserID: uint(userID), RoleID: uint(roleID)}
-	err = GetUserRoleRepo().Create(&userRole)
+	// 實作使用者角色分配（權限已由 RequirePermission 中間件檢查）
+	err = GetPermissionService().AssignRoleToUser(uint(userID), uint(roleID))
+	if errors.Is(err, services.ErrRoleAlreadyAssigned) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法分配角色"})
 		return
@@ -164,8 +191,8 @@ func AssignRoleToUser(c *gin.Context) {
 
 // RemoveRoleFromUser 從使用者移除角色
 func RemoveRoleFromUser(c *gin.Context) {
+	roleIDStr := c.Param("id")
 	userIDStr := c.Param("userId")
-	roleIDStr := c.Param("roleId")
 
 	userID, err := strconv.ParseUint(userIDStr, 10, 32)
 	if err != nil {
@@ -179,20 +206,8 @@ func RemoveRoleFromUser(c *gin.Context) {
 		return
 	}
 
-	// 檢查使用者等級權限
-	userLevel, exists := c.Get("level")
-	if !exists {
-		c.JSON(http.StatusForbidden, gin.H{"error": "無法獲取使用者等級"})
-		return
-	}
-
-	if userLevel != "admin" && userLevel != "super_admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "只有管理員可以移除角色"})
-		return
-	}
-
-	// 實作使用者角色移除
-	err = GetUserRoleRepo().Delete(uint(userID), uint(roleID))
+	// 實作使用者角色移除（權限已由 RequirePermission 中間件檢查）
+	err = GetPermissionService().RemoveRoleFromUser(uint(userID), uint(roleID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法移除角色"})
 		return
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"erp/middleware"
+	"erp/models"
+	"erp/services"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMySessions 取得目前使用者的登入工作階段
+func GetMySessions(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認證失敗"})
+		return
+	}
+
+	respondSessions(c, userID, currentSessionID(c))
+}
+
+// TerminateMySession 結束目前使用者的單一工作階段 (可為目前的工作階段)
+func TerminateMySession(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認證失敗"})
+		return
+	}
+
+	terminateSession(c, userID, c.Param("id"), services.SessionEndedByUser)
+}
+
+// TerminateOtherSessions 結束目前使用者除了目前工作階段以外的所有工作階段
+func TerminateOtherSessions(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認證失敗"})
+		return
+	}
+
+	terminated, err := GetTokenService().TerminateOtherSessions(userID, currentSessionID(c), services.SessionEndedByUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法結束工作階段"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已登出其他裝置", "terminated": terminated})
+}
+
+// GetUserSessions 管理員取得指定使用者的登入工作階段 (不可查看等級高於自己的使用者)
+func GetUserSessions(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok || !authorizeUserManagement(c, user) {
+		return
+	}
+
+	respondSessions(c, user.ID, "")
+}
+
+// TerminateUserSession 管理員結束指定使用者的單一工作階段 (結束所有工作階段請使用強制登出)
+func TerminateUserSession(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok || !authorizeUserManagement(c, user) {
+		return
+	}
+
+	terminateSession(c, user.ID, c.Param("sessionId"), services.SessionEndedByAdmin)
+}
+
+// respondSessions 回應使用者尚未結束的工作階段
+func respondSessions(c *gin.Context, userID uint, currentID string) {
+	sessions, err := GetTokenService().ListSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取工作階段列表"})
+		return
+	}
+
+	sessionResponses := []models.SessionResponse{}
+	for _, session := range sessions {
+		sessionResponses = append(sessionResponses, session.ToResponse(currentID))
+	}
+
+	c.JSON(http.StatusOK, sessionResponses)
+}
+
+// terminateSession 結束工作階段並回應結果
+func terminateSession(c *gin.Context, userID uint, sessionID, reason string) {
+	err := GetTokenService().TerminateSession(userID, sessionID, reason)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法結束工作階段"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "工作階段已結束"})
+}
+
+// sessionUserParam 取得路徑參數 id 對應的使用者，失敗時直接寫入錯誤響應
+func sessionUserParam(c *gin.Context) (*models.User, bool) {
+	var userID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的使用者 ID"})
+		return nil, false
+	}
+
+	user, err := GetUserRepo().GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到使用者"})
+		return nil, false
+	}
+	return user, true
+}
+
+// currentSessionID 取得本次請求 access token 所屬的工作階段 (以 API 金鑰存取時為空值)
+func currentSessionID(c *gin.Context) string {
+	if claims, ok := currentClaims(c); ok {
+		return claims.SessionID
+	}
+	return ""
+}
+
+// currentClaims 取得 AuthMiddleware 設定的 access token 聲明 (以 API 金鑰存取時為 false)
+func currentClaims(c *gin.Context) (*services.AccessClaims, bool) {
+	value, exists := c.Get("token_claims")
+	if !exists {
+		return nil, false
+	}
+
+	claims, ok := value.(*services.AccessClaims)
+	return claims, ok
+}
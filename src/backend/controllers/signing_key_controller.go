@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jwksCacheControl JWKS 允許快取的時間，需小於 JWT_KEY_PREPUBLISH 才能在新金鑰啟用前取得
+const jwksCacheControl = "public, max-age=300"
+
+// GetJWKS 公開 JWT 驗證金鑰 (JWKS)，供前端與其他服務驗證 access token
+func GetJWKS(c *gin.Context) {
+	set, err := GetKeyRingService().JWKS()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法取得簽章金鑰"})
+		return
+	}
+
+	c.Header("Cache-Control", jwksCacheControl)
+	c.JSON(http.StatusOK, set)
+}
+
+// GetSigningKeys 取得金鑰環中的簽章金鑰資訊
+func GetSigningKeys(c *gin.Context) {
+	keys, err := GetKeyRingService().Keys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法取得簽章金鑰"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RotateSigningKey 立即輪替簽章金鑰，舊金鑰在重疊期間內仍可驗證
+// 確定金鑰外洩時使用 revoke=true，舊金鑰立即停止驗證，以其簽署的 access token 需以 refresh token 重新取得
+func RotateSigningKey(c *gin.Context) {
+	var query struct {
+		Revoke bool `form:"revoke"`
+	}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := GetKeyRingService().Rotate(query.Revoke)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法輪替簽章金鑰"})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
@@ -1,12 +1,19 @@
 package controllers
 
 import (
+	"erp/db"
+	"erp/middleware"
 	"erp/models"
+	"erp/services"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 // CreateUser 建立新使用者
@@ -17,68 +24,78 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
-	// 雜湊密碼
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法處理密碼"})
-		return
+	// 建立使用者；由管理員設定密碼的帳號視為已驗證，需要受邀者自行設定密碼時改用邀請
+	now := time.Now()
+	user := models.User{
+		Username:        input.Username,
+		Email:           input.Email,
+		EmailVerifiedAt: &now,
 	}
 
-	// 建立使用者
-	user := models.User{
-		Username: input.Username,
-		Email:    input.Email,
-		Password: string(hashedPassword),
+	// 依密碼政策檢查並雜湊密碼
+	if err := GetPasswordPolicyService().SetPassword(&user, input.Password); err != nil {
+		respondPasswordError(c, err)
+		return
 	}
 
-	// 設置等級：如果沒有指定，默認為 "user"
+	// 設置等級：如果沒有指定，默認為 "user"；不可高於建立者自己的等級
 	if input.Level != "" {
 		user.Level = input.Level
 	} else {
 		user.Level = "user"
 	}
+	actor, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if err := GetUserPolicyService().CheckAssignableLevel(actor, user.Level); err != nil {
+		respondUserPolicyError(c, err)
+		return
+	}
 
-	err = GetUserRepo().Create(&user)
+	err := GetUserRepo().Create(&user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法建立使用者"})
 		return
 	}
 
+	if err := GetPasswordPolicyService().RecordHistory(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法記錄密碼歷史"})
+		return
+	}
+
 	// 回傳新建立的使用者資訊
-	c.JSON(http.StatusCreated, models.UserResponse{
-		ID:          user.ID,
-		Username:    user.Username,
-		Email:       user.Email,
-		Level:       user.Level,
-		LastLoginAt: user.LastLoginAt,
-		CreatedAt:   user.CreatedAt,
-		UpdatedAt:   user.UpdatedAt,
-	})
+	c.JSON(http.StatusCreated, user.ToResponse())
 }
 
-// GetUsers 取得所有使用者
+// GetUsers 分頁查詢使用者，可依等級、服務帳號、建立時間與關鍵字 (帳號、電子郵件) 篩選
 func GetUsers(c *gin.Context) {
-	users, err := GetUserRepo().GetAll()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取使用者列表"})
+	var query models.UserListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !authorizeUserListQuery(c, query) {
+		return
+	}
+
+	users, page, err := GetUserRepo().List(query)
+	if respondListError(c, err, "無法獲取使用者列表") {
 		return
 	}
 
-	// 轉換為 UserResponse 以隱藏密碼等敏感資訊
-	var userResponses []models.UserResponse
-	for _, user := range users {
-		userResponses = append(userResponses, models.UserResponse{
-			ID:          user.ID,
-			Username:    user.Username,
-			Email:       user.Email,
-			Level:       user.Level,
-			LastLoginAt: user.LastLoginAt,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.UpdatedAt,
-		})
+	// 轉換為 UserResponse 以隱藏密碼等敏感資訊，並附帶個人資料
+	userResponses, err := userResponsesWithProfiles(users)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取個人資料"})
+		return
 	}
 
-	c.JSON(http.StatusOK, userResponses)
+	c.JSON(http.StatusOK, gin.H{
+		"users":      userResponses,
+		"pagination": page,
+	})
 }
 
 // GetUserByID 根據 ID 取得特定使用者
@@ -98,15 +115,12 @@ func GetUserByID(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, models.UserResponse{
-		ID:          user.ID,
-		Username:    user.Username,
-		Email:       user.Email,
-		Level:       user.Level,
-		LastLoginAt: user.LastLoginAt,
-		CreatedAt:   user.CreatedAt,
-		UpdatedAt:   user.UpdatedAt,
-	})
+	responses, err := userResponsesWithProfiles([]models.User{*user})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取個人資料"})
+		return
+	}
+	c.JSON(http.StatusOK, responses[0])
 }
 
 // UpdateUser 更新使用者資訊
@@ -134,60 +148,136 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// 管理員可以管理等級不高於自己的使用者；其他人只能修改自己的使用者名稱與電子郵件
+	actor, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	managing := GetUserPolicyService().CanManage(actor, user) == nil
+	if !managing {
+		if actor.ID != user.ID {
+			respondUserPolicyError(c, services.ErrUserManagementForbidden)
+			return
+		}
+		if input.Password != nil || input.Level != nil || input.AuthBackends != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "只能修改自己的使用者名稱與電子郵件，變更密碼請使用 /api/me/password"})
+			return
+		}
+	}
+
+	// 模擬登入期間不可變更登入憑證與等級
+	if _, impersonating := middleware.CurrentImpersonatorID(c); impersonating {
+		if err := GetUserPolicyService().CheckImpersonatedUpdate(&input); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// 修改自己的密碼與 /api/me/password 相同，必須提供目前的密碼
+	if input.Password != nil && actor.ID == user.ID {
+		if input.CurrentPassword == nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(*input.CurrentPassword)) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "目前的密碼錯誤"})
+			return
+		}
+	}
+
+	// 密碼或等級變更時，先前簽發的令牌必須全部失效
+	invalidateTokens := false
+	passwordChanged := false
+	emailChanged := false
+	var columns []string
+
 	// 更新使用者欄位
-	if input.Username != nil {
+	if input.Username != nil && *input.Username != user.Username {
+		if taken, err := userFieldTaken(GetUserRepo().GetByUsername(*input.Username)); err != nil || taken {
+			respondFieldTaken(c, err, "使用者名稱已被使用")
+			return
+		}
 		user.Username = *input.Username
+		columns = append(columns, "username")
 	}
-	if input.Email != nil {
-		user.Email = *input.Email
+	if input.Email != nil && *input.Email != user.Email {
+		if taken, err := userFieldTaken(GetUserRepo().GetByEmail(*input.Email)); err != nil || taken {
+			respondFieldTaken(c, err, "電子郵件已被使用")
+			return
+		}
+	}
+	if input.Email != nil && (*input.Email != user.Email || user.PendingEmail != "") {
+		// 新的電子郵件驗證後才生效
+		emailColumns, verify := changeEmail(user, *input.Email)
+		columns = append(columns, emailColumns...)
+		emailChanged = verify
 	}
 	if input.Password != nil {
-		// 如果提供了新密碼，則進行雜湊處理
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*input.Password), bcrypt.DefaultCost)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "無法處理密碼"})
+		// 如果提供了新密碼，依密碼政策檢查後進行雜湊處理 (使用更新後的名稱與信箱比對)
+		if err := GetPasswordPolicyService().SetPassword(user, *input.Password); err != nil {
+			respondPasswordError(c, err)
 			return
 		}
-		user.Password = string(hashedPassword)
+		invalidateTokens = true
+		passwordChanged = true
+		columns = append(columns, "password", "password_changed_at")
 	}
-	if input.Level != nil {
-		// 檢查權限：只有管理員或超級管理員可以修改等級
-		currentUserLevel, exists := c.Get("level")
-		if !exists || (currentUserLevel != "admin" && currentUserLevel != "super_admin") {
-			c.JSON(http.StatusForbidden, gin.H{"error": "只有管理員或超級管理員可以修改使用者等級"})
+	if input.Level != nil && *input.Level != user.Level {
+		// 不可升級到高於自己的等級，也不可降級最後一位超級管理員
+		if err := GetUserPolicyService().CheckLevelChange(actor, user, *input.Level); err != nil {
+			respondUserPolicyError(c, err)
 			return
 		}
+		invalidateTokens = true
+		user.Level = *input.Level
+		columns = append(columns, "level")
+	}
+
+	if input.AuthBackends != nil {
+		authBackends := ""
+		if *input.AuthBackends != "" {
+			normalized, err := GetAuthenticationService().NormalizeOrder(*input.AuthBackends)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "available": GetAuthenticationService().Backends()})
+				return
+			}
+			authBackends = normalized
+		}
+		user.AuthBackends = authBackends
+		columns = append(columns, "auth_backends")
+	}
 
-		// 禁止修改最高管理員的等級 (ID=1 且等級為 super_admin)
-		if user.ID == 1 && user.Level == "super_admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "無法修改系統最高管理員的等級"})
+	// 儲存變更 (只寫入有變更的欄位)；變更等級時在同一個交易中確認不會降級最後一位超級管理員
+	if len(columns) > 0 {
+		update := GetUserRepo().Update
+		if slices.Contains(columns, "level") {
+			update = GetUserPolicyService().Update
+		}
+		if err := update(user, columns...); err != nil {
+			if errors.Is(err, services.ErrLastSuperAdmin) {
+				respondUserPolicyError(c, err)
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "無法更新使用者"})
 			return
 		}
+	}
 
-		// 驗證等級值
-		if *input.Level != "super_admin" && *input.Level != "admin" && *input.Level != "user" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "無效的等級值，只能是 'super_admin', 'admin' 或 'user'"})
+	if passwordChanged {
+		if err := GetPasswordPolicyService().RecordHistory(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "無法記錄密碼歷史"})
 			return
 		}
+	}
 
-		user.Level = *input.Level
+	if invalidateTokens {
+		if err := GetTokenService().InvalidateUserTokens(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "無法撤銷使用者令牌"})
+			return
+		}
 	}
 
-	// 儲存變更
-	if err := GetUserRepo().Update(user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法更新使用者"})
-		return
+	if emailChanged {
+		sendEmailVerification(user, actor)
 	}
 
-	c.JSON(http.StatusOK, models.UserResponse{
-		ID:          user.ID,
-		Username:    user.Username,
-		Email:       user.Email,
-		Level:       user.Level,
-		LastLoginAt: user.LastLoginAt,
-		CreatedAt:   user.CreatedAt,
-		UpdatedAt:   user.UpdatedAt,
-	})
+	c.JSON(http.StatusOK, user.ToResponse())
 }
 
 // DeleteUser 刪除使用者
@@ -208,17 +298,309 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
-	// 檢查權限：禁止刪除最高管理員 (ID=1 且角色為 admin)
-	if user.ID == 1 && user.Level == "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "無法刪除系統最高管理員"})
+	// 管理員不可刪除超級管理員，也不可刪除最後一位超級管理員
+	actor, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if err := GetUserPolicyService().CheckDelete(actor, user); err != nil {
+		respondUserPolicyError(c, err)
 		return
 	}
 
-	// 執行軟刪除
-	if err := GetUserRepo().Delete(userID); err != nil {
+	// 執行軟刪除 (在同一個交易中確認不是最後一位超級管理員)
+	if err := GetUserPolicyService().Delete(user); err != nil {
+		if errors.Is(err, services.ErrLastSuperAdmin) {
+			respondUserPolicyError(c, err)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除使用者"})
 		return
 	}
 
+	// 撤銷已刪除使用者的所有令牌
+	if err := GetTokenService().InvalidateUserTokens(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法撤銷使用者令牌"})
+		return
+	}
+
+	// 服務帳號的 API 金鑰一併撤銷
+	if user.ServiceAccount {
+		if err := GetAPIKeyService().RevokeAll(userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "無法撤銷 API 金鑰"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "使用者已成功刪除"})
 }
+
+// ForceLogoutUser 強制登出使用者，使其所有已簽發的令牌立即失效
+func ForceLogoutUser(c *gin.Context) {
+	id := c.Param("id")
+
+	// 將 string ID 轉換為 uint
+	var userID uint
+	if _, err := fmt.Sscanf(id, "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的使用者 ID"})
+		return
+	}
+
+	// 檢查使用者是否存在
+	user, err := GetUserRepo().GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到使用者"})
+		return
+	}
+	if !authorizeUserManagement(c, user) {
+		return
+	}
+
+	if err := GetTokenService().InvalidateUserTokens(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法撤銷使用者令牌"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "使用者已被強制登出"})
+}
+
+// UnlockUser 解除使用者的登入鎖定並清除失敗次數
+func UnlockUser(c *gin.Context) {
+	id := c.Param("id")
+
+	// 將 string ID 轉換為 uint
+	var userID uint
+	if _, err := fmt.Sscanf(id, "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的使用者 ID"})
+		return
+	}
+
+	user, err := GetUserRepo().GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到使用者"})
+		return
+	}
+	if !authorizeUserManagement(c, user) {
+		return
+	}
+
+	if err := GetLoginGuardService().Unlock(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法解除鎖定"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user.ToResponse())
+}
+
+// SetUserAllowedNetworks 設定使用者的來源網路允許清單 (CIDR)，空陣列代表取消限制
+func SetUserAllowedNetworks(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+	if !authorizeUserManagement(c, user) {
+		return
+	}
+
+	var input models.NetworkListInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	networks, err := models.ParseNetworkList(input.Networks)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 避免管理員把自己鎖在目前的網路之外
+	if currentID, _ := middleware.CurrentUserID(c); currentID == user.ID && !networks.Allows(c.ClientIP()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "允許清單必須包含目前的來源 IP"})
+		return
+	}
+
+	user.AllowedNetworks = networks
+	if err := GetUserRepo().Update(user, "allowed_networks"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法更新來源網路限制"})
+		return
+	}
+	GetTokenService().RefreshUserState(user.ID)
+
+	c.JSON(http.StatusOK, user.ToResponse())
+}
+
+// SuspendUser 停權使用者，可指定到期時間 (到期後自動恢復)
+func SuspendUser(c *gin.Context) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+	actor, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var input models.SuspendUserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := GetUserStatusService().Suspend(actor, user, input.Reason, input.Until); err != nil {
+		respondUserStatusError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user.ToResponse())
+}
+
+// LockUser 因安全疑慮鎖定使用者，需管理員恢復
+func LockUser(c *gin.Context) {
+	changeUserStatus(c, GetUserStatusService().Lock)
+}
+
+// ArchiveUser 封存使用者 (例如離職)
+func ArchiveUser(c *gin.Context) {
+	changeUserStatus(c, GetUserStatusService().Archive)
+}
+
+// ReactivateUser 恢復停權、鎖定或封存的使用者
+func ReactivateUser(c *gin.Context) {
+	changeUserStatus(c, GetUserStatusService().Reactivate)
+}
+
+// RestoreUser 還原已刪除的使用者
+func RestoreUser(c *gin.Context) {
+	var userID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的使用者 ID"})
+		return
+	}
+
+	user, err := GetUserRepo().GetDeletedByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到已刪除的使用者"})
+		return
+	}
+	actor, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := GetUserStatusService().Restore(actor, user); err != nil {
+		respondUserStatusError(c, err)
+		return
+	}
+
+	user, err = GetUserRepo().GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法讀取使用者"})
+		return
+	}
+	c.JSON(http.StatusOK, user.ToResponse())
+}
+
+// changeUserStatus 依 :id 與選填的原因變更使用者帳號狀態
+func changeUserStatus(c *gin.Context, change func(actor, target *models.User, reason string) error) {
+	user, ok := sessionUserParam(c)
+	if !ok {
+		return
+	}
+	actor, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	// 原因為選填，允許不帶 body
+	var input models.UserStatusInput
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := change(actor, user, input.Reason); err != nil {
+		respondUserStatusError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user.ToResponse())
+}
+
+// authorizeUserManagement 檢查目前使用者可以管理 target，失敗時直接寫入錯誤響應
+func authorizeUserManagement(c *gin.Context, target *models.User) bool {
+	actor, ok := currentUser(c)
+	if !ok {
+		return false
+	}
+	if err := GetUserPolicyService().CanManage(actor, target); err != nil {
+		respondUserPolicyError(c, err)
+		return false
+	}
+	return true
+}
+
+// respondUserPolicyError 回應違反使用者管理政策的錯誤
+func respondUserPolicyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidLevel):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUserManagementForbidden), errors.Is(err, services.ErrLevelEscalation), errors.Is(err, services.ErrLastSuperAdmin),
+		errors.Is(err, services.ErrOwnStatusChange):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法檢查使用者管理權限"})
+	}
+}
+
+// respondUserStatusError 回應帳號狀態變更失敗
+func respondUserStatusError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidStatusTransition):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidSuspensionEnd):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到已刪除的使用者"})
+	case errors.Is(err, services.ErrInvalidLevel), errors.Is(err, services.ErrUserManagementForbidden),
+		errors.Is(err, services.ErrLevelEscalation), errors.Is(err, services.ErrLastSuperAdmin), errors.Is(err, services.ErrOwnStatusChange):
+		respondUserPolicyError(c, err)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法變更帳號狀態"})
+	}
+}
+
+// respondPasswordError 回應密碼設定失敗；不符合密碼政策時列出所有違反的規則
+func respondPasswordError(c *gin.Context, err error) {
+	var policyErr *services.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密碼不符合安全政策", "violations": policyErr.Violations})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "無法處理密碼"})
+}
+
+// authorizeUserListQuery 已刪除 (可還原) 的使用者只有管理員可以查看，與還原相同
+func authorizeUserListQuery(c *gin.Context, query models.UserListQuery) bool {
+	if !query.Deleted {
+		return true
+	}
+	level, _ := c.Get("level")
+	if levelStr, _ := level.(string); models.LevelRank(levelStr) < models.LevelRank("admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "需要更高的權限"})
+		return false
+	}
+	return true
+}
+
+// respondListError 將列表查詢錯誤轉換為 HTTP 響應，回傳是否已寫入響應
+func respondListError(c *gin.Context, err error, message string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, db.ErrInvalidSort), errors.Is(err, db.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+	return true
+}
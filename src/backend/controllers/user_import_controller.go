@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"bytes"
+	"erp/middleware"
+	"erp/models"
+	"erp/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 匯入檔案大小上限
+const maxUserImportFileSize = 5 << 20
+
+// 匯入匯出檔案的 Content-Type
+var userFileContentTypes = map[string]string{
+	models.UserFileFormatCSV:  "text/csv; charset=utf-8",
+	models.UserFileFormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// ImportUsers 以 CSV 或 XLSX 批次邀請使用者 (欄位 username, email, level, roles)
+// dry_run=true 時只檢查並回報逐列錯誤；正式匯入時全部通過驗證才會在同一個交易中建立
+func ImportUsers(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUserImportFileSize)
+
+	var input models.UserImportInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "請上傳匯入檔案 (file)"})
+		return
+	}
+	format := input.Format
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(fileHeader.Filename)), ".")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidUserFile.Error()})
+		return
+	}
+	defer file.Close()
+
+	actor, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	// 指定角色與分配角色需要相同的權限
+	canAssignRoles := middleware.HasPermission(c, "system.roles.manage")
+	result, err := GetUserImportService().Import(format, file, actor, canAssignRoles, input.DryRun)
+	switch {
+	case errors.Is(err, services.ErrUnsupportedUserFileFormat), errors.Is(err, services.ErrInvalidUserFile):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUserImportInvalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "result": result})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法匯入使用者"})
+	case input.DryRun:
+		c.JSON(http.StatusOK, result)
+	default:
+		c.JSON(http.StatusCreated, result)
+	}
+}
+
+// ExportUsers 匯出使用者為 CSV 或 XLSX，篩選與排序條件同使用者列表，格式可直接再匯入
+func ExportUsers(c *gin.Context) {
+	var query models.UserExportQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if query.Format == "" {
+		query.Format = models.UserFileFormatCSV
+	}
+
+	var buf bytes.Buffer
+	err := GetUserImportService().Export(query.UserListQuery, query.Format, &buf)
+	if respondListError(c, err, "無法匯出使用者") {
+		return
+	}
+
+	filename := fmt.Sprintf("users-%s.%s", time.Now().Format("20060102"), query.Format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, userFileContentTypes[query.Format], buf.Bytes())
+}
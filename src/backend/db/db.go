@@ -1,12 +1,16 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"erp/models"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -43,6 +47,14 @@ func (db *DB) Close() error {
 	return sqlDB.Close()
 }
 
+// WithTransaction 在同一個資料庫交易中執行 fn，fn 回傳錯誤時全部回滾
+// fn 內應以 tx 建立 repository，例如 NewUserRepository(tx)
+func (db *DB) WithTransaction(fn func(tx *DB) error) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		return fn(&DB{tx})
+	})
+}
+
 // TestConnection 測試資料庫連接
 func (db *DB) TestConnection() error {
 	sqlDB, err := db.DB.DB()
@@ -59,8 +71,21 @@ func (db *DB) TestConnection() error {
 }
 
 // AutoMigrate 自動建立或更新資料表 schema
-func (db *DB) AutoMigrate(models ...interface{}) error {
-	return db.DB.AutoMigrate(models...)
+func (db *DB) AutoMigrate(values ...interface{}) error {
+	// 加入電子郵件驗證欄位前建立的使用者視為已驗證，避免升級後無法登入
+	migrator := db.DB.Migrator()
+	backfillEmailVerified := migrator.HasTable(&models.User{}) && !migrator.HasColumn(&models.User{}, "EmailVerifiedAt")
+
+	if err := db.DB.AutoMigrate(values...); err != nil {
+		return err
+	}
+
+	if backfillEmailVerified && migrator.HasColumn(&models.User{}, "EmailVerifiedAt") {
+		return db.DB.Model(&models.User{}).Unscoped().
+			Where("email_verified_at IS NULL").
+			Update("email_verified_at", gorm.Expr("created_at")).Error
+	}
+	return nil
 }
 
 // === Repository 介面定義 ===
@@ -69,10 +94,22 @@ func (db *DB) AutoMigrate(models ...interface{}) error {
 type UserRepository interface {
 	Create(user *models.User) error
 	GetByUsername(username string) (*models.User, error)
+	GetByEmail(email string) (*models.User, error)
 	GetByID(id uint) (*models.User, error)
 	GetAll() ([]models.User, error)
-	Update(user *models.User) error
+	List(query models.UserListQuery) ([]models.User, models.ListPage, error)
+	Update(user *models.User, columns ...string) error
+	UpdateUnlessLastActive(user *models.User, level string, now time.Time, columns ...string) (bool, error)
 	Delete(id uint) error
+	DeleteUnlessLastActive(id uint, level string, now time.Time) (bool, error)
+	GetServiceAccounts() ([]models.User, error)
+	CountActiveByLevel(level string, now time.Time) (int64, error)
+	GetDeletedByID(id uint) (*models.User, error)
+	Restore(id uint) error
+	IncrementTokenVersion(id uint) error
+	RecordLoginFailure(id uint, at time.Time, maxAttempts int, lockUntil time.Time) (*models.User, error)
+	ClearLoginFailures(id uint, attempts int, at time.Time) (bool, error)
+	ResetLoginFailures(id uint) error
 }
 
 // RoleRepository 角色資料存取介面
@@ -81,6 +118,7 @@ type RoleRepository interface {
 	GetByID(id uint) (*models.Role, error)
 	GetByName(name string) (*models.Role, error)
 	GetAll() ([]models.Role, error)
+	List(query models.RoleListQuery) ([]models.Role, models.ListPage, error)
 	Update(role *models.Role) error
 	Delete(id uint) error
 }
@@ -91,6 +129,7 @@ type PermissionRepository interface {
 	GetByID(id uint) (*models.Permission, error)
 	GetByCode(code string) (*models.Permission, error)
 	GetAll() ([]models.Permission, error)
+	List(query models.PermissionListQuery) ([]models.Permission, models.ListPage, error)
 	GetByModule(module string) ([]models.Permission, error)
 	Update(permission *models.Permission) error
 	Delete(id uint) error
@@ -110,10 +149,187 @@ type UserRoleRepository interface {
 	Create(userRole *models.UserRole) error
 	Delete(userID, roleID uint) error
 	GetRolesByUserID(userID uint) ([]models.Role, error)
+	GetRoleNamesByUserIDs(userIDs []uint) (map[uint][]string, error)
 	GetUsersByRoleID(roleID uint) ([]models.User, error)
 	Exists(userID, roleID uint) (bool, error)
 }
 
+// RefreshTokenRepository 刷新令牌資料存取介面
+type RefreshTokenRepository interface {
+	Create(token *models.RefreshToken) error
+	GetByHash(tokenHash string) (*models.RefreshToken, error)
+	MarkUsed(id uint, usedAt time.Time) (bool, error)
+	RevokeFamily(familyID string, revokedAt time.Time) error
+	RevokeAllByUserID(userID uint, revokedAt time.Time) error
+}
+
+// SessionRepository 登入工作階段資料存取介面
+type SessionRepository interface {
+	Create(session *models.Session) error
+	GetByID(id string) (*models.Session, error)
+	GetActiveByUserID(userID uint, now time.Time) ([]models.Session, error)
+	UpdateActivity(id, ipAddress, userAgent string, lastSeenAt, expiresAt time.Time) (bool, error)
+	Touch(id string, lastSeenAt time.Time) error
+	Revoke(id string, revokedAt time.Time, reason string) error
+	RevokeAllByUserID(userID uint, revokedAt time.Time, reason string) error
+	DeleteExpired(before time.Time) error
+}
+
+// RevokedTokenRepository 已撤銷 access token 資料存取介面
+type RevokedTokenRepository interface {
+	Create(token *models.RevokedToken) error
+	GetActive(now time.Time) ([]models.RevokedToken, error)
+	DeleteExpired(before time.Time) error
+}
+
+// SigningKeyRepository JWT 簽章金鑰資料存取介面
+type SigningKeyRepository interface {
+	Create(key *models.SigningKey) error
+	GetValid(now time.Time) ([]models.SigningKey, error)
+	RetireOthers(keepID uint, expiresAt time.Time) error
+	RevokeOthers(keepID uint, revokedAt time.Time) error
+	DeleteExpired(before time.Time) error
+}
+
+// PasswordResetTokenRepository 密碼重設令牌資料存取介面
+type PasswordResetTokenRepository interface {
+	Create(token *models.PasswordResetToken) error
+	GetByHash(tokenHash string) (*models.PasswordResetToken, error)
+	MarkUsed(id uint, usedAt time.Time) (bool, error)
+	InvalidateByUserID(userID uint, at time.Time) error
+}
+
+// UserInvitationRepository 使用者邀請資料存取介面
+type UserInvitationRepository interface {
+	Create(invitation *models.UserInvitation) error
+	GetByHash(tokenHash string) (*models.UserInvitation, error)
+	MarkAccepted(id uint, acceptedAt time.Time) (bool, error)
+	RevokeByUserID(userID uint, revokedAt time.Time) (int64, error)
+}
+
+// PasswordHistoryRepository 密碼歷史資料存取介面
+type PasswordHistoryRepository interface {
+	Create(history *models.PasswordHistory) error
+	GetRecentByUserID(userID uint, limit int) ([]models.PasswordHistory, error)
+	PruneByUserID(userID uint, keep int) error
+}
+
+// UserIdentityRepository 外部身分資料存取介面
+type UserIdentityRepository interface {
+	Create(identity *models.UserIdentity) error
+	GetByProviderSubject(provider, subject string) (*models.UserIdentity, error)
+	GetByUserID(userID uint) ([]models.UserIdentity, error)
+	UpdateLastLogin(id uint, at time.Time) error
+}
+
+// OIDCLoginStateRepository OIDC 授權狀態資料存取介面
+type OIDCLoginStateRepository interface {
+	Create(state *models.OIDCLoginState) error
+	Consume(stateHash string) (*models.OIDCLoginState, error)
+	DeleteExpired(before time.Time) error
+}
+
+// OIDCLoginCodeRepository 單一登入交換碼資料存取介面
+type OIDCLoginCodeRepository interface {
+	Create(code *models.OIDCLoginCode) error
+	Consume(codeHash string) (*models.OIDCLoginCode, error)
+	DeleteExpired(before time.Time) error
+}
+
+// APIKeyRepository API 金鑰資料存取介面
+type APIKeyRepository interface {
+	Create(key *models.APIKey) error
+	GetByID(id uint) (*models.APIKey, error)
+	GetByPrefix(prefix string) (*models.APIKey, error)
+	GetByUserID(userID uint) ([]models.APIKey, error)
+	Revoke(id uint, at time.Time) error
+	RevokeAllByUserID(userID uint, at time.Time) error
+	UpdateLastUsed(id uint, at time.Time, ip string) error
+}
+
+// ImpersonationAuditRepository 模擬登入稽核紀錄資料存取介面
+type ImpersonationAuditRepository interface {
+	Create(entry *models.ImpersonationAudit) error
+	UpdateStatus(id uint, statusCode int) error
+	GetByUserID(userID uint, limit int) ([]models.ImpersonationAudit, error)
+}
+
+// LoginEventRepository 登入事件資料存取介面
+type LoginEventRepository interface {
+	Create(event *models.LoginEvent) error
+	List(query models.LoginEventQuery) ([]models.LoginEvent, int64, error)
+	DeleteBefore(before time.Time) (int64, error)
+}
+
+// UserMFARepository 兩步驟驗證設定資料存取介面
+type UserMFARepository interface {
+	GetByUserID(userID uint) (*models.UserMFA, error)
+	Save(mfa *models.UserMFA) error
+	Delete(userID uint) error
+	UpdateLastUsedStep(userID uint, step int64) (bool, error)
+}
+
+// UserProfileRepository 使用者個人資料存取介面
+type UserProfileRepository interface {
+	GetByUserID(userID uint) (*models.UserProfile, error)
+	GetByUserIDs(userIDs []uint) (map[uint]*models.UserProfile, error)
+	GetByEmployeeNumber(employeeNumber string) (*models.UserProfile, error)
+	GetManagerID(userID uint) (*uint, error)
+	Save(profile *models.UserProfile) error
+}
+
+// DepartmentRepository 部門資料存取介面
+type DepartmentRepository interface {
+	Create(department *models.Department) error
+	GetByID(id uint) (*models.Department, error)
+	GetAll() ([]models.Department, error)
+	GetSubtree(id uint) ([]models.Department, error)
+	GetByManagerID(userID uint) ([]models.Department, error)
+	Update(department *models.Department) error
+	Move(department *models.Department, parent *models.Department) error
+	Delete(id uint) error
+	CountChildren(id uint) (int64, error)
+	NameExists(parentID *uint, name string, excludeID uint) (bool, error)
+}
+
+// UserDepartmentRepository 使用者所屬部門資料存取介面
+type UserDepartmentRepository interface {
+	GetByUserID(userID uint) ([]models.UserDepartmentResponse, error)
+	GetPrimaryByUserIDs(userIDs []uint) (map[uint]models.DepartmentSummary, error)
+	ReplaceForUser(userID uint, memberships []models.UserDepartment) error
+	CountByDepartmentID(departmentID uint) (int64, error)
+	GetUserIDs(departmentID uint, subDepartments, primaryOnly bool) ([]uint, error)
+	HasUser(departmentID, userID uint, subDepartments bool) (bool, error)
+}
+
+// MFARecoveryCodeRepository 兩步驟驗證備用碼資料存取介面
+type MFARecoveryCodeRepository interface {
+	ReplaceForUser(userID uint, codes []models.MFARecoveryCode) error
+	GetUnusedByUserID(userID uint) ([]models.MFARecoveryCode, error)
+	MarkUsed(id uint, usedAt time.Time) (bool, error)
+	DeleteByUserID(userID uint) error
+}
+
+// WebAuthnCredentialRepository 通行金鑰資料存取介面
+type WebAuthnCredentialRepository interface {
+	Create(credential *models.WebAuthnCredential) error
+	GetByUserID(userID uint) ([]models.WebAuthnCredential, error)
+	GetByCredentialID(credentialID string) (*models.WebAuthnCredential, error)
+	CountByUserID(userID uint) (int64, error)
+	RecordUse(id uint, previousCount, signCount uint32, backupState bool, usedAt time.Time) (bool, error)
+	MarkCloned(id uint) error
+	Rename(userID, id uint, name string) error
+	Delete(userID, id uint) error
+	DeleteByUserID(userID uint) error
+}
+
+// WebAuthnCeremonyRepository WebAuthn 儀式狀態資料存取介面
+type WebAuthnCeremonyRepository interface {
+	Create(ceremony *models.WebAuthnCeremony) error
+	Consume(tokenHash string) (*models.WebAuthnCeremony, error)
+	DeleteExpired(before time.Time) error
+}
+
 // === Repository 實作 ===
 
 // userRepository 使用者資料存取實作
@@ -141,6 +357,16 @@ func (r *userRepository) GetByUsername(username string) (*models.User, error) {
 	return &user, nil
 }
 
+// GetByEmail 根據電子郵件獲取使用者
+func (r *userRepository) GetByEmail(email string) (*models.User, error) {
+	var user models.User
+	err := r.db.DB.Where("email = ?", email).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 // GetByID 根據 ID 獲取使用者
 func (r *userRepository) GetByID(id uint) (*models.User, error) {
 	var user models.User
@@ -158,9 +384,165 @@ func (r *userRepository) GetAll() ([]models.User, error) {
 	return users, err
 }
 
-// Update 更新使用者
-func (r *userRepository) Update(user *models.User) error {
-	return r.db.DB.Save(user).Error
+// userListSpec 使用者列表可排序與搜尋的欄位
+var userListSpec = listSpec[models.User]{
+	columns: map[string]listColumn[models.User]{
+		"id":         {"id", sortInt, func(u *models.User) interface{} { return u.ID }},
+		"username":   {"username", sortString, func(u *models.User) interface{} { return u.Username }},
+		"email":      {"email", sortString, func(u *models.User) interface{} { return u.Email }},
+		"level":      {"level", sortString, func(u *models.User) interface{} { return u.Level }},
+		"created_at": {"created_at", sortTime, func(u *models.User) interface{} { return u.CreatedAt }},
+		"updated_at": {"updated_at", sortTime, func(u *models.User) interface{} { return u.UpdatedAt }},
+	},
+	defaultSort: "id",
+	// 顯示名稱存放在個人資料表，以子查詢比對
+	search: []string{
+		"username",
+		"email",
+		"(SELECT display_name_zh FROM user_profiles WHERE user_profiles.user_id = users.id)",
+		"(SELECT display_name_en FROM user_profiles WHERE user_profiles.user_id = users.id)",
+	},
+}
+
+// List 依條件分頁查詢使用者
+func (r *userRepository) List(query models.UserListQuery) ([]models.User, models.ListPage, error) {
+	tx := r.db.DB.Model(&models.User{})
+	if query.Deleted {
+		tx = tx.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if levels := splitList(query.Level); len(levels) > 0 {
+		tx = tx.Where("level IN ?", levels)
+	}
+	if statuses := splitList(query.Status); len(statuses) > 0 {
+		conditions := make([]string, 0, len(statuses))
+		args := make([]interface{}, 0, len(statuses))
+		for _, status := range statuses {
+			condition, conditionArgs := userStatusCondition(status, time.Now())
+			conditions = append(conditions, condition)
+			args = append(args, conditionArgs...)
+		}
+		tx = tx.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+	if query.ServiceAccount != nil {
+		tx = tx.Where("service_account = ?", *query.ServiceAccount)
+	}
+	if query.DepartmentID != nil {
+		members := departmentMemberQuery(r.db.DB, *query.DepartmentID, query.SubDepartments, query.PrimaryOnly)
+		tx = tx.Where("id IN (?)", members)
+	}
+	return listPage(tx, userListSpec, query.ListQuery)
+}
+
+// GetServiceAccounts 獲取所有服務帳號
+func (r *userRepository) GetServiceAccounts() ([]models.User, error) {
+	var users []models.User
+	err := r.db.DB.Where("service_account = ?", true).Find(&users).Error
+	return users, err
+}
+
+// userStatusCondition 依實際帳號狀態篩選的條件，停權已到期視為 active (與 User.EffectiveStatus 一致)
+func userStatusCondition(status string, now time.Time) (string, []interface{}) {
+	switch status {
+	case models.UserStatusActive:
+		return "(status = ? OR (status = ? AND suspended_until <= ?))", []interface{}{models.UserStatusActive, models.UserStatusSuspended, now}
+	case models.UserStatusSuspended:
+		return "(status = ? AND (suspended_until IS NULL OR suspended_until > ?))", []interface{}{models.UserStatusSuspended, now}
+	}
+	return "status = ?", []interface{}{status}
+}
+
+// CountActiveByLevel 計算指定等級且目前可登入 (實際狀態為 active) 的使用者數量
+func (r *userRepository) CountActiveByLevel(level string, now time.Time) (int64, error) {
+	var count int64
+	condition, args := userStatusCondition(models.UserStatusActive, now)
+	err := r.db.DB.Model(&models.User{}).Where("level = ?", level).Where(condition, args...).Count(&count).Error
+	return count, err
+}
+
+// GetDeletedByID 根據 ID 獲取已軟刪除的使用者
+func (r *userRepository) GetDeletedByID(id uint) (*models.User, error) {
+	var user models.User
+	err := r.db.DB.Unscoped().Where("deleted_at IS NOT NULL").First(&user, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Restore 還原已軟刪除的使用者
+func (r *userRepository) Restore(id uint) error {
+	result := r.db.DB.Unscoped().Model(&models.User{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Update 只更新使用者的指定欄位 (資料庫欄位名稱)，不以整列覆寫
+// 避免把同時進行的令牌版本遞增、登入失敗紀錄或鎖定改回請求開始時讀到的舊值
+func (r *userRepository) Update(user *models.User, columns ...string) error {
+	if err := checkUserColumns(columns); err != nil {
+		return err
+	}
+	return r.db.DB.Model(user).Select(columns).Updates(user).Error
+}
+
+// UpdateUnlessLastActive 與 Update 相同，但在同一個交易中先鎖定所有可登入的 level 使用者；
+// 使用者是其中唯一的一位時不寫入並回傳 false，讓同時進行的降級或停用依序檢查
+func (r *userRepository) UpdateUnlessLastActive(user *models.User, level string, now time.Time, columns ...string) (bool, error) {
+	if err := checkUserColumns(columns); err != nil {
+		return false, err
+	}
+	updated := false
+	err := r.db.WithTransaction(func(tx *DB) error {
+		last, err := lockLastActiveByLevel(tx, user.ID, level, now)
+		if err != nil || last {
+			return err
+		}
+		updated = true
+		return tx.DB.Model(user).Select(columns).Updates(user).Error
+	})
+	return updated, err
+}
+
+// checkUserColumns 檢查 Update 指定的欄位
+func checkUserColumns(columns []string) error {
+	if len(columns) == 0 {
+		return errors.New("未指定要更新的使用者欄位")
+	}
+	for _, column := range columns {
+		if protectedUserColumns[column] {
+			return fmt.Errorf("欄位 %s 只能以專用方法更新", column)
+		}
+	}
+	return nil
+}
+
+// lockLastActiveByLevel 以 SELECT ... FOR UPDATE 鎖定所有可登入的 level 使用者，回傳 id 是否為其中唯一的一位
+// 等待鎖定期間被其他交易降級或停用的列會在取得鎖定後重新比對條件而排除
+func lockLastActiveByLevel(tx *DB, id uint, level string, now time.Time) (bool, error) {
+	var ids []uint
+	condition, args := userStatusCondition(models.UserStatusActive, now)
+	err := tx.DB.Model(&models.User{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("level = ?", level).Where(condition, args...).
+		Order("id").Pluck("id", &ids).Error
+	if err != nil {
+		return false, err
+	}
+	return len(ids) == 1 && ids[0] == id, nil
+}
+
+// protectedUserColumns 由專用方法以條件更新維護的欄位，不可經由 Update 以讀取後寫回的方式覆寫
+var protectedUserColumns = map[string]bool{
+	"token_version":         true,
+	"failed_login_attempts": true,
+	"last_failed_login_at":  true,
+	"locked_until":          true,
 }
 
 // Delete 刪除使用者
@@ -168,6 +550,72 @@ func (r *userRepository) Delete(id uint) error {
 	return r.db.DB.Delete(&models.User{}, id).Error
 }
 
+// DeleteUnlessLastActive 與 Delete 相同，但使用者是唯一可登入的 level 使用者時不刪除並回傳 false (見 UpdateUnlessLastActive)
+func (r *userRepository) DeleteUnlessLastActive(id uint, level string, now time.Time) (bool, error) {
+	deleted := false
+	err := r.db.WithTransaction(func(tx *DB) error {
+		last, err := lockLastActiveByLevel(tx, id, level, now)
+		if err != nil || last {
+			return err
+		}
+		deleted = true
+		return tx.DB.Delete(&models.User{}, id).Error
+	})
+	return deleted, err
+}
+
+// IncrementTokenVersion 遞增使用者的令牌版本，使先前簽發的令牌全部失效
+func (r *userRepository) IncrementTokenVersion(id uint) error {
+	return r.db.DB.Model(&models.User{}).Where("id = ?", id).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error
+}
+
+// RecordLoginFailure 累加連續登入失敗次數，累加後達到 maxAttempts 時在同一個 UPDATE 內鎖定到 lockUntil
+// 鎖定期間不累加；上次的鎖定已過期時重新開始計算。回傳更新後的失敗次數與鎖定狀態
+func (r *userRepository) RecordLoginFailure(id uint, at time.Time, maxAttempts int, lockUntil time.Time) (*models.User, error) {
+	// UPDATE 的運算式讀取的是更新前的欄位值
+	attempts := "CASE WHEN locked_until IS NULL THEN failed_login_attempts + 1 ELSE 1 END"
+	err := r.db.DB.Model(&models.User{}).
+		Where("id = ? AND (locked_until IS NULL OR locked_until <= ?)", id, at).
+		UpdateColumns(map[string]interface{}{
+			"failed_login_attempts": gorm.Expr(attempts),
+			"locked_until":          gorm.Expr("CASE WHEN "+attempts+" >= ? THEN ? ELSE NULL END", maxAttempts, lockUntil),
+			"last_failed_login_at":  at,
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = r.db.DB.Select("id", "failed_login_attempts", "last_failed_login_at", "locked_until").First(&user, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ClearLoginFailures 登入成功時清除失敗紀錄，只有在失敗次數仍為 attempts 且未被鎖定時才會清除
+// 回傳 false 代表檢查後又有其他登入失敗或帳號已被鎖定 (例如同時進行的暴力破解嘗試)
+func (r *userRepository) ClearLoginFailures(id uint, attempts int, at time.Time) (bool, error) {
+	result := r.db.DB.Model(&models.User{}).
+		Where("id = ? AND failed_login_attempts = ? AND (locked_until IS NULL OR locked_until <= ?)", id, attempts, at).
+		UpdateColumns(map[string]interface{}{
+			"failed_login_attempts": 0,
+			"last_failed_login_at":  nil,
+			"locked_until":          nil,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// ResetLoginFailures 清除登入失敗次數與鎖定狀態
+func (r *userRepository) ResetLoginFailures(id uint) error {
+	return r.db.DB.Model(&models.User{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"last_failed_login_at":  nil,
+		"locked_until":          nil,
+	}).Error
+}
+
 // === Role Repository 實作 ===
 
 // roleRepository 角色資料存取實作
@@ -212,6 +660,32 @@ func (r *roleRepository) GetAll() ([]models.Role, error) {
 	return roles, err
 }
 
+// roleListSpec 角色列表可排序與搜尋的欄位
+var roleListSpec = listSpec[models.Role]{
+	columns: map[string]listColumn[models.Role]{
+		"id":           {"id", sortInt, func(r *models.Role) interface{} { return r.ID }},
+		"name":         {"name", sortString, func(r *models.Role) interface{} { return r.Name }},
+		"display_name": {"display_name", sortString, func(r *models.Role) interface{} { return r.DisplayName }},
+		"status":       {"status", sortString, func(r *models.Role) interface{} { return r.Status }},
+		"created_at":   {"created_at", sortTime, func(r *models.Role) interface{} { return r.CreatedAt }},
+		"updated_at":   {"updated_at", sortTime, func(r *models.Role) interface{} { return r.UpdatedAt }},
+	},
+	defaultSort: "id",
+	search:      []string{"name", "display_name", "description"},
+}
+
+// List 依條件分頁查詢角色
+func (r *roleRepository) List(query models.RoleListQuery) ([]models.Role, models.ListPage, error) {
+	tx := r.db.DB.Model(&models.Role{})
+	if statuses := splitList(query.Status); len(statuses) > 0 {
+		tx = tx.Where("status IN ?", statuses)
+	}
+	if query.IsSystem != nil {
+		tx = tx.Where("is_system = ?", *query.IsSystem)
+	}
+	return listPage(tx, roleListSpec, query.ListQuery)
+}
+
 // Update 更新角色
 func (r *roleRepository) Update(role *models.Role) error {
 	return r.db.DB.Save(role).Error
@@ -266,6 +740,37 @@ func (r *permissionRepository) GetAll() ([]models.Permission, error) {
 	return permissions, err
 }
 
+// permissionListSpec 權限列表可排序與搜尋的欄位
+var permissionListSpec = listSpec[models.Permission]{
+	columns: map[string]listColumn[models.Permission]{
+		"id":           {"id", sortInt, func(p *models.Permission) interface{} { return p.ID }},
+		"code":         {"code", sortString, func(p *models.Permission) interface{} { return p.Code }},
+		"module":       {"module_name", sortString, func(p *models.Permission) interface{} { return p.ModuleName }},
+		"resource":     {"resource", sortString, func(p *models.Permission) interface{} { return p.Resource }},
+		"action":       {"action", sortString, func(p *models.Permission) interface{} { return p.Action }},
+		"display_name": {"display_name", sortString, func(p *models.Permission) interface{} { return p.DisplayName }},
+		"status":       {"status", sortString, func(p *models.Permission) interface{} { return p.Status }},
+		"created_at":   {"created_at", sortTime, func(p *models.Permission) interface{} { return p.CreatedAt }},
+	},
+	defaultSort: "code",
+	search:      []string{"code", "display_name", "description"},
+}
+
+// List 依條件分頁查詢權限
+func (r *permissionRepository) List(query models.PermissionListQuery) ([]models.Permission, models.ListPage, error) {
+	tx := r.db.DB.Model(&models.Permission{})
+	if modules := splitList(query.Module); len(modules) > 0 {
+		tx = tx.Where("module_name IN ?", modules)
+	}
+	if query.Resource != "" {
+		tx = tx.Where("resource = ?", query.Resource)
+	}
+	if statuses := splitList(query.Status); len(statuses) > 0 {
+		tx = tx.Where("status IN ?", statuses)
+	}
+	return listPage(tx, permissionListSpec, query.ListQuery)
+}
+
 // GetByModule 根據模組獲取權限
 func (r *permissionRepository) GetByModule(module string) ([]models.Permission, error) {
 	var permissions []models.Permission
@@ -359,6 +864,31 @@ func (r *userRoleRepository) GetRolesByUserID(userID uint) ([]models.Role, error
 	return roles, err
 }
 
+// GetRoleNamesByUserIDs 一次取得多個使用者的角色名稱 (依名稱排序)
+func (r *userRoleRepository) GetRoleNamesByUserIDs(userIDs []uint) (map[uint][]string, error) {
+	var rows []struct {
+		UserID uint
+		Name   string
+	}
+	names := make(map[uint][]string)
+	if len(userIDs) == 0 {
+		return names, nil
+	}
+	err := r.db.DB.Table("user_roles").
+		Select("user_roles.user_id, roles.name").
+		Joins("JOIN roles ON roles.id = user_roles.role_id AND roles.deleted_at IS NULL").
+		Where("user_roles.user_id IN ?", userIDs).
+		Order("roles.name").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		names[row.UserID] = append(names[row.UserID], row.Name)
+	}
+	return names, nil
+}
+
 // GetUsersByRoleID 根據角色 ID 獲取使用者列表
 func (r *userRoleRepository) GetUsersByRoleID(roleID uint) ([]models.User, error) {
 	var users []models.User
@@ -374,3 +904,1098 @@ func (r *userRoleRepository) Exists(userID, roleID uint) (bool, error) {
 		Where("user_id = ? AND role_id = ?", userID, roleID).Count(&count).Error
 	return count > 0, err
 }
+
+// === RefreshToken Repository 實作 ===
+
+// refreshTokenRepository 刷新令牌資料存取實作
+type refreshTokenRepository struct {
+	db *DB
+}
+
+// NewRefreshTokenRepository 建立刷新令牌 repository
+func NewRefreshTokenRepository(db *DB) RefreshTokenRepository {
+	return &refreshTokenRepository{db: db}
+}
+
+// Create 建立刷新令牌
+func (r *refreshTokenRepository) Create(token *models.RefreshToken) error {
+	return r.db.DB.Create(token).Error
+}
+
+// GetByHash 根據令牌雜湊值獲取刷新令牌
+func (r *refreshTokenRepository) GetByHash(tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.db.DB.Where("token_hash = ?", tokenHash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed 將尚未使用的刷新令牌標記為已使用，回傳是否成功標記
+// 以條件更新確保同一令牌在併發請求下只能被輪替一次
+func (r *refreshTokenRepository) MarkUsed(id uint, usedAt time.Time) (bool, error) {
+	result := r.db.DB.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", usedAt)
+	return result.RowsAffected > 0, result.Error
+}
+
+// RevokeFamily 撤銷同一家族中所有尚未撤銷的刷新令牌
+func (r *refreshTokenRepository) RevokeFamily(familyID string, revokedAt time.Time) error {
+	return r.db.DB.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", revokedAt).Error
+}
+
+// RevokeAllByUserID 撤銷使用者所有尚未撤銷的刷新令牌
+func (r *refreshTokenRepository) RevokeAllByUserID(userID uint, revokedAt time.Time) error {
+	return r.db.DB.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", revokedAt).Error
+}
+
+// === Session Repository 實作 ===
+
+// sessionRepository 登入工作階段資料存取實作
+type sessionRepository struct {
+	db *DB
+}
+
+// NewSessionRepository 建立登入工作階段 repository
+func NewSessionRepository(db *DB) SessionRepository {
+	return &sessionRepository{db: db}
+}
+
+// Create 建立工作階段
+func (r *sessionRepository) Create(session *models.Session) error {
+	return r.db.DB.Create(session).Error
+}
+
+// GetByID 根據 ID 獲取工作階段
+func (r *sessionRepository) GetByID(id string) (*models.Session, error) {
+	var session models.Session
+	err := r.db.DB.Where("id = ?", id).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetActiveByUserID 獲取使用者尚未結束的工作階段，最近使用的在前
+func (r *sessionRepository) GetActiveByUserID(userID uint, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// UpdateActivity 刷新令牌後更新工作階段的來源與到期時間，回傳工作階段是否存在
+func (r *sessionRepository) UpdateActivity(id, ipAddress, userAgent string, lastSeenAt, expiresAt time.Time) (bool, error) {
+	result := r.db.DB.Model(&models.Session{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"ip_address":   ipAddress,
+			"user_agent":   userAgent,
+			"last_seen_at": lastSeenAt,
+			"expires_at":   expiresAt,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Touch 更新工作階段最後使用時間
+func (r *sessionRepository) Touch(id string, lastSeenAt time.Time) error {
+	return r.db.DB.Model(&models.Session{}).
+		Where("id = ?", id).
+		Update("last_seen_at", lastSeenAt).Error
+}
+
+// Revoke 結束尚未撤銷的工作階段
+func (r *sessionRepository) Revoke(id string, revokedAt time.Time, reason string) error {
+	return r.db.DB.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{"revoked_at": revokedAt, "revoked_reason": reason}).Error
+}
+
+// RevokeAllByUserID 結束使用者所有尚未撤銷的工作階段
+func (r *sessionRepository) RevokeAllByUserID(userID uint, revokedAt time.Time, reason string) error {
+	return r.db.DB.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{"revoked_at": revokedAt, "revoked_reason": reason}).Error
+}
+
+// DeleteExpired 刪除在指定時間前已過期的工作階段
+func (r *sessionRepository) DeleteExpired(before time.Time) error {
+	return r.db.DB.Where("expires_at <= ?", before).Delete(&models.Session{}).Error
+}
+
+// === RevokedToken Repository 實作 ===
+
+// revokedTokenRepository 已撤銷 access token 資料存取實作
+type revokedTokenRepository struct {
+	db *DB
+}
+
+// NewRevokedTokenRepository 建立已撤銷 access token repository
+func NewRevokedTokenRepository(db *DB) RevokedTokenRepository {
+	return &revokedTokenRepository{db: db}
+}
+
+// Create 將 access token 加入撤銷清單，重複撤銷時忽略
+func (r *revokedTokenRepository) Create(token *models.RevokedToken) error {
+	return r.db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(token).Error
+}
+
+// GetActive 獲取尚未過期的撤銷紀錄
+func (r *revokedTokenRepository) GetActive(now time.Time) ([]models.RevokedToken, error) {
+	var tokens []models.RevokedToken
+	err := r.db.DB.Where("expires_at > ?", now).Find(&tokens).Error
+	return tokens, err
+}
+
+// DeleteExpired 刪除在指定時間前已過期的撤銷紀錄
+func (r *revokedTokenRepository) DeleteExpired(before time.Time) error {
+	return r.db.DB.Where("expires_at <= ?", before).Delete(&models.RevokedToken{}).Error
+}
+
+// === SigningKey Repository 實作 ===
+
+// signingKeyRepository JWT 簽章金鑰資料存取實作
+type signingKeyRepository struct {
+	db *DB
+}
+
+// NewSigningKeyRepository 建立 JWT 簽章金鑰 repository
+func NewSigningKeyRepository(db *DB) SigningKeyRepository {
+	return &signingKeyRepository{db: db}
+}
+
+// Create 建立簽章金鑰
+func (r *signingKeyRepository) Create(key *models.SigningKey) error {
+	return r.db.DB.Create(key).Error
+}
+
+// GetValid 獲取仍可用於驗證的金鑰 (包含尚未啟用的下一把金鑰)，依啟用時間排序
+func (r *signingKeyRepository) GetValid(now time.Time) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := r.db.DB.Where("expires_at IS NULL OR expires_at > ?", now).Order("activates_at ASC, id ASC").Find(&keys).Error
+	return keys, err
+}
+
+// RetireOthers 為指定金鑰以外尚未設定到期時間的金鑰設定停止驗證的時間
+func (r *signingKeyRepository) RetireOthers(keepID uint, expiresAt time.Time) error {
+	return r.db.DB.Model(&models.SigningKey{}).
+		Where("id <> ? AND expires_at IS NULL", keepID).
+		Update("expires_at", expiresAt).Error
+}
+
+// RevokeOthers 讓指定金鑰以外所有仍可驗證的金鑰 (包含重疊期間中的舊金鑰) 立即停止驗證
+func (r *signingKeyRepository) RevokeOthers(keepID uint, revokedAt time.Time) error {
+	return r.db.DB.Model(&models.SigningKey{}).
+		Where("id <> ? AND (expires_at IS NULL OR expires_at > ?)", keepID, revokedAt).
+		Update("expires_at", revokedAt).Error
+}
+
+// DeleteExpired 刪除在指定時間前已停止驗證的金鑰
+func (r *signingKeyRepository) DeleteExpired(before time.Time) error {
+	return r.db.DB.Where("expires_at <= ?", before).Delete(&models.SigningKey{}).Error
+}
+
+// === PasswordResetToken Repository 實作 ===
+
+// passwordResetTokenRepository 密碼重設令牌資料存取實作
+type passwordResetTokenRepository struct {
+	db *DB
+}
+
+// NewPasswordResetTokenRepository 建立密碼重設令牌 repository
+func NewPasswordResetTokenRepository(db *DB) PasswordResetTokenRepository {
+	return &passwordResetTokenRepository{db: db}
+}
+
+// Create 建立密碼重設令牌
+func (r *passwordResetTokenRepository) Create(token *models.PasswordResetToken) error {
+	return r.db.DB.Create(token).Error
+}
+
+// GetByHash 根據令牌雜湊值獲取密碼重設令牌
+func (r *passwordResetTokenRepository) GetByHash(tokenHash string) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	err := r.db.DB.Where("token_hash = ?", tokenHash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed 將尚未使用的令牌標記為已使用，回傳是否成功標記
+func (r *passwordResetTokenRepository) MarkUsed(id uint, usedAt time.Time) (bool, error) {
+	result := r.db.DB.Model(&models.PasswordResetToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt)
+	return result.RowsAffected > 0, result.Error
+}
+
+// InvalidateByUserID 使使用者所有尚未使用的密碼重設令牌失效
+func (r *passwordResetTokenRepository) InvalidateByUserID(userID uint, at time.Time) error {
+	return r.db.DB.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", at).Error
+}
+
+// === UserInvitation Repository 實作 ===
+
+// userInvitationRepository 使用者邀請資料存取實作
+type userInvitationRepository struct {
+	db *DB
+}
+
+// NewUserInvitationRepository 建立使用者邀請 repository
+func NewUserInvitationRepository(db *DB) UserInvitationRepository {
+	return &userInvitationRepository{db: db}
+}
+
+// Create 建立使用者邀請
+func (r *userInvitationRepository) Create(invitation *models.UserInvitation) error {
+	return r.db.DB.Create(invitation).Error
+}
+
+// GetByHash 根據令牌雜湊值獲取使用者邀請
+func (r *userInvitationRepository) GetByHash(tokenHash string) (*models.UserInvitation, error) {
+	var invitation models.UserInvitation
+	err := r.db.DB.Where("token_hash = ?", tokenHash).First(&invitation).Error
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// MarkAccepted 將尚未使用且未撤銷的邀請標記為已接受，回傳是否成功標記
+func (r *userInvitationRepository) MarkAccepted(id uint, acceptedAt time.Time) (bool, error) {
+	result := r.db.DB.Model(&models.UserInvitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id).
+		Update("accepted_at", acceptedAt)
+	return result.RowsAffected > 0, result.Error
+}
+
+// RevokeByUserID 撤銷使用者所有尚未接受的邀請，回傳撤銷筆數
+func (r *userInvitationRepository) RevokeByUserID(userID uint, revokedAt time.Time) (int64, error) {
+	result := r.db.DB.Model(&models.UserInvitation{}).
+		Where("user_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", userID, revokedAt).
+		Update("revoked_at", revokedAt)
+	return result.RowsAffected, result.Error
+}
+
+// === PasswordHistory Repository 實作 ===
+
+// passwordHistoryRepository 密碼歷史資料存取實作
+type passwordHistoryRepository struct {
+	db *DB
+}
+
+// NewPasswordHistoryRepository 建立密碼歷史 repository
+func NewPasswordHistoryRepository(db *DB) PasswordHistoryRepository {
+	return &passwordHistoryRepository{db: db}
+}
+
+// Create 建立密碼歷史紀錄
+func (r *passwordHistoryRepository) Create(history *models.PasswordHistory) error {
+	return r.db.DB.Create(history).Error
+}
+
+// GetRecentByUserID 獲取使用者最近的密碼歷史 (新到舊)
+func (r *passwordHistoryRepository) GetRecentByUserID(userID uint, limit int) ([]models.PasswordHistory, error) {
+	var histories []models.PasswordHistory
+	err := r.db.DB.Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&histories).Error
+	return histories, err
+}
+
+// PruneByUserID 只保留使用者最近 keep 筆密碼歷史，其餘刪除
+func (r *passwordHistoryRepository) PruneByUserID(userID uint, keep int) error {
+	var keepIDs []uint
+	err := r.db.DB.Model(&models.PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(keep).
+		Pluck("id", &keepIDs).Error
+	if err != nil || len(keepIDs) == 0 {
+		return err
+	}
+	return r.db.DB.Where("user_id = ? AND id NOT IN ?", userID, keepIDs).
+		Delete(&models.PasswordHistory{}).Error
+}
+
+// === UserIdentity Repository 實作 ===
+
+// userIdentityRepository 外部身分資料存取實作
+type userIdentityRepository struct {
+	db *DB
+}
+
+// NewUserIdentityRepository 建立外部身分 repository
+func NewUserIdentityRepository(db *DB) UserIdentityRepository {
+	return &userIdentityRepository{db: db}
+}
+
+// Create 建立外部身分
+func (r *userIdentityRepository) Create(identity *models.UserIdentity) error {
+	return r.db.DB.Create(identity).Error
+}
+
+// GetByProviderSubject 根據提供者與 subject 獲取外部身分
+func (r *userIdentityRepository) GetByProviderSubject(provider, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := r.db.DB.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// GetByUserID 獲取使用者綁定的所有外部身分
+func (r *userIdentityRepository) GetByUserID(userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := r.db.DB.Where("user_id = ?", userID).Find(&identities).Error
+	return identities, err
+}
+
+// UpdateLastLogin 更新外部身分的最後登入時間
+func (r *userIdentityRepository) UpdateLastLogin(id uint, at time.Time) error {
+	return r.db.DB.Model(&models.UserIdentity{}).Where("id = ?", id).Update("last_login_at", at).Error
+}
+
+// === OIDCLoginState Repository 實作 ===
+
+// oidcLoginStateRepository OIDC 授權狀態資料存取實作
+type oidcLoginStateRepository struct {
+	db *DB
+}
+
+// NewOIDCLoginStateRepository 建立 OIDC 授權狀態 repository
+func NewOIDCLoginStateRepository(db *DB) OIDCLoginStateRepository {
+	return &oidcLoginStateRepository{db: db}
+}
+
+// Create 建立 OIDC 授權狀態
+func (r *oidcLoginStateRepository) Create(state *models.OIDCLoginState) error {
+	return r.db.DB.Create(state).Error
+}
+
+// Consume 取出並刪除授權狀態，確保同一個 state 只能使用一次
+func (r *oidcLoginStateRepository) Consume(stateHash string) (*models.OIDCLoginState, error) {
+	var state models.OIDCLoginState
+	result := r.db.DB.Clauses(clause.Returning{}).
+		Where("state_hash = ?", stateHash).
+		Delete(&state)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &state, nil
+}
+
+// DeleteExpired 刪除在指定時間前已過期的授權狀態
+func (r *oidcLoginStateRepository) DeleteExpired(before time.Time) error {
+	return r.db.DB.Where("expires_at <= ?", before).Delete(&models.OIDCLoginState{}).Error
+}
+
+// === OIDCLoginCode Repository 實作 ===
+
+// oidcLoginCodeRepository 單一登入交換碼資料存取實作
+type oidcLoginCodeRepository struct {
+	db *DB
+}
+
+// NewOIDCLoginCodeRepository 建立單一登入交換碼 repository
+func NewOIDCLoginCodeRepository(db *DB) OIDCLoginCodeRepository {
+	return &oidcLoginCodeRepository{db: db}
+}
+
+// Create 建立交換碼
+func (r *oidcLoginCodeRepository) Create(code *models.OIDCLoginCode) error {
+	return r.db.DB.Create(code).Error
+}
+
+// Consume 取出並刪除交換碼，確保同一個交換碼只能使用一次
+func (r *oidcLoginCodeRepository) Consume(codeHash string) (*models.OIDCLoginCode, error) {
+	var code models.OIDCLoginCode
+	result := r.db.DB.Clauses(clause.Returning{}).
+		Where("code_hash = ?", codeHash).
+		Delete(&code)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &code, nil
+}
+
+// DeleteExpired 刪除在指定時間前已過期的交換碼
+func (r *oidcLoginCodeRepository) DeleteExpired(before time.Time) error {
+	return r.db.DB.Where("expires_at <= ?", before).Delete(&models.OIDCLoginCode{}).Error
+}
+
+// === APIKey Repository 實作 ===
+
+// apiKeyRepository API 金鑰資料存取實作
+type apiKeyRepository struct {
+	db *DB
+}
+
+// NewAPIKeyRepository 建立 API 金鑰 repository
+func NewAPIKeyRepository(db *DB) APIKeyRepository {
+	return &apiKeyRepository{db: db}
+}
+
+// Create 建立 API 金鑰
+func (r *apiKeyRepository) Create(key *models.APIKey) error {
+	return r.db.DB.Create(key).Error
+}
+
+// GetByID 根據 ID 獲取 API 金鑰
+func (r *apiKeyRepository) GetByID(id uint) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.DB.First(&key, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetByPrefix 根據識別前綴獲取 API 金鑰
+func (r *apiKeyRepository) GetByPrefix(prefix string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.DB.Where("prefix = ?", prefix).First(&key).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetByUserID 獲取服務帳號的所有 API 金鑰
+func (r *apiKeyRepository) GetByUserID(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// Revoke 撤銷 API 金鑰，已撤銷 (或已排定撤銷時間) 較早的金鑰不受影響
+func (r *apiKeyRepository) Revoke(id uint, at time.Time) error {
+	return r.db.DB.Model(&models.APIKey{}).
+		Where("id = ? AND (revoked_at IS NULL OR revoked_at > ?)", id, at).
+		Update("revoked_at", at).Error
+}
+
+// RevokeAllByUserID 撤銷服務帳號所有尚未撤銷的 API 金鑰
+func (r *apiKeyRepository) RevokeAllByUserID(userID uint, at time.Time) error {
+	return r.db.DB.Model(&models.APIKey{}).
+		Where("user_id = ? AND (revoked_at IS NULL OR revoked_at > ?)", userID, at).
+		Update("revoked_at", at).Error
+}
+
+// UpdateLastUsed 記錄 API 金鑰最後使用的時間與來源 IP
+func (r *apiKeyRepository) UpdateLastUsed(id uint, at time.Time, ip string) error {
+	return r.db.DB.Model(&models.APIKey{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"last_used_at": at, "last_used_ip": ip}).Error
+}
+
+// === ImpersonationAudit Repository 實作 ===
+
+// impersonationAuditRepository 模擬登入稽核紀錄資料存取實作
+type impersonationAuditRepository struct {
+	db *DB
+}
+
+// NewImpersonationAuditRepository 建立模擬登入稽核紀錄 repository
+func NewImpersonationAuditRepository(db *DB) ImpersonationAuditRepository {
+	return &impersonationAuditRepository{db: db}
+}
+
+// Create 建立稽核紀錄
+func (r *impersonationAuditRepository) Create(entry *models.ImpersonationAudit) error {
+	return r.db.DB.Create(entry).Error
+}
+
+// UpdateStatus 請求處理完成後寫入回應狀態碼
+func (r *impersonationAuditRepository) UpdateStatus(id uint, statusCode int) error {
+	return r.db.DB.Model(&models.ImpersonationAudit{}).
+		Where("id = ?", id).
+		Update("status_code", statusCode).Error
+}
+
+// GetByUserID 獲取使用者作為被模擬者或操作者的稽核紀錄，最新的在前
+func (r *impersonationAuditRepository) GetByUserID(userID uint, limit int) ([]models.ImpersonationAudit, error) {
+	var entries []models.ImpersonationAudit
+	err := r.db.DB.Where("user_id = ? OR impersonator_id = ?", userID, userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&entries).Error
+	return entries, err
+}
+
+// === LoginEvent Repository 實作 ===
+
+// loginEventRepository 登入事件資料存取實作
+type loginEventRepository struct {
+	db *DB
+}
+
+// NewLoginEventRepository 建立登入事件 repository
+func NewLoginEventRepository(db *DB) LoginEventRepository {
+	return &loginEventRepository{db: db}
+}
+
+// Create 建立登入事件
+func (r *loginEventRepository) Create(event *models.LoginEvent) error {
+	return r.db.DB.Create(event).Error
+}
+
+// List 依條件查詢登入事件，回傳該頁資料與符合條件的總筆數，最新的在前
+func (r *loginEventRepository) List(query models.LoginEventQuery) ([]models.LoginEvent, int64, error) {
+	tx := r.db.DB.Model(&models.LoginEvent{})
+	if query.UserID != nil {
+		tx = tx.Where("user_id = ?", *query.UserID)
+	}
+	if query.Username != "" {
+		tx = tx.Where("username = ?", query.Username)
+	}
+	if query.Success != nil {
+		tx = tx.Where("success = ?", *query.Success)
+	}
+	if query.IPAddress != "" {
+		tx = tx.Where("ip_address = ?", query.IPAddress)
+	}
+	if query.Since != nil {
+		tx = tx.Where("created_at >= ?", *query.Since)
+	}
+	if query.Until != nil {
+		tx = tx.Where("created_at < ?", *query.Until)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []models.LoginEvent
+	err := tx.Order("created_at DESC, id DESC").
+		Limit(query.Limit).
+		Offset(query.Offset).
+		Find(&events).Error
+	return events, total, err
+}
+
+// DeleteBefore 刪除指定時間前的登入事件，回傳刪除筆數
+func (r *loginEventRepository) DeleteBefore(before time.Time) (int64, error) {
+	result := r.db.DB.Where("created_at < ?", before).Delete(&models.LoginEvent{})
+	return result.RowsAffected, result.Error
+}
+
+// === UserMFA Repository 實作 ===
+
+// userMFARepository 兩步驟驗證設定資料存取實作
+type userMFARepository struct {
+	db *DB
+}
+
+// NewUserMFARepository 建立兩步驟驗證設定 repository
+func NewUserMFARepository(db *DB) UserMFARepository {
+	return &userMFARepository{db: db}
+}
+
+// GetByUserID 根據使用者 ID 獲取兩步驟驗證設定
+func (r *userMFARepository) GetByUserID(userID uint) (*models.UserMFA, error) {
+	var mfa models.UserMFA
+	err := r.db.DB.Where("user_id = ?", userID).First(&mfa).Error
+	if err != nil {
+		return nil, err
+	}
+	return &mfa, nil
+}
+
+// Save 建立或更新兩步驟驗證設定
+func (r *userMFARepository) Save(mfa *models.UserMFA) error {
+	return r.db.DB.Save(mfa).Error
+}
+
+// Delete 刪除兩步驟驗證設定
+func (r *userMFARepository) Delete(userID uint) error {
+	return r.db.DB.Where("user_id = ?", userID).Delete(&models.UserMFA{}).Error
+}
+
+// UpdateLastUsedStep 記錄最後使用的時間區間，只有比目前紀錄新的區間才會更新
+func (r *userMFARepository) UpdateLastUsedStep(userID uint, step int64) (bool, error) {
+	result := r.db.DB.Model(&models.UserMFA{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+// === UserProfile Repository 實作 ===
+
+// userProfileRepository 使用者個人資料存取實作
+type userProfileRepository struct {
+	db *DB
+}
+
+// NewUserProfileRepository 建立使用者個人資料 repository
+func NewUserProfileRepository(db *DB) UserProfileRepository {
+	return &userProfileRepository{db: db}
+}
+
+// GetByUserID 根據使用者 ID 獲取個人資料
+func (r *userProfileRepository) GetByUserID(userID uint) (*models.UserProfile, error) {
+	var profile models.UserProfile
+	err := r.db.DB.Where("user_id = ?", userID).First(&profile).Error
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// GetByUserIDs 批次獲取多位使用者的個人資料，尚未建立個人資料的使用者不會出現在結果中
+func (r *userProfileRepository) GetByUserIDs(userIDs []uint) (map[uint]*models.UserProfile, error) {
+	profiles := make(map[uint]*models.UserProfile)
+	if len(userIDs) == 0 {
+		return profiles, nil
+	}
+	var rows []models.UserProfile
+	if err := r.db.DB.Where("user_id IN ?", userIDs).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		profiles[rows[i].UserID] = &rows[i]
+	}
+	return profiles, nil
+}
+
+// GetByEmployeeNumber 根據員工編號獲取個人資料
+func (r *userProfileRepository) GetByEmployeeNumber(employeeNumber string) (*models.UserProfile, error) {
+	var profile models.UserProfile
+	err := r.db.DB.Where("employee_number = ?", employeeNumber).First(&profile).Error
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// GetManagerID 獲取使用者的直屬主管 ID，未設定時回傳空值
+func (r *userProfileRepository) GetManagerID(userID uint) (*uint, error) {
+	var profiles []models.UserProfile
+	err := r.db.DB.Select("user_id", "manager_id").Where("user_id = ?", userID).Limit(1).Find(&profiles).Error
+	if err != nil || len(profiles) == 0 {
+		return nil, err
+	}
+	return profiles[0].ManagerID, nil
+}
+
+// Save 建立或更新個人資料
+func (r *userProfileRepository) Save(profile *models.UserProfile) error {
+	return r.db.DB.Save(profile).Error
+}
+
+// === Department Repository 實作 ===
+
+// departmentRepository 部門資料存取實作
+type departmentRepository struct {
+	db *DB
+}
+
+// NewDepartmentRepository 建立部門 repository
+func NewDepartmentRepository(db *DB) DepartmentRepository {
+	return &departmentRepository{db: db}
+}
+
+// Create 建立部門，並依上層部門產生物化路徑
+func (r *departmentRepository) Create(department *models.Department) error {
+	return r.db.DB.Transaction(func(tx *gorm.DB) error {
+		parentPath := ""
+		if department.ParentID != nil {
+			var parent models.Department
+			if err := tx.First(&parent, *department.ParentID).Error; err != nil {
+				return err
+			}
+			parentPath = parent.Path
+		}
+		if err := tx.Create(department).Error; err != nil {
+			return err
+		}
+		department.Path = models.DepartmentPath(parentPath, department.ID)
+		return tx.Model(department).Update("path", department.Path).Error
+	})
+}
+
+// GetByID 根據 ID 獲取部門
+func (r *departmentRepository) GetByID(id uint) (*models.Department, error) {
+	var department models.Department
+	err := r.db.DB.First(&department, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &department, nil
+}
+
+// GetAll 獲取所有部門，依物化路徑排序 (上層部門一定排在子部門之前)
+func (r *departmentRepository) GetAll() ([]models.Department, error) {
+	var departments []models.Department
+	err := r.db.DB.Order("path").Find(&departments).Error
+	return departments, err
+}
+
+// GetSubtree 獲取部門本身與底下所有子部門，依物化路徑排序
+func (r *departmentRepository) GetSubtree(id uint) ([]models.Department, error) {
+	var departments []models.Department
+	err := r.db.DB.Where("path LIKE ?", departmentPathPattern(id)).Order("path").Find(&departments).Error
+	return departments, err
+}
+
+// GetByManagerID 獲取使用者擔任主管的部門
+func (r *departmentRepository) GetByManagerID(userID uint) ([]models.Department, error) {
+	var departments []models.Department
+	err := r.db.DB.Where("manager_id = ?", userID).Order("path").Find(&departments).Error
+	return departments, err
+}
+
+// Update 更新部門 (上層部門需透過 Move 變更)
+func (r *departmentRepository) Update(department *models.Department) error {
+	return r.db.DB.Model(department).Select("name", "manager_id", "cost_center_code").Updates(department).Error
+}
+
+// Move 將部門連同所有子部門移到 parent 底下 (parent 為空值時移到最上層)，並更新整個子樹的物化路徑
+func (r *departmentRepository) Move(department *models.Department, parent *models.Department) error {
+	parentPath := ""
+	var parentID *uint
+	if parent != nil {
+		parentPath = parent.Path
+		parentID = &parent.ID
+	}
+	oldPath := department.Path
+	newPath := models.DepartmentPath(parentPath, department.ID)
+
+	err := r.db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Department{}).
+			Where("path LIKE ?", oldPath+"%").
+			Update("path", gorm.Expr("CAST(? AS TEXT) || SUBSTR(path, ?)", newPath, len(oldPath)+1)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.Department{}).Where("id = ?", department.ID).Update("parent_id", parentID).Error
+	})
+	if err != nil {
+		return err
+	}
+	department.ParentID = parentID
+	department.Path = newPath
+	return nil
+}
+
+// Delete 刪除部門
+func (r *departmentRepository) Delete(id uint) error {
+	return r.db.DB.Delete(&models.Department{}, id).Error
+}
+
+// CountChildren 計算直屬子部門數量
+func (r *departmentRepository) CountChildren(id uint) (int64, error) {
+	var count int64
+	err := r.db.DB.Model(&models.Department{}).Where("parent_id = ?", id).Count(&count).Error
+	return count, err
+}
+
+// NameExists 檢查同一個上層部門底下是否已有相同名稱的部門 (排除 excludeID)
+func (r *departmentRepository) NameExists(parentID *uint, name string, excludeID uint) (bool, error) {
+	tx := r.db.DB.Model(&models.Department{}).Where("LOWER(name) = LOWER(?) AND id <> ?", name, excludeID)
+	if parentID == nil {
+		tx = tx.Where("parent_id IS NULL")
+	} else {
+		tx = tx.Where("parent_id = ?", *parentID)
+	}
+	var count int64
+	err := tx.Count(&count).Error
+	return count > 0, err
+}
+
+// departmentPathPattern 比對部門本身與所有子部門的物化路徑條件 (路徑包含 /id/)
+func departmentPathPattern(id uint) string {
+	return fmt.Sprintf("%%/%d/%%", id)
+}
+
+// === UserDepartment Repository 實作 ===
+
+// userDepartmentRepository 使用者所屬部門資料存取實作
+type userDepartmentRepository struct {
+	db *DB
+}
+
+// NewUserDepartmentRepository 建立使用者所屬部門 repository
+func NewUserDepartmentRepository(db *DB) UserDepartmentRepository {
+	return &userDepartmentRepository{db: db}
+}
+
+// GetByUserID 獲取使用者所屬的部門，主要部門排在最前面
+func (r *userDepartmentRepository) GetByUserID(userID uint) ([]models.UserDepartmentResponse, error) {
+	departments := []models.UserDepartmentResponse{}
+	err := r.db.DB.Table("user_departments").
+		Select("departments.id, departments.name, departments.path, departments.cost_center_code, user_departments.is_primary").
+		Joins("JOIN departments ON departments.id = user_departments.department_id").
+		Where("user_departments.user_id = ?", userID).
+		Order("user_departments.is_primary DESC, departments.path").
+		Scan(&departments).Error
+	return departments, err
+}
+
+// GetPrimaryByUserIDs 批次獲取多位使用者的主要部門，沒有主要部門的使用者不會出現在結果中
+func (r *userDepartmentRepository) GetPrimaryByUserIDs(userIDs []uint) (map[uint]models.DepartmentSummary, error) {
+	var rows []struct {
+		UserID uint
+		ID     uint
+		Name   string
+	}
+	departments := make(map[uint]models.DepartmentSummary)
+	if len(userIDs) == 0 {
+		return departments, nil
+	}
+	err := r.db.DB.Table("user_departments").
+		Select("user_departments.user_id, departments.id, departments.name").
+		Joins("JOIN departments ON departments.id = user_departments.department_id").
+		Where("user_departments.user_id IN ? AND user_departments.is_primary = ?", userIDs, true).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		departments[row.UserID] = models.DepartmentSummary{ID: row.ID, Name: row.Name}
+	}
+	return departments, nil
+}
+
+// ReplaceForUser 以新的部門清單取代使用者原本所屬的所有部門
+func (r *userDepartmentRepository) ReplaceForUser(userID uint, memberships []models.UserDepartment) error {
+	return r.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.UserDepartment{}).Error; err != nil {
+			return err
+		}
+		if len(memberships) == 0 {
+			return nil
+		}
+		return tx.Create(&memberships).Error
+	})
+}
+
+// CountByDepartmentID 計算部門 (不含子部門) 的成員數量
+func (r *userDepartmentRepository) CountByDepartmentID(departmentID uint) (int64, error) {
+	var count int64
+	err := r.db.DB.Model(&models.UserDepartment{}).Where("department_id = ?", departmentID).Count(&count).Error
+	return count, err
+}
+
+// GetUserIDs 獲取部門成員的使用者 ID；subDepartments 為 true 時包含所有子部門，primaryOnly 為 true 時只比對主要部門
+func (r *userDepartmentRepository) GetUserIDs(departmentID uint, subDepartments, primaryOnly bool) ([]uint, error) {
+	var userIDs []uint
+	err := departmentMemberQuery(r.db.DB, departmentID, subDepartments, primaryOnly).
+		Distinct().
+		Order("user_departments.user_id").
+		Pluck("user_departments.user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// HasUser 檢查使用者是否為部門成員；subDepartments 為 true 時包含所有子部門
+func (r *userDepartmentRepository) HasUser(departmentID, userID uint, subDepartments bool) (bool, error) {
+	var count int64
+	err := departmentMemberQuery(r.db.DB, departmentID, subDepartments, false).
+		Where("user_departments.user_id = ?", userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// departmentMemberQuery 部門成員使用者 ID 的子查詢，可用於其他查詢的 IN 條件
+func departmentMemberQuery(db *gorm.DB, departmentID uint, subDepartments, primaryOnly bool) *gorm.DB {
+	query := db.Table("user_departments").Select("user_departments.user_id")
+	if subDepartments {
+		query = query.Joins("JOIN departments ON departments.id = user_departments.department_id").
+			Where("departments.path LIKE ?", departmentPathPattern(departmentID))
+	} else {
+		query = query.Where("user_departments.department_id = ?", departmentID)
+	}
+	if primaryOnly {
+		query = query.Where("user_departments.is_primary = ?", true)
+	}
+	return query
+}
+
+// === MFARecoveryCode Repository 實作 ===
+
+// mfaRecoveryCodeRepository 兩步驟驗證備用碼資料存取實作
+type mfaRecoveryCodeRepository struct {
+	db *DB
+}
+
+// NewMFARecoveryCodeRepository 建立兩步驟驗證備用碼 repository
+func NewMFARecoveryCodeRepository(db *DB) MFARecoveryCodeRepository {
+	return &mfaRecoveryCodeRepository{db: db}
+}
+
+// ReplaceForUser 以新的備用碼取代使用者所有舊備用碼
+func (r *mfaRecoveryCodeRepository) ReplaceForUser(userID uint, codes []models.MFARecoveryCode) error {
+	return r.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// GetUnusedByUserID 獲取使用者尚未使用的備用碼
+func (r *mfaRecoveryCodeRepository) GetUnusedByUserID(userID uint) ([]models.MFARecoveryCode, error) {
+	var codes []models.MFARecoveryCode
+	err := r.db.DB.Where("user_id = ? AND used_at IS NULL", userID).Find(&codes).Error
+	return codes, err
+}
+
+// MarkUsed 將尚未使用的備用碼標記為已使用，回傳是否成功標記
+func (r *mfaRecoveryCodeRepository) MarkUsed(id uint, usedAt time.Time) (bool, error) {
+	result := r.db.DB.Model(&models.MFARecoveryCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt)
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteByUserID 刪除使用者所有備用碼
+func (r *mfaRecoveryCodeRepository) DeleteByUserID(userID uint) error {
+	return r.db.DB.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error
+}
+
+// === WebAuthnCredential Repository 實作 ===
+
+// webAuthnCredentialRepository 通行金鑰資料存取實作
+type webAuthnCredentialRepository struct {
+	db *DB
+}
+
+// NewWebAuthnCredentialRepository 建立通行金鑰 repository
+func NewWebAuthnCredentialRepository(db *DB) WebAuthnCredentialRepository {
+	return &webAuthnCredentialRepository{db: db}
+}
+
+// Create 建立通行金鑰
+func (r *webAuthnCredentialRepository) Create(credential *models.WebAuthnCredential) error {
+	return r.db.DB.Create(credential).Error
+}
+
+// GetByUserID 獲取使用者所有通行金鑰 (依建立時間排序)
+func (r *webAuthnCredentialRepository) GetByUserID(userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	err := r.db.DB.Where("user_id = ?", userID).Order("created_at, id").Find(&credentials).Error
+	return credentials, err
+}
+
+// GetByCredentialID 根據憑證 ID 獲取通行金鑰
+func (r *webAuthnCredentialRepository) GetByCredentialID(credentialID string) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	err := r.db.DB.Where("credential_id = ?", credentialID).First(&credential).Error
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// CountByUserID 計算使用者的通行金鑰數量
+func (r *webAuthnCredentialRepository) CountByUserID(userID uint) (int64, error) {
+	var count int64
+	err := r.db.DB.Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// RecordUse 記錄通行金鑰的使用，只有計數器仍為 previousCount 時才更新，避免同一個簽章被並行重放
+func (r *webAuthnCredentialRepository) RecordUse(id uint, previousCount, signCount uint32, backupState bool, usedAt time.Time) (bool, error) {
+	result := r.db.DB.Model(&models.WebAuthnCredential{}).
+		Where("id = ? AND sign_count = ? AND clone_warning = ?", id, previousCount, false).
+		Updates(map[string]interface{}{
+			"sign_count":   signCount,
+			"backup_state": backupState,
+			"last_used_at": usedAt,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// MarkCloned 標記通行金鑰疑似遭複製，之後不再接受
+func (r *webAuthnCredentialRepository) MarkCloned(id uint) error {
+	return r.db.DB.Model(&models.WebAuthnCredential{}).Where("id = ?", id).Update("clone_warning", true).Error
+}
+
+// Rename 修改使用者通行金鑰的顯示名稱
+func (r *webAuthnCredentialRepository) Rename(userID, id uint, name string) error {
+	result := r.db.DB.Model(&models.WebAuthnCredential{}).
+		Where("id = ? AND user_id = ?", id, userID).
+		Update("name", name)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete 刪除使用者的通行金鑰
+func (r *webAuthnCredentialRepository) Delete(userID, id uint) error {
+	result := r.db.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteByUserID 刪除使用者所有通行金鑰
+func (r *webAuthnCredentialRepository) DeleteByUserID(userID uint) error {
+	return r.db.DB.Where("user_id = ?", userID).Delete(&models.WebAuthnCredential{}).Error
+}
+
+// === WebAuthnCeremony Repository 實作 ===
+
+// webAuthnCeremonyRepository WebAuthn 儀式狀態資料存取實作
+type webAuthnCeremonyRepository struct {
+	db *DB
+}
+
+// NewWebAuthnCeremonyRepository 建立 WebAuthn 儀式狀態 repository
+func NewWebAuthnCeremonyRepository(db *DB) WebAuthnCeremonyRepository {
+	return &webAuthnCeremonyRepository{db: db}
+}
+
+// Create 建立儀式狀態
+func (r *webAuthnCeremonyRepository) Create(ceremony *models.WebAuthnCeremony) error {
+	return r.db.DB.Create(ceremony).Error
+}
+
+// Consume 取出並刪除儀式狀態，確保同一個挑戰值只能使用一次
+func (r *webAuthnCeremonyRepository) Consume(tokenHash string) (*models.WebAuthnCeremony, error) {
+	var ceremony models.WebAuthnCeremony
+	result := r.db.DB.Clauses(clause.Returning{}).
+		Where("token_hash = ?", tokenHash).
+		Delete(&ceremony)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &ceremony, nil
+}
+
+// DeleteExpired 刪除在指定時間前已過期的儀式狀態
+func (r *webAuthnCeremonyRepository) DeleteExpired(before time.Time) error {
+	return r.db.DB.Where("expires_at <= ?", before).Delete(&models.WebAuthnCeremony{}).Error
+}
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"erp/models"
+	"gorm.io/gorm"
+)
+
+// 列表查詢錯誤
+var (
+	ErrInvalidSort   = errors.New("無效的排序欄位")
+	ErrInvalidCursor = errors.New("無效的分頁游標")
+)
+
+// sortKind 排序欄位的資料型別，用於還原游標中的值
+type sortKind int
+
+const (
+	sortString sortKind = iota
+	sortInt
+	sortTime
+)
+
+// listColumn 可排序的欄位 (不可為 NULL，否則游標分頁會漏資料)
+type listColumn[T any] struct {
+	column string
+	kind   sortKind
+	value  func(*T) interface{}
+}
+
+// listSpec 列表查詢的欄位設定，columns 必須包含 id 作為排序的最後依據
+type listSpec[T any] struct {
+	columns     map[string]listColumn[T] // API 欄位名稱 → 資料表欄位
+	defaultSort string
+	search      []string // q 比對的欄位
+}
+
+// sortTerm 解析後的排序條件
+type sortTerm[T any] struct {
+	listColumn[T]
+	desc bool
+}
+
+// listCursor 游標內容：產生時的排序方式與上一頁最後一筆的排序欄位值
+type listCursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// listPage 套用共用的建立時間區間、關鍵字、排序與分頁條件後查詢，tx 應已套用各列表專屬的篩選條件
+func listPage[T any](tx *gorm.DB, spec listSpec[T], query models.ListQuery) ([]T, models.ListPage, error) {
+	terms, sortKey, err := spec.parseSort(query.Sort)
+	if err != nil {
+		return nil, models.ListPage{}, err
+	}
+
+	if query.CreatedSince != nil {
+		tx = tx.Where("created_at >= ?", *query.CreatedSince)
+	}
+	if query.CreatedUntil != nil {
+		tx = tx.Where("created_at < ?", *query.CreatedUntil)
+	}
+	if search := strings.TrimSpace(query.Search); search != "" && len(spec.search) > 0 {
+		pattern := "%" + escapeLike(strings.ToLower(search)) + "%"
+		conditions := make([]string, 0, len(spec.search))
+		args := make([]interface{}, 0, len(spec.search))
+		for _, column := range spec.search {
+			conditions = append(conditions, "LOWER("+column+") LIKE ? ESCAPE '\\'")
+			args = append(args, pattern)
+		}
+		tx = tx.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+
+	limit := query.Limit()
+	page := models.ListPage{PageSize: limit}
+	if err := tx.Count(&page.Total).Error; err != nil {
+		return nil, models.ListPage{}, err
+	}
+	page.TotalPages = int((page.Total + int64(limit) - 1) / int64(limit))
+
+	if query.Cursor != "" {
+		values, err := decodeCursor(query.Cursor, sortKey, terms)
+		if err != nil {
+			return nil, models.ListPage{}, err
+		}
+		condition, args := keysetCondition(terms, values)
+		tx = tx.Where("("+condition+")", args...)
+	} else {
+		page.Page = max(query.Page, 1)
+	}
+
+	orders := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if term.desc {
+			orders = append(orders, term.column+" DESC")
+		} else {
+			orders = append(orders, term.column+" ASC")
+		}
+	}
+
+	// 多取一筆判斷是否還有下一頁
+	var rows []T
+	err = tx.Order(strings.Join(orders, ", ")).
+		Limit(limit + 1).
+		Offset(query.Offset()).
+		Find(&rows).Error
+	if err != nil {
+		return nil, models.ListPage{}, err
+	}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		page.NextCursor = encodeCursor(sortKey, terms, &rows[limit-1])
+	}
+	if rows == nil {
+		rows = []T{}
+	}
+	return rows, page, nil
+}
+
+// parseSort 解析 sort 參數，未指定 id 時以 id 遞增作為最後的排序依據；回傳正規化後的排序字串供游標比對
+func (s listSpec[T]) parseSort(sort string) ([]sortTerm[T], string, error) {
+	if strings.TrimSpace(sort) == "" {
+		sort = s.defaultSort
+	}
+
+	var terms []sortTerm[T]
+	var fields []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		desc := strings.HasPrefix(field, "-")
+		name := strings.TrimPrefix(field, "-")
+		column, ok := s.columns[name]
+		if !ok || seen[name] {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidSort, name)
+		}
+		seen[name] = true
+		terms = append(terms, sortTerm[T]{listColumn: column, desc: desc})
+		fields = append(fields, field)
+	}
+	if !seen["id"] {
+		terms = append(terms, sortTerm[T]{listColumn: s.columns["id"]})
+		fields = append(fields, "id")
+	}
+	return terms, strings.Join(fields, ","), nil
+}
+
+// keysetCondition 產生「排在游標之後」的條件，例如 (a > ?) OR (a = ? AND id > ?)
+func keysetCondition[T any](terms []sortTerm[T], values []interface{}) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for i, term := range terms {
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			parts = append(parts, terms[j].column+" = ?")
+			args = append(args, values[j])
+		}
+		operator := " > ?"
+		if term.desc {
+			operator = " < ?"
+		}
+		parts = append(parts, term.column+operator)
+		args = append(args, values[i])
+		conditions = append(conditions, "("+strings.Join(parts, " AND ")+")")
+	}
+	return strings.Join(conditions, " OR "), args
+}
+
+// encodeCursor 以最後一筆資料的排序欄位值產生游標
+func encodeCursor[T any](sortKey string, terms []sortTerm[T], row *T) string {
+	cursor := listCursor{Sort: sortKey, Values: make([]string, len(terms))}
+	for i, term := range terms {
+		switch value := term.value(row).(type) {
+		case time.Time:
+			cursor.Values[i] = value.UTC().Format(time.RFC3339Nano)
+		default:
+			cursor.Values[i] = fmt.Sprint(value)
+		}
+	}
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor 還原游標中的排序欄位值，排序方式與產生游標時不同時視為無效
+func decodeCursor[T any](token, sortKey string, terms []sortTerm[T]) ([]interface{}, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.Sort != sortKey || len(cursor.Values) != len(terms) {
+		return nil, ErrInvalidCursor
+	}
+
+	values := make([]interface{}, len(terms))
+	for i, term := range terms {
+		switch term.kind {
+		case sortInt:
+			n, err := strconv.ParseInt(cursor.Values[i], 10, 64)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			values[i] = n
+		case sortTime:
+			t, err := time.Parse(time.RFC3339Nano, cursor.Values[i])
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			values[i] = t
+		default:
+			values[i] = cursor.Values[i]
+		}
+	}
+	return values, nil
+}
+
+// escapeLike 跳脫 LIKE 的萬用字元
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// splitList 解析逗號分隔的篩選值，忽略空白項目
+func splitList(s string) []string {
+	var values []string
+	for _, value := range strings.Split(s, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
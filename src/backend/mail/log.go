@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogSender 將郵件內容寫入檔案或標準輸出，不實際寄送，供本機開發使用
+type LogSender struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewLogSender 建立記錄型郵件寄送器，path 為空時輸出到標準輸出
+func NewLogSender(path string) *LogSender {
+	return &LogSender{path: path}
+}
+
+// Send 記錄郵件內容
+func (s *LogSender) Send(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var w io.Writer = os.Stdout
+	if s.path != "" {
+		f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("無法開啟郵件記錄檔: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	_, err := fmt.Fprintf(w, "=== 郵件 %s ===\nTo: %s\nSubject: %s\n\n%s\n=== 結束 ===\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	return err
+}
@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+)
+
+// Message 郵件內容
+type Message struct {
+	To      string
+	Subject string
+	Body    string // 純文字內容
+}
+
+// Sender 郵件寄送介面，可依環境替換不同實作
+type Sender interface {
+	Send(msg Message) error
+}
+
+// NewFromEnv 依環境變數 MAIL_DRIVER 建立郵件寄送器
+// smtp: 透過 SMTP 伺服器寄送；log (預設): 寫入 MAIL_LOG_FILE 或標準輸出，供本機開發使用
+func NewFromEnv() (Sender, error) {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "smtp":
+		return NewSMTPSender(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		})
+	case "", "log":
+		return NewLogSender(os.Getenv("MAIL_LOG_FILE")), nil
+	default:
+		return nil, fmt.Errorf("不支援的郵件寄送方式: %s", driver)
+	}
+}
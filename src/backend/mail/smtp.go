@@ -0,0 +1,64 @@
+package mail
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPConfig SMTP 連線設定
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPSender 透過 SMTP 伺服器寄送郵件
+type SMTPSender struct {
+	config SMTPConfig
+}
+
+// NewSMTPSender 建立 SMTP 郵件寄送器
+func NewSMTPSender(config SMTPConfig) (*SMTPSender, error) {
+	if config.Host == "" || config.From == "" {
+		return nil, fmt.Errorf("SMTP 設定不完整: 需要 SMTP_HOST 與 MAIL_FROM")
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+	return &SMTPSender{config: config}, nil
+}
+
+// Send 寄送郵件
+func (s *SMTPSender) Send(msg Message) error {
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+	}
+
+	addr := net.JoinHostPort(s.config.Host, s.config.Port)
+	if err := smtp.SendMail(addr, auth, s.config.From, []string{msg.To}, buildMessage(s.config.From, msg)); err != nil {
+		return fmt.Errorf("無法寄送郵件: %v", err)
+	}
+	return nil
+}
+
+// buildMessage 組合 RFC 5322 格式的郵件內容
+func buildMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("UTF-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
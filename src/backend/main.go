@@ -7,14 +7,24 @@ import (
 	"github.com/gin-gonic/gin"
 	"erp/db"
 	"erp/controllers"
+	"erp/mail"
+	"erp/middleware"
 	"erp/models"
 	"erp/routes"
+	"erp/services"
+	"erp/storage"
 )
 
 func main() {
 	// 創建 Gin 引擎
 	r := gin.Default()
 
+	// 只信任 TRUSTED_PROXIES 轉送的用戶端位址 (網段限制與登入節流都依 ClientIP 判斷)
+	if err := middleware.ConfigureTrustedProxies(r); err != nil {
+		fmt.Fprintf(os.Stderr, "TRUSTED_PROXIES 設定錯誤: %v\n", err)
+		os.Exit(1)
+	}
+
 	// 啟用自動重定向 - 統一處理斜線問題
 	r.RedirectTrailingSlash = true
 	r.RedirectFixedPath = true
@@ -50,7 +60,7 @@ func main() {
 	fmt.Println("資料庫連線測試成功")
 
 	// 自動建立/更新資料表 schema
-	err = database.AutoMigrate(&models.User{}, &models.Role{}, &models.Permission{}, &models.UserRole{}, &models.RolePermission{})
+	err = database.AutoMigrate(&models.User{}, &models.Role{}, &models.Permission{}, &models.UserRole{}, &models.RolePermission{}, &models.RefreshToken{}, &models.Session{}, &models.ImpersonationAudit{}, &models.RevokedToken{}, &models.SigningKey{}, &models.PasswordResetToken{}, &models.UserInvitation{}, &models.UserMFA{}, &models.MFARecoveryCode{}, &models.WebAuthnCredential{}, &models.WebAuthnCeremony{}, &models.PasswordHistory{}, &models.UserIdentity{}, &models.OIDCLoginState{}, &models.OIDCLoginCode{}, &models.APIKey{}, &models.LoginEvent{}, &models.UserProfile{}, &models.Department{}, &models.UserDepartment{})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "資料庫 migration 失敗: %v\n", err)
 		os.Exit(1)
@@ -61,13 +71,73 @@ func main() {
 	// 初始化 controllers 並注入資料庫依賴
 	controllers.SetDB(database)
 
+	// 初始化郵件寄送器 (MAIL_DRIVER=smtp 或 log)
+	mailSender, err := mail.NewFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "郵件寄送器初始化失敗: %v\n", err)
+		os.Exit(1)
+	}
+	controllers.SetMailSender(mailSender)
+
+	// 初始化檔案儲存 (STORAGE_DRIVER=local)
+	fileStorage, err := storage.NewFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "檔案儲存初始化失敗: %v\n", err)
+		os.Exit(1)
+	}
+	controllers.SetFileStorage(fileStorage)
+
+	// 將權限、令牌、兩步驟驗證、通行金鑰、API 金鑰與模擬登入服務注入中間件
+	middleware.SetPermissionService(controllers.GetPermissionService())
+	middleware.SetTokenService(controllers.GetTokenService())
+	middleware.SetMFAService(controllers.GetMFAService())
+	middleware.SetWebAuthnService(controllers.GetWebAuthnService())
+	middleware.SetAPIKeyService(controllers.GetAPIKeyService())
+	middleware.SetImpersonationService(controllers.GetImpersonationService())
+
+	// 清除已過期的 access token 撤銷紀錄
+	if err := controllers.GetTokenService().PruneRevokedTokens(); err != nil {
+		fmt.Fprintf(os.Stderr, "清除過期撤銷紀錄失敗: %v\n", err)
+	}
+	if err := controllers.GetTokenService().PruneSessions(); err != nil {
+		fmt.Fprintf(os.Stderr, "清除過期工作階段失敗: %v\n", err)
+	}
+	if _, err := controllers.GetLoginEventService().Prune(); err != nil {
+		fmt.Fprintf(os.Stderr, "清除過期登入紀錄失敗: %v\n", err)
+	}
+
+	// 載入 JWT 簽章金鑰環，沒有可用金鑰時建立第一把金鑰
+	if _, err := controllers.GetKeyRingService().Keys(); err != nil {
+		fmt.Fprintf(os.Stderr, "JWT 簽章金鑰初始化失敗: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 公開的 JWT 驗證金鑰 (JWKS)
+	routes.RegisterWellKnownRoutes(&r.RouterGroup)
+
 	// 設置 API 路由組
 	api := r.Group("/api")
 	{
 		routes.RegisterAuthRoutes(api)
 		routes.RegisterUserRoutes(api)
+		routes.RegisterMeRoutes(api)
 		routes.RegisterRoleRoutes(api)
 		routes.RegisterPermissionRoutes(api)
+		routes.RegisterServiceAccountRoutes(api)
+		routes.RegisterLoginEventRoutes(api)
+		routes.RegisterDepartmentRoutes(api)
+	}
+
+	// 將路由宣告的權限同步到資料庫（需在所有路由註冊完成後執行）
+	syncResult, err := services.SyncRegisteredPermissions(controllers.GetPermissionRepo())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "權限自動註冊失敗: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("權限自動註冊完成: 新增 %d, 更新 %d, 恢復 %d, 過期 %d\n",
+		len(syncResult.Created), len(syncResult.Updated), len(syncResult.Reactivated), len(syncResult.Stale))
+	for _, code := range syncResult.Stale {
+		fmt.Fprintf(os.Stderr, "權限 %s 已不在任何路由中，標記為 stale\n", code)
 	}
 
 	// 啟動伺服器，監聽 8000 端口
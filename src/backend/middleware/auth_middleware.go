@@ -1,17 +1,69 @@
 package middleware
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
+	"erp/models"
+	"erp/services"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
+// 令牌服務實例 (依賴注入)
+var tokenService *services.TokenService
+
+// 兩步驟驗證服務實例 (依賴注入)
+var mfaService *services.MFAService
+
+// 通行金鑰服務實例 (依賴注入)
+var webAuthnService *services.WebAuthnService
+
+// API 金鑰服務實例 (依賴注入)
+var apiKeyService *services.APIKeyService
+
+// SetTokenService 設定驗證 access token 使用的服務 (依賴注入)
+func SetTokenService(service *services.TokenService) {
+	tokenService = service
+}
+
+// SetMFAService 設定判斷等級是否強制兩步驟驗證的服務 (依賴注入)
+func SetMFAService(service *services.MFAService) {
+	mfaService = service
+}
+
+// SetWebAuthnService 設定判斷等級是否強制通行金鑰的服務 (依賴注入)
+func SetWebAuthnService(service *services.WebAuthnService) {
+	webAuthnService = service
+}
+
+// SetAPIKeyService 設定驗證 X-API-Key 使用的服務 (依賴注入)
+func SetAPIKeyService(service *services.APIKeyService) {
+	apiKeyService = service
+}
+
+// AuthMiddleware 驗證 Bearer access token (或服務帳號的 X-API-Key) 並將使用者資訊存入 context
+// 等級強制要求兩步驟驗證但本次登入未通過驗證的令牌會被拒絕
 func AuthMiddleware() gin.HandlerFunc {
+	return authenticate(false)
+}
+
+// MFASetupAuthMiddleware 與 AuthMiddleware 相同，但允許尚未完成兩步驟驗證設定的令牌通過
+// 僅用於兩步驟驗證綁定相關的路由
+func MFASetupAuthMiddleware() gin.HandlerFunc {
+	return authenticate(true)
+}
+
+// authenticate 驗證 access token 的共用實作
+func authenticate(allowPendingMFA bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// 服務帳號以 API 金鑰存取，只能使用宣告了權限的路由，且權限受金鑰範圍限制 (見 RequirePermission)
+		if rawKey := c.GetHeader("X-API-Key"); rawKey != "" {
+			authenticateAPIKey(c, rawKey)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "未提供授權標頭"})
@@ -24,31 +76,108 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// 檢查簽名算法
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("非預期的簽名方法: %v", token.Header["alg"])
-			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		if tokenService == nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "令牌服務尚未初始化"})
+			return
+		}
+
+		// 驗證簽章並檢查使用者狀態、令牌版本與撤銷清單
+		claims, err := tokenService.ValidateAccessToken(tokenString)
+		if errors.Is(err, services.ErrInvalidAccessToken) || errors.Is(err, services.ErrAccessTokenRevoked) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "無法驗證 token"})
+			return
+		}
 
+		// 停權、鎖定或封存的帳號不可存取 (停用時已撤銷令牌，此處另外防止停權期間仍有效的令牌)
+		status, err := tokenService.AccountStatus(claims.UserID)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "無效的 token"})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "無法驗證 token"})
+			return
+		}
+		if status != models.UserStatusActive {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "帳號已停用", "account_status": status})
+			return
+		}
+
+		// 使用者設有來源網路限制時，只能從允許的網段存取
+		allowed, err := tokenService.AllowsNetwork(claims.UserID, c.ClientIP())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "無法驗證 token"})
+			return
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "目前的網路不允許存取", "network_not_allowed": true})
+			return
+		}
+
+		// 強制兩步驟驗證 (或通行金鑰) 的等級必須以通過驗證的令牌存取
+		passkeyRequired := webAuthnService != nil && webAuthnService.RequiredForLevel(claims.Level)
+		mfaRequired := passkeyRequired || (mfaService != nil && mfaService.RequiredForLevel(claims.Level))
+		if !allowPendingMFA && !claims.MFAVerified && mfaRequired {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":                  "需要先設定兩步驟驗證",
+				"mfa_setup_required":     true,
+				"passkey_setup_required": passkeyRequired,
+			})
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// 將使用者資訊存入 context
-			c.Set("user_id", claims["user_id"])
-			c.Set("username", claims["username"])
-			c.Set("level", claims["level"]) // 添加等級信息
-			c.Next()
-		} else {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "無效的 token"})
+		// 將使用者資訊存入 context
+		c.Set("user_id", claims.UserID)
+		c.Set("username", claims.Username)
+		c.Set("level", claims.Level) // 添加等級信息
+		c.Set("token_claims", claims)
+
+		// 模擬登入期間的每個請求都寫入稽核紀錄
+		if claims.Actor != nil {
+			auditImpersonation(c, claims)
+			return
 		}
+		c.Next()
 	}
 }
 
+// authenticateAPIKey 驗證 API 金鑰並將服務帳號與金鑰存入 context
+func authenticateAPIKey(c *gin.Context, rawKey string) {
+	if apiKeyService == nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "API 金鑰服務尚未初始化"})
+		return
+	}
+	if !apiKeyAllowed(c) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API 金鑰無法存取此路由"})
+		return
+	}
+
+	user, key, err := apiKeyService.Authenticate(rawKey, c.ClientIP())
+	if errors.Is(err, services.ErrInvalidAPIKey) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "無法驗證 API 金鑰"})
+		return
+	}
+
+	if status := user.EffectiveStatus(time.Now()); status != models.UserStatusActive {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "帳號已停用", "account_status": status})
+		return
+	}
+	if !user.AllowedNetworks.Allows(c.ClientIP()) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "目前的網路不允許存取", "network_not_allowed": true})
+		return
+	}
+
+	c.Set("user_id", user.ID)
+	c.Set("username", user.Username)
+	c.Set("level", user.Level)
+	c.Set("api_key", key)
+	c.Next()
+}
+
 // LevelMiddleware 檢查使用者等級是否為管理員或超級管理員
 func LevelMiddleware(requiredLevels ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"erp/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 模擬登入服務實例 (依賴注入)
+var impersonationService *services.ImpersonationService
+
+// SetImpersonationService 設定記錄模擬登入請求使用的服務 (依賴注入)
+func SetImpersonationService(service *services.ImpersonationService) {
+	impersonationService = service
+}
+
+// DenyImpersonation 禁止以模擬登入令牌執行敏感操作 (密碼、兩步驟驗證、工作階段與金鑰管理等)
+// 必須放在 AuthMiddleware 之後使用
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, impersonating := CurrentImpersonatorID(c); impersonating {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "模擬登入期間無法執行此操作"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentImpersonatorID 取得模擬登入時實際操作的管理員 ID (非模擬登入時為 false)
+func CurrentImpersonatorID(c *gin.Context) (uint, bool) {
+	value, exists := c.Get("impersonator_id")
+	if !exists {
+		return 0, false
+	}
+
+	id, ok := value.(uint)
+	return id, ok
+}
+
+// auditImpersonation 記錄模擬登入期間的請求後繼續處理，無法寫入稽核紀錄時拒絕請求
+func auditImpersonation(c *gin.Context, claims *services.AccessClaims) {
+	if impersonationService == nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "模擬登入服務尚未初始化"})
+		return
+	}
+
+	entry, err := impersonationService.RecordRequest(claims, c.Request.Method, c.Request.URL.RequestURI(), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "無法記錄模擬登入操作"})
+		return
+	}
+
+	c.Set("impersonator_id", claims.Actor.UserID)
+	c.Next()
+
+	if err := impersonationService.CompleteRequest(entry, c.Writer.Status()); err != nil {
+		fmt.Fprintf(os.Stderr, "無法更新模擬登入稽核紀錄 %d: %v\n", entry.ID, err)
+	}
+}
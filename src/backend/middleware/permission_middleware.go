@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+
+	"erp/models"
+	"erp/services"
+	"github.com/gin-gonic/gin"
+)
+
+// 權限服務實例 (依賴注入)
+var permissionService *services.PermissionService
+
+// SetPermissionService 設定權限檢查使用的服務 (依賴注入)
+func SetPermissionService(service *services.PermissionService) {
+	permissionService = service
+}
+
+// RequirePermission 檢查目前使用者是否擁有指定的權限代碼 (module.resource.action)
+// 必須放在 AuthMiddleware 之後使用
+// 呼叫時會同時將權限代碼登記到註冊表，啟動時由 services.SyncRegisteredPermissions 寫入資料庫
+func RequirePermission(permissionCode string) gin.HandlerFunc {
+	// 權限代碼格式錯誤屬於程式錯誤，與 gin 路由衝突相同，於啟動時直接 panic
+	if err := services.RegisterPermission(permissionCode); err != nil {
+		panic(err)
+	}
+	return permissionCheck(permissionCode)
+}
+
+// permissionCheck RequirePermission 實際執行的檢查
+// API 金鑰只能存取處理鏈中包含此檢查的路由 (見 apiKeyAllowed)
+func permissionCheck(permissionCode string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if permissionService == nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "權限服務尚未初始化"})
+			return
+		}
+
+		userID, ok := CurrentUserID(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "無法獲取使用者資訊"})
+			return
+		}
+
+		if !hasPermission(c, userID, permissionCode) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":      "權限不足",
+				"permission": permissionCode,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// HasPermission 檢查目前請求的使用者 (或 API 金鑰) 是否擁有指定權限，供需要依情況判斷權限的控制器使用
+func HasPermission(c *gin.Context, permissionCode string) bool {
+	userID, ok := CurrentUserID(c)
+	if !ok || permissionService == nil {
+		return false
+	}
+	return hasPermission(c, userID, permissionCode)
+}
+
+// hasPermission API 金鑰只能使用建立時授予的權限，其餘依使用者等級與角色 (及角色的來源網路限制) 判斷
+func hasPermission(c *gin.Context, userID uint, permissionCode string) bool {
+	if key, ok := CurrentAPIKey(c); ok {
+		return key.Allows(permissionCode)
+	}
+	return permissionService.HasPermission(userID, permissionCode, c.ClientIP())
+}
+
+// CurrentUserID 從 context 取得 AuthMiddleware 設定的使用者 ID
+func CurrentUserID(c *gin.Context) (uint, bool) {
+	value, exists := c.Get("user_id")
+	if !exists {
+		return 0, false
+	}
+
+	id, ok := value.(uint)
+	return id, ok
+}
+
+// CurrentAPIKey 取得本次請求使用的 API 金鑰 (以 Bearer token 存取時為 false)
+func CurrentAPIKey(c *gin.Context) (*models.APIKey, bool) {
+	value, exists := c.Get("api_key")
+	if !exists {
+		return nil, false
+	}
+
+	key, ok := value.(*models.APIKey)
+	return key, ok
+}
+
+// AllowAPIKey 標記不需要權限但允許 API 金鑰存取的路由 (例如查詢金鑰本身的權限)
+// 未宣告權限也未標記的路由一律拒絕 API 金鑰，避免金鑰取得範圍以外的存取
+func AllowAPIKey() gin.HandlerFunc {
+	return allowAPIKey
+}
+
+// allowAPIKey AllowAPIKey 的標記處理函式
+func allowAPIKey(c *gin.Context) {
+	c.Next()
+}
+
+// apiKeyRouteHandlers 處理鏈中出現其中之一時，路由才允許 API 金鑰存取
+var apiKeyRouteHandlers = map[string]bool{
+	handlerName(permissionCheck("")): true,
+	handlerName(allowAPIKey):         true,
+}
+
+// apiKeyAllowed 檢查目前的路由是否宣告了權限 (RequirePermission) 或標記了 AllowAPIKey
+func apiKeyAllowed(c *gin.Context) bool {
+	for _, name := range c.HandlerNames() {
+		if apiKeyRouteHandlers[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// handlerName 處理函式的名稱，與 gin 的 HandlerNames 使用相同的方式取得
+func handlerName(handler gin.HandlerFunc) string {
+	return runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+}
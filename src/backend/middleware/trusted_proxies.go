@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConfigureTrustedProxies 依環境變數 TRUSTED_PROXIES (逗號分隔的 IP 或 CIDR) 設定信任的反向代理
+// 只有來自這些位址的 X-Forwarded-For / X-Real-IP 才會被 ClientIP() 採用；未設定時不信任任何代理，
+// 一律使用連線的來源位址，避免用戶端偽造標頭繞過網段限制與登入 IP 節流
+func ConfigureTrustedProxies(r *gin.Engine) error {
+	var proxies []string
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			proxies = append(proxies, entry)
+		}
+	}
+	return r.SetTrustedProxies(proxies)
+}
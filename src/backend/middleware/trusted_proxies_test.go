@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"erp/models"
+	"erp/services"
+	"github.com/gin-gonic/gin"
+)
+
+// newNetworkRestrictedEngine 建立只允許 10.0.0.0/8 存取的路由，與登入及 AuthMiddleware 的網段檢查相同
+func newNetworkRestrictedEngine(t *testing.T, trustedProxies string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("TRUSTED_PROXIES", trustedProxies)
+
+	r := gin.New()
+	if err := ConfigureTrustedProxies(r); err != nil {
+		t.Fatalf("ConfigureTrustedProxies: %v", err)
+	}
+	allowed := models.NetworkList{"10.0.0.0/8"}
+	r.GET("/", func(c *gin.Context) {
+		if !allowed.Allows(c.ClientIP()) {
+			c.Status(http.StatusForbidden)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	return r
+}
+
+func requestFrom(r *gin.Engine, remoteAddr, forwardedFor string) int {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestSpoofedForwardedForFromUntrustedPeerIsRejected(t *testing.T) {
+	r := newNetworkRestrictedEngine(t, "")
+
+	if code := requestFrom(r, "203.0.113.7:51234", "10.0.0.5"); code != http.StatusForbidden {
+		t.Fatalf("spoofed X-Forwarded-For: status = %d, want 403", code)
+	}
+	if code := requestFrom(r, "10.0.0.5:51234", ""); code != http.StatusOK {
+		t.Fatalf("office address: status = %d, want 200", code)
+	}
+}
+
+func TestForwardedForFromTrustedProxyIsUsed(t *testing.T) {
+	r := newNetworkRestrictedEngine(t, "192.0.2.10, 198.51.100.0/24")
+
+	if code := requestFrom(r, "192.0.2.10:443", "10.0.0.5"); code != http.StatusOK {
+		t.Fatalf("forwarded by trusted proxy: status = %d, want 200", code)
+	}
+	// 用戶端自行加入的位址在代理附加的真實位址之前，不會被採用
+	if code := requestFrom(r, "192.0.2.10:443", "10.0.0.5, 203.0.113.7"); code != http.StatusForbidden {
+		t.Fatalf("spoofed entry before the proxy's: status = %d, want 403", code)
+	}
+	if code := requestFrom(r, "203.0.113.7:51234", "10.0.0.5"); code != http.StatusForbidden {
+		t.Fatalf("untrusted peer: status = %d, want 403", code)
+	}
+}
+
+func TestRotatedForwardedForDoesNotResetLoginIPThrottle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("TRUSTED_PROXIES", "")
+	t.Setenv("LOGIN_IP_MAX_ATTEMPTS", "3")
+
+	// 與登入端點相同：先檢查 IP 是否封鎖，失敗時計入 IP
+	guard := services.NewLoginGuardService(nil)
+	r := gin.New()
+	if err := ConfigureTrustedProxies(r); err != nil {
+		t.Fatalf("ConfigureTrustedProxies: %v", err)
+	}
+	r.GET("/", func(c *gin.Context) {
+		if err := guard.CheckIP(c.ClientIP()); err != nil {
+			c.Status(http.StatusTooManyRequests)
+			return
+		}
+		guard.RecordFailure(nil, c.ClientIP())
+		c.Status(http.StatusUnauthorized)
+	})
+
+	for i, forwardedFor := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if code := requestFrom(r, "203.0.113.7:51234", forwardedFor); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want 401", i+1, code)
+		}
+	}
+	if code := requestFrom(r, "203.0.113.7:51234", "10.0.0.4"); code != http.StatusTooManyRequests {
+		t.Fatalf("after the limit with a new X-Forwarded-For: status = %d, want 429", code)
+	}
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// APIKey 服務帳號的 API 金鑰 (僅儲存雜湊值，以前綴識別)
+type APIKey struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index;not null" json:"user_id"` // 所屬的服務帳號
+	Name        string     `gorm:"not null;size:100" json:"name"`
+	Prefix      string     `gorm:"uniqueIndex;not null;size:16" json:"prefix"` // 公開的識別前綴，方便在日誌與列表中辨識
+	KeyHash     string     `gorm:"column:key_hash;not null;size:64" json:"-"`
+	Permissions string     `gorm:"type:text;not null" json:"-"` // 以逗號分隔的權限代碼
+	ExpiresAt   *time.Time `gorm:"index" json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	LastUsedIP  string     `gorm:"column:last_used_ip;size:64" json:"last_used_ip"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedBy   uint       `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// TableName 指定資料表名稱
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// PermissionCodes 取得金鑰允許的權限代碼
+func (k *APIKey) PermissionCodes() []string {
+	if k.Permissions == "" {
+		return []string{}
+	}
+	return strings.Split(k.Permissions, ",")
+}
+
+// Allows 檢查金鑰是否允許指定的權限代碼
+func (k *APIKey) Allows(permissionCode string) bool {
+	for _, code := range k.PermissionCodes() {
+		if code == permissionCode {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive 檢查金鑰在指定時間是否仍可使用
+func (k *APIKey) IsActive(now time.Time) bool {
+	if k.RevokedAt != nil && !now.Before(*k.RevokedAt) {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// APIKeyResponse 回傳給前端的 API 金鑰資訊 (不包含金鑰本身)
+type APIKeyResponse struct {
+	ID          uint       `json:"id"`
+	UserID      uint       `json:"user_id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	LastUsedIP  string     `json:"last_used_ip"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	Active      bool       `json:"active"`
+	CreatedBy   uint       `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ToResponse 轉換為回傳給前端的 API 金鑰資訊
+func (k *APIKey) ToResponse() APIKeyResponse {
+	return APIKeyResponse{
+		ID:          k.ID,
+		UserID:      k.UserID,
+		Name:        k.Name,
+		Prefix:      k.Prefix,
+		Permissions: k.PermissionCodes(),
+		ExpiresAt:   k.ExpiresAt,
+		LastUsedAt:  k.LastUsedAt,
+		LastUsedIP:  k.LastUsedIP,
+		RevokedAt:   k.RevokedAt,
+		Active:      k.IsActive(time.Now()),
+		CreatedBy:   k.CreatedBy,
+		CreatedAt:   k.CreatedAt,
+	}
+}
+
+// CreateServiceAccountInput 建立服務帳號時的輸入
+type CreateServiceAccountInput struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"omitempty,email"` // 可選，預設為 <username>@service.local
+}
+
+// CreateAPIKeyInput 建立 API 金鑰時的輸入
+type CreateAPIKeyInput struct {
+	Name        string     `json:"name" binding:"required"`
+	Permissions []string   `json:"permissions" binding:"required,min=1"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // 可選，預設依 API_KEY_DEFAULT_TTL
+}
+
+// RotateAPIKeyInput 輪替 API 金鑰時的輸入
+type RotateAPIKeyInput struct {
+	GracePeriodSeconds int `json:"grace_period_seconds" binding:"min=0,max=604800"` // 舊金鑰在輪替後仍可使用的秒數
+}
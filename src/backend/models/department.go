@@ -0,0 +1,92 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Department 部門，以 ParentID 組成樹狀結構
+// Path 為物化路徑 (例如 /1/4/9/)，查詢子樹時以前綴比對，不需遞迴查詢
+type Department struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	Name           string    `gorm:"not null;size:100" json:"name"`
+	ParentID       *uint     `gorm:"index" json:"parent_id"`                // 上層部門，空值代表最上層
+	ManagerID      *uint     `gorm:"index" json:"manager_id"`               // 部門主管的使用者 ID
+	CostCenterCode string    `gorm:"size:50;index" json:"cost_center_code"` // 成本中心代碼
+	Path           string    `gorm:"not null;size:1000;index" json:"path"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// TableName 指定資料表名稱
+func (Department) TableName() string {
+	return "departments"
+}
+
+// DepartmentPath 部門的物化路徑
+func DepartmentPath(parentPath string, id uint) string {
+	if parentPath == "" {
+		parentPath = "/"
+	}
+	return fmt.Sprintf("%s%d/", parentPath, id)
+}
+
+// DepartmentNode 部門樹的節點
+type DepartmentNode struct {
+	Department
+	Children []*DepartmentNode `json:"children"`
+}
+
+// UserDepartment 使用者所屬的部門，每位使用者最多一個主要部門，其餘為兼任部門
+type UserDepartment struct {
+	UserID       uint      `gorm:"primaryKey" json:"user_id"`
+	DepartmentID uint      `gorm:"primaryKey;index" json:"department_id"`
+	IsPrimary    bool      `gorm:"not null;default:false" json:"is_primary"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (UserDepartment) TableName() string {
+	return "user_departments"
+}
+
+// UserDepartmentResponse 使用者所屬部門的資訊
+type UserDepartmentResponse struct {
+	ID             uint   `json:"id"`
+	Name           string `json:"name"`
+	Path           string `json:"path"`
+	CostCenterCode string `json:"cost_center_code"`
+	IsPrimary      bool   `json:"is_primary"`
+}
+
+// DepartmentSummary 部門的簡要資訊
+type DepartmentSummary struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// CreateDepartmentInput 建立部門時的輸入
+type CreateDepartmentInput struct {
+	Name           string `json:"name" binding:"required,max=100"`
+	ParentID       *uint  `json:"parent_id"`
+	ManagerID      *uint  `json:"manager_id"`
+	CostCenterCode string `json:"cost_center_code" binding:"max=50"`
+}
+
+// UpdateDepartmentInput 更新部門時的輸入，上層部門需透過移動 API 變更
+type UpdateDepartmentInput struct {
+	Name           *string `json:"name,omitempty" binding:"omitempty,min=1,max=100"`
+	ManagerID      *uint   `json:"manager_id,omitempty"` // 0 代表清除
+	CostCenterCode *string `json:"cost_center_code,omitempty" binding:"omitempty,max=50"`
+}
+
+// MoveDepartmentInput 移動部門 (連同所有子部門) 時的輸入
+type MoveDepartmentInput struct {
+	ParentID *uint `json:"parent_id"` // 空值代表移到最上層
+}
+
+// SetUserDepartmentsInput 設定使用者所屬部門的輸入，會取代原本的所有部門
+type SetUserDepartmentsInput struct {
+	PrimaryDepartmentID    *uint  `json:"primary_department_id"` // 空值代表不屬於任何部門 (此時不可有兼任部門)
+	SecondaryDepartmentIDs []uint `json:"secondary_department_ids" binding:"max=50"`
+}
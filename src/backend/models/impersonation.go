@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+// 模擬登入稽核紀錄的動作類型
+const (
+	ImpersonationActionStart   = "start"   // 管理員開始模擬登入
+	ImpersonationActionRequest = "request" // 以模擬登入令牌發出的請求
+	ImpersonationActionEnd     = "end"     // 提前結束模擬登入
+)
+
+// ImpersonationAudit 模擬登入稽核紀錄，記錄開始、結束與期間的每一個請求
+type ImpersonationAudit struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	ImpersonatorID uint      `gorm:"index;not null" json:"impersonator_id"` // 實際操作的管理員
+	UserID         uint      `gorm:"index;not null" json:"user_id"`         // 被模擬的使用者
+	TokenID        string    `gorm:"column:token_id;index;size:64" json:"token_id"`
+	Action         string    `gorm:"size:20;not null" json:"action"`
+	Method         string    `gorm:"size:10" json:"method"`
+	Path           string    `gorm:"size:255" json:"path"`
+	StatusCode     int       `json:"status_code"`
+	Reason         string    `gorm:"size:255" json:"reason,omitempty"`
+	IPAddress      string    `gorm:"column:ip_address;size:64" json:"ip_address"`
+	UserAgent      string    `gorm:"size:255" json:"user_agent"`
+	CreatedAt      time.Time `gorm:"index" json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (ImpersonationAudit) TableName() string {
+	return "impersonation_audits"
+}
+
+// ImpersonateInput 開始模擬登入的輸入結構，必須說明原因以供稽核
+type ImpersonateInput struct {
+	Reason string `json:"reason" binding:"required,max=255"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+)
+
+// 邀請令牌的用途
+const (
+	InvitationPurposeInvite            = "invite"             // 受邀者設定密碼並啟用帳號
+	InvitationPurposeEmailVerification = "email_verification" // 變更電子郵件後重新驗證，不變更密碼
+)
+
+// UserInvitation 使用者邀請 (僅儲存令牌雜湊值，單次使用)
+// 受邀者透過邀請連結自行設定密碼，接受後電子郵件即視為已驗證；
+// 變更電子郵件時也以同一張表寄出驗證連結 (Purpose 為 email_verification)
+type UserInvitation struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"user_id"`
+	Purpose    string     `gorm:"size:32;not null;default:invite" json:"purpose"`
+	TokenHash  string     `gorm:"column:token_hash;uniqueIndex;not null;size:64" json:"-"`
+	InvitedBy  uint       `gorm:"not null" json:"invited_by"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	RevokedAt  *time.Time `json:"revoked_at"` // 重新寄送或管理員撤銷的時間
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (UserInvitation) TableName() string {
+	return "user_invitations"
+}
+
+// IsActive 檢查邀請在指定時間是否仍可使用
+func (i *UserInvitation) IsActive(now time.Time) bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && now.Before(i.ExpiresAt)
+}
+
+// InviteUserInput 邀請使用者時的輸入
+type InviteUserInput struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Level    string `json:"level,omitempty"`    // 可選，默認為 "user"
+	RoleIDs  []uint `json:"role_ids,omitempty"` // 初始角色
+}
+
+// InvitationTokenInput 查詢邀請時的輸入
+type InvitationTokenInput struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// AcceptInvitationInput 接受邀請並設定密碼時的輸入
+type AcceptInvitationInput struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"` // 強度由密碼政策檢查
+}
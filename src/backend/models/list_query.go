@@ -0,0 +1,77 @@
+package models
+
+import "time"
+
+// 列表查詢每頁筆數
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ListQuery 列表查詢的共用條件 (query string)
+// 分頁使用 page/page_size，或以上一頁回傳的 next_cursor 帶入 cursor 繼續查詢 (此時忽略 page)
+// sort 以逗號分隔欄位，欄位前加 - 代表遞減，例如 sort=level,-created_at
+type ListQuery struct {
+	Page         int        `form:"page" binding:"omitempty,min=1"`
+	PageSize     int        `form:"page_size" binding:"omitempty,min=1,max=200"`
+	Cursor       string     `form:"cursor" binding:"max=2048"`
+	Sort         string     `form:"sort" binding:"max=200"`
+	Search       string     `form:"q" binding:"max=100"` // 關鍵字，不分大小寫的部分比對
+	CreatedSince *time.Time `form:"created_since" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedUntil *time.Time `form:"created_until" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// Limit 每頁筆數，未指定時使用預設值
+func (q ListQuery) Limit() int {
+	switch {
+	case q.PageSize <= 0:
+		return DefaultPageSize
+	case q.PageSize > MaxPageSize:
+		return MaxPageSize
+	}
+	return q.PageSize
+}
+
+// Offset 依頁碼計算略過的筆數，使用 cursor 時為 0
+func (q ListQuery) Offset() int {
+	if q.Cursor != "" || q.Page <= 1 {
+		return 0
+	}
+	return (q.Page - 1) * q.Limit()
+}
+
+// ListPage 列表查詢的分頁資訊
+type ListPage struct {
+	Total      int64  `json:"total"`                 // 符合條件的總筆數
+	Page       int    `json:"page,omitempty"`        // 目前頁碼，使用 cursor 時省略
+	PageSize   int    `json:"page_size"`             // 每頁筆數
+	TotalPages int    `json:"total_pages"`           // 總頁數
+	NextCursor string `json:"next_cursor,omitempty"` // 下一頁的游標，已無下一頁時省略
+}
+
+// UserListQuery 查詢使用者列表的條件
+type UserListQuery struct {
+	ListQuery
+	Level          string `form:"level"`  // 可用逗號分隔多個等級
+	Status         string `form:"status"` // 可用逗號分隔多個帳號狀態，依實際狀態篩選 (停權已到期視為 active)
+	ServiceAccount *bool  `form:"service_account"`
+	Deleted        bool   `form:"deleted"` // true 時只列出已刪除 (可還原) 的使用者
+	DepartmentID   *uint  `form:"department_id"`
+	SubDepartments bool   `form:"sub_departments"` // true 時包含 department_id 底下所有子部門的使用者
+	PrimaryOnly    bool   `form:"primary_only"`    // true 時只比對主要部門，不含兼任
+}
+
+// RoleListQuery 查詢角色列表的條件
+type RoleListQuery struct {
+	ListQuery
+	Status   string `form:"status"` // 可用逗號分隔多個狀態
+	IsSystem *bool  `form:"is_system"`
+}
+
+// PermissionListQuery 查詢權限列表的條件
+type PermissionListQuery struct {
+	ListQuery
+	Module   string `form:"module"` // 可用逗號分隔多個模組
+	Resource string `form:"resource"`
+	Status   string `form:"status"` // 可用逗號分隔多個狀態
+}
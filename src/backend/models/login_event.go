@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+)
+
+// 登入事件的驗證步驟
+const (
+	LoginStepPassword = "password"
+	LoginStepMFA      = "mfa"
+	LoginStepOIDC     = "oidc"
+	LoginStepPasskey  = "passkey"
+)
+
+// 登入事件的結果原因
+const (
+	LoginReasonSuccess            = "success"
+	LoginReasonMFARequired        = "mfa_required" // 第一步驗證通過，等待兩步驟驗證
+	LoginReasonInvalidCredentials = "invalid_credentials"
+	LoginReasonInvalidMFACode     = "invalid_mfa_code"
+	LoginReasonInvalidPasskey     = "invalid_passkey"
+	LoginReasonPasskeyCloned      = "passkey_clone_detected" // 簽章計數器倒退，疑似複製的驗證器
+	LoginReasonMFAExpired         = "mfa_challenge_expired"
+	LoginReasonLocked             = "locked"
+	LoginReasonRateLimited        = "rate_limited"
+	LoginReasonServiceAccount     = "service_account"
+	LoginReasonNotProvisioned     = "not_provisioned"
+	LoginReasonPasswordExpired    = "password_expired"
+	LoginReasonEmailUnverified    = "email_unverified"
+	LoginReasonNetworkNotAllowed  = "network_not_allowed" // 來源 IP 不在使用者的允許網段內
+	LoginReasonAccountInactive    = "account_inactive"    // 帳號已停權、鎖定或封存，detail 為帳號狀態
+	LoginReasonInvalidState       = "invalid_state"
+	LoginReasonSSOFailed          = "sso_failed"
+	LoginReasonBackendUnavailable = "backend_unavailable"
+)
+
+// LoginEvent 登入事件，記錄每一次成功與失敗的登入嘗試
+type LoginEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    *uint     `gorm:"index" json:"user_id"`           // 帳號不存在時為空值
+	Username  string    `gorm:"size:255;index" json:"username"` // 嘗試登入的帳號
+	Step      string    `gorm:"size:20;not null" json:"step"`   // password, mfa, oidc, passkey
+	Success   bool      `gorm:"index;not null" json:"success"`
+	Reason    string    `gorm:"size:50;not null" json:"reason"`
+	Detail    string    `gorm:"size:255" json:"detail,omitempty"` // 補充資訊，例如單一登入的提供者
+	IPAddress string    `gorm:"column:ip_address;size:64;index" json:"ip_address"`
+	UserAgent string    `gorm:"size:255" json:"user_agent"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (LoginEvent) TableName() string {
+	return "login_events"
+}
+
+// LoginEventQuery 查詢登入事件的條件 (query string)
+type LoginEventQuery struct {
+	UserID    *uint      `form:"user_id"`
+	Username  string     `form:"username"`
+	Success   *bool      `form:"success"`
+	IPAddress string     `form:"ip"`
+	Since     *time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until     *time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit     int        `form:"limit" binding:"omitempty,min=1,max=500"`
+	Offset    int        `form:"offset" binding:"omitempty,min=0"`
+}
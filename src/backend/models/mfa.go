@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+)
+
+// UserMFA 使用者的 TOTP 兩步驟驗證設定
+type UserMFA struct {
+	UserID       uint       `gorm:"primaryKey" json:"user_id"`
+	Secret       string     `gorm:"not null;size:255" json:"-"`  // 加密後的 TOTP 金鑰
+	ConfirmedAt  *time.Time `json:"confirmed_at"`                // 完成綁定驗證的時間，未確認前不生效
+	LastUsedStep int64      `gorm:"not null;default:0" json:"-"` // 最後使用的時間區間，防止驗證碼重放
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// TableName 指定資料表名稱
+func (UserMFA) TableName() string {
+	return "user_mfa"
+}
+
+// MFARecoveryCode 兩步驟驗證備用碼 (僅儲存雜湊值，單次使用)
+type MFARecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	CodeHash  string     `gorm:"column:code_hash;not null;size:64" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (MFARecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+// MFACodeInput 提交 TOTP 驗證碼時的輸入
+type MFACodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// MFADisableInput 停用兩步驟驗證時的輸入
+type MFADisableInput struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// MFAChallengeInput 登入第二步驟的輸入，code 與 recovery_code 擇一提供
+type MFAChallengeInput struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// NetworkList 來源網路允許清單 (CIDR)，資料庫中以逗號分隔儲存；空清單代表不限制
+type NetworkList []string
+
+// NetworkListInput 設定來源網路允許清單的輸入，空陣列代表取消限制
+type NetworkListInput struct {
+	Networks []string `json:"networks" binding:"max=100"`
+}
+
+// ParseNetworkList 驗證並正規化 CIDR 清單，單一 IP 視為 /32 (IPv6 為 /128)，重複項目只保留一筆
+func ParseNetworkList(entries []string) (NetworkList, error) {
+	list := NetworkList{}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var prefix netip.Prefix
+		if strings.Contains(entry, "/") {
+			parsed, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("無效的網段: %s", entry)
+			}
+			prefix = parsed.Masked()
+		} else {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("無效的網段: %s", entry)
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+
+		normalized := prefix.String()
+		if !seen[normalized] {
+			seen[normalized] = true
+			list = append(list, normalized)
+		}
+	}
+	return list, nil
+}
+
+// Allows 檢查來源 IP 是否在允許清單內；清單為空時一律允許，無法解析的 IP 一律拒絕
+func (l NetworkList) Allows(ip string) bool {
+	if len(l) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, entry := range l {
+		prefix, err := netip.ParsePrefix(entry)
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON 未設定時輸出空陣列
+func (l NetworkList) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]string(l))
+}
+
+// Value 寫入資料庫時轉為逗號分隔字串
+func (l NetworkList) Value() (driver.Value, error) {
+	return strings.Join(l, ","), nil
+}
+
+// Scan 從資料庫讀取逗號分隔字串
+func (l *NetworkList) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("無法轉換 %T 為網路清單", value)
+	}
+
+	*l = NetworkList{}
+	if raw != "" {
+		*l = strings.Split(raw, ",")
+	}
+	return nil
+}
+
+// GormDataType 資料表欄位型別
+func (NetworkList) GormDataType() string {
+	return "text"
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity 使用者在外部身分提供者 (OIDC) 的身分，以 provider + subject 唯一識別
+type UserIdentity struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index;not null" json:"user_id"`
+	Provider    string     `gorm:"uniqueIndex:idx_user_identities_provider_subject;not null;size:50" json:"provider"`
+	Subject     string     `gorm:"uniqueIndex:idx_user_identities_provider_subject;not null;size:255" json:"subject"`
+	Email       string     `gorm:"size:100" json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// TableName 指定資料表名稱
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
+
+// OIDCLoginState 進行中的 OIDC 授權流程 (state 僅儲存雜湊值，單次使用)
+type OIDCLoginState struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	StateHash    string    `gorm:"column:state_hash;uniqueIndex;not null;size:64" json:"-"`
+	Provider     string    `gorm:"not null;size:50" json:"provider"`
+	CodeVerifier string    `gorm:"not null;size:128" json:"-"` // PKCE code verifier
+	Nonce        string    `gorm:"not null;size:128" json:"-"`
+	ExpiresAt    time.Time `gorm:"index;not null" json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (OIDCLoginState) TableName() string {
+	return "oidc_login_states"
+}
+
+// OIDCLoginCode 單一登入回呼後交給前端換取令牌的一次性交換碼 (僅儲存雜湊值)
+type OIDCLoginCode struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CodeHash  string    `gorm:"column:code_hash;uniqueIndex;not null;size:64" json:"-"`
+	UserID    uint      `gorm:"not null" json:"user_id"`
+	Provider  string    `gorm:"not null;size:50" json:"provider"`
+	ExpiresAt time.Time `gorm:"index;not null" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (OIDCLoginCode) TableName() string {
+	return "oidc_login_codes"
+}
+
+// OIDCLoginCodeInput 以交換碼換取令牌的輸入
+type OIDCLoginCodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// PasswordHistory 使用者過去使用過的密碼雜湊，用於防止重複使用舊密碼
+type PasswordHistory struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"index;not null" json:"user_id"`
+	PasswordHash string    `gorm:"not null;size:255" json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (PasswordHistory) TableName() string {
+	return "password_histories"
+}
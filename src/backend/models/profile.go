@@ -0,0 +1,114 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// languageTagPattern 語言標籤格式 (BCP 47 的常見形式，例如 zh、zh-TW、zh-Hant-TW)
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// phonePattern 電話號碼格式，允許國碼、分機與常見的分隔符號，例如 +886 2 1234-5678 #123
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()\-]{5,28}(\s*(#|ext\.?)\s*[0-9]{1,6})?$`)
+
+// UserProfile 使用者的個人與員工資料，與登入帳號分開儲存
+// 語言與時區沿用使用者的偏好設定 (users.language、users.timezone)，部門為使用者的主要部門 (user_departments)
+type UserProfile struct {
+	UserID            uint       `gorm:"primaryKey" json:"user_id"`
+	DisplayNameZh     string     `gorm:"size:100" json:"display_name_zh"` // 中文顯示名稱
+	DisplayNameEn     string     `gorm:"size:100" json:"display_name_en"` // 英文顯示名稱
+	Phone             string     `gorm:"size:40" json:"phone"`
+	AvatarKey         string     `gorm:"size:255" json:"-"` // 頭像在檔案儲存中的鍵值，空值代表未設定
+	AvatarContentType string     `gorm:"size:50" json:"-"`
+	AvatarUpdatedAt   *time.Time `json:"avatar_updated_at"`
+	JobTitle          string     `gorm:"size:100" json:"job_title"`
+	ManagerID         *uint      `gorm:"index" json:"manager_id"`                    // 直屬主管的使用者 ID
+	EmployeeNumber    *string    `gorm:"uniqueIndex;size:50" json:"employee_number"` // 員工編號，空值代表未設定
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// TableName 指定資料表名稱
+func (UserProfile) TableName() string {
+	return "user_profiles"
+}
+
+// UserProfileResponse 回傳給前端的使用者個人資料
+type UserProfileResponse struct {
+	UserID         uint               `json:"user_id"`
+	DisplayNameZh  string             `json:"display_name_zh"`
+	DisplayNameEn  string             `json:"display_name_en"`
+	Phone          string             `json:"phone"`
+	AvatarURL      string             `json:"avatar_url,omitempty"` // 未設定頭像時省略
+	JobTitle       string             `json:"job_title"`
+	Department     *DepartmentSummary `json:"department"` // 主要部門，未指定時為空值
+	ManagerID      *uint              `json:"manager_id"`
+	EmployeeNumber *string            `json:"employee_number"`
+	Locale         string             `json:"locale"`
+	Timezone       string             `json:"timezone"`
+	UpdatedAt      *time.Time         `json:"updated_at"` // 尚未建立個人資料時為空值
+}
+
+// ToResponse 轉換為回傳給前端的個人資料，語言與時區取自使用者的偏好設定
+func (p *UserProfile) ToResponse(user *User) UserProfileResponse {
+	response := UserProfileResponse{
+		UserID:         user.ID,
+		DisplayNameZh:  p.DisplayNameZh,
+		DisplayNameEn:  p.DisplayNameEn,
+		Phone:          p.Phone,
+		JobTitle:       p.JobTitle,
+		ManagerID:      p.ManagerID,
+		EmployeeNumber: p.EmployeeNumber,
+		Locale:         user.Language,
+		Timezone:       user.Timezone,
+	}
+	if p.AvatarKey != "" && p.AvatarUpdatedAt != nil {
+		// 以更新時間作為版本，頭像變更後瀏覽器不會沿用快取
+		response.AvatarURL = fmt.Sprintf("/api/users/%d/avatar?v=%d", user.ID, p.AvatarUpdatedAt.UnixMilli())
+	}
+	if !p.UpdatedAt.IsZero() {
+		response.UpdatedAt = &p.UpdatedAt
+	}
+	return response
+}
+
+// UpdateUserProfileInput 更新個人資料時的輸入，未提供的欄位維持不變，空字串代表清除
+// 職稱、主管與員工編號只有管理員可以修改；部門透過使用者所屬部門 API 設定
+type UpdateUserProfileInput struct {
+	DisplayNameZh  *string `json:"display_name_zh,omitempty" binding:"omitempty,max=100"`
+	DisplayNameEn  *string `json:"display_name_en,omitempty" binding:"omitempty,max=100"`
+	Phone          *string `json:"phone,omitempty" binding:"omitempty,max=40"`
+	JobTitle       *string `json:"job_title,omitempty" binding:"omitempty,max=100"`
+	ManagerID      *uint   `json:"manager_id,omitempty"` // 0 代表清除
+	EmployeeNumber *string `json:"employee_number,omitempty" binding:"omitempty,max=50"`
+	Locale         *string `json:"locale,omitempty"`
+	Timezone       *string `json:"timezone,omitempty"`
+}
+
+// HasEmployeeFields 是否包含只有管理員可以修改的員工資料欄位
+func (in *UpdateUserProfileInput) HasEmployeeFields() bool {
+	return in.JobTitle != nil || in.ManagerID != nil || in.EmployeeNumber != nil
+}
+
+// ValidLanguageTag 檢查語言標籤格式，空字串代表使用系統預設
+func ValidLanguageTag(tag string) bool {
+	return tag == "" || languageTagPattern.MatchString(tag)
+}
+
+// ValidTimezone 檢查 IANA 時區名稱，空字串代表使用系統預設
+func ValidTimezone(name string) bool {
+	if name == "" {
+		return true
+	}
+	if name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// ValidPhone 檢查電話號碼格式，空字串代表清除
+func ValidPhone(phone string) bool {
+	return phone == "" || phonePattern.MatchString(phone)
+}
@@ -26,18 +26,19 @@ type Permission struct {
 
 // Role 角色模型
 type Role struct {
-	ID          uint           `gorm:"primaryKey" json:"id"`
-	Name        string         `gorm:"uniqueIndex;not null;size:50" json:"name"`
-	DisplayName string         `gorm:"not null;size:100" json:"display_name"`
-	Description string         `gorm:"size:255" json:"description"`
-	IsSystem    bool           `gorm:"default:false" json:"is_system"`
-	Status      string         `gorm:"size:20;default:active" json:"status"`
-	CreatedBy   *uint          `json:"created_by"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
-	Users       []User         `gorm:"many2many:user_roles;" json:"users,omitempty"`
-	Permissions []Permission   `gorm:"many2many:role_permissions;" json:"permissions,omitempty"`
+	ID              uint           `gorm:"primaryKey" json:"id"`
+	Name            string         `gorm:"uniqueIndex;not null;size:50" json:"name"`
+	DisplayName     string         `gorm:"not null;size:100" json:"display_name"`
+	Description     string         `gorm:"size:255" json:"description"`
+	IsSystem        bool           `gorm:"default:false" json:"is_system"`
+	Status          string         `gorm:"size:20;default:active" json:"status"`
+	AllowedNetworks NetworkList    `json:"allowed_networks"` // 角色可使用的來源網段，不符合時角色權限不生效；空值不限制
+	CreatedBy       *uint          `json:"created_by"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+	Users           []User         `gorm:"many2many:user_roles;" json:"users,omitempty"`
+	Permissions     []Permission   `gorm:"many2many:role_permissions;" json:"permissions,omitempty"`
 }
 
 // TableName 指定資料表名稱
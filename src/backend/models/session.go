@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+)
+
+// Session 登入工作階段，每次登入建立一筆，與 refresh token 家族一一對應
+// ID 即為令牌家族 ID，並寫入 access token 的 sid 聲明
+type Session struct {
+	ID            string     `gorm:"primaryKey;size:64" json:"id"`
+	UserID        uint       `gorm:"index;not null" json:"user_id"`
+	IPAddress     string     `gorm:"column:ip_address;size:64" json:"ip_address"`
+	UserAgent     string     `gorm:"size:255" json:"user_agent"`
+	MFAVerified   bool       `gorm:"column:mfa_verified;default:false" json:"mfa_verified"` // 登入時是否通過兩步驟驗證
+	AuthMethod    string     `gorm:"size:20" json:"auth_method"`                            // 登入方式 (password, oidc)
+	LastSeenAt    time.Time  `gorm:"not null" json:"last_seen_at"`
+	ExpiresAt     time.Time  `gorm:"index;not null" json:"expires_at"` // 目前 refresh token 的到期時間
+	RevokedAt     *time.Time `json:"revoked_at"`
+	RevokedReason string     `gorm:"size:50" json:"revoked_reason,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (Session) TableName() string {
+	return "sessions"
+}
+
+// IsActive 工作階段尚未結束 (未撤銷且 refresh token 尚未過期)
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// SessionResponse 工作階段回應格式，Current 標示發出請求的工作階段
+type SessionResponse struct {
+	ID          string    `json:"id"`
+	IPAddress   string    `json:"ip_address"`
+	UserAgent   string    `json:"user_agent"`
+	MFAVerified bool      `json:"mfa_verified"`
+	AuthMethod  string    `json:"auth_method"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	Current     bool      `json:"current"`
+}
+
+// ToResponse 轉換為回應格式
+func (s *Session) ToResponse(currentID string) SessionResponse {
+	return SessionResponse{
+		ID:          s.ID,
+		IPAddress:   s.IPAddress,
+		UserAgent:   s.UserAgent,
+		MFAVerified: s.MFAVerified,
+		AuthMethod:  s.AuthMethod,
+		LastSeenAt:  s.LastSeenAt,
+		ExpiresAt:   s.ExpiresAt,
+		CreatedAt:   s.CreatedAt,
+		Current:     currentID != "" && s.ID == currentID,
+	}
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken 刷新令牌 (僅儲存雜湊值)
+// 同一次登入後輪替產生的令牌共用 FamilyID，偵測到重複使用時整個家族一併撤銷
+type RefreshToken struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index;not null" json:"user_id"`
+	FamilyID    string     `gorm:"column:family_id;index;not null;size:64" json:"family_id"`
+	TokenHash   string     `gorm:"column:token_hash;uniqueIndex;not null;size:64" json:"-"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`    // 已輪替換發新令牌的時間
+	RevokedAt   *time.Time `json:"revoked_at"` // 登出或偵測到重複使用而撤銷的時間
+	UserAgent   string     `gorm:"size:255" json:"user_agent"`
+	IPAddress   string     `gorm:"column:ip_address;size:64" json:"ip_address"`
+	MFAVerified bool       `gorm:"column:mfa_verified;default:false" json:"mfa_verified"` // 登入時是否通過兩步驟驗證
+	AuthMethod  string     `gorm:"size:20" json:"auth_method"`                            // 登入方式 (password, oidc)
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// RevokedToken 已撤銷的 access token (以 jti 識別)，過期後即可清除
+type RevokedToken struct {
+	JTI       string    `gorm:"column:jti;primaryKey;size:64" json:"jti"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	ExpiresAt time.Time `gorm:"index;not null" json:"expires_at"`
+	Reason    string    `gorm:"size:100" json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// PasswordResetToken 密碼重設令牌 (僅儲存雜湊值，單次使用)
+type PasswordResetToken struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index;not null" json:"user_id"`
+	TokenHash   string     `gorm:"column:token_hash;uniqueIndex;not null;size:64" json:"-"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	RequestedIP string     `gorm:"column:requested_ip;size:64" json:"requested_ip"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
+
+// RefreshTokenInput 刷新令牌或登出時的輸入
+type RefreshTokenInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// ForgotPasswordInput 申請重設密碼時的輸入
+type ForgotPasswordInput struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordInput 以重設令牌設定新密碼時的輸入
+type ResetPasswordInput struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// SigningKey JWT 簽章金鑰 (以 kid 識別)，多個服務實例共用同一組金鑰環
+// 新金鑰在 ActivatesAt 之前先公開於 JWKS，被取代後保留到 ExpiresAt 供驗證尚未過期的令牌
+type SigningKey struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	KID         string     `gorm:"column:kid;uniqueIndex;not null;size:64" json:"kid"`
+	Algorithm   string     `gorm:"size:10;not null" json:"algorithm"` // RS256 或 ES256
+	PrivateKey  string     `gorm:"type:text;not null" json:"-"`       // 加密後的 PKCS#8 PEM
+	PublicKey   string     `gorm:"type:text;not null" json:"public_key"`
+	ActivatesAt time.Time  `gorm:"index;not null" json:"activates_at"` // 開始用於簽署的時間
+	ExpiresAt   *time.Time `gorm:"index" json:"expires_at"`            // 停止驗證的時間，目前金鑰為空值
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
@@ -1,21 +1,47 @@
 package models
 
 import (
-	"time"
 	"gorm.io/gorm"
+	"time"
+)
+
+// 使用者帳號狀態
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended" // 停權，可指定到期時間，到期後自動恢復
+	UserStatusLocked    = "locked"    // 管理員因安全疑慮鎖定，需管理員恢復
+	UserStatusArchived  = "archived"  // 封存 (例如離職)，保留資料但不可登入
 )
 
 // User 使用者模型 (GORM)
 type User struct {
-	ID          uint           `gorm:"primaryKey" json:"id"`
-	Username    string         `gorm:"uniqueIndex;not null;size:50" json:"username"`
-	Email       string         `gorm:"uniqueIndex;not null;size:100" json:"email"`
-	Password    string         `gorm:"not null;size:255" json:"-"` // 隱藏密碼欄位
-	Level       string         `gorm:"default:user;size:20" json:"level"` // 等級：user, admin, super_admin
-	LastLoginAt *time.Time     `json:"last_login_at"` // 最後登入時間
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                  uint           `gorm:"primaryKey" json:"id"`
+	Username            string         `gorm:"uniqueIndex;not null;size:50" json:"username"`
+	Email               string         `gorm:"uniqueIndex;not null;size:100" json:"email"`
+	Password            string         `gorm:"not null;size:255" json:"-"`                          // 隱藏密碼欄位
+	Level               string         `gorm:"default:user;size:20" json:"level"`                   // 等級：user, admin, super_admin
+	EmailVerifiedAt     *time.Time     `json:"email_verified_at"`                                   // 電子郵件驗證時間，空值代表邀請尚未接受，不可登入
+	PendingEmail        string         `gorm:"size:100" json:"pending_email"`                       // 等待驗證的新電子郵件，驗證後才取代 Email
+	LastLoginAt         *time.Time     `json:"last_login_at"`                                       // 最後登入時間
+	PasswordChangedAt   *time.Time     `json:"password_changed_at"`                                 // 最後變更密碼時間，用於密碼有效期限
+	TokenVersion        uint           `gorm:"not null;default:0" json:"-"`                         // 令牌版本，變更時先前簽發的令牌全部失效
+	MFAEnabled          bool           `gorm:"column:mfa_enabled;default:false" json:"mfa_enabled"` // 是否已啟用兩步驟驗證
+	AuthBackends        string         `gorm:"size:50" json:"auth_backends"`                        // 登入驗證順序，例如 "ldap,local"；空值使用系統預設
+	ServiceAccount      bool           `gorm:"default:false" json:"service_account"`                // 服務帳號只能以 API 金鑰存取，不可登入
+	FailedLoginAttempts int            `gorm:"not null;default:0" json:"failed_login_attempts"`     // 連續登入失敗次數
+	LastFailedLoginAt   *time.Time     `json:"last_failed_login_at"`                                // 最後一次登入失敗時間
+	LockedUntil         *time.Time     `json:"locked_until"`                                        // 暫時鎖定到期時間
+	Language            string         `gorm:"size:35" json:"language"`                             // 介面語言 (BCP 47，例如 zh-TW)，空值使用系統預設
+	Timezone            string         `gorm:"size:64" json:"timezone"`                             // 時區 (IANA，例如 Asia/Taipei)，空值使用系統預設
+	AllowedNetworks     NetworkList    `json:"allowed_networks"`                                    // 允許登入與存取的來源網段，空值不限制
+	Status              string         `gorm:"size:20;not null;default:active;index" json:"status"` // 帳號狀態：active, suspended, locked, archived
+	StatusReason        string         `gorm:"size:255" json:"status_reason"`                       // 最近一次變更狀態的原因
+	StatusChangedAt     *time.Time     `json:"status_changed_at"`                                   // 最近一次變更狀態的時間
+	StatusChangedBy     *uint          `json:"status_changed_by"`                                   // 最近一次變更狀態的管理員
+	SuspendedUntil      *time.Time     `json:"suspended_until"`                                     // 停權到期時間，空值代表直到手動恢復
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 // TableName 指定資料表名稱
@@ -33,8 +59,8 @@ type Credentials struct {
 type CreateUserInput struct {
 	Username string `json:"username" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
-	Level    string `json:"level,omitempty"` // 可選，默認為 "user"
+	Password string `json:"password" binding:"required"` // 強度由密碼政策檢查
+	Level    string `json:"level,omitempty"`             // 可選，默認為 "user"
 }
 
 // UpdateUserInput 更新使用者時的輸入
@@ -42,16 +68,143 @@ type UpdateUserInput struct {
 	Username *string `json:"username,omitempty"`
 	Email    *string `json:"email,omitempty"`
 	Password *string `json:"password,omitempty"`
-	Level    *string `json:"level,omitempty"`
+	// CurrentPassword 修改自己的密碼時必須提供目前的密碼
+	CurrentPassword *string `json:"current_password,omitempty"`
+	Level           *string `json:"level,omitempty"`
+	// AuthBackends 登入驗證順序 (僅管理員可修改)，空字串代表使用系統預設
+	AuthBackends *string `json:"auth_backends,omitempty"`
+}
+
+// SuspendUserInput 停權使用者的輸入
+type SuspendUserInput struct {
+	Reason string     `json:"reason" binding:"required,max=255"`
+	Until  *time.Time `json:"until"` // 停權到期時間，空值代表直到手動恢復
+}
+
+// UserStatusInput 鎖定、封存或恢復使用者的輸入
+type UserStatusInput struct {
+	Reason string `json:"reason" binding:"max=255"`
+}
+
+// UpdateProfileInput 使用者更新自己的基本資料時的輸入
+type UpdateProfileInput struct {
+	Username *string `json:"username,omitempty" binding:"omitempty,min=1,max=50"`
+	Email    *string `json:"email,omitempty" binding:"omitempty,email"`
+}
+
+// ChangePasswordInput 使用者變更自己的密碼時的輸入，需要提供目前的密碼
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"` // 強度由密碼政策檢查
+}
+
+// UserPreferences 使用者偏好設定
+type UserPreferences struct {
+	Language string `json:"language"`
+	Timezone string `json:"timezone"`
+}
+
+// UpdatePreferencesInput 更新偏好設定時的輸入，空字串代表改回系統預設
+type UpdatePreferencesInput struct {
+	Language *string `json:"language,omitempty"`
+	Timezone *string `json:"timezone,omitempty"`
 }
 
 // UserResponse 回傳給前端的使用者資訊 (不包含密碼)
 type UserResponse struct {
-	ID          uint       `json:"id"`
-	Username    string     `json:"username"`
-	Email       string     `json:"email"`
-	Level       string     `json:"level"`
-	LastLoginAt *time.Time `json:"last_login_at"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
+	ID                  uint        `json:"id"`
+	Username            string      `json:"username"`
+	Email               string      `json:"email"`
+	Level               string      `json:"level"`
+	EmailVerified       bool        `json:"email_verified"`
+	EmailVerifiedAt     *time.Time  `json:"email_verified_at"`
+	PendingEmail        string      `json:"pending_email"` // 等待驗證的新電子郵件
+	LastLoginAt         *time.Time  `json:"last_login_at"`
+	PasswordChangedAt   *time.Time  `json:"password_changed_at"`
+	MFAEnabled          bool        `json:"mfa_enabled"`
+	AuthBackends        string      `json:"auth_backends"`
+	ServiceAccount      bool        `json:"service_account"`
+	Locked              bool        `json:"locked"`
+	LockedUntil         *time.Time  `json:"locked_until"`
+	FailedLoginAttempts int         `json:"failed_login_attempts"`
+	Language            string      `json:"language"`
+	Timezone            string      `json:"timezone"`
+	AllowedNetworks     NetworkList `json:"allowed_networks"`
+	Status              string      `json:"status"` // 目前實際的帳號狀態 (停權已到期視為 active)
+	StatusReason        string      `json:"status_reason"`
+	StatusChangedAt     *time.Time  `json:"status_changed_at"`
+	SuspendedUntil      *time.Time  `json:"suspended_until"`
+	CreatedAt           time.Time   `json:"created_at"`
+	UpdatedAt           time.Time   `json:"updated_at"`
+
+	Profile *UserProfileResponse `json:"profile,omitempty"` // 個人資料，只在查詢使用者時附帶
+}
+
+// ToResponse 轉換為回傳給前端的使用者資訊
+func (u *User) ToResponse() UserResponse {
+	return UserResponse{
+		ID:                  u.ID,
+		Username:            u.Username,
+		Email:               u.Email,
+		Level:               u.Level,
+		EmailVerified:       u.IsEmailVerified(),
+		EmailVerifiedAt:     u.EmailVerifiedAt,
+		PendingEmail:        u.PendingEmail,
+		LastLoginAt:         u.LastLoginAt,
+		PasswordChangedAt:   u.PasswordChangedAt,
+		MFAEnabled:          u.MFAEnabled,
+		AuthBackends:        u.AuthBackends,
+		ServiceAccount:      u.ServiceAccount,
+		Locked:              u.IsLocked(time.Now()),
+		LockedUntil:         u.LockedUntil,
+		FailedLoginAttempts: u.FailedLoginAttempts,
+		Language:            u.Language,
+		Timezone:            u.Timezone,
+		AllowedNetworks:     u.AllowedNetworks,
+		Status:              u.EffectiveStatus(time.Now()),
+		StatusReason:        u.StatusReason,
+		StatusChangedAt:     u.StatusChangedAt,
+		SuspendedUntil:      u.SuspendedUntil,
+		CreatedAt:           u.CreatedAt,
+		UpdatedAt:           u.UpdatedAt,
+	}
+}
+
+// LevelRank 使用者等級的高低 (user < admin < super_admin)，無效的等級回傳 0
+func LevelRank(level string) int {
+	switch level {
+	case "user":
+		return 1
+	case "admin":
+		return 2
+	case "super_admin":
+		return 3
+	}
+	return 0
+}
+
+// IsEmailVerified 檢查電子郵件是否已驗證 (受邀使用者接受邀請後才會驗證)
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// EffectiveStatus 帳號在指定時間的實際狀態，停權已到期視為 active
+func (u *User) EffectiveStatus(now time.Time) string {
+	switch {
+	case u.Status == "":
+		return UserStatusActive
+	case u.Status == UserStatusSuspended && u.SuspendedUntil != nil && !now.Before(*u.SuspendedUntil):
+		return UserStatusActive
+	}
+	return u.Status
+}
+
+// IsActive 檢查帳號在指定時間是否可以登入與存取
+func (u *User) IsActive(now time.Time) bool {
+	return u.EffectiveStatus(now) == UserStatusActive
+}
+
+// IsLocked 檢查帳號在指定時間是否處於暫時鎖定狀態
+func (u *User) IsLocked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
 }
@@ -0,0 +1,47 @@
+package models
+
+// 使用者匯入匯出的檔案格式
+const (
+	UserFileFormatCSV  = "csv"
+	UserFileFormatXLSX = "xlsx"
+)
+
+// UserFileColumns 使用者匯入匯出檔案的欄位 (第一列為標題)，roles 以分號分隔多個角色名稱
+var UserFileColumns = []string{"username", "email", "level", "roles"}
+
+// UserImportInput 匯入使用者的選項 (query string)，檔案以 multipart 表單的 file 欄位上傳
+type UserImportInput struct {
+	DryRun bool   `form:"dry_run"`
+	Format string `form:"format" binding:"omitempty,oneof=csv xlsx"` // 未指定時依副檔名判斷
+}
+
+// UserExportQuery 匯出使用者的條件，篩選與排序同使用者列表 (忽略分頁)
+type UserExportQuery struct {
+	UserListQuery
+	Format string `form:"format" binding:"omitempty,oneof=csv xlsx"` // 預設 csv
+}
+
+// UserImportRow 匯入檔案中的一筆使用者資料
+type UserImportRow struct {
+	Row      int      `json:"row"` // 檔案中的列號，標題為第 1 列
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Level    string   `json:"level"`
+	Roles    []string `json:"roles"`
+}
+
+// UserImportError 匯入資料的驗證錯誤
+type UserImportError struct {
+	Row     int    `json:"row"` // 0 代表整個檔案的錯誤，例如缺少必要欄位
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// UserImportResult 匯入 (或試算) 的結果；有任何錯誤時不會建立任何使用者
+type UserImportResult struct {
+	DryRun   bool              `json:"dry_run"`
+	Total    int               `json:"total"` // 檔案中的資料筆數
+	Errors   []UserImportError `json:"errors"`
+	Users    []UserResponse    `json:"users"`              // 試算時為將建立的使用者，正式匯入時為已建立的使用者
+	Warnings []string          `json:"warnings,omitempty"` // 例如邀請郵件寄送失敗
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebAuthn 儀式類型
+const (
+	WebAuthnCeremonyRegistration = "registration" // 綁定通行金鑰
+	WebAuthnCeremonyLogin        = "login"        // 以通行金鑰免密碼登入
+	WebAuthnCeremonyMFA          = "mfa"          // 以通行金鑰完成登入第二步驟
+)
+
+// WebAuthnCredential 使用者綁定的通行金鑰 (WebAuthn 公開金鑰憑證)
+type WebAuthnCredential struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"index;not null" json:"user_id"`
+	CredentialID    string     `gorm:"column:credential_id;uniqueIndex;not null;size:1400" json:"credential_id"` // base64url 編碼的憑證 ID
+	PublicKey       []byte     `gorm:"not null" json:"-"`                                                        // COSE 格式公開金鑰
+	AttestationType string     `gorm:"size:32" json:"attestation_type"`
+	AAGUID          string     `gorm:"column:aaguid;size:36" json:"aaguid"`  // 驗證器型號識別碼
+	Transports      string     `gorm:"size:255" json:"transports"`           // 逗號分隔，例如 internal,hybrid
+	SignCount       uint32     `gorm:"not null;default:0" json:"sign_count"` // 驗證器簽章計數器，用於偵測複製的金鑰
+	BackupEligible  bool       `gorm:"not null;default:false" json:"backup_eligible"`
+	BackupState     bool       `gorm:"not null;default:false" json:"backup_state"`  // 是否已同步備份 (例如雲端鑰匙圈)
+	CloneWarning    bool       `gorm:"not null;default:false" json:"clone_warning"` // 計數器倒退，疑似遭複製，已停止接受
+	Name            string     `gorm:"not null;size:100" json:"name"`               // 使用者自訂的顯示名稱
+	LastUsedAt      *time.Time `json:"last_used_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// TableName 指定資料表名稱
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
+
+// WebAuthnCeremony 進行中的 WebAuthn 儀式 (挑戰值等狀態，令牌僅儲存雜湊值，單次使用)
+type WebAuthnCeremony struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TokenHash string    `gorm:"column:token_hash;uniqueIndex;not null;size:64" json:"-"`
+	UserID    *uint     `gorm:"index" json:"user_id"` // 免密碼登入在驗證前不知道使用者
+	Ceremony  string    `gorm:"not null;size:20" json:"ceremony"`
+	Data      string    `gorm:"type:text;not null" json:"-"` // 序列化的 webauthn.SessionData
+	ExpiresAt time.Time `gorm:"index;not null" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName 指定資料表名稱
+func (WebAuthnCeremony) TableName() string {
+	return "webauthn_ceremonies"
+}
+
+// PasskeyRegistrationInput 完成綁定通行金鑰的輸入
+type PasskeyRegistrationInput struct {
+	CeremonyToken string          `json:"ceremony_token" binding:"required"`
+	Name          string          `json:"name" binding:"max=100"`
+	Credential    json.RawMessage `json:"credential" binding:"required"` // navigator.credentials.create() 的結果
+}
+
+// PasskeyAssertionInput 以通行金鑰免密碼登入的輸入
+type PasskeyAssertionInput struct {
+	CeremonyToken string          `json:"ceremony_token" binding:"required"`
+	Credential    json.RawMessage `json:"credential" binding:"required"` // navigator.credentials.get() 的結果
+}
+
+// PasskeyMFABeginInput 以通行金鑰進行登入第二步驟的輸入
+type PasskeyMFABeginInput struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// PasskeyMFAFinishInput 完成通行金鑰登入第二步驟的輸入
+type PasskeyMFAFinishInput struct {
+	MFAToken      string          `json:"mfa_token" binding:"required"`
+	CeremonyToken string          `json:"ceremony_token" binding:"required"`
+	Credential    json.RawMessage `json:"credential" binding:"required"`
+}
+
+// RenamePasskeyInput 修改通行金鑰顯示名稱的輸入
+type RenamePasskeyInput struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
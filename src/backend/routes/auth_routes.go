@@ -11,6 +11,70 @@ func RegisterAuthRoutes(r *gin.RouterGroup) {
 	auth := r.Group("/auth")
 	{
 		auth.POST("/login", controllers.Login)
+		auth.POST("/refresh", controllers.RefreshToken)
+		auth.POST("/logout", controllers.Logout)
+		auth.POST("/forgot-password", controllers.ForgotPassword)
+		auth.POST("/reset-password", controllers.ResetPassword)
+		auth.GET("/password-policy", controllers.GetPasswordPolicy)
+
+		// 受邀者查詢邀請並設定密碼 (令牌放在請求內容，避免出現在存取紀錄)
+		auth.POST("/invitation", controllers.GetInvitation)
+		auth.POST("/invitation/accept", controllers.AcceptInvitation)
+
+		// 變更電子郵件後的重新驗證
+		auth.POST("/verify-email", controllers.VerifyEmail)
+
+		// 兩步驟驗證 (TOTP)
+		mfa := auth.Group("/mfa")
+		{
+			// 登入第二步驟，使用 Login 回傳的暫時令牌
+			mfa.POST("/challenge", controllers.CompleteMFALogin)
+			mfa.POST("/passkey/begin", controllers.BeginPasskeyMFA)
+			mfa.POST("/passkey/finish", controllers.FinishPasskeyMFA)
+
+			// 綁定流程允許尚未完成強制兩步驟驗證的令牌
+			mfa.POST("/enroll", middleware.MFASetupAuthMiddleware(), middleware.DenyImpersonation(), controllers.EnrollMFA)
+			mfa.POST("/verify", middleware.MFASetupAuthMiddleware(), middleware.DenyImpersonation(), controllers.ConfirmMFA)
+
+			mfa.POST("/disable", middleware.AuthMiddleware(), middleware.DenyImpersonation(), controllers.DisableMFA)
+			mfa.POST("/recovery-codes", middleware.AuthMiddleware(), middleware.DenyImpersonation(), controllers.RegenerateRecoveryCodes)
+		}
+
+		// 以通行金鑰免密碼登入 (可探索憑證，不需輸入帳號)
+		passkey := auth.Group("/passkey")
+		{
+			passkey.POST("/login/begin", controllers.BeginPasskeyLogin)
+			passkey.POST("/login/finish", controllers.FinishPasskeyLogin)
+		}
+
+		// OpenID Connect 單一登入 (authorization code + PKCE)
+		oidc := auth.Group("/oidc")
+		{
+			oidc.GET("/providers", controllers.GetOIDCProviders)
+			oidc.GET("/:provider/login", controllers.OIDCLogin)
+			oidc.GET("/:provider/callback", controllers.OIDCCallback)
+			oidc.POST("/exchange", controllers.OIDCExchange)
+		}
+
+		// 目前使用者的登入工作階段
+		sessions := auth.Group("/sessions")
+		sessions.Use(middleware.AuthMiddleware())
+		{
+			sessions.GET("/", controllers.GetMySessions)
+			sessions.DELETE("/", middleware.DenyImpersonation(), controllers.TerminateOtherSessions)
+			sessions.DELETE("/:id", middleware.DenyImpersonation(), controllers.TerminateMySession)
+		}
+
+		// 結束模擬登入 (使用模擬登入令牌呼叫)
+		auth.POST("/impersonation/end", middleware.AuthMiddleware(), controllers.EndImpersonation)
+
+		// JWT 簽章金鑰管理 (公開金鑰另見 /.well-known/jwks.json)
+		signingKeys := auth.Group("/signing-keys")
+		signingKeys.Use(middleware.AuthMiddleware(), middleware.DenyImpersonation(), middleware.RequirePermission("system.settings.manage"))
+		{
+			signingKeys.GET("/", controllers.GetSigningKeys)
+			signingKeys.POST("/rotate", controllers.RotateSigningKey)
+		}
 
 		// 添加驗證端點，使用標準的身份驗證中間件
 		auth.GET("/verify", middleware.AuthMiddleware(), controllers.VerifyToken)
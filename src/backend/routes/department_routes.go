@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"erp/controllers"
+	"erp/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterDepartmentRoutes(r *gin.RouterGroup) {
+	departments := r.Group("/departments")
+	departments.Use(middleware.AuthMiddleware())
+	{
+		// 組織架構管理需要 system.departments.manage 權限，模擬登入期間不可變更
+		departments.POST("/", middleware.RequirePermission("system.departments.manage"), middleware.DenyImpersonation(), controllers.CreateDepartment)
+		departments.GET("/", controllers.GetDepartments)
+		departments.GET("/tree", controllers.GetDepartmentTree)
+		departments.GET("/:id", controllers.GetDepartmentByID)
+		departments.PUT("/:id", middleware.RequirePermission("system.departments.manage"), middleware.DenyImpersonation(), controllers.UpdateDepartment)
+		departments.DELETE("/:id", middleware.RequirePermission("system.departments.manage"), middleware.DenyImpersonation(), controllers.DeleteDepartment)
+
+		// 移動部門會連同所有子部門一起移動
+		departments.POST("/:id/move", middleware.RequirePermission("system.departments.manage"), middleware.DenyImpersonation(), controllers.MoveDepartment)
+
+		// 部門成員，sub_departments=true 時包含所有子部門
+		departments.GET("/:id/users", controllers.GetDepartmentUsers)
+	}
+}
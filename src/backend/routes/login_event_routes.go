@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"erp/controllers"
+	"erp/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterLoginEventRoutes(r *gin.RouterGroup) {
+	events := r.Group("/login-events")
+	events.Use(middleware.AuthMiddleware(), middleware.RequirePermission("system.logs.view"))
+	{
+		events.GET("/", controllers.GetLoginEvents)
+	}
+}
@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"erp/controllers"
+	"erp/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterMeRoutes(r *gin.RouterGroup) {
+	me := r.Group("/me")
+	me.Use(middleware.AuthMiddleware())
+	{
+		// API 金鑰可查詢所屬的服務帳號與金鑰本身的權限，其餘路由不開放金鑰存取
+		me.GET("", middleware.AllowAPIKey(), controllers.GetMe)
+		me.PUT("", controllers.UpdateMe)
+		me.PUT("/password", middleware.DenyImpersonation(), controllers.ChangeMyPassword)
+		me.GET("/roles", controllers.GetMyRoles)
+		me.GET("/permissions", middleware.AllowAPIKey(), controllers.GetMyPermissions)
+		me.GET("/preferences", controllers.GetMyPreferences)
+		me.PUT("/preferences", controllers.UpdateMyPreferences)
+		me.GET("/profile", controllers.GetMyProfile)
+		me.PUT("/profile", controllers.UpdateMyProfile)
+		me.PUT("/avatar", controllers.UploadMyAvatar)
+		me.DELETE("/avatar", controllers.DeleteMyAvatar)
+		me.GET("/departments", controllers.GetMyDepartments)
+	}
+
+	// 通行金鑰 (WebAuthn)；綁定流程允許尚未完成強制兩步驟驗證的令牌
+	passkeys := r.Group("/me/passkeys")
+	{
+		passkeys.GET("", middleware.AuthMiddleware(), controllers.GetMyPasskeys)
+		passkeys.POST("/register/begin", middleware.MFASetupAuthMiddleware(), middleware.DenyImpersonation(), controllers.BeginPasskeyRegistration)
+		passkeys.POST("/register/finish", middleware.MFASetupAuthMiddleware(), middleware.DenyImpersonation(), controllers.FinishPasskeyRegistration)
+		passkeys.PUT("/:id", middleware.AuthMiddleware(), middleware.DenyImpersonation(), controllers.RenameMyPasskey)
+		passkeys.DELETE("/:id", middleware.AuthMiddleware(), middleware.DenyImpersonation(), controllers.DeleteMyPasskey)
+	}
+}
@@ -10,15 +10,15 @@ func RegisterPermissionRoutes(r *gin.RouterGroup) {
 	permissions := r.Group("/permissions")
 	permissions.Use(middleware.AuthMiddleware())
 	{
-		// 權限管理需要管理員權限
-		permissions.POST("/", middleware.AdminMiddleware(), controllers.CreatePermission)
+		// 權限管理需要 system.roles.manage 權限，模擬登入期間不可變更
+		permissions.POST("/", middleware.RequirePermission("system.roles.manage"), middleware.DenyImpersonation(), controllers.CreatePermission)
 		permissions.GET("/", controllers.GetPermissions)
 		permissions.GET("/:id", controllers.GetPermissionByID)
-		permissions.PUT("/:id", middleware.AdminMiddleware(), controllers.UpdatePermission)
-		permissions.DELETE("/:id", middleware.AdminMiddleware(), controllers.DeletePermission)
+		permissions.PUT("/:id", middleware.RequirePermission("system.roles.manage"), middleware.DenyImpersonation(), controllers.UpdatePermission)
+		permissions.DELETE("/:id", middleware.RequirePermission("system.roles.manage"), middleware.DenyImpersonation(), controllers.DeletePermission)
 
 		// 角色權限分配 - 使用不同的路徑結構避免參數衝突
-		permissions.POST("/:id/roles/:roleId", middleware.AdminMiddleware(), controllers.AssignPermissionToRole)
-		permissions.DELETE("/:id/roles/:roleId", middleware.AdminMiddleware(), controllers.RemovePermissionFromRole)
+		permissions.POST("/:id/roles/:roleId", middleware.RequirePermission("system.roles.manage"), middleware.DenyImpersonation(), controllers.AssignPermissionToRole)
+		permissions.DELETE("/:id/roles/:roleId", middleware.RequirePermission("system.roles.manage"), middleware.DenyImpersonation(), controllers.RemovePermissionFromRole)
 	}
 }
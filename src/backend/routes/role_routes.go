@@ -10,15 +10,15 @@ func RegisterRoleRoutes(r *gin.RouterGroup) {
 	roles := r.Group("/roles")
 	roles.Use(middleware.AuthMiddleware())
 	{
-		// 角色管理需要管理員權限
-		roles.POST("/", middleware.AdminMiddleware(), controllers.CreateRole)
+		// 角色管理需要 system.roles.manage 權限，模擬登入期間不可變更
+		roles.POST("/", middleware.RequirePermission("system.roles.manage"), middleware.DenyImpersonation(), controllers.CreateRole)
 		roles.GET("/", controllers.GetRoles)
 		roles.GET("/:id", controllers.GetRoleByID)
-		roles.PUT("/:id", middleware.AdminMiddleware(), controllers.UpdateRole)
-		roles.DELETE("/:id", middleware.AdminMiddleware(), controllers.DeleteRole)
+		roles.PUT("/:id", middleware.RequirePermission("system.roles.manage"), middleware.DenyImpersonation(), controllers.UpdateRole)
+		roles.DELETE("/:id", middleware.RequirePermission("system.roles.manage"), middleware.DenyImpersonation(), controllers.DeleteRole)
 
 		// 使用者角色分配 - 使用不同的路徑結構避免參數衝突
-		roles.POST("/:id/users/:userId", middleware.AdminMiddleware(), controllers.AssignRoleToUser)
-		roles.DELETE("/:id/users/:userId", middleware.AdminMiddleware(), controllers.RemoveRoleFromUser)
+		roles.POST("/:id/users/:userId", middleware.RequirePermission("system.roles.manage"), middleware.DenyImpersonation(), controllers.AssignRoleToUser)
+		roles.DELETE("/:id/users/:userId", middleware.RequirePermission("system.roles.manage"), middleware.DenyImpersonation(), controllers.RemoveRoleFromUser)
 	}
 }
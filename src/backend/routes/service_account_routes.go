@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"erp/controllers"
+	"erp/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterServiceAccountRoutes(r *gin.RouterGroup) {
+	accounts := r.Group("/service-accounts")
+	accounts.Use(middleware.AuthMiddleware(), middleware.DenyImpersonation(), middleware.RequirePermission("system.api_keys.manage"))
+	{
+		accounts.POST("/", controllers.CreateServiceAccount)
+		accounts.GET("/", controllers.GetServiceAccounts)
+
+		// API 金鑰管理
+		accounts.POST("/:id/api-keys", controllers.CreateAPIKey)
+		accounts.GET("/:id/api-keys", controllers.GetAPIKeys)
+		accounts.POST("/:id/api-keys/:keyId/rotate", controllers.RotateAPIKey)
+		accounts.DELETE("/:id/api-keys/:keyId", controllers.RevokeAPIKey)
+	}
+}
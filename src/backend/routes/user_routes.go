@@ -11,10 +11,62 @@ func RegisterUserRoutes(r *gin.RouterGroup) {
 	users.Use(middleware.AuthMiddleware())
 	{
 		// 創建使用者需要管理員權限
-		users.POST("/", middleware.AdminMiddleware(), controllers.CreateUser)
+		users.POST("/", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.CreateUser)
 		users.GET("/", controllers.GetUsers)
+
+		// 邀請使用者自行設定密碼需要管理員權限
+		users.POST("/invite", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.InviteUser)
+		users.POST("/:id/invitation/resend", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.ResendInvitation)
+		users.DELETE("/:id/invitation", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.RevokeInvitation)
+
+		// 批次匯入 (以邀請方式建立) 與匯出使用者需要管理員權限
+		users.POST("/import", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.ImportUsers)
+		users.GET("/export", middleware.AdminMiddleware(), controllers.ExportUsers)
+
 		users.GET("/:id", controllers.GetUserByID)
+		// 一般使用者只能修改自己的部分欄位；刪除需要管理員權限，且管理員不可管理超級管理員 (於控制器內檢查)
 		users.PUT("/:id", controllers.UpdateUser)
-		users.DELETE("/:id", controllers.DeleteUser)
+		users.DELETE("/:id", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.DeleteUser)
+
+		// 強制登出需要管理員權限
+		users.POST("/:id/logout", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.ForceLogoutUser)
+
+		// 查看與結束使用者的登入工作階段需要管理員權限
+		users.GET("/:id/sessions", middleware.AdminMiddleware(), controllers.GetUserSessions)
+		users.DELETE("/:id/sessions/:sessionId", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.TerminateUserSession)
+
+		// 登入紀錄：本人或擁有 system.logs.view 權限者可查看 (於控制器內檢查)
+		users.GET("/:id/login-history", controllers.GetUserLoginHistory)
+
+		// 解除登入鎖定需要管理員權限
+		users.POST("/:id/unlock", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.UnlockUser)
+
+		// 設定使用者的來源網路允許清單需要管理員權限
+		users.PUT("/:id/allowed-networks", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.SetUserAllowedNetworks)
+
+		// 個人資料與頭像，修改需要管理員權限
+		users.GET("/:id/profile", controllers.GetUserProfile)
+		users.PUT("/:id/profile", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.UpdateUserProfile)
+		users.GET("/:id/avatar", controllers.GetUserAvatar)
+		users.PUT("/:id/avatar", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.UploadUserAvatar)
+		users.DELETE("/:id/avatar", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.DeleteUserAvatar)
+
+		// 使用者所屬的部門，設定需要 system.departments.manage 權限
+		users.GET("/:id/departments", controllers.GetUserDepartments)
+		users.PUT("/:id/departments", middleware.RequirePermission("system.departments.manage"), middleware.DenyImpersonation(), controllers.SetUserDepartments)
+
+		// 變更帳號狀態 (停權、鎖定、封存、恢復) 與還原已刪除的使用者需要管理員權限
+		users.POST("/:id/suspend", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.SuspendUser)
+		users.POST("/:id/lock", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.LockUser)
+		users.POST("/:id/archive", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.ArchiveUser)
+		users.POST("/:id/reactivate", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.ReactivateUser)
+		users.POST("/:id/restore", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.RestoreUser)
+
+		// 重設使用者的兩步驟驗證需要管理員權限
+		users.DELETE("/:id/mfa", middleware.AdminMiddleware(), middleware.DenyImpersonation(), controllers.ResetUserMFA)
+
+		// 模擬登入與稽核紀錄只開放超級管理員
+		users.POST("/:id/impersonate", middleware.LevelMiddleware("super_admin"), middleware.DenyImpersonation(), controllers.ImpersonateUser)
+		users.GET("/:id/impersonation-logs", middleware.LevelMiddleware("super_admin"), controllers.GetImpersonationLogs)
 	}
 }
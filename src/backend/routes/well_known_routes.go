@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"erp/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterWellKnownRoutes(r *gin.RouterGroup) {
+	wellKnown := r.Group("/.well-known")
+	{
+		wellKnown.GET("/jwks.json", controllers.GetJWKS)
+	}
+}
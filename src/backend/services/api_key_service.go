@@ -0,0 +1,253 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"erp/db"
+	"erp/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidAPIKey API 金鑰格式錯誤、不存在、已撤銷或已過期
+var ErrInvalidAPIKey = errors.New("無效的 API 金鑰")
+
+// ErrNotServiceAccount 只有服務帳號可以擁有 API 金鑰
+var ErrNotServiceAccount = errors.New("只有服務帳號可以使用 API 金鑰")
+
+// ErrUnknownPermission 權限代碼不存在
+var ErrUnknownPermission = errors.New("權限代碼不存在")
+
+// ErrPermissionNotGrantable 不可授予自己沒有的權限
+var ErrPermissionNotGrantable = errors.New("無法授予自己沒有的權限")
+
+// ErrInvalidAPIKeyExpiry 到期時間已過或超過允許的最長期限
+var ErrInvalidAPIKeyExpiry = errors.New("無效的 API 金鑰到期時間")
+
+// API 金鑰格式為 erp_<前綴>_<密鑰>，前綴可公開用於識別
+const (
+	apiKeyScheme           = "erp"
+	apiKeyPrefixBytes      = 6
+	defaultAPIKeyTTL       = 90 * 24 * time.Hour  // API_KEY_DEFAULT_TTL: 未指定到期時間時的有效期限
+	defaultAPIKeyMaxTTL    = 365 * 24 * time.Hour // API_KEY_MAX_TTL: 可設定的最長有效期限
+	apiKeyLastUsedInterval = time.Minute          // 最後使用時間的更新間隔，避免每個請求都寫入資料庫
+)
+
+// APIKeyService 服務帳號與 API 金鑰服務
+type APIKeyService struct {
+	userRepo          db.UserRepository
+	apiKeyRepo        db.APIKeyRepository
+	permissionRepo    db.PermissionRepository
+	permissionService *PermissionService
+	defaultTTL        time.Duration
+	maxTTL            time.Duration
+}
+
+// NewAPIKeyService 建立 API 金鑰服務實例
+func NewAPIKeyService(userRepo db.UserRepository, apiKeyRepo db.APIKeyRepository, permissionRepo db.PermissionRepository, permissionService *PermissionService) *APIKeyService {
+	return &APIKeyService{
+		userRepo:          userRepo,
+		apiKeyRepo:        apiKeyRepo,
+		permissionRepo:    permissionRepo,
+		permissionService: permissionService,
+		defaultTTL:        durationFromEnv("API_KEY_DEFAULT_TTL", defaultAPIKeyTTL),
+		maxTTL:            durationFromEnv("API_KEY_MAX_TTL", defaultAPIKeyMaxTTL),
+	}
+}
+
+// CreateServiceAccount 建立服務帳號，密碼設為無法使用的隨機值
+func (s *APIKeyService) CreateServiceAccount(username, email string) (*models.User, error) {
+	if email == "" {
+		email = username + "@service.local"
+	}
+	password, err := unusablePasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Username:       username,
+		Email:          email,
+		Password:       password,
+		Level:          "user",
+		ServiceAccount: true,
+	}
+	if err := s.userRepo.Create(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Create 為服務帳號建立 API 金鑰，回傳金鑰紀錄與只會顯示一次的明文金鑰
+// 建立者必須從目前的來源 IP (creatorIP) 擁有所有要授予的權限；以 API 金鑰呼叫時 (creatorKey) 也必須在該金鑰的範圍內
+func (s *APIKeyService) Create(serviceAccount *models.User, creatorID uint, creatorIP string, creatorKey *models.APIKey, name string, permissions []string, expiresAt *time.Time) (*models.APIKey, string, error) {
+	if !serviceAccount.ServiceAccount {
+		return nil, "", ErrNotServiceAccount
+	}
+
+	codes, err := s.validatePermissions(creatorID, creatorIP, creatorKey, permissions)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if expiresAt == nil {
+		defaultExpiry := now.Add(s.defaultTTL)
+		expiresAt = &defaultExpiry
+	}
+	if !expiresAt.After(now) || expiresAt.After(now.Add(s.maxTTL)) {
+		return nil, "", ErrInvalidAPIKeyExpiry
+	}
+
+	return s.issue(&models.APIKey{
+		UserID:      serviceAccount.ID,
+		Name:        name,
+		Permissions: strings.Join(codes, ","),
+		ExpiresAt:   expiresAt,
+		CreatedBy:   creatorID,
+	})
+}
+
+// Rotate 以相同的名稱、權限與有效期限長度建立新金鑰，舊金鑰在寬限期後失效
+func (s *APIKeyService) Rotate(key *models.APIKey, creatorID uint, creatorIP string, creatorKey *models.APIKey, gracePeriod time.Duration) (*models.APIKey, string, error) {
+	now := time.Now()
+	if !key.IsActive(now) {
+		return nil, "", ErrInvalidAPIKey
+	}
+
+	codes, err := s.validatePermissions(creatorID, creatorIP, creatorKey, key.PermissionCodes())
+	if err != nil {
+		return nil, "", err
+	}
+
+	expiresAt := now.Add(s.defaultTTL)
+	if key.ExpiresAt != nil {
+		expiresAt = now.Add(key.ExpiresAt.Sub(key.CreatedAt))
+	}
+	if expiresAt.After(now.Add(s.maxTTL)) {
+		expiresAt = now.Add(s.maxTTL)
+	}
+
+	rotated, rawKey, err := s.issue(&models.APIKey{
+		UserID:      key.UserID,
+		Name:        key.Name,
+		Permissions: strings.Join(codes, ","),
+		ExpiresAt:   &expiresAt,
+		CreatedBy:   creatorID,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := s.apiKeyRepo.Revoke(key.ID, now.Add(gracePeriod)); err != nil {
+		return nil, "", err
+	}
+	return rotated, rawKey, nil
+}
+
+// Revoke 立即撤銷 API 金鑰
+func (s *APIKeyService) Revoke(key *models.APIKey) error {
+	return s.apiKeyRepo.Revoke(key.ID, time.Now())
+}
+
+// RevokeAll 撤銷服務帳號的所有 API 金鑰 (例如刪除服務帳號時)
+func (s *APIKeyService) RevokeAll(userID uint) error {
+	return s.apiKeyRepo.RevokeAllByUserID(userID, time.Now())
+}
+
+// Authenticate 驗證 X-API-Key 標頭的金鑰，回傳所屬的服務帳號與金鑰紀錄
+func (s *APIKeyService) Authenticate(rawKey, clientIP string) (*models.User, *models.APIKey, error) {
+	parts := strings.Split(rawKey, "_")
+	if len(parts) != 3 || parts[0] != apiKeyScheme {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.apiKeyRepo.GetByPrefix(parts[1])
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashToken(rawKey))) != 1 || !key.IsActive(now) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.userRepo.GetByID(key.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.ServiceAccount || user.IsLocked(now) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyLastUsedInterval {
+		if err := s.apiKeyRepo.UpdateLastUsed(key.ID, now, clientIP); err != nil {
+			return nil, nil, err
+		}
+		key.LastUsedAt = &now
+		key.LastUsedIP = clientIP
+	}
+	return user, key, nil
+}
+
+// issue 產生金鑰前綴與密鑰並儲存雜湊值
+func (s *APIKeyService) issue(key *models.APIKey) (*models.APIKey, string, error) {
+	prefixBytes := make([]byte, apiKeyPrefixBytes)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return nil, "", fmt.Errorf("無法產生 API 金鑰: %v", err)
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key.Prefix = hex.EncodeToString(prefixBytes)
+	// base64url 可能包含底線，改為連字號以免與分隔符號衝突
+	rawKey := fmt.Sprintf("%s_%s_%s", apiKeyScheme, key.Prefix, strings.ReplaceAll(secret, "_", "-"))
+	key.KeyHash = hashToken(rawKey)
+
+	if err := s.apiKeyRepo.Create(key); err != nil {
+		return nil, "", err
+	}
+	return key, rawKey, nil
+}
+
+// validatePermissions 檢查權限代碼存在且建立者擁有該權限，回傳去除重複後的代碼
+// 建立者以 API 金鑰呼叫時只能授予該金鑰本身擁有的權限，避免金鑰建立範圍更大的金鑰
+func (s *APIKeyService) validatePermissions(creatorID uint, creatorIP string, creatorKey *models.APIKey, permissions []string) ([]string, error) {
+	seen := make(map[string]bool)
+	codes := make([]string, 0, len(permissions))
+	for _, code := range permissions {
+		code = strings.TrimSpace(code)
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+
+		if _, err := s.permissionRepo.GetByCode(code); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownPermission, code)
+			}
+			return nil, err
+		}
+		if !s.permissionService.HasPermission(creatorID, code, creatorIP) || (creatorKey != nil && !creatorKey.Allows(code)) {
+			return nil, fmt.Errorf("%w: %s", ErrPermissionNotGrantable, code)
+		}
+		codes = append(codes, code)
+	}
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("%w: 未指定任何權限", ErrUnknownPermission)
+	}
+	return codes, nil
+}
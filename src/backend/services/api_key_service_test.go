@@ -0,0 +1,35 @@
+package services
+
+import (
+	"erp/models"
+	"errors"
+	"testing"
+)
+
+func TestAPIKeyCallerCannotGrantOutsideItsScope(t *testing.T) {
+	userRepo := &fakeUserRepo{}
+	userRepo.Create(&models.User{Username: "root", Level: "super_admin"})
+	userRepo.Create(&models.User{Username: "ci", Level: "user", ServiceAccount: true})
+	creator, _ := userRepo.GetByUsername("root")
+	serviceAccount, _ := userRepo.GetByUsername("ci")
+	permissionRepo := &fakePermissionRepo{codes: []string{"system.api_keys.manage", "system.users.manage"}}
+	service := NewAPIKeyService(userRepo, &fakeAPIKeyRepo{}, permissionRepo, NewPermissionService(userRepo, nil, nil))
+
+	// 建立者本身擁有所有權限，但呼叫用的金鑰只有 system.api_keys.manage
+	callerKey := &models.APIKey{Permissions: "system.api_keys.manage"}
+	if _, _, err := service.Create(serviceAccount, creator.ID, "10.0.0.1", callerKey, "wider", []string{"system.api_keys.manage", "system.users.manage"}, nil); !errors.Is(err, ErrPermissionNotGrantable) {
+		t.Fatalf("granting outside the caller key: err = %v, want ErrPermissionNotGrantable", err)
+	}
+	if _, _, err := service.Create(serviceAccount, creator.ID, "10.0.0.1", callerKey, "same", []string{"system.api_keys.manage"}, nil); err != nil {
+		t.Fatalf("granting within the caller key: %v", err)
+	}
+
+	// 輪替同樣受限於呼叫用金鑰的範圍
+	wide, _, err := service.Create(serviceAccount, creator.ID, "10.0.0.1", nil, "wide", []string{"system.users.manage"}, nil)
+	if err != nil {
+		t.Fatalf("granting with a bearer token: %v", err)
+	}
+	if _, _, err := service.Rotate(wide, creator.ID, "10.0.0.1", callerKey, 0); !errors.Is(err, ErrPermissionNotGrantable) {
+		t.Fatalf("rotating a wider key: err = %v, want ErrPermissionNotGrantable", err)
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"erp/models"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials 帳號或密碼錯誤
+var ErrInvalidCredentials = errors.New("帳號或密碼錯誤")
+
+// ErrUnknownAuthBackend 未啟用的驗證後端
+var ErrUnknownAuthBackend = errors.New("未啟用的驗證後端")
+
+// 驗證後端名稱
+const (
+	AuthBackendLocal = "local"
+	AuthBackendLDAP  = "ldap"
+)
+
+// Authenticator 帳號密碼驗證後端
+type Authenticator interface {
+	// Name 驗證後端名稱，對應使用者的 AuthBackends 設定
+	Name() string
+	// Authenticate 驗證帳號密碼；user 為本地既有的使用者 (可能為 nil)，成功時回傳對應的本地使用者
+	// 帳號或密碼錯誤時回傳 ErrInvalidCredentials，其他錯誤代表後端暫時無法使用
+	Authenticate(user *models.User, username, password string) (*models.User, error)
+}
+
+// LocalAuthenticator 以資料庫中的 bcrypt 雜湊驗證密碼
+type LocalAuthenticator struct{}
+
+// dummyPasswordHash 使用者不存在時用來比對的雜湊值，使回應時間與密碼錯誤時一致
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// NewLocalAuthenticator 建立本地密碼驗證後端
+func NewLocalAuthenticator() *LocalAuthenticator {
+	return &LocalAuthenticator{}
+}
+
+// Name 實作 Authenticator
+func (a *LocalAuthenticator) Name() string {
+	return AuthBackendLocal
+}
+
+// Authenticate 實作 Authenticator
+func (a *LocalAuthenticator) Authenticate(user *models.User, username, password string) (*models.User, error) {
+	if user == nil {
+		// 使用者不存在時仍執行一次 bcrypt，避免以回應時間判斷帳號是否存在
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// AuthenticationService 依使用者設定的順序嘗試各驗證後端
+type AuthenticationService struct {
+	authenticators map[string]Authenticator
+	defaultOrder   []string
+}
+
+// NewAuthenticationService 建立驗證服務實例
+// AUTH_BACKEND_ORDER 設定使用者未指定時的預設順序 (例如 "local,ldap")，未設定時只使用本地密碼
+func NewAuthenticationService(authenticators ...Authenticator) *AuthenticationService {
+	s := &AuthenticationService{
+		authenticators: make(map[string]Authenticator),
+	}
+	for _, authenticator := range authenticators {
+		s.authenticators[authenticator.Name()] = authenticator
+	}
+
+	order := os.Getenv("AUTH_BACKEND_ORDER")
+	if order == "" {
+		order = AuthBackendLocal
+	}
+	if _, err := s.NormalizeOrder(order); err != nil {
+		fmt.Fprintf(os.Stderr, "AUTH_BACKEND_ORDER 設定錯誤 (%v)，改為只使用本地密碼\n", err)
+		order = AuthBackendLocal
+	}
+	s.defaultOrder = parseAuthOrder(order)
+	return s
+}
+
+// Backends 回傳已啟用的驗證後端名稱
+func (s *AuthenticationService) Backends() []string {
+	names := make([]string, 0, len(s.authenticators))
+	for name := range s.authenticators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NormalizeOrder 檢查驗證順序設定 (以逗號分隔的後端名稱) 是否都已啟用，回傳正規化後的設定
+func (s *AuthenticationService) NormalizeOrder(order string) (string, error) {
+	names := parseAuthOrder(order)
+	if len(names) == 0 {
+		return "", fmt.Errorf("%w: 未指定任何驗證後端", ErrUnknownAuthBackend)
+	}
+	for _, name := range names {
+		if _, ok := s.authenticators[name]; !ok {
+			return "", fmt.Errorf("%w: %s", ErrUnknownAuthBackend, name)
+		}
+	}
+	return strings.Join(names, ","), nil
+}
+
+// Authenticate 依序嘗試驗證後端，回傳通過驗證的使用者與後端名稱
+// 所有後端都拒絕時回傳 ErrInvalidCredentials；有後端無法完成驗證時回傳該錯誤，避免目錄服務中斷時累計登入失敗
+func (s *AuthenticationService) Authenticate(user *models.User, username, password string) (*models.User, string, error) {
+	var lastErr error
+	for _, name := range s.orderFor(user) {
+		authenticator, ok := s.authenticators[name]
+		if !ok {
+			continue
+		}
+
+		authenticated, err := authenticator.Authenticate(user, username, password)
+		if err == nil {
+			return authenticated, name, nil
+		}
+		if errors.Is(err, ErrInvalidCredentials) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "驗證後端 %s 無法完成驗證: %v\n", name, err)
+		lastErr = err
+	}
+
+	if lastErr != nil {
+		return nil, "", lastErr
+	}
+	return nil, "", ErrInvalidCredentials
+}
+
+// orderFor 取得使用者的驗證順序，未設定時使用預設順序
+func (s *AuthenticationService) orderFor(user *models.User) []string {
+	if user != nil && user.AuthBackends != "" {
+		return parseAuthOrder(user.AuthBackends)
+	}
+	return s.defaultOrder
+}
+
+// parseAuthOrder 解析以逗號分隔的驗證後端名稱
+func parseAuthOrder(order string) []string {
+	var names []string
+	for _, name := range strings.Split(order, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
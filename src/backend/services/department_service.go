@@ -0,0 +1,282 @@
+package services
+
+import (
+	"erp/db"
+	"erp/models"
+	"errors"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 部門錯誤
+var (
+	ErrDepartmentNotFound     = errors.New("找不到部門")
+	ErrDepartmentNameTaken    = errors.New("同一個上層部門底下已有相同名稱的部門")
+	ErrDepartmentCycle        = errors.New("無法將部門移到自己或自己的子部門底下")
+	ErrDepartmentNotEmpty     = errors.New("部門仍有子部門或成員，無法刪除")
+	ErrInvalidDepartmentHead  = errors.New("找不到指定的部門主管")
+	ErrPrimaryDepartmentUnset = errors.New("設定兼任部門前必須先指定主要部門")
+)
+
+// DepartmentService 部門與組織架構管理
+// 部門以物化路徑 (Path) 表示樹狀結構，供其他模組以「某部門底下 (含子部門) 的使用者」限定資料範圍
+type DepartmentService struct {
+	departmentRepo     db.DepartmentRepository
+	userDepartmentRepo db.UserDepartmentRepository
+	userRepo           db.UserRepository
+}
+
+// NewDepartmentService 建立部門服務實例
+func NewDepartmentService(departmentRepo db.DepartmentRepository, userDepartmentRepo db.UserDepartmentRepository, userRepo db.UserRepository) *DepartmentService {
+	return &DepartmentService{
+		departmentRepo:     departmentRepo,
+		userDepartmentRepo: userDepartmentRepo,
+		userRepo:           userRepo,
+	}
+}
+
+// Get 取得部門
+func (s *DepartmentService) Get(id uint) (*models.Department, error) {
+	department, err := s.departmentRepo.GetByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDepartmentNotFound
+	}
+	return department, err
+}
+
+// List 取得所有部門 (平面清單，上層部門排在子部門之前)
+func (s *DepartmentService) List() ([]models.Department, error) {
+	return s.departmentRepo.GetAll()
+}
+
+// Tree 取得部門樹；指定 rootID 時只回傳該部門 (含子部門) 的子樹
+func (s *DepartmentService) Tree(rootID *uint) ([]*models.DepartmentNode, error) {
+	var departments []models.Department
+	var err error
+	if rootID != nil {
+		if _, err := s.Get(*rootID); err != nil {
+			return nil, err
+		}
+		departments, err = s.departmentRepo.GetSubtree(*rootID)
+	} else {
+		departments, err = s.departmentRepo.GetAll()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 依路徑排序後上層部門一定先出現，子部門可直接掛到已建立的節點
+	nodes := make(map[uint]*models.DepartmentNode, len(departments))
+	roots := []*models.DepartmentNode{}
+	for _, department := range departments {
+		node := &models.DepartmentNode{Department: department, Children: []*models.DepartmentNode{}}
+		nodes[department.ID] = node
+		if department.ParentID != nil {
+			if parent, ok := nodes[*department.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots, nil
+}
+
+// Create 建立部門
+func (s *DepartmentService) Create(input models.CreateDepartmentInput) (*models.Department, error) {
+	name := strings.TrimSpace(input.Name)
+	if input.ParentID != nil {
+		if _, err := s.Get(*input.ParentID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.checkName(input.ParentID, name, 0); err != nil {
+		return nil, err
+	}
+	if err := s.checkManager(input.ManagerID); err != nil {
+		return nil, err
+	}
+
+	department := &models.Department{
+		Name:           name,
+		ParentID:       input.ParentID,
+		ManagerID:      input.ManagerID,
+		CostCenterCode: strings.TrimSpace(input.CostCenterCode),
+	}
+	if err := s.departmentRepo.Create(department); err != nil {
+		return nil, err
+	}
+	return department, nil
+}
+
+// Update 更新部門名稱、主管與成本中心代碼
+func (s *DepartmentService) Update(department *models.Department, input models.UpdateDepartmentInput) error {
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if err := s.checkName(department.ParentID, name, department.ID); err != nil {
+			return err
+		}
+		department.Name = name
+	}
+	if input.ManagerID != nil {
+		department.ManagerID = nil
+		if *input.ManagerID != 0 {
+			if err := s.checkManager(input.ManagerID); err != nil {
+				return err
+			}
+			department.ManagerID = input.ManagerID
+		}
+	}
+	if input.CostCenterCode != nil {
+		department.CostCenterCode = strings.TrimSpace(*input.CostCenterCode)
+	}
+	return s.departmentRepo.Update(department)
+}
+
+// Move 將部門連同所有子部門移到另一個上層部門底下，parentID 為空值時移到最上層
+func (s *DepartmentService) Move(department *models.Department, parentID *uint) error {
+	var parent *models.Department
+	if parentID != nil {
+		var err error
+		if parent, err = s.Get(*parentID); err != nil {
+			return err
+		}
+		// 上層部門的路徑以自己的路徑開頭，代表是自己或自己的子部門
+		if strings.HasPrefix(parent.Path, department.Path) {
+			return ErrDepartmentCycle
+		}
+	}
+	if err := s.checkName(parentID, department.Name, department.ID); err != nil {
+		return err
+	}
+	return s.departmentRepo.Move(department, parent)
+}
+
+// Delete 刪除沒有子部門與成員的部門
+func (s *DepartmentService) Delete(department *models.Department) error {
+	children, err := s.departmentRepo.CountChildren(department.ID)
+	if err != nil {
+		return err
+	}
+	members, err := s.userDepartmentRepo.CountByDepartmentID(department.ID)
+	if err != nil {
+		return err
+	}
+	if children > 0 || members > 0 {
+		return ErrDepartmentNotEmpty
+	}
+	return s.departmentRepo.Delete(department.ID)
+}
+
+// UserDepartments 取得使用者所屬的部門，主要部門排在最前面
+func (s *DepartmentService) UserDepartments(userID uint) ([]models.UserDepartmentResponse, error) {
+	return s.userDepartmentRepo.GetByUserID(userID)
+}
+
+// PrimaryDepartments 批次取得多位使用者的主要部門
+func (s *DepartmentService) PrimaryDepartments(userIDs []uint) (map[uint]models.DepartmentSummary, error) {
+	return s.userDepartmentRepo.GetPrimaryByUserIDs(userIDs)
+}
+
+// SetUserDepartments 設定使用者的主要部門與兼任部門，取代原本的所有部門
+func (s *DepartmentService) SetUserDepartments(userID uint, input models.SetUserDepartmentsInput) ([]models.UserDepartmentResponse, error) {
+	if input.PrimaryDepartmentID == nil && len(input.SecondaryDepartmentIDs) > 0 {
+		return nil, ErrPrimaryDepartmentUnset
+	}
+
+	var memberships []models.UserDepartment
+	seen := map[uint]bool{}
+	if input.PrimaryDepartmentID != nil {
+		if _, err := s.Get(*input.PrimaryDepartmentID); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, models.UserDepartment{UserID: userID, DepartmentID: *input.PrimaryDepartmentID, IsPrimary: true})
+		seen[*input.PrimaryDepartmentID] = true
+	}
+	// 與主要部門重複或重複指定的兼任部門忽略
+	for _, id := range input.SecondaryDepartmentIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if _, err := s.Get(id); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, models.UserDepartment{UserID: userID, DepartmentID: id})
+	}
+
+	if err := s.userDepartmentRepo.ReplaceForUser(userID, memberships); err != nil {
+		return nil, err
+	}
+	return s.userDepartmentRepo.GetByUserID(userID)
+}
+
+// DepartmentIDsUnder 取得部門本身與底下所有子部門的 ID
+func (s *DepartmentService) DepartmentIDsUnder(departmentID uint) ([]uint, error) {
+	departments, err := s.departmentRepo.GetSubtree(departmentID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(departments))
+	for _, department := range departments {
+		ids = append(ids, department.ID)
+	}
+	return ids, nil
+}
+
+// UserIDsUnder 取得部門底下 (含所有子部門) 的使用者 ID，包含兼任成員
+func (s *DepartmentService) UserIDsUnder(departmentID uint) ([]uint, error) {
+	return s.userDepartmentRepo.GetUserIDs(departmentID, true, false)
+}
+
+// IsUserUnder 檢查使用者是否屬於部門或其子部門 (包含兼任)
+func (s *DepartmentService) IsUserUnder(userID, departmentID uint) (bool, error) {
+	return s.userDepartmentRepo.HasUser(departmentID, userID, true)
+}
+
+// ManagedUserIDs 取得使用者擔任主管的所有部門 (含子部門) 底下的使用者 ID，可用於主管的資料範圍
+func (s *DepartmentService) ManagedUserIDs(managerID uint) ([]uint, error) {
+	departments, err := s.departmentRepo.GetByManagerID(managerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var userIDs []uint
+	for _, department := range departments {
+		ids, err := s.UserIDsUnder(department.ID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, ids...)
+	}
+	slices.Sort(userIDs)
+	return slices.Compact(userIDs), nil
+}
+
+// checkName 檢查同一個上層部門底下沒有相同名稱的部門
+func (s *DepartmentService) checkName(parentID *uint, name string, excludeID uint) error {
+	exists, err := s.departmentRepo.NameExists(parentID, name, excludeID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrDepartmentNameTaken
+	}
+	return nil
+}
+
+// checkManager 檢查部門主管存在
+func (s *DepartmentService) checkManager(managerID *uint) error {
+	if managerID == nil {
+		return nil
+	}
+	if _, err := s.userRepo.GetByID(*managerID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidDepartmentHead
+		}
+		return err
+	}
+	return nil
+}
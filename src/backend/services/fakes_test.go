@@ -0,0 +1,404 @@
+package services
+
+import (
+	"erp/db"
+	"erp/mail"
+	"erp/models"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 測試用的記憶體 repository，只實作受測服務會呼叫的方法 (其餘方法由內嵌的介面提供，呼叫時會 panic)
+
+// fakeUserRepo 使用者
+type fakeUserRepo struct {
+	db.UserRepository
+	mu    sync.Mutex
+	users []*models.User
+}
+
+func (r *fakeUserRepo) Create(user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user.ID = uint(len(r.users) + 1)
+	stored := *user
+	r.users = append(r.users, &stored)
+	return nil
+}
+
+// Update 以整筆資料覆寫，不區分欄位
+func (r *fakeUserRepo) Update(user *models.User, columns ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, stored := range r.users {
+		if stored.ID == user.ID {
+			*stored = *user
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// UpdateUnlessLastActive 在同一個鎖內檢查與寫入，對應實作中的 SELECT ... FOR UPDATE
+func (r *fakeUserRepo) UpdateUnlessLastActive(user *models.User, level string, now time.Time, columns ...string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var active []uint
+	var target *models.User
+	for _, stored := range r.users {
+		if stored.Level == level && stored.IsActive(now) {
+			active = append(active, stored.ID)
+		}
+		if stored.ID == user.ID {
+			target = stored
+		}
+	}
+	if target == nil {
+		return false, gorm.ErrRecordNotFound
+	}
+	if len(active) == 1 && active[0] == user.ID {
+		return false, nil
+	}
+	*target = *user
+	return true, nil
+}
+
+func (r *fakeUserRepo) CountActiveByLevel(level string, now time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	for _, stored := range r.users {
+		if stored.Level == level && stored.IsActive(now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fakeUserRepo) find(match func(*models.User) bool) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if match(user) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeUserRepo) GetByID(id uint) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.ID == id })
+}
+
+func (r *fakeUserRepo) GetByEmail(email string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return strings.EqualFold(u.Email, email) })
+}
+
+func (r *fakeUserRepo) GetByUsername(username string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Username == username })
+}
+
+// fakeRoleRepo 角色 (沒有任何角色)
+type fakeRoleRepo struct {
+	db.RoleRepository
+}
+
+func (r *fakeRoleRepo) GetByName(name string) (*models.Role, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+// fakeIdentityRepo 外部身分
+type fakeIdentityRepo struct {
+	db.UserIdentityRepository
+	identities []*models.UserIdentity
+}
+
+func (r *fakeIdentityRepo) Create(identity *models.UserIdentity) error {
+	identity.ID = uint(len(r.identities) + 1)
+	stored := *identity
+	r.identities = append(r.identities, &stored)
+	return nil
+}
+
+func (r *fakeIdentityRepo) GetByProviderSubject(provider, subject string) (*models.UserIdentity, error) {
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			found := *identity
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeIdentityRepo) UpdateLastLogin(id uint, at time.Time) error {
+	for _, identity := range r.identities {
+		if identity.ID == id {
+			identity.LastLoginAt = &at
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// fakeOIDCStateRepo OIDC 授權狀態 (Consume 只能取出一次)
+type fakeOIDCStateRepo struct {
+	db.OIDCLoginStateRepository
+	states map[string]*models.OIDCLoginState
+}
+
+func (r *fakeOIDCStateRepo) Create(state *models.OIDCLoginState) error {
+	if r.states == nil {
+		r.states = make(map[string]*models.OIDCLoginState)
+	}
+	r.states[state.StateHash] = state
+	return nil
+}
+
+func (r *fakeOIDCStateRepo) Consume(stateHash string) (*models.OIDCLoginState, error) {
+	state, ok := r.states[stateHash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(r.states, stateHash)
+	return state, nil
+}
+
+func (r *fakeOIDCStateRepo) DeleteExpired(before time.Time) error {
+	return nil
+}
+
+// fakeOIDCCodeRepo 單一登入交換碼 (Consume 只能取出一次)
+type fakeOIDCCodeRepo struct {
+	db.OIDCLoginCodeRepository
+	codes map[string]*models.OIDCLoginCode
+}
+
+func (r *fakeOIDCCodeRepo) Create(code *models.OIDCLoginCode) error {
+	if r.codes == nil {
+		r.codes = make(map[string]*models.OIDCLoginCode)
+	}
+	r.codes[code.CodeHash] = code
+	return nil
+}
+
+func (r *fakeOIDCCodeRepo) Consume(codeHash string) (*models.OIDCLoginCode, error) {
+	code, ok := r.codes[codeHash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(r.codes, codeHash)
+	return code, nil
+}
+
+func (r *fakeOIDCCodeRepo) DeleteExpired(before time.Time) error {
+	return nil
+}
+
+// fakeCredentialRepo 通行金鑰
+type fakeCredentialRepo struct {
+	db.WebAuthnCredentialRepository
+	credentials []*models.WebAuthnCredential
+}
+
+func (r *fakeCredentialRepo) Create(credential *models.WebAuthnCredential) error {
+	credential.ID = uint(len(r.credentials) + 1)
+	stored := *credential
+	r.credentials = append(r.credentials, &stored)
+	return nil
+}
+
+func (r *fakeCredentialRepo) GetByUserID(userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	for _, credential := range r.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, *credential)
+		}
+	}
+	return credentials, nil
+}
+
+func (r *fakeCredentialRepo) GetByCredentialID(credentialID string) (*models.WebAuthnCredential, error) {
+	for _, credential := range r.credentials {
+		if credential.CredentialID == credentialID {
+			found := *credential
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeCredentialRepo) RecordUse(id uint, previousCount, signCount uint32, backupState bool, usedAt time.Time) (bool, error) {
+	for _, credential := range r.credentials {
+		if credential.ID == id && credential.SignCount == previousCount && !credential.CloneWarning {
+			credential.SignCount = signCount
+			credential.BackupState = backupState
+			credential.LastUsedAt = &usedAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeCredentialRepo) MarkCloned(id uint) error {
+	for _, credential := range r.credentials {
+		if credential.ID == id {
+			credential.CloneWarning = true
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// fakeCeremonyRepo WebAuthn 儀式狀態 (Consume 只能取出一次)
+type fakeCeremonyRepo struct {
+	db.WebAuthnCeremonyRepository
+	ceremonies map[string]*models.WebAuthnCeremony
+}
+
+func (r *fakeCeremonyRepo) Create(ceremony *models.WebAuthnCeremony) error {
+	if r.ceremonies == nil {
+		r.ceremonies = make(map[string]*models.WebAuthnCeremony)
+	}
+	r.ceremonies[ceremony.TokenHash] = ceremony
+	return nil
+}
+
+func (r *fakeCeremonyRepo) Consume(tokenHash string) (*models.WebAuthnCeremony, error) {
+	ceremony, ok := r.ceremonies[tokenHash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(r.ceremonies, tokenHash)
+	return ceremony, nil
+}
+
+func (r *fakeCeremonyRepo) DeleteExpired(before time.Time) error {
+	return nil
+}
+
+// fakeInvitationRepo 邀請與驗證令牌 (MarkAccepted 只能成功一次)
+type fakeInvitationRepo struct {
+	db.UserInvitationRepository
+	invitations []*models.UserInvitation
+}
+
+func (r *fakeInvitationRepo) Create(invitation *models.UserInvitation) error {
+	invitation.ID = uint(len(r.invitations) + 1)
+	stored := *invitation
+	r.invitations = append(r.invitations, &stored)
+	return nil
+}
+
+func (r *fakeInvitationRepo) GetByHash(tokenHash string) (*models.UserInvitation, error) {
+	for _, invitation := range r.invitations {
+		if invitation.TokenHash == tokenHash {
+			found := *invitation
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeInvitationRepo) MarkAccepted(id uint, acceptedAt time.Time) (bool, error) {
+	for _, invitation := range r.invitations {
+		if invitation.ID == id && invitation.IsActive(acceptedAt) {
+			invitation.AcceptedAt = &acceptedAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeInvitationRepo) RevokeByUserID(userID uint, revokedAt time.Time) (int64, error) {
+	var revoked int64
+	for _, invitation := range r.invitations {
+		if invitation.UserID == userID && invitation.IsActive(revokedAt) {
+			invitation.RevokedAt = &revokedAt
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// fakeMailSender 記錄寄出的郵件
+type fakeMailSender struct {
+	messages []mail.Message
+}
+
+func (s *fakeMailSender) Send(msg mail.Message) error {
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+// fakePermissionRepo 權限 (codes 中的代碼皆存在)
+type fakePermissionRepo struct {
+	db.PermissionRepository
+	codes []string
+}
+
+func (r *fakePermissionRepo) GetByCode(code string) (*models.Permission, error) {
+	for _, existing := range r.codes {
+		if existing == code {
+			return &models.Permission{Code: code}, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// fakeAPIKeyRepo API 金鑰
+type fakeAPIKeyRepo struct {
+	db.APIKeyRepository
+	keys []*models.APIKey
+}
+
+func (r *fakeAPIKeyRepo) Create(key *models.APIKey) error {
+	key.ID = uint(len(r.keys) + 1)
+	key.CreatedAt = time.Now()
+	r.keys = append(r.keys, key)
+	return nil
+}
+
+// fakeSigningKeyRepo 簽章金鑰 (loaded 在每次 GetValid 後收到通知，可為 nil)
+type fakeSigningKeyRepo struct {
+	db.SigningKeyRepository
+	mu     sync.Mutex
+	keys   []models.SigningKey
+	loaded chan struct{}
+}
+
+func (r *fakeSigningKeyRepo) Create(key *models.SigningKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key.ID = uint(len(r.keys) + 1)
+	r.keys = append(r.keys, *key)
+	return nil
+}
+
+func (r *fakeSigningKeyRepo) GetValid(now time.Time) ([]models.SigningKey, error) {
+	r.mu.Lock()
+	keys := append([]models.SigningKey(nil), r.keys...)
+	r.mu.Unlock()
+	if r.loaded != nil {
+		r.loaded <- struct{}{}
+	}
+	return keys, nil
+}
+
+func (r *fakeSigningKeyRepo) RetireOthers(keepID uint, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.keys {
+		if r.keys[i].ID != keepID && r.keys[i].ExpiresAt == nil {
+			r.keys[i].ExpiresAt = &expiresAt
+		}
+	}
+	return nil
+}
+
+func (r *fakeSigningKeyRepo) DeleteExpired(before time.Time) error {
+	return nil
+}
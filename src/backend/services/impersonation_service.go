@@ -0,0 +1,121 @@
+package services
+
+import (
+	"erp/db"
+	"erp/models"
+	"errors"
+	"time"
+)
+
+// ErrImpersonationNotAllowed 目標使用者不可被模擬 (自己、超級管理員或服務帳號)
+var ErrImpersonationNotAllowed = errors.New("無法模擬此使用者")
+
+// ErrAlreadyImpersonating 模擬登入期間不可再模擬其他使用者
+var ErrAlreadyImpersonating = errors.New("模擬登入期間無法再模擬其他使用者")
+
+// ErrNotImpersonating 目前的令牌不是模擬登入令牌
+var ErrNotImpersonating = errors.New("目前不是模擬登入狀態")
+
+// defaultImpersonationTTL 模擬登入令牌的預設有效期限，可由環境變數 IMPERSONATION_TTL 覆寫
+const defaultImpersonationTTL = 15 * time.Minute
+
+// ImpersonationService 模擬登入服務，負責簽發模擬令牌並記錄稽核軌跡
+type ImpersonationService struct {
+	auditRepo    db.ImpersonationAuditRepository
+	tokenService *TokenService
+	ttl          time.Duration
+}
+
+// NewImpersonationService 建立模擬登入服務實例
+func NewImpersonationService(auditRepo db.ImpersonationAuditRepository, tokenService *TokenService) *ImpersonationService {
+	return &ImpersonationService{
+		auditRepo:    auditRepo,
+		tokenService: tokenService,
+		ttl:          durationFromEnv("IMPERSONATION_TTL", defaultImpersonationTTL),
+	}
+}
+
+// TTL 模擬登入令牌的有效期限
+func (s *ImpersonationService) TTL() time.Duration {
+	return s.ttl
+}
+
+// Start 由 actor 開始模擬 target，回傳模擬登入令牌
+// actorClaims 為 actor 目前使用的令牌，模擬令牌沿用其兩步驟驗證狀態
+func (s *ImpersonationService) Start(actor, target *models.User, actorClaims *AccessClaims, reason, clientIP, userAgent string) (string, *AccessClaims, error) {
+	if actorClaims != nil && actorClaims.Actor != nil {
+		return "", nil, ErrAlreadyImpersonating
+	}
+	if target.ID == actor.ID || target.Level == "super_admin" || target.ServiceAccount {
+		return "", nil, ErrImpersonationNotAllowed
+	}
+
+	mfaVerified := actorClaims != nil && actorClaims.MFAVerified
+	token, claims, err := s.tokenService.GenerateImpersonationToken(target, actor, mfaVerified, s.ttl)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = s.auditRepo.Create(&models.ImpersonationAudit{
+		ImpersonatorID: actor.ID,
+		UserID:         target.ID,
+		TokenID:        claims.ID,
+		Action:         models.ImpersonationActionStart,
+		Reason:         reason,
+		IPAddress:      clientIP,
+		UserAgent:      truncateUserAgent(userAgent),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// End 提前結束模擬登入，模擬令牌立即失效
+func (s *ImpersonationService) End(claims *AccessClaims, clientIP, userAgent string) error {
+	if claims.Actor == nil {
+		return ErrNotImpersonating
+	}
+	if err := s.tokenService.RevokeAccessToken(claims, "impersonation_end"); err != nil {
+		return err
+	}
+
+	return s.auditRepo.Create(&models.ImpersonationAudit{
+		ImpersonatorID: claims.Actor.UserID,
+		UserID:         claims.UserID,
+		TokenID:        claims.ID,
+		Action:         models.ImpersonationActionEnd,
+		IPAddress:      clientIP,
+		UserAgent:      truncateUserAgent(userAgent),
+	})
+}
+
+// RecordRequest 在處理模擬登入期間的請求前寫入稽核紀錄，回應狀態碼稍後以 CompleteRequest 補上
+func (s *ImpersonationService) RecordRequest(claims *AccessClaims, method, path, clientIP, userAgent string) (*models.ImpersonationAudit, error) {
+	path = truncateUTF8(path, 255)
+
+	entry := &models.ImpersonationAudit{
+		ImpersonatorID: claims.Actor.UserID,
+		UserID:         claims.UserID,
+		TokenID:        claims.ID,
+		Action:         models.ImpersonationActionRequest,
+		Method:         method,
+		Path:           path,
+		IPAddress:      clientIP,
+		UserAgent:      truncateUserAgent(userAgent),
+	}
+	if err := s.auditRepo.Create(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// CompleteRequest 寫入請求的回應狀態碼
+func (s *ImpersonationService) CompleteRequest(entry *models.ImpersonationAudit, statusCode int) error {
+	return s.auditRepo.UpdateStatus(entry.ID, statusCode)
+}
+
+// Logs 取得使用者作為被模擬者或操作者的稽核紀錄
+func (s *ImpersonationService) Logs(userID uint, limit int) ([]models.ImpersonationAudit, error) {
+	return s.auditRepo.GetByUserID(userID, limit)
+}
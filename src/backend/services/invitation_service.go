@@ -0,0 +1,319 @@
+package services
+
+import (
+	"erp/db"
+	"erp/mail"
+	"erp/models"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidInvitation 邀請令牌不存在、已接受、已撤銷或已過期
+var ErrInvalidInvitation = errors.New("無效或已過期的邀請")
+
+// ErrUserAlreadyVerified 使用者已完成註冊，不需要邀請
+var ErrUserAlreadyVerified = errors.New("使用者已完成註冊")
+
+// ErrInvitationRoleNotFound 指定的初始角色不存在
+var ErrInvitationRoleNotFound = errors.New("找不到角色")
+
+// ErrPendingEmailTaken 驗證時新的電子郵件已被其他使用者使用
+var ErrPendingEmailTaken = errors.New("電子郵件已被使用")
+
+// ErrInvitationMailFailed 邀請已建立但郵件寄送失敗，可稍後重新寄送
+var ErrInvitationMailFailed = errors.New("邀請郵件寄送失敗")
+
+// 邀請連結的預設有效期限，可由環境變數 INVITATION_TTL 覆寫
+const defaultInvitationTTL = 72 * time.Hour
+
+// InvitationService 使用者邀請服務：建立待啟用帳號並寄出邀請連結，受邀者自行設定密碼
+type InvitationService struct {
+	userRepo          db.UserRepository
+	invitationRepo    db.UserInvitationRepository
+	roleRepo          db.RoleRepository
+	permissionService *PermissionService
+	policy            *PasswordPolicyService
+	sender            mail.Sender
+	ttl               time.Duration
+	invitationURL     string
+	verificationURL   string
+}
+
+// NewInvitationService 建立使用者邀請服務實例
+func NewInvitationService(userRepo db.UserRepository, invitationRepo db.UserInvitationRepository, roleRepo db.RoleRepository, permissionService *PermissionService, policy *PasswordPolicyService, sender mail.Sender) *InvitationService {
+	return &InvitationService{
+		userRepo:          userRepo,
+		invitationRepo:    invitationRepo,
+		roleRepo:          roleRepo,
+		permissionService: permissionService,
+		policy:            policy,
+		sender:            sender,
+		ttl:               durationFromEnv("INVITATION_TTL", defaultInvitationTTL),
+		invitationURL:     os.Getenv("INVITATION_URL"),
+		verificationURL:   os.Getenv("EMAIL_VERIFICATION_URL"),
+	}
+}
+
+// Invite 建立尚未驗證電子郵件的使用者、分配初始角色並寄出邀請
+// 郵件寄送失敗時帳號與邀請仍會保留，回傳 ErrInvitationMailFailed 讓管理員重新寄送
+func (s *InvitationService) Invite(input models.InviteUserInput, inviter *models.User) (*models.User, *models.UserInvitation, error) {
+	for _, roleID := range input.RoleIDs {
+		if _, err := s.roleRepo.GetByID(roleID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil, fmt.Errorf("%w: %d", ErrInvitationRoleNotFound, roleID)
+			}
+			return nil, nil, err
+		}
+	}
+
+	// 接受邀請前密碼設為無法使用的隨機值
+	password, err := unusablePasswordHash()
+	if err != nil {
+		return nil, nil, err
+	}
+	user := &models.User{
+		Username: input.Username,
+		Email:    input.Email,
+		Password: password,
+		Level:    input.Level,
+	}
+	if user.Level == "" {
+		user.Level = "user"
+	}
+	if err := s.userRepo.Create(user); err != nil {
+		return nil, nil, err
+	}
+
+	for _, roleID := range input.RoleIDs {
+		err := s.permissionService.AssignRoleToUser(user.ID, roleID)
+		if err != nil && !errors.Is(err, ErrRoleAlreadyAssigned) {
+			return nil, nil, err
+		}
+	}
+
+	invitation, err := s.send(user, inviter)
+	return user, invitation, err
+}
+
+// SendEmailVerification 申請變更電子郵件後撤銷尚未使用的連結，寄出驗證連結到新的電子郵件 (PendingEmail)，
+// 並通知原本的電子郵件；驗證前帳號繼續使用原本的電子郵件
+func (s *InvitationService) SendEmailVerification(user, requester *models.User) (*models.UserInvitation, error) {
+	if _, err := s.invitationRepo.RevokeByUserID(user.ID, time.Now()); err != nil {
+		return nil, err
+	}
+	invitation, rawToken, err := s.create(s.invitationRepo, user, requester, models.InvitationPurposeEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+	err = s.sender.Send(mail.Message{
+		To:      user.PendingEmail,
+		Subject: "請驗證您的電子郵件",
+		Body: fmt.Sprintf("%s 您好：\n\n您的帳號 %s 申請將電子郵件變更為此地址，請在 %d 小時內開啟以下連結完成驗證，驗證後才會生效：\n\n%s\n\n若您沒有變更電子郵件，請忽略此郵件。\n",
+			user.Username, user.Username, int(s.ttl.Hours()), tokenLink(s.verificationURL, "http://localhost:3000/verify-email", rawToken)),
+	})
+	if err == nil {
+		err = s.sender.Send(mail.Message{
+			To:      user.Email,
+			Subject: "電子郵件變更通知",
+			Body: fmt.Sprintf("%s 您好：\n\n您的帳號 %s 已申請將電子郵件變更為 %s，新的電子郵件驗證後才會生效，在此之前仍使用此電子郵件。\n\n若您沒有變更電子郵件，請立即聯絡系統管理員。\n",
+				user.Username, user.Username, user.PendingEmail),
+		})
+	}
+	if err != nil {
+		return invitation, fmt.Errorf("%w: %v", ErrInvitationMailFailed, err)
+	}
+	return invitation, nil
+}
+
+// VerifyEmail 以驗證連結將新的電子郵件 (PendingEmail) 取代原本的電子郵件
+func (s *InvitationService) VerifyEmail(rawToken string) (*models.User, error) {
+	user, invitation, err := s.lookup(rawToken, models.InvitationPurposeEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	// 申請後新的電子郵件可能已被其他使用者使用
+	existing, err := s.userRepo.GetByEmail(user.PendingEmail)
+	if err == nil && existing.ID != user.ID {
+		return nil, ErrPendingEmailTaken
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	now := time.Now()
+	marked, err := s.invitationRepo.MarkAccepted(invitation.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !marked {
+		return nil, ErrInvalidInvitation
+	}
+
+	user.Email, user.PendingEmail = user.PendingEmail, ""
+	user.EmailVerifiedAt = &now
+	if err := s.userRepo.Update(user, "email", "pending_email", "email_verified_at"); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Resend 撤銷尚未接受的邀請並寄出新的邀請連結
+func (s *InvitationService) Resend(user, inviter *models.User) (*models.UserInvitation, error) {
+	if user.IsEmailVerified() {
+		return nil, ErrUserAlreadyVerified
+	}
+	if _, err := s.invitationRepo.RevokeByUserID(user.ID, time.Now()); err != nil {
+		return nil, err
+	}
+	return s.send(user, inviter)
+}
+
+// Revoke 撤銷使用者尚未接受的邀請，帳號保留為待啟用狀態，可再重新寄送或刪除
+func (s *InvitationService) Revoke(user *models.User) error {
+	if user.IsEmailVerified() {
+		return ErrUserAlreadyVerified
+	}
+	revoked, err := s.invitationRepo.RevokeByUserID(user.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrInvalidInvitation
+	}
+	return nil
+}
+
+// Lookup 驗證邀請令牌並回傳受邀的使用者，供設定密碼頁面顯示帳號資訊
+func (s *InvitationService) Lookup(rawToken string) (*models.User, *models.UserInvitation, error) {
+	return s.lookup(rawToken, models.InvitationPurposeInvite)
+}
+
+// lookup 驗證指定用途的令牌並回傳使用者：邀請需要尚未驗證電子郵件的使用者，電子郵件驗證需要尚未驗證的新電子郵件
+func (s *InvitationService) lookup(rawToken, purpose string) (*models.User, *models.UserInvitation, error) {
+	invitation, err := s.invitationRepo.GetByHash(hashToken(rawToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !invitation.IsActive(time.Now()) || invitation.Purpose != purpose {
+		return nil, nil, ErrInvalidInvitation
+	}
+
+	user, err := s.userRepo.GetByID(invitation.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if purpose == models.InvitationPurposeEmailVerification && user.PendingEmail == "" {
+		return nil, nil, ErrInvalidInvitation
+	}
+	if purpose == models.InvitationPurposeInvite && user.IsEmailVerified() {
+		return nil, nil, ErrInvalidInvitation
+	}
+	return user, invitation, nil
+}
+
+// Accept 接受邀請：依密碼政策設定密碼並將電子郵件標記為已驗證
+func (s *InvitationService) Accept(rawToken, password string) (*models.User, error) {
+	user, invitation, err := s.Lookup(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// 密碼不符合政策時不消耗邀請，讓使用者可以重新輸入
+	if err := s.policy.SetPassword(user, password); err != nil {
+		return nil, err
+	}
+
+	// 條件更新確保邀請只能使用一次
+	now := time.Now()
+	marked, err := s.invitationRepo.MarkAccepted(invitation.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !marked {
+		return nil, ErrInvalidInvitation
+	}
+
+	user.EmailVerifiedAt = &now
+	if err := s.userRepo.Update(user, "password", "password_changed_at", "email_verified_at"); err != nil {
+		return nil, err
+	}
+	if err := s.policy.RecordHistory(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// TTL 取得邀請連結的有效期限
+func (s *InvitationService) TTL() time.Duration {
+	return s.ttl
+}
+
+// send 產生新的邀請令牌並寄出邀請郵件
+func (s *InvitationService) send(user, inviter *models.User) (*models.UserInvitation, error) {
+	invitation, rawToken, err := s.create(s.invitationRepo, user, inviter, models.InvitationPurposeInvite)
+	if err != nil {
+		return nil, err
+	}
+	return invitation, s.sendMail(user, inviter, rawToken)
+}
+
+// create 產生指定用途的新令牌並寫入 invitationRepo (可為交易內的 repository)，回傳原始令牌供寄送郵件
+func (s *InvitationService) create(invitationRepo db.UserInvitationRepository, user, inviter *models.User, purpose string) (*models.UserInvitation, string, error) {
+	rawToken, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	invitation := &models.UserInvitation{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashToken(rawToken),
+		InvitedBy: inviter.ID,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+	if err := invitationRepo.Create(invitation); err != nil {
+		return nil, "", err
+	}
+	return invitation, rawToken, nil
+}
+
+// sendMail 寄出邀請郵件
+func (s *InvitationService) sendMail(user, inviter *models.User, rawToken string) error {
+	err := s.sender.Send(mail.Message{
+		To:      user.Email,
+		Subject: "您已受邀加入系統",
+		Body: fmt.Sprintf("%s 您好：\n\n%s 邀請您使用系統，您的帳號為 %s。請在 %d 小時內開啟以下連結設定密碼並啟用帳號：\n\n%s\n\n若您不認識邀請人，請忽略此郵件。\n",
+			user.Username, inviter.Username, user.Username, int(s.ttl.Hours()), tokenLink(s.invitationURL, "http://localhost:3000/accept-invitation", rawToken)),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvitationMailFailed, err)
+	}
+	return nil
+}
+
+// tokenLink 組合前端的邀請或驗證連結，base 未設定時使用 fallback
+func tokenLink(base, fallback, rawToken string) string {
+	if base == "" {
+		base = fallback
+	}
+
+	link, err := url.Parse(base)
+	if err != nil {
+		return base + "?token=" + url.QueryEscape(rawToken)
+	}
+	query := link.Query()
+	query.Set("token", rawToken)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"erp/models"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var mailTokenPattern = regexp.MustCompile(`https?://\S+`)
+
+// mailedToken 從最後一封帶有連結的郵件取出令牌
+func mailedToken(t *testing.T, sender *fakeMailSender) string {
+	t.Helper()
+	for i := len(sender.messages) - 1; i >= 0; i-- {
+		match := mailTokenPattern.FindString(sender.messages[i].Body)
+		if match == "" {
+			continue
+		}
+		link, err := url.Parse(match)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := link.Query().Get("token")
+		if token == "" {
+			t.Fatalf("mail link %q has no token", link)
+		}
+		return token
+	}
+	t.Fatal("no mail with a link was sent")
+	return ""
+}
+
+// newTestInvitationService 建立邀請服務與一位尚未驗證電子郵件的使用者
+func newTestInvitationService(t *testing.T) (*InvitationService, *fakeUserRepo, *fakeMailSender, *models.User) {
+	t.Helper()
+	t.Setenv("EMAIL_VERIFICATION_URL", "https://erp.example.com/verify-email")
+	t.Setenv("INVITATION_URL", "https://erp.example.com/accept-invitation")
+
+	userRepo := &fakeUserRepo{}
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+	user, _ := userRepo.GetByUsername("alice")
+	sender := &fakeMailSender{}
+	service := NewInvitationService(userRepo, &fakeInvitationRepo{}, &fakeRoleRepo{}, nil, nil, sender)
+	return service, userRepo, sender, user
+}
+
+// requestEmailChange 將使用者標記為已驗證並申請變更為 email
+func requestEmailChange(t *testing.T, userRepo *fakeUserRepo, user *models.User, email string) {
+	t.Helper()
+	verifiedAt := time.Now().Add(-time.Hour)
+	user.EmailVerifiedAt = &verifiedAt
+	user.PendingEmail = email
+	if err := userRepo.Update(user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyEmailSwapsPendingEmailOnce(t *testing.T) {
+	service, userRepo, sender, user := newTestInvitationService(t)
+	requestEmailChange(t, userRepo, user, "alice@new.example.com")
+
+	if _, err := service.SendEmailVerification(user, user); err != nil {
+		t.Fatalf("SendEmailVerification: %v", err)
+	}
+	if len(sender.messages) != 2 {
+		t.Fatalf("%d mails sent, want the verification and the notice", len(sender.messages))
+	}
+	if to := sender.messages[0].To; to != "alice@new.example.com" {
+		t.Fatalf("verification sent to %q, want the new address", to)
+	}
+	if to := sender.messages[1].To; to != "alice@example.com" {
+		t.Fatalf("notice sent to %q, want the old address", to)
+	}
+
+	// 驗證前繼續使用原本已驗證的電子郵件
+	stored, _ := userRepo.GetByID(user.ID)
+	if stored.Email != "alice@example.com" || !stored.IsEmailVerified() {
+		t.Fatalf("before verification: email = %q, verified = %v", stored.Email, stored.IsEmailVerified())
+	}
+
+	token := mailedToken(t, sender)
+	if _, err := service.VerifyEmail(token); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	stored, _ = userRepo.GetByID(user.ID)
+	if stored.Email != "alice@new.example.com" || stored.PendingEmail != "" || !stored.IsEmailVerified() {
+		t.Fatalf("after verification: email = %q, pending = %q, verified = %v", stored.Email, stored.PendingEmail, stored.IsEmailVerified())
+	}
+	if stored.Password != user.Password {
+		t.Fatal("verifying the email must not change the password")
+	}
+
+	// 驗證連結只能使用一次
+	if _, err := service.VerifyEmail(token); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("reused token: err = %v, want ErrInvalidInvitation", err)
+	}
+}
+
+func TestVerifyEmailRejectsPendingEmailTakenMeanwhile(t *testing.T) {
+	service, userRepo, sender, user := newTestInvitationService(t)
+	requestEmailChange(t, userRepo, user, "shared@example.com")
+	if _, err := service.SendEmailVerification(user, user); err != nil {
+		t.Fatalf("SendEmailVerification: %v", err)
+	}
+
+	userRepo.Create(&models.User{Username: "bob", Email: "shared@example.com", Level: "user"})
+	if _, err := service.VerifyEmail(mailedToken(t, sender)); !errors.Is(err, ErrPendingEmailTaken) {
+		t.Fatalf("VerifyEmail: err = %v, want ErrPendingEmailTaken", err)
+	}
+	stored, _ := userRepo.GetByID(user.ID)
+	if stored.Email != "alice@example.com" {
+		t.Fatalf("email = %q, want the original address", stored.Email)
+	}
+}
+
+func TestVerifyEmailTokensAreNotInvitations(t *testing.T) {
+	service, userRepo, sender, user := newTestInvitationService(t)
+	user.PendingEmail = "alice@new.example.com"
+	userRepo.Update(user)
+
+	if _, err := service.send(user, user); err != nil {
+		t.Fatalf("send invitation: %v", err)
+	}
+	invitationToken := mailedToken(t, sender)
+
+	// 寄出驗證連結時撤銷尚未使用的邀請
+	if _, err := service.SendEmailVerification(user, user); err != nil {
+		t.Fatalf("SendEmailVerification: %v", err)
+	}
+	verificationToken := mailedToken(t, sender)
+	if _, _, err := service.Lookup(invitationToken); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("invitation after verification mail: err = %v, want ErrInvalidInvitation", err)
+	}
+
+	// 驗證連結不能用來設定密碼
+	if _, err := service.Accept(verificationToken, "N3w-passw0rd!"); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("Accept with verification token: err = %v, want ErrInvalidInvitation", err)
+	}
+
+	// 邀請連結也不能用來驗證電子郵件
+	if _, err := service.send(user, user); err != nil {
+		t.Fatalf("send invitation: %v", err)
+	}
+	if _, err := service.VerifyEmail(mailedToken(t, sender)); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("VerifyEmail with invitation token: err = %v, want ErrInvalidInvitation", err)
+	}
+}
@@ -0,0 +1,455 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"erp/db"
+	"erp/models"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownSigningKey 令牌的 kid 不在金鑰環中 (金鑰已過期或令牌並非本系統簽發)
+var ErrUnknownSigningKey = errors.New("未知的簽章金鑰")
+
+// 支援的 JWT 簽章演算法
+const (
+	SigningAlgorithmRS256 = "RS256"
+	SigningAlgorithmES256 = "ES256"
+)
+
+// 金鑰環預設值，可由環境變數覆寫
+const (
+	defaultKeyRotationInterval = 30 * 24 * time.Hour // JWT_KEY_ROTATION_INTERVAL: 簽章金鑰的使用期間
+	defaultKeyPrepublish       = time.Hour           // JWT_KEY_PREPUBLISH: 新金鑰啟用前先公開於 JWKS 的時間，需大於驗證端快取 JWKS 的時間
+	defaultKeyOverlap          = 24 * time.Hour      // JWT_KEY_OVERLAP: 舊金鑰被取代後仍可驗證的時間，至少為 access token 有效期限
+	keyRingRefreshInterval     = time.Minute         // 從資料庫重新載入金鑰環的間隔 (多個實例共用金鑰)
+	keyRingMissReloadInterval  = 10 * time.Second    // 遇到未知 kid 時強制重新載入的最短間隔
+	rsaKeyBits                 = 2048
+)
+
+// JSONWebKey JWKS 中的公開金鑰 (RFC 7517)
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet /.well-known/jwks.json 的回應內容
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// SigningKeyInfo 管理介面顯示的金鑰資訊 (不含私鑰)
+type SigningKeyInfo struct {
+	KID         string     `json:"kid"`
+	Algorithm   string     `json:"algorithm"`
+	ActivatesAt time.Time  `json:"activates_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	Signing     bool       `json:"signing"` // 目前用於簽署新令牌
+}
+
+// ringKey 已載入記憶體的金鑰
+type ringKey struct {
+	kid         string
+	algorithm   string
+	activatesAt time.Time
+	expiresAt   *time.Time
+	private     crypto.Signer // 無法解密私鑰時為 nil，只能用於驗證
+	public      crypto.PublicKey
+}
+
+// KeyRingService JWT 簽章金鑰環：簽署使用目前金鑰，驗證接受所有尚未到期的金鑰
+// 金鑰保存在資料庫中，依 JWT_KEY_ROTATION_INTERVAL 自動輪替，不需要共用 JWT_SECRET
+type KeyRingService struct {
+	repo             db.SigningKeyRepository
+	algorithm        string
+	rotationInterval time.Duration
+	prepublish       time.Duration
+	overlap          time.Duration
+
+	mu             sync.Mutex
+	keys           []*ringKey
+	loadedAt       time.Time
+	forcedReloadAt time.Time
+}
+
+// NewKeyRingService 建立金鑰環服務實例
+func NewKeyRingService(repo db.SigningKeyRepository) *KeyRingService {
+	algorithm := strings.ToUpper(os.Getenv("JWT_SIGNING_ALGORITHM"))
+	switch algorithm {
+	case SigningAlgorithmRS256, SigningAlgorithmES256:
+	case "":
+		algorithm = SigningAlgorithmRS256
+	default:
+		fmt.Fprintf(os.Stderr, "不支援的 JWT_SIGNING_ALGORITHM %q，改用 %s\n", algorithm, SigningAlgorithmRS256)
+		algorithm = SigningAlgorithmRS256
+	}
+
+	// 舊金鑰必須保留到以其簽署的 access token 全部過期
+	overlap := durationFromEnv("JWT_KEY_OVERLAP", defaultKeyOverlap)
+	if accessTTL := durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL); overlap < accessTTL {
+		overlap = accessTTL
+	}
+
+	return &KeyRingService{
+		repo:             repo,
+		algorithm:        algorithm,
+		rotationInterval: durationFromEnv("JWT_KEY_ROTATION_INTERVAL", defaultKeyRotationInterval),
+		prepublish:       durationFromEnv("JWT_KEY_PREPUBLISH", defaultKeyPrepublish),
+		overlap:          overlap,
+	}
+}
+
+// SigningKey 取得目前用於簽署的金鑰，必要時先輪替
+func (s *KeyRingService) SigningKey() (kid, algorithm string, signer crypto.Signer, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if err := s.refresh(now, false); err != nil {
+		return "", "", nil, err
+	}
+	key := s.signingKey(now)
+	if key == nil || key.private == nil {
+		return "", "", nil, errors.New("沒有可用的簽章金鑰")
+	}
+	return key.kid, key.algorithm, key.private, nil
+}
+
+// VerificationKey 依 kid 取得驗證用的公開金鑰，演算法必須與金鑰相符
+// 找不到 kid 時重新載入金鑰環一次，以便取得其他實例剛輪替產生的金鑰
+func (s *KeyRingService) VerificationKey(kid, algorithm string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if err := s.refresh(now, false); err != nil {
+		return nil, err
+	}
+	key := s.find(kid, now)
+	if key == nil && now.Sub(s.forcedReloadAt) >= keyRingMissReloadInterval {
+		s.forcedReloadAt = now
+		if err := s.refresh(now, true); err != nil {
+			return nil, err
+		}
+		key = s.find(kid, now)
+	}
+	if key == nil || key.algorithm != algorithm {
+		return nil, ErrUnknownSigningKey
+	}
+	return key.public, nil
+}
+
+// JWKS 回傳所有可用於驗證的公開金鑰 (包含尚未啟用的下一把金鑰)
+func (s *KeyRingService) JWKS() (JSONWebKeySet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if err := s.refresh(now, false); err != nil {
+		return JSONWebKeySet{}, err
+	}
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range s.keys {
+		if key.expiresAt != nil && !key.expiresAt.After(now) {
+			continue
+		}
+		jwk, err := publicJWK(key)
+		if err != nil {
+			return JSONWebKeySet{}, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// Keys 回傳金鑰環中的金鑰資訊
+func (s *KeyRingService) Keys() ([]SigningKeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if err := s.refresh(now, false); err != nil {
+		return nil, err
+	}
+
+	signing := s.signingKey(now)
+	infos := make([]SigningKeyInfo, 0, len(s.keys))
+	for _, key := range s.keys {
+		if key.expiresAt != nil && !key.expiresAt.After(now) {
+			continue
+		}
+		infos = append(infos, SigningKeyInfo{
+			KID:         key.kid,
+			Algorithm:   key.algorithm,
+			ActivatesAt: key.activatesAt,
+			ExpiresAt:   key.expiresAt,
+			Signing:     key == signing,
+		})
+	}
+	return infos, nil
+}
+
+// Rotate 立即以新金鑰取代目前金鑰，舊金鑰在重疊期間內仍可驗證
+// revoke 為 true 時 (金鑰外洩) 其他金鑰立即停止驗證並從 JWKS 移除，以其簽署的 access token 全部失效，
+// 其他實例最晚在下次重新載入金鑰環時 (keyRingRefreshInterval) 生效
+// 新金鑰未經預先公開，快取 JWKS 的驗證端會在重新取得 JWKS 前拒絕新令牌
+func (s *KeyRingService) Rotate(revoke bool) (*SigningKeyInfo, error) {
+	// 產生金鑰 (RSA 可能需要數百毫秒) 不持有鎖，避免阻塞簽署與驗證
+	now := time.Now()
+	key, err := s.generateKey(now)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.repo.Create(key); err != nil {
+		return nil, err
+	}
+	if revoke {
+		err = s.repo.RevokeOthers(key.ID, now)
+	} else {
+		err = s.repo.RetireOthers(key.ID, now.Add(s.overlap))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.refresh(now, true); err != nil {
+		return nil, err
+	}
+	return &SigningKeyInfo{KID: key.KID, Algorithm: key.Algorithm, ActivatesAt: key.ActivatesAt, Signing: true}, nil
+}
+
+// refresh 金鑰環過期時從資料庫重新載入，並依排程建立下一把金鑰
+// 呼叫時必須持有 s.mu；產生金鑰期間暫時釋放鎖 (同 Rotate)，重新取得鎖後再次載入，
+// 確認仍需要新金鑰才寫入，期間已由其他 goroutine 或實例建立時捨棄產生的金鑰
+func (s *KeyRingService) refresh(now time.Time, force bool) error {
+	if !force && !s.loadedAt.IsZero() && now.Sub(s.loadedAt) < keyRingRefreshInterval {
+		return nil
+	}
+
+	if err := s.load(now); err != nil {
+		return err
+	}
+	activatesAt, needed := s.nextKeyActivation(now)
+	if !needed {
+		return nil
+	}
+
+	s.mu.Unlock()
+	key, err := s.generateKey(activatesAt)
+	s.mu.Lock()
+	if err != nil {
+		return err
+	}
+
+	if err := s.load(now); err != nil {
+		return err
+	}
+	if key.ActivatesAt, needed = s.nextKeyActivation(now); !needed {
+		return nil
+	}
+	if err := s.storeKey(key); err != nil {
+		return err
+	}
+	return s.load(now)
+}
+
+// nextKeyActivation 判斷是否需要建立新金鑰，需要時回傳新金鑰的啟用時間
+func (s *KeyRingService) nextKeyActivation(now time.Time) (time.Time, bool) {
+	// 目前沒有可簽署的金鑰，或設定的演算法已變更：立即啟用新金鑰
+	current := s.signingKey(now)
+	if current == nil || current.private == nil || current.algorithm != s.algorithm {
+		return now, true
+	}
+
+	// 目前金鑰即將到期且尚未有下一把金鑰：先公開新金鑰，在預先公開期間後才開始簽署
+	if current.expiresAt == nil && !now.Before(current.activatesAt.Add(s.rotationInterval-s.prepublish)) {
+		return now.Add(s.prepublish), true
+	}
+	return time.Time{}, false
+}
+
+// load 從資料庫載入尚未到期的金鑰並清除已到期的金鑰
+func (s *KeyRingService) load(now time.Time) error {
+	if err := s.repo.DeleteExpired(now); err != nil {
+		return err
+	}
+	stored, err := s.repo.GetValid(now)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*ringKey, 0, len(stored))
+	for _, record := range stored {
+		key, err := parseRingKey(record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "無法載入簽章金鑰 %s: %v\n", record.KID, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	s.keys = keys
+	s.loadedAt = now
+	return nil
+}
+
+// signingKey 選出目前用於簽署的金鑰：已啟用且尚未被取代的金鑰優先，其次為最近啟用的金鑰
+func (s *KeyRingService) signingKey(now time.Time) *ringKey {
+	var candidates []*ringKey
+	for _, key := range s.keys {
+		if !key.activatesAt.After(now) && (key.expiresAt == nil || key.expiresAt.After(now)) {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		iCurrent, jCurrent := candidates[i].expiresAt == nil, candidates[j].expiresAt == nil
+		if iCurrent != jCurrent {
+			return iCurrent
+		}
+		return candidates[i].activatesAt.After(candidates[j].activatesAt)
+	})
+	return candidates[0]
+}
+
+// find 依 kid 搜尋尚未到期的金鑰
+func (s *KeyRingService) find(kid string, now time.Time) *ringKey {
+	for _, key := range s.keys {
+		if key.kid == kid && (key.expiresAt == nil || key.expiresAt.After(now)) {
+			return key
+		}
+	}
+	return nil
+}
+
+// storeKey 寫入產生的新金鑰並設定其他金鑰在新金鑰啟用後的重疊期間結束時停止驗證
+func (s *KeyRingService) storeKey(key *models.SigningKey) error {
+	if err := s.repo.Create(key); err != nil {
+		return err
+	}
+	return s.repo.RetireOthers(key.ID, key.ActivatesAt.Add(s.overlap))
+}
+
+// generateKey 產生新的金鑰 (私鑰已加密)，尚未寫入資料庫
+func (s *KeyRingService) generateKey(activatesAt time.Time) (*models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch s.algorithm {
+	case SigningAlgorithmES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("無法產生簽章金鑰: %v", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := encryptSecret(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})))
+	if err != nil {
+		return nil, err
+	}
+	kid, err := randomToken(12)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		KID:         kid,
+		Algorithm:   s.algorithm,
+		PrivateKey:  encrypted,
+		PublicKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+		ActivatesAt: activatesAt,
+	}, nil
+}
+
+// parseRingKey 解析資料庫中的金鑰，私鑰無法解密時仍保留公開金鑰供驗證
+func parseRingKey(record models.SigningKey) (*ringKey, error) {
+	block, _ := pem.Decode([]byte(record.PublicKey))
+	if block == nil {
+		return nil, errors.New("公開金鑰格式錯誤")
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &ringKey{
+		kid:         record.KID,
+		algorithm:   record.Algorithm,
+		activatesAt: record.ActivatesAt,
+		expiresAt:   record.ExpiresAt,
+		public:      public,
+	}
+
+	privatePEM, err := decryptSecret(record.PrivateKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "無法解密簽章金鑰 %s 的私鑰，只用於驗證: %v\n", record.KID, err)
+		return key, nil
+	}
+	if block, _ := pem.Decode([]byte(privatePEM)); block != nil {
+		if private, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			key.private, _ = private.(crypto.Signer)
+		}
+	}
+	return key, nil
+}
+
+// publicJWK 將公開金鑰轉換為 JWK 格式
+func publicJWK(key *ringKey) (JSONWebKey, error) {
+	jwk := JSONWebKey{Use: "sig", Algorithm: key.algorithm, KeyID: key.kid}
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		ecdh, err := public.ECDH()
+		if err != nil {
+			return JSONWebKey{}, err
+		}
+		// 未壓縮格式：0x04 || X || Y，座標各為 32 位元組
+		point := ecdh.Bytes()
+		size := (len(point) - 1) / 2
+		jwk.KeyType = "EC"
+		jwk.Curve = public.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(point[1 : 1+size])
+		jwk.Y = base64.RawURLEncoding.EncodeToString(point[1+size:])
+	default:
+		return JSONWebKey{}, fmt.Errorf("不支援的公開金鑰類型 %T", key.public)
+	}
+	return jwk, nil
+}
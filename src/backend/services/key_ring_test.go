@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyRingGeneratesKeysWithoutHoldingTheLock(t *testing.T) {
+	t.Setenv("JWT_SIGNING_ALGORITHM", SigningAlgorithmRS256)
+	repo := &fakeSigningKeyRepo{loaded: make(chan struct{})}
+	ring := NewKeyRingService(repo)
+
+	// 空的金鑰環：第一次載入後開始產生 RSA 金鑰
+	done := make(chan error)
+	go func() {
+		_, err := ring.Keys()
+		done <- err
+	}()
+	<-repo.loaded
+
+	// 產生金鑰期間其他請求不需等待，看到的是尚未加入新金鑰的金鑰環
+	jwks := make(chan JSONWebKeySet)
+	go func() {
+		set, _ := ring.JWKS()
+		jwks <- set
+	}()
+	select {
+	case set := <-jwks:
+		if len(set.Keys) != 0 {
+			t.Fatalf("JWKS waited for key generation: %d keys", len(set.Keys))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("JWKS blocked while the key was being generated")
+	}
+
+	<-repo.loaded // 產生後重新載入確認
+	<-repo.loaded // 寫入後重新載入
+	if err := <-done; err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if len(repo.keys) != 1 {
+		t.Fatalf("%d keys were stored, want 1", len(repo.keys))
+	}
+}
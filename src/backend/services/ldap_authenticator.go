@@ -0,0 +1,308 @@
+package services
+
+import (
+	"crypto/tls"
+	"erp/db"
+	"erp/models"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+// ErrLDAPUserNotProvisioned LDAP 驗證通過但本地沒有對應帳號，且未開啟自動建立帳號
+var ErrLDAPUserNotProvisioned = errors.New("此帳號尚未開通，請聯絡管理員")
+
+// LDAP 連線預設值
+const (
+	defaultLDAPUserFilter  = "(&(objectClass=person)(uid=%s))"
+	defaultLDAPGroupFilter = "(|(member=%s)(uniqueMember=%s))"
+	defaultLDAPTimeout     = 5 * time.Second
+)
+
+// LDAPConfig LDAP / Active Directory 連線設定
+type LDAPConfig struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	BindDN             string // 搜尋使用者用的服務帳號，空值代表匿名搜尋
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string // %s 代入登入帳號 (AD 通常為 (sAMAccountName=%s))
+	EmailAttribute     string
+	GroupBaseDN        string // 設定時以 GroupFilter 搜尋群組，否則讀取使用者的 memberOf 屬性
+	GroupFilter        string // %s 代入使用者 DN
+	GroupRoles         map[string][]string
+	AutoProvision      bool
+	DefaultLevel       string
+	Timeout            time.Duration
+}
+
+// LDAPConfigFromEnv 從環境變數讀取 LDAP 設定，未設定 LDAP_URL 時回傳 false
+func LDAPConfigFromEnv() (LDAPConfig, bool) {
+	config := LDAPConfig{
+		URL:                os.Getenv("LDAP_URL"),
+		StartTLS:           os.Getenv("LDAP_START_TLS") == "true",
+		InsecureSkipVerify: os.Getenv("LDAP_INSECURE_SKIP_VERIFY") == "true",
+		BindDN:             os.Getenv("LDAP_BIND_DN"),
+		BindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:             os.Getenv("LDAP_BASE_DN"),
+		UserFilter:         os.Getenv("LDAP_USER_FILTER"),
+		EmailAttribute:     os.Getenv("LDAP_EMAIL_ATTRIBUTE"),
+		GroupBaseDN:        os.Getenv("LDAP_GROUP_BASE_DN"),
+		GroupFilter:        os.Getenv("LDAP_GROUP_FILTER"),
+		GroupRoles:         parseLDAPGroupRoles(os.Getenv("LDAP_GROUP_ROLES")),
+		AutoProvision:      os.Getenv("LDAP_AUTO_PROVISION") == "true",
+		DefaultLevel:       os.Getenv("LDAP_DEFAULT_LEVEL"),
+		Timeout:            durationFromEnv("LDAP_TIMEOUT", defaultLDAPTimeout),
+	}
+	if config.URL == "" {
+		return config, false
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = defaultLDAPUserFilter
+	}
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = "mail"
+	}
+	if config.GroupFilter == "" {
+		config.GroupFilter = defaultLDAPGroupFilter
+	}
+	if config.DefaultLevel == "" {
+		config.DefaultLevel = "user"
+	}
+	return config, true
+}
+
+// LDAPAuthenticator 以 LDAP bind 驗證密碼，並在每次登入時依群組同步角色
+type LDAPAuthenticator struct {
+	config            LDAPConfig
+	userRepo          db.UserRepository
+	roleRepo          db.RoleRepository
+	permissionService *PermissionService
+}
+
+// NewLDAPAuthenticator 建立 LDAP 驗證後端
+func NewLDAPAuthenticator(config LDAPConfig, userRepo db.UserRepository, roleRepo db.RoleRepository, permissionService *PermissionService) *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		config:            config,
+		userRepo:          userRepo,
+		roleRepo:          roleRepo,
+		permissionService: permissionService,
+	}
+}
+
+// Name 實作 Authenticator
+func (a *LDAPAuthenticator) Name() string {
+	return AuthBackendLDAP
+}
+
+// Authenticate 實作 Authenticator：搜尋使用者 DN 後以使用者密碼 bind
+func (a *LDAPAuthenticator) Authenticate(user *models.User, username, password string) (*models.User, error) {
+	// 空密碼在多數目錄服務會被視為匿名 bind 而成功，必須先拒絕
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if a.config.BindDN != "" {
+		if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP 服務帳號 bind 失敗: %v", err)
+		}
+	}
+
+	entry, err := a.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP bind 失敗: %v", err)
+	}
+
+	// 以服務帳號重新 bind 後再查詢群組，避免一般使用者沒有讀取群組的權限
+	if a.config.BindDN != "" {
+		if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP 服務帳號 bind 失敗: %v", err)
+		}
+	}
+	groups, err := a.userGroups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	email := entry.GetAttributeValue(a.config.EmailAttribute)
+	if user == nil {
+		user, err = a.provisionUser(username, email)
+		if err != nil {
+			return nil, err
+		}
+	} else if !a.linked(user, email) {
+		// 避免 LDAP 中同名的其他人登入本地帳號
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := syncMappedRoles(a.roleRepo, a.permissionService, user.ID, a.config.GroupRoles, groups); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// linked 檢查本地使用者是否可由 LDAP 帳號登入：明確設定使用 LDAP，或電子郵件相同
+func (a *LDAPAuthenticator) linked(user *models.User, email string) bool {
+	for _, name := range parseAuthOrder(user.AuthBackends) {
+		if name == AuthBackendLDAP {
+			return true
+		}
+	}
+	return email != "" && strings.EqualFold(email, user.Email)
+}
+
+// dial 建立 LDAP 連線，需要時升級為 StartTLS
+func (a *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.config.InsecureSkipVerify}
+	conn, err := ldap.DialURL(a.config.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("無法連線至 LDAP 伺服器: %v", err)
+	}
+	conn.SetTimeout(a.config.Timeout)
+
+	if a.config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("LDAP StartTLS 失敗: %v", err)
+		}
+	}
+	return conn, nil
+}
+
+// findUser 以登入帳號搜尋使用者，找不到或不唯一時視為帳號錯誤
+func (a *LDAPAuthenticator) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(
+		a.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.config.Timeout.Seconds()), false,
+		strings.ReplaceAll(a.config.UserFilter, "%s", ldap.EscapeFilter(username)),
+		[]string{"dn", a.config.EmailAttribute, "memberOf"},
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("LDAP 搜尋使用者失敗: %v", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+// userGroups 取得使用者所屬群組的 DN (已正規化)
+func (a *LDAPAuthenticator) userGroups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	if len(a.config.GroupRoles) == 0 {
+		return nil, nil
+	}
+
+	var groupDNs []string
+	if a.config.GroupBaseDN == "" {
+		groupDNs = entry.GetAttributeValues("memberOf")
+	} else {
+		request := ldap.NewSearchRequest(
+			a.config.GroupBaseDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(a.config.Timeout.Seconds()), false,
+			strings.ReplaceAll(a.config.GroupFilter, "%s", ldap.EscapeFilter(entry.DN)),
+			[]string{"dn"},
+			nil,
+		)
+		result, err := conn.Search(request)
+		if err != nil {
+			return nil, fmt.Errorf("LDAP 搜尋群組失敗: %v", err)
+		}
+		for _, group := range result.Entries {
+			groupDNs = append(groupDNs, group.DN)
+		}
+	}
+
+	groups := make([]string, 0, len(groupDNs))
+	for _, dn := range groupDNs {
+		groups = append(groups, normalizeDN(dn))
+	}
+	return groups, nil
+}
+
+// provisionUser 為第一次登入的 LDAP 使用者建立本地帳號
+func (a *LDAPAuthenticator) provisionUser(username, email string) (*models.User, error) {
+	if !a.config.AutoProvision {
+		return nil, ErrLDAPUserNotProvisioned
+	}
+	if email == "" {
+		return nil, fmt.Errorf("LDAP 帳號 %s 缺少 %s 屬性，無法建立使用者", username, a.config.EmailAttribute)
+	}
+	if _, err := a.userRepo.GetByEmail(email); !errors.Is(err, gorm.ErrRecordNotFound) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("電子郵件 %s 已被其他使用者使用，無法建立 LDAP 帳號 %s", email, username)
+	}
+
+	password, err := unusablePasswordHash()
+	if err != nil {
+		return nil, err
+	}
+	// 電子郵件由目錄服務提供，視為已驗證
+	now := time.Now()
+	user := &models.User{
+		Username:        username,
+		Email:           email,
+		Password:        password,
+		Level:           a.config.DefaultLevel,
+		AuthBackends:    AuthBackendLDAP,
+		EmailVerifiedAt: &now,
+	}
+	if err := a.userRepo.Create(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// parseLDAPGroupRoles 解析群組對應表，格式為 "<群組 DN>=><角色名稱>;..."
+// 例如 "cn=erp-admins,ou=groups,dc=example,dc=com=>admin;cn=sales,ou=groups,dc=example,dc=com=>業務"
+func parseLDAPGroupRoles(value string) map[string][]string {
+	groupRoles := make(map[string][]string)
+	for _, pair := range strings.Split(value, ";") {
+		dn, role, ok := strings.Cut(pair, "=>")
+		dn, role = strings.TrimSpace(dn), strings.TrimSpace(role)
+		if ok && dn != "" && role != "" {
+			key := normalizeDN(dn)
+			groupRoles[key] = append(groupRoles[key], role)
+		}
+	}
+	return groupRoles
+}
+
+// normalizeDN 正規化 DN 以便比對 (忽略大小寫與 RDN 之間的空白)
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(dn))
+	}
+	rdns := make([]string, 0, len(parsed.RDNs))
+	for _, rdn := range parsed.RDNs {
+		attributes := make([]string, 0, len(rdn.Attributes))
+		for _, attribute := range rdn.Attributes {
+			attributes = append(attributes, strings.ToLower(attribute.Type)+"="+strings.ToLower(attribute.Value))
+		}
+		rdns = append(rdns, strings.Join(attributes, "+"))
+	}
+	return strings.Join(rdns, ",")
+}
@@ -0,0 +1,395 @@
+package services
+
+import (
+	"erp/models"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// 測試目錄的帳號
+const (
+	testLDAPServiceDN       = "cn=svc,dc=example,dc=com"
+	testLDAPServicePassword = "svc-secret"
+	testLDAPAliceDN         = "uid=alice,ou=people,dc=example,dc=com"
+	testLDAPObrienDN        = "cn=O'Brien (Sales),ou=people,dc=example,dc=com"
+	testLDAPSalesDN         = "cn=sales,ou=groups,dc=example,dc=com"
+)
+
+// testLDAPEntry 目錄中的項目
+type testLDAPEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// testLDAPServer 行程內的 LDAPv3 伺服器，只支援 simple bind、search 與 unbind
+// 只有服務帳號可以搜尋 (類似關閉匿名讀取的 AD)；空密碼的 bind 視為未驗證 bind 而成功 (RFC 4513 5.1.2)
+type testLDAPServer struct {
+	listener net.Listener
+	entries  []testLDAPEntry
+
+	mu      sync.Mutex
+	binds   []string // 成功 bind 的 DN
+	filters []string // 收到的搜尋過濾條件 (DecompileFilter 的結果)
+}
+
+func newTestLDAPServer(t *testing.T) *testLDAPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testLDAPServer{
+		listener: listener,
+		entries: []testLDAPEntry{
+			{dn: testLDAPServiceDN, password: testLDAPServicePassword, attributes: map[string][]string{"objectClass": {"applicationProcess"}}},
+			{dn: testLDAPAliceDN, password: "alice-secret", attributes: map[string][]string{
+				"objectClass": {"person"}, "uid": {"alice"}, "mail": {"alice@example.com"},
+			}},
+			{dn: "uid=bob,ou=people,dc=example,dc=com", password: "bob-secret", attributes: map[string][]string{
+				"objectClass": {"person"}, "uid": {"bob"}, "mail": {"bob@example.com"},
+			}},
+			{dn: testLDAPObrienDN, password: "obrien-secret", attributes: map[string][]string{
+				"objectClass": {"person"}, "uid": {"obrien"}, "mail": {"obrien@example.com"},
+			}},
+			{dn: testLDAPSalesDN, attributes: map[string][]string{
+				"objectClass": {"groupOfNames"}, "member": {testLDAPObrienDN},
+			}},
+		},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+// URL 伺服器的連線網址
+func (s *testLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Binds 回傳成功 bind 的 DN
+func (s *testLDAPServer) Binds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...)
+}
+
+// Filters 回傳收到的搜尋過濾條件
+func (s *testLDAPServer) Filters() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.filters...)
+}
+
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	boundDN := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := ber.DecodeString(request.Children[1].Data.Bytes())
+			password := ber.DecodeString(request.Children[2].Data.Bytes())
+			code := s.bind(dn, password)
+			if code == ldap.LDAPResultSuccess && password != "" {
+				boundDN = dn
+			} else {
+				boundDN = ""
+			}
+			writeLDAPResponse(conn, messageID, ldapResult(ldap.ApplicationBindResponse, code))
+
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(request.Children[6])
+			s.mu.Lock()
+			s.filters = append(s.filters, filter)
+			s.mu.Unlock()
+
+			if boundDN != testLDAPServiceDN {
+				writeLDAPResponse(conn, messageID, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				continue
+			}
+			baseDN := strings.ToLower(ber.DecodeString(request.Children[0].Data.Bytes()))
+			sizeLimit, _ := request.Children[3].Value.(int64)
+			code := uint16(ldap.LDAPResultSuccess)
+			sent := int64(0)
+			for _, entry := range s.entries {
+				if !strings.HasSuffix(strings.ToLower(entry.dn), baseDN) || !entry.matches(request.Children[6]) {
+					continue
+				}
+				if sizeLimit > 0 && sent == sizeLimit {
+					code = ldap.LDAPResultSizeLimitExceeded
+					break
+				}
+				writeLDAPResponse(conn, messageID, entry.searchResult())
+				sent++
+			}
+			writeLDAPResponse(conn, messageID, ldapResult(ldap.ApplicationSearchResultDone, code))
+
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// bind 驗證 simple bind，空密碼為未驗證 bind
+func (s *testLDAPServer) bind(dn, password string) uint16 {
+	if password == "" {
+		return ldap.LDAPResultSuccess
+	}
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.dn, dn) && entry.password != "" && entry.password == password {
+			s.mu.Lock()
+			s.binds = append(s.binds, entry.dn)
+			s.mu.Unlock()
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+// matches 以解碼後的過濾條件比對項目 (支援 and、or、not、equality 與 present)
+func (e testLDAPEntry) matches(filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !e.matches(child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if e.matches(child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !e.matches(filter.Children[0])
+	case ldap.FilterEqualityMatch:
+		attribute := ber.DecodeString(filter.Children[0].Data.Bytes())
+		value := ber.DecodeString(filter.Children[1].Data.Bytes())
+		for _, candidate := range e.values(attribute) {
+			if strings.EqualFold(candidate, value) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(e.values(ber.DecodeString(filter.Data.Bytes()))) > 0
+	}
+	return false
+}
+
+// values 取得屬性值 (屬性名稱不分大小寫)
+func (e testLDAPEntry) values(attribute string) []string {
+	for name, values := range e.attributes {
+		if strings.EqualFold(name, attribute) {
+			return values
+		}
+	}
+	return nil
+}
+
+// searchResult 編碼為 SearchResultEntry
+func (e testLDAPEntry) searchResult() *ber.Packet {
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "DN"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	entry.AppendChild(attributes)
+	return entry
+}
+
+// ldapResult 編碼 LDAPResult (BindResponse、SearchResultDone)
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return result
+}
+
+// writeLDAPResponse 以 LDAPMessage 包裝回應並寫出
+func writeLDAPResponse(conn net.Conn, messageID interface{}, response *ber.Packet) {
+	message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	message.AppendChild(response)
+	conn.Write(message.Bytes())
+}
+
+// newTestLDAPAuthenticator 建立連線到測試伺服器的 LDAP 驗證後端
+func newTestLDAPAuthenticator(server *testLDAPServer, userRepo *fakeUserRepo) *LDAPAuthenticator {
+	return NewLDAPAuthenticator(LDAPConfig{
+		URL:            server.URL(),
+		BindDN:         testLDAPServiceDN,
+		BindPassword:   testLDAPServicePassword,
+		BaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:     defaultLDAPUserFilter,
+		EmailAttribute: "mail",
+		GroupFilter:    defaultLDAPGroupFilter,
+		GroupRoles:     map[string][]string{},
+		DefaultLevel:   "user",
+		Timeout:        5 * time.Second,
+	}, userRepo, &fakeRoleRepo{}, nil)
+}
+
+func TestLDAPAuthenticateBindsAsUser(t *testing.T) {
+	server := newTestLDAPServer(t)
+	userRepo := &fakeUserRepo{}
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+	alice, _ := userRepo.GetByUsername("alice")
+	authenticator := newTestLDAPAuthenticator(server, userRepo)
+
+	user, err := authenticator.Authenticate(alice, "alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.ID != alice.ID {
+		t.Fatalf("user = %d, want %d", user.ID, alice.ID)
+	}
+
+	// 以服務帳號搜尋、以使用者 DN 驗證密碼，再切回服務帳號讀取群組
+	want := []string{testLDAPServiceDN, testLDAPAliceDN, testLDAPServiceDN}
+	if got := server.Binds(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("binds = %v, want %v", got, want)
+	}
+	if got := server.Filters(); len(got) != 1 || got[0] != "(&(objectClass=person)(uid=alice))" {
+		t.Fatalf("filters = %v", got)
+	}
+}
+
+func TestLDAPAuthenticateRejectsWrongPassword(t *testing.T) {
+	server := newTestLDAPServer(t)
+	userRepo := &fakeUserRepo{}
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+	alice, _ := userRepo.GetByUsername("alice")
+	authenticator := newTestLDAPAuthenticator(server, userRepo)
+
+	if _, err := authenticator.Authenticate(alice, "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := authenticator.Authenticate(nil, "nobody", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("unknown user: err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLDAPAuthenticateRejectsEmptyPassword(t *testing.T) {
+	server := newTestLDAPServer(t)
+	userRepo := &fakeUserRepo{}
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+	alice, _ := userRepo.GetByUsername("alice")
+	authenticator := newTestLDAPAuthenticator(server, userRepo)
+
+	// 空密碼在目錄服務是會成功的未驗證 bind，必須在連線前拒絕
+	if _, err := authenticator.Authenticate(alice, "alice", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v, want ErrInvalidCredentials", err)
+	}
+	if got := server.Filters(); len(got) != 0 {
+		t.Fatalf("server was queried with an empty password: %v", got)
+	}
+}
+
+func TestLDAPAuthenticateEscapesUserFilter(t *testing.T) {
+	server := newTestLDAPServer(t)
+	userRepo := &fakeUserRepo{}
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+	alice, _ := userRepo.GetByUsername("alice")
+	authenticator := newTestLDAPAuthenticator(server, userRepo)
+
+	// 未跳脫時會成為 (&(objectClass=person)(uid=alice)(uid=*)) 並以 alice 的身分登入
+	tests := []struct {
+		username string
+		filter   string
+	}{
+		{"alice)(uid=*", `(&(objectClass=person)(uid=alice\29\28uid=\2a))`},
+		{"*", `(&(objectClass=person)(uid=\2a))`},
+		{`alice\`, `(&(objectClass=person)(uid=alice\5c))`},
+	}
+	for _, test := range tests {
+		if _, err := authenticator.Authenticate(alice, test.username, "alice-secret"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("username %q: err = %v, want ErrInvalidCredentials", test.username, err)
+		}
+		filters := server.Filters()
+		if got := filters[len(filters)-1]; got != test.filter {
+			t.Fatalf("username %q: filter = %s, want %s", test.username, got, test.filter)
+		}
+	}
+	for _, dn := range server.Binds() {
+		if dn == testLDAPAliceDN {
+			t.Fatal("injected filter reached a user bind")
+		}
+	}
+}
+
+func TestLDAPAuthenticateEscapesGroupFilter(t *testing.T) {
+	server := newTestLDAPServer(t)
+	userRepo := &fakeUserRepo{}
+	authenticator := newTestLDAPAuthenticator(server, userRepo)
+	authenticator.config.AutoProvision = true
+	authenticator.config.GroupBaseDN = "ou=groups,dc=example,dc=com"
+	authenticator.config.GroupRoles = parseLDAPGroupRoles(testLDAPSalesDN + "=>業務")
+
+	// 使用者 DN 含有括號，代入群組過濾條件時必須跳脫
+	user, err := authenticator.Authenticate(nil, "obrien", "obrien-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.Email != "obrien@example.com" || user.AuthBackends != AuthBackendLDAP || user.EmailVerifiedAt == nil {
+		t.Fatalf("provisioned user = %+v", user)
+	}
+
+	filters := server.Filters()
+	want := `(|(member=cn=O'Brien \28Sales\29,ou=people,dc=example,dc=com)(uniqueMember=cn=O'Brien \28Sales\29,ou=people,dc=example,dc=com))`
+	if len(filters) != 2 || filters[1] != want {
+		t.Fatalf("filters = %v, want group filter %s", filters, want)
+	}
+}
+
+func TestLDAPAuthenticateRejectsUnlinkedLocalUser(t *testing.T) {
+	server := newTestLDAPServer(t)
+	userRepo := &fakeUserRepo{}
+	// 本地的 bob 與目錄中的 bob 電子郵件不同，也沒有設定使用 LDAP 登入
+	userRepo.Create(&models.User{Username: "bob", Email: "bob@other.example.com", Level: "admin"})
+	bob, _ := userRepo.GetByUsername("bob")
+	authenticator := newTestLDAPAuthenticator(server, userRepo)
+
+	if _, err := authenticator.Authenticate(bob, "bob", "bob-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v, want ErrInvalidCredentials", err)
+	}
+
+	bob.AuthBackends = AuthBackendLDAP
+	if _, err := authenticator.Authenticate(bob, "bob", "bob-secret"); err != nil {
+		t.Fatalf("linked user: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"erp/db"
+	"erp/models"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLoginEventRetention = 90 * 24 * time.Hour // LOGIN_EVENT_RETENTION: 登入事件的保存期限
+	loginEventPruneInterval    = time.Hour           // 寫入事件時順便清除過期紀錄的間隔
+	defaultLoginEventLimit     = 50
+)
+
+// LoginEventService 登入事件服務，記錄登入嘗試並依保存期限清除舊紀錄
+type LoginEventService struct {
+	repo      db.LoginEventRepository
+	retention time.Duration
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+// NewLoginEventService 建立登入事件服務實例
+func NewLoginEventService(repo db.LoginEventRepository) *LoginEventService {
+	return &LoginEventService{
+		repo:      repo,
+		retention: durationFromEnv("LOGIN_EVENT_RETENTION", defaultLoginEventRetention),
+	}
+}
+
+// Record 寫入登入事件，並定期清除超過保存期限的紀錄
+func (s *LoginEventService) Record(event *models.LoginEvent) error {
+	event.Username = truncateUTF8(event.Username, 255)
+	event.Detail = truncateUTF8(event.Detail, 255)
+	event.UserAgent = truncateUserAgent(event.UserAgent)
+	if err := s.repo.Create(event); err != nil {
+		return err
+	}
+
+	if s.pruneDue(time.Now()) {
+		_, err := s.Prune()
+		return err
+	}
+	return nil
+}
+
+// List 依條件查詢登入事件，回傳該頁資料與總筆數
+func (s *LoginEventService) List(query models.LoginEventQuery) ([]models.LoginEvent, int64, error) {
+	if query.Limit <= 0 {
+		query.Limit = defaultLoginEventLimit
+	}
+	return s.repo.List(query)
+}
+
+// Prune 清除超過保存期限的登入事件，回傳刪除筆數
+func (s *LoginEventService) Prune() (int64, error) {
+	return s.repo.DeleteBefore(time.Now().Add(-s.retention))
+}
+
+// pruneDue 距離上次清除超過間隔時回傳 true 並記錄本次時間
+func (s *LoginEventService) pruneDue(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastPruned) < loginEventPruneInterval {
+		return false
+	}
+	s.lastPruned = now
+	return true
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"erp/db"
+	"erp/models"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 登入防護的預設值，可由環境變數覆寫
+const (
+	defaultLoginMaxAttempts   = 5                // LOGIN_MAX_ATTEMPTS: 帳號連續失敗幾次後鎖定
+	defaultLoginLockout       = 15 * time.Minute // LOGIN_LOCKOUT_DURATION: 帳號鎖定時間
+	defaultLoginIPMaxAttempts = 20               // LOGIN_IP_MAX_ATTEMPTS: 同一 IP 在時間窗內的失敗上限
+	defaultLoginIPWindow      = 15 * time.Minute // LOGIN_IP_WINDOW: IP 失敗次數的計算時間窗
+	loginDelayFreeAttempts    = 2                // 前幾次失敗不延遲
+	loginMaxDelay             = 30 * time.Second // 漸進延遲上限
+)
+
+// LoginBlockedError 登入因鎖定或延遲而被拒絕
+type LoginBlockedError struct {
+	Locked bool      // true: 帳號已鎖定；false: 需等待漸進延遲或 IP 被暫時封鎖
+	Until  time.Time // 可再次嘗試的時間
+}
+
+// Error 實作 error 介面
+func (e *LoginBlockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("帳號已暫時鎖定，請於 %s 後再試", e.Until.Format("15:04:05"))
+	}
+	return "登入嘗試過於頻繁，請稍後再試"
+}
+
+// RetryAfter 回傳距離可再次嘗試的秒數 (至少 1 秒)
+func (e *LoginBlockedError) RetryAfter(now time.Time) int {
+	seconds := int(math.Ceil(e.Until.Sub(now).Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// ipFailureRecord 單一 IP 的登入失敗紀錄
+type ipFailureRecord struct {
+	count        int
+	windowStart  time.Time
+	blockedUntil time.Time
+}
+
+// LoginGuardService 登入暴力破解防護服務
+// 帳號失敗次數保存在資料庫；IP 失敗次數保存在行程內，
+// 以 ClientIP() 為鍵，只有 TRUSTED_PROXIES 列出的代理能指定用戶端位址
+type LoginGuardService struct {
+	userRepo        db.UserRepository
+	maxAttempts     int
+	lockout         time.Duration
+	ipMaxAttempts   int
+	ipWindow        time.Duration
+	mu              sync.Mutex
+	ipFailures      map[string]*ipFailureRecord
+	lastIPSweepTime time.Time
+}
+
+// NewLoginGuardService 建立登入防護服務實例
+func NewLoginGuardService(userRepo db.UserRepository) *LoginGuardService {
+	return &LoginGuardService{
+		userRepo:      userRepo,
+		maxAttempts:   intFromEnv("LOGIN_MAX_ATTEMPTS", defaultLoginMaxAttempts),
+		lockout:       durationFromEnv("LOGIN_LOCKOUT_DURATION", defaultLoginLockout),
+		ipMaxAttempts: intFromEnv("LOGIN_IP_MAX_ATTEMPTS", defaultLoginIPMaxAttempts),
+		ipWindow:      durationFromEnv("LOGIN_IP_WINDOW", defaultLoginIPWindow),
+		ipFailures:    make(map[string]*ipFailureRecord),
+	}
+}
+
+// CheckIP 檢查來源 IP 是否被暫時封鎖
+func (s *LoginGuardService) CheckIP(clientIP string) error {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.ipFailures[clientIP]
+	if ok && now.Before(record.blockedUntil) {
+		return &LoginBlockedError{Until: record.blockedUntil}
+	}
+	return nil
+}
+
+// CheckUser 檢查帳號是否鎖定或仍在漸進延遲中
+// 以資料庫目前的失敗紀錄判斷 (不使用請求開始時載入的資料)，並將最新的紀錄寫回 user 供 RecordSuccess 比對
+func (s *LoginGuardService) CheckUser(user *models.User) error {
+	current, err := s.userRepo.GetByID(user.ID)
+	if err != nil {
+		return err
+	}
+	user.FailedLoginAttempts = current.FailedLoginAttempts
+	user.LastFailedLoginAt = current.LastFailedLoginAt
+	user.LockedUntil = current.LockedUntil
+	return s.blocked(user, time.Now())
+}
+
+// RecordFailure 記錄一次登入失敗；user 為 nil 代表使用者不存在，只計入 IP
+// 若帳號因此 (或已經) 鎖定，回傳 LoginBlockedError
+func (s *LoginGuardService) RecordFailure(user *models.User, clientIP string) error {
+	now := time.Now()
+	s.recordIPFailure(clientIP, now)
+
+	if user == nil {
+		return nil
+	}
+
+	current, err := s.userRepo.RecordLoginFailure(user.ID, now, s.maxAttempts, now.Add(s.lockout))
+	if err != nil {
+		return err
+	}
+	user.FailedLoginAttempts = current.FailedLoginAttempts
+	user.LastFailedLoginAt = current.LastFailedLoginAt
+	user.LockedUntil = current.LockedUntil
+
+	if user.IsLocked(now) {
+		return &LoginBlockedError{Locked: true, Until: *user.LockedUntil}
+	}
+	return nil
+}
+
+// RecordSuccess 登入成功後清除帳號的失敗紀錄
+// CheckUser 之後若有同時進行的登入失敗或帳號已被鎖定，本次登入視為被拒絕並回傳 LoginBlockedError
+func (s *LoginGuardService) RecordSuccess(user *models.User) error {
+	now := time.Now()
+	cleared, err := s.userRepo.ClearLoginFailures(user.ID, user.FailedLoginAttempts, now)
+	if err != nil {
+		return err
+	}
+	if !cleared {
+		if err := s.CheckUser(user); err != nil {
+			return err
+		}
+		return &LoginBlockedError{Until: now.Add(time.Second)}
+	}
+	user.FailedLoginAttempts = 0
+	user.LastFailedLoginAt = nil
+	user.LockedUntil = nil
+	return nil
+}
+
+// Unlock 管理員解除帳號鎖定
+func (s *LoginGuardService) Unlock(user *models.User) error {
+	if err := s.userRepo.ResetLoginFailures(user.ID); err != nil {
+		return err
+	}
+	user.FailedLoginAttempts = 0
+	user.LastFailedLoginAt = nil
+	user.LockedUntil = nil
+	return nil
+}
+
+// blocked 依帳號的失敗紀錄判斷是否鎖定或仍在漸進延遲中；鎖定已過期時視為重新開始計算
+func (s *LoginGuardService) blocked(user *models.User, now time.Time) error {
+	if user.IsLocked(now) {
+		return &LoginBlockedError{Locked: true, Until: *user.LockedUntil}
+	}
+	if user.LockedUntil != nil || user.LastFailedLoginAt == nil {
+		return nil
+	}
+
+	next := user.LastFailedLoginAt.Add(s.delayFor(user.FailedLoginAttempts))
+	if now.Before(next) {
+		return &LoginBlockedError{Until: next}
+	}
+	return nil
+}
+
+// delayFor 依連續失敗次數計算下一次嘗試前需等待的時間 (指數成長，有上限)
+func (s *LoginGuardService) delayFor(attempts int) time.Duration {
+	if attempts <= loginDelayFreeAttempts {
+		return 0
+	}
+	exponent := attempts - loginDelayFreeAttempts - 1
+	if exponent > 10 {
+		return loginMaxDelay
+	}
+	delay := time.Second << uint(exponent)
+	if delay > loginMaxDelay {
+		return loginMaxDelay
+	}
+	return delay
+}
+
+// recordIPFailure 累加來源 IP 在時間窗內的失敗次數，超過上限時封鎖一段時間
+func (s *LoginGuardService) recordIPFailure(clientIP string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepIPFailures(now)
+
+	record, ok := s.ipFailures[clientIP]
+	if !ok || now.Sub(record.windowStart) > s.ipWindow {
+		record = &ipFailureRecord{windowStart: now}
+		s.ipFailures[clientIP] = record
+	}
+	record.count++
+	if record.count >= s.ipMaxAttempts {
+		record.blockedUntil = now.Add(s.lockout)
+	}
+}
+
+// sweepIPFailures 定期清除過期的 IP 紀錄，避免記憶體無限成長 (呼叫端需持有鎖)
+func (s *LoginGuardService) sweepIPFailures(now time.Time) {
+	if now.Sub(s.lastIPSweepTime) < s.ipWindow {
+		return
+	}
+	for ip, record := range s.ipFailures {
+		if now.Sub(record.windowStart) > s.ipWindow && now.After(record.blockedUntil) {
+			delete(s.ipFailures, ip)
+		}
+	}
+	s.lastIPSweepTime = now
+}
+
+// intFromEnv 從環境變數讀取正整數，未設定或格式錯誤時使用預設值
+func intFromEnv(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"erp/db"
+	"erp/models"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrMFANotEnrolled 使用者尚未開始綁定兩步驟驗證
+var ErrMFANotEnrolled = errors.New("尚未設定兩步驟驗證")
+
+// ErrMFAAlreadyEnabled 使用者已啟用兩步驟驗證
+var ErrMFAAlreadyEnabled = errors.New("已啟用兩步驟驗證")
+
+// ErrMFARequired 使用者等級強制要求兩步驟驗證，不可停用
+var ErrMFARequired = errors.New("此等級的使用者必須啟用兩步驟驗證")
+
+// ErrInvalidMFACode 驗證碼或備用碼錯誤
+var ErrInvalidMFACode = errors.New("驗證碼錯誤或已使用")
+
+// 備用碼數量與長度
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// 備用碼字元集，排除容易混淆的 0/O、1/I/L
+const recoveryCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// MFAService TOTP 兩步驟驗證服務
+type MFAService struct {
+	userRepo         db.UserRepository
+	mfaRepo          db.UserMFARepository
+	recoveryCodeRepo db.MFARecoveryCodeRepository
+	issuer           string
+	requiredLevels   map[string]bool
+}
+
+// NewMFAService 建立兩步驟驗證服務實例
+// MFA_ISSUER 設定驗證器 App 顯示的名稱；MFA_REQUIRED_LEVELS 以逗號分隔強制啟用的等級 (例如 "admin,super_admin")
+func NewMFAService(userRepo db.UserRepository, mfaRepo db.UserMFARepository, recoveryCodeRepo db.MFARecoveryCodeRepository) *MFAService {
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "JasonTech ERP"
+	}
+
+	requiredLevels := make(map[string]bool)
+	for _, level := range strings.Split(os.Getenv("MFA_REQUIRED_LEVELS"), ",") {
+		if level = strings.TrimSpace(level); level != "" {
+			requiredLevels[level] = true
+		}
+	}
+
+	return &MFAService{
+		userRepo:         userRepo,
+		mfaRepo:          mfaRepo,
+		recoveryCodeRepo: recoveryCodeRepo,
+		issuer:           issuer,
+		requiredLevels:   requiredLevels,
+	}
+}
+
+// RequiredForLevel 檢查該等級是否強制要求兩步驟驗證
+func (s *MFAService) RequiredForLevel(level string) bool {
+	return s.requiredLevels[level]
+}
+
+// Enroll 產生新的 TOTP 金鑰，回傳金鑰與 otpauth URI；需再呼叫 ConfirmEnrollment 才會生效
+func (s *MFAService) Enroll(user *models.User) (secret, uri string, err error) {
+	if user.MFAEnabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err = generateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	encrypted, err := encryptSecret(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = s.mfaRepo.Save(&models.UserMFA{
+		UserID: user.ID,
+		Secret: encrypted,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return secret, totpURI(s.issuer, user.Username, secret), nil
+}
+
+// ConfirmEnrollment 以驗證碼確認綁定，啟用兩步驟驗證並回傳一組新的備用碼
+func (s *MFAService) ConfirmEnrollment(user *models.User, code string) ([]string, error) {
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	mfa, err := s.mfaRepo.GetByUserID(user.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verifyTOTP(mfa, code); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	mfa.ConfirmedAt = &now
+	if err := s.mfaRepo.Save(mfa); err != nil {
+		return nil, err
+	}
+
+	user.MFAEnabled = true
+	if err := s.userRepo.Update(user, "mfa_enabled"); err != nil {
+		return nil, err
+	}
+	return s.RegenerateRecoveryCodes(user.ID)
+}
+
+// Disable 停用兩步驟驗證並刪除金鑰與備用碼
+// force 為 true 時 (管理員重設) 略過等級強制檢查
+func (s *MFAService) Disable(user *models.User, force bool) error {
+	if !force && s.RequiredForLevel(user.Level) {
+		return ErrMFARequired
+	}
+
+	if err := s.mfaRepo.Delete(user.ID); err != nil {
+		return err
+	}
+	if err := s.recoveryCodeRepo.DeleteByUserID(user.ID); err != nil {
+		return err
+	}
+
+	user.MFAEnabled = false
+	return s.userRepo.Update(user, "mfa_enabled")
+}
+
+// Verify 驗證已啟用使用者的 TOTP 驗證碼
+func (s *MFAService) Verify(userID uint, code string) error {
+	mfa, err := s.mfaRepo.GetByUserID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrMFANotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if mfa.ConfirmedAt == nil {
+		return ErrMFANotEnrolled
+	}
+	return s.verifyTOTP(mfa, code)
+}
+
+// VerifyRecoveryCode 驗證並消耗一組備用碼
+func (s *MFAService) VerifyRecoveryCode(userID uint, code string) error {
+	codes, err := s.recoveryCodeRepo.GetUnusedByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	hashed := hashToken(normalizeRecoveryCode(code))
+	for _, stored := range codes {
+		if subtle.ConstantTimeCompare([]byte(stored.CodeHash), []byte(hashed)) != 1 {
+			continue
+		}
+		marked, err := s.recoveryCodeRepo.MarkUsed(stored.ID, time.Now())
+		if err != nil {
+			return err
+		}
+		if !marked {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+	return ErrInvalidMFACode
+}
+
+// RegenerateRecoveryCodes 產生新的備用碼並使舊備用碼全部失效，明文只在此時回傳一次
+func (s *MFAService) RegenerateRecoveryCodes(userID uint) ([]string, error) {
+	plain := make([]string, 0, recoveryCodeCount)
+	records := make([]models.MFARecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		plain = append(plain, code)
+		records = append(records, models.MFARecoveryCode{
+			UserID:   userID,
+			CodeHash: hashToken(normalizeRecoveryCode(code)),
+		})
+	}
+
+	if err := s.recoveryCodeRepo.ReplaceForUser(userID, records); err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// verifyTOTP 解密金鑰並驗證驗證碼，同一時間區間的驗證碼只能使用一次
+func (s *MFAService) verifyTOTP(mfa *models.UserMFA, code string) error {
+	secret, err := decryptSecret(mfa.Secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := validateTOTP(secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	updated, err := s.mfaRepo.UpdateLastUsedStep(mfa.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrInvalidMFACode
+	}
+	mfa.LastUsedStep = step
+	return nil
+}
+
+// generateRecoveryCode 產生格式為 XXXXX-XXXXX 的備用碼
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, recoveryCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("無法產生備用碼: %v", err)
+	}
+
+	var b strings.Builder
+	for i, v := range buf {
+		if i == recoveryCodeLength/2 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(recoveryCodeAlphabet[int(v)%len(recoveryCodeAlphabet)])
+	}
+	return b.String(), nil
+}
+
+// normalizeRecoveryCode 忽略大小寫、空白與連字號，方便使用者輸入
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
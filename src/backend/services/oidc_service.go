@@ -0,0 +1,511 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"erp/db"
+	"erp/models"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// ErrOIDCProviderNotFound 未設定的單一登入提供者
+var ErrOIDCProviderNotFound = errors.New("找不到單一登入提供者")
+
+// ErrOIDCInvalidState state 不存在、已使用或已過期
+var ErrOIDCInvalidState = errors.New("單一登入狀態無效或已過期，請重新登入")
+
+// ErrOIDCAuthFailed 授權碼交換或 ID token 驗證失敗
+var ErrOIDCAuthFailed = errors.New("單一登入驗證失敗")
+
+// ErrOIDCUserNotProvisioned 找不到對應的使用者且未開啟自動建立帳號
+var ErrOIDCUserNotProvisioned = errors.New("此帳號尚未開通，請聯絡管理員")
+
+// ErrOIDCEmailRequired 身分提供者未提供已驗證的電子郵件，無法對應使用者
+var ErrOIDCEmailRequired = errors.New("身分提供者未提供已驗證的電子郵件")
+
+// ErrOIDCServiceAccount 身分對應到服務帳號 (服務帳號只能使用 API 金鑰，不可登入)
+var ErrOIDCServiceAccount = errors.New("服務帳號不可使用單一登入")
+
+// ErrOIDCInvalidLoginCode 交換碼不存在、已使用或已過期
+var ErrOIDCInvalidLoginCode = errors.New("單一登入交換碼無效或已過期，請重新登入")
+
+// oidcStateTTL 授權流程 (跳轉至身分提供者到回呼) 的有效期限
+const oidcStateTTL = 10 * time.Minute
+
+// oidcLoginCodeTTL 回呼導回前端後，前端以交換碼換取令牌的有效期限
+const oidcLoginCodeTTL = time.Minute
+
+// OIDCProviderConfig 單一登入提供者設定
+type OIDCProviderConfig struct {
+	Name          string
+	IssuerURL     string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	AutoProvision bool                // 找不到使用者時自動建立帳號
+	DefaultLevel  string              // 自動建立帳號的等級
+	TrustEmail    bool                // 未提供 email_verified 聲明時仍信任電子郵件
+	GroupsClaim   string              // ID token 中的群組聲明名稱
+	GroupRoles    map[string][]string // 群組對應的角色名稱
+}
+
+// oidcClient 已完成 discovery 的提供者
+type oidcClient struct {
+	config   OIDCProviderConfig
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// oidcClaims ID token 中使用到的聲明
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     *bool  `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// OIDCService OpenID Connect 單一登入服務 (authorization code + PKCE)
+type OIDCService struct {
+	userRepo          db.UserRepository
+	identityRepo      db.UserIdentityRepository
+	stateRepo         db.OIDCLoginStateRepository
+	codeRepo          db.OIDCLoginCodeRepository
+	roleRepo          db.RoleRepository
+	permissionService *PermissionService
+	configs           map[string]OIDCProviderConfig
+	frontendURL       string
+	mu                sync.Mutex
+	clients           map[string]*oidcClient
+}
+
+// NewOIDCService 建立單一登入服務實例，提供者設定由環境變數讀取
+func NewOIDCService(userRepo db.UserRepository, identityRepo db.UserIdentityRepository, stateRepo db.OIDCLoginStateRepository, codeRepo db.OIDCLoginCodeRepository, roleRepo db.RoleRepository, permissionService *PermissionService) *OIDCService {
+	return &OIDCService{
+		userRepo:          userRepo,
+		identityRepo:      identityRepo,
+		stateRepo:         stateRepo,
+		codeRepo:          codeRepo,
+		roleRepo:          roleRepo,
+		permissionService: permissionService,
+		configs:           loadOIDCConfigs(),
+		frontendURL:       os.Getenv("OIDC_FRONTEND_URL"),
+		clients:           make(map[string]*oidcClient),
+	}
+}
+
+// Providers 回傳已設定的提供者名稱
+func (s *OIDCService) Providers() []string {
+	names := make([]string, 0, len(s.configs))
+	for name := range s.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AuthorizationURL 建立授權流程狀態 (state、nonce、PKCE verifier)，回傳身分提供者的登入網址與 state
+// 呼叫端需將 state 存放在發起登入的瀏覽器 (cookie)，回呼時交給 Authenticate 比對
+func (s *OIDCService) AuthorizationURL(ctx context.Context, providerName string) (string, string, error) {
+	client, err := s.client(ctx, providerName)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	now := time.Now()
+	if err := s.stateRepo.DeleteExpired(now); err != nil {
+		return "", "", err
+	}
+	err = s.stateRepo.Create(&models.OIDCLoginState{
+		StateHash:    hashToken(state),
+		Provider:     providerName,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    now.Add(oidcStateTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return client.oauth2.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce)), state, nil
+}
+
+// StateTTL 取得授權流程的有效期限
+func (s *OIDCService) StateTTL() time.Duration {
+	return oidcStateTTL
+}
+
+// Authenticate 處理回呼：驗證 state、以 PKCE 交換授權碼、驗證 ID token，回傳對應的使用者
+// browserState 為發起登入的瀏覽器保存的 state，與回呼的 state 不同時拒絕 (防止攻擊者將自己的回呼網址交給受害者完成登入)
+func (s *OIDCService) Authenticate(ctx context.Context, providerName, state, browserState, code string) (*models.User, error) {
+	if browserState == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return nil, ErrOIDCInvalidState
+	}
+
+	stored, err := s.stateRepo.Consume(hashToken(state))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOIDCInvalidState
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.Provider != providerName || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrOIDCInvalidState
+	}
+
+	client, err := s.client(ctx, providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := client.oauth2.Exchange(ctx, code, oauth2.VerifierOption(stored.CodeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCAuthFailed, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: 回應中沒有 id_token", ErrOIDCAuthFailed)
+	}
+	idToken, err := client.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCAuthFailed, err)
+	}
+	if idToken.Nonce != stored.Nonce {
+		return nil, fmt.Errorf("%w: nonce 不符", ErrOIDCAuthFailed)
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCAuthFailed, err)
+	}
+	var rawClaims map[string]interface{}
+	if err := idToken.Claims(&rawClaims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCAuthFailed, err)
+	}
+
+	user, err := s.resolveUser(client.config, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := claimStrings(rawClaims[client.config.GroupsClaim])
+	if err := syncMappedRoles(s.roleRepo, s.permissionService, user.ID, client.config.GroupRoles, groups); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// IssueLoginCode 回呼驗證通過後產生一次性交換碼，由前端以 ExchangeLoginCode 換取令牌
+// 回呼是瀏覽器的頂層跳轉，令牌不直接放在回應或網址中
+func (s *OIDCService) IssueLoginCode(user *models.User, providerName string) (string, error) {
+	rawCode, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if err := s.codeRepo.DeleteExpired(now); err != nil {
+		return "", err
+	}
+	err = s.codeRepo.Create(&models.OIDCLoginCode{
+		CodeHash:  hashToken(rawCode),
+		UserID:    user.ID,
+		Provider:  providerName,
+		ExpiresAt: now.Add(oidcLoginCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return rawCode, nil
+}
+
+// ExchangeLoginCode 取出交換碼對應的使用者，交換碼只能使用一次
+func (s *OIDCService) ExchangeLoginCode(rawCode string) (*models.User, error) {
+	code, err := s.codeRepo.Consume(hashToken(rawCode))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOIDCInvalidLoginCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(code.ExpiresAt) {
+		return nil, ErrOIDCInvalidLoginCode
+	}
+
+	user, err := s.userRepo.GetByID(code.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOIDCInvalidLoginCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.ServiceAccount {
+		return nil, ErrOIDCServiceAccount
+	}
+	return user, nil
+}
+
+// FrontendURL 組合回呼結束後導回前端的網址
+// 交換碼或錯誤放在 fragment 中，不會送到任何伺服器或出現在 Referer
+func (s *OIDCService) FrontendURL(params url.Values) string {
+	base := s.frontendURL
+	if base == "" {
+		base = "http://localhost:3000/sso/callback"
+	}
+
+	link, err := url.Parse(base)
+	if err != nil {
+		return base + "#" + params.Encode()
+	}
+	link.Fragment = params.Encode()
+	return link.String()
+}
+
+// client 取得提供者，第一次使用時才進行 discovery (身分提供者暫時無法連線不影響啟動)
+func (s *OIDCService) client(ctx context.Context, providerName string) (*oidcClient, error) {
+	config, ok := s.configs[providerName]
+	if !ok {
+		return nil, ErrOIDCProviderNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if client, ok := s.clients[providerName]; ok {
+		return client, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("無法取得身分提供者 %s 的設定: %v", providerName, err)
+	}
+
+	client := &oidcClient{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       config.Scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}
+	s.clients[providerName] = client
+	return client, nil
+}
+
+// resolveUser 依序以外部身分、已驗證的電子郵件對應使用者，必要時自動建立帳號
+func (s *OIDCService) resolveUser(config OIDCProviderConfig, claims oidcClaims) (*models.User, error) {
+	now := time.Now()
+
+	identity, err := s.identityRepo.GetByProviderSubject(config.Name, claims.Subject)
+	if err == nil {
+		user, err := s.userRepo.GetByID(identity.UserID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOIDCUserNotProvisioned
+		}
+		if err != nil {
+			return nil, err
+		}
+		if user.ServiceAccount {
+			return nil, ErrOIDCServiceAccount
+		}
+		if err := s.identityRepo.UpdateLastLogin(identity.ID, now); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// 第一次以此身分登入：只有已驗證的電子郵件可以對應到既有帳號
+	emailVerified := claims.EmailVerified != nil && *claims.EmailVerified
+	if claims.EmailVerified == nil && config.TrustEmail {
+		emailVerified = true
+	}
+	if claims.Email == "" || !emailVerified {
+		return nil, ErrOIDCEmailRequired
+	}
+
+	user, err := s.userRepo.GetByEmail(claims.Email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if !config.AutoProvision {
+			return nil, ErrOIDCUserNotProvisioned
+		}
+		user, err = s.provisionUser(config, claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 與密碼登入相同，服務帳號不可互動登入，也不建立外部身分連結
+	if user.ServiceAccount {
+		return nil, ErrOIDCServiceAccount
+	}
+
+	err = s.identityRepo.Create(&models.UserIdentity{
+		UserID:      user.ID,
+		Provider:    config.Name,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		LastLoginAt: &now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// provisionUser 自動建立單一登入使用者，密碼設為無法使用的隨機值
+func (s *OIDCService) provisionUser(config OIDCProviderConfig, claims oidcClaims) (*models.User, error) {
+	username, err := s.availableUsername(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := unusablePasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	// 電子郵件已由身分提供者驗證
+	now := time.Now()
+	user := &models.User{
+		Username:        username,
+		Email:           claims.Email,
+		Password:        password,
+		Level:           config.DefaultLevel,
+		EmailVerifiedAt: &now,
+	}
+	if err := s.userRepo.Create(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// usernameSanitizer 使用者名稱只保留英數字與 . _ -
+var usernameSanitizer = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// availableUsername 以 preferred_username 或電子郵件帳號產生不重複的使用者名稱
+func (s *OIDCService) availableUsername(claims oidcClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" || strings.Contains(base, "@") {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameSanitizer.ReplaceAllString(base, "")
+	if base == "" {
+		base = "sso-user"
+	}
+	if len(base) > 40 {
+		base = base[:40]
+	}
+
+	candidate := base
+	for i := 2; i < 100; i++ {
+		_, err := s.userRepo.GetByUsername(candidate)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s-%d", base, i)
+	}
+	return "", fmt.Errorf("無法為 %s 產生不重複的使用者名稱", base)
+}
+
+// claimStrings 將字串或字串陣列形式的聲明轉為字串切片
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// loadOIDCConfigs 讀取提供者設定
+// OIDC_PROVIDERS 以逗號分隔提供者名稱，每個提供者以 OIDC_<NAME>_* 設定，例如：
+//
+//	OIDC_PROVIDERS=company
+//	OIDC_COMPANY_ISSUER=https://login.example.com/realms/company
+//	OIDC_COMPANY_CLIENT_ID=erp
+//	OIDC_COMPANY_CLIENT_SECRET=secret
+//	OIDC_COMPANY_REDIRECT_URL=http://localhost:8000/api/auth/oidc/company/callback
+//	OIDC_COMPANY_GROUP_ROLES=erp-admins=系統管理員,erp-sales=業務
+func loadOIDCConfigs() map[string]OIDCProviderConfig {
+	configs := make(map[string]OIDCProviderConfig)
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		config := OIDCProviderConfig{
+			Name:          name,
+			IssuerURL:     os.Getenv(prefix + "ISSUER"),
+			ClientID:      os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:  os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:   os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:        []string{oidc.ScopeOpenID, "email", "profile"},
+			AutoProvision: os.Getenv(prefix+"AUTO_PROVISION") == "true",
+			DefaultLevel:  os.Getenv(prefix + "DEFAULT_LEVEL"),
+			TrustEmail:    os.Getenv(prefix+"TRUST_EMAIL") == "true",
+			GroupsClaim:   os.Getenv(prefix + "GROUPS_CLAIM"),
+			GroupRoles:    make(map[string][]string),
+		}
+		if config.IssuerURL == "" || config.ClientID == "" {
+			fmt.Fprintf(os.Stderr, "單一登入提供者 %s 缺少 %sISSUER 或 %sCLIENT_ID，已略過\n", name, prefix, prefix)
+			continue
+		}
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			config.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+		}
+		if config.DefaultLevel == "" {
+			config.DefaultLevel = "user"
+		}
+		if config.GroupsClaim == "" {
+			config.GroupsClaim = "groups"
+		}
+		for _, pair := range strings.Split(os.Getenv(prefix+"GROUP_ROLES"), ",") {
+			group, role, ok := strings.Cut(pair, "=")
+			group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+			if ok && group != "" && role != "" {
+				config.GroupRoles[group] = append(config.GroupRoles[group], role)
+			}
+		}
+		configs[name] = config
+	}
+	return configs
+}
@@ -0,0 +1,431 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"erp/models"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockOIDCProvider 本機的 OpenID Connect 身分提供者：discovery、JWKS、授權 (直接視為已登入) 與 token 端點
+// token 端點會以 S256 檢查 PKCE code_verifier，ID token 帶回授權請求的 nonce
+type mockOIDCProvider struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu     sync.Mutex
+	codes  map[string]mockAuthorization
+	claims map[string]interface{} // 加入 ID token 的聲明
+	nonce  *string                // 設定時以此值取代授權請求的 nonce
+}
+
+// mockAuthorization 授權碼對應的授權請求
+type mockAuthorization struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{
+		key:      key,
+		clientID: "erp",
+		codes:    make(map[string]mockAuthorization),
+		claims:   map[string]interface{}{"sub": "alice-subject", "email": "alice@example.com", "email_verified": true},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": "mock",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize 模擬使用者在身分提供者登入完成，帶著授權碼與 state 跳轉回 redirect_uri
+func (p *mockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != p.clientID ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code, _ := randomToken(16)
+	p.mu.Lock()
+	p.codes[code] = mockAuthorization{
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		redirectURI: query.Get("redirect_uri"),
+	}
+	p.mu.Unlock()
+
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token 以授權碼交換 ID token，授權碼只能使用一次，code_verifier 必須符合授權請求的 code_challenge
+func (p *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	p.mu.Lock()
+	authorization, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	claims := jwt.MapClaims{}
+	for name, value := range p.claims {
+		claims[name] = value
+	}
+	nonce := authorization.nonce
+	if p.nonce != nil {
+		nonce = *p.nonce
+	}
+	p.mu.Unlock()
+
+	digest := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != authorization.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(digest[:]) != authorization.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims["iss"] = p.server.URL
+	claims["aud"] = p.clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(5 * time.Minute).Unix()
+	claims["nonce"] = nonce
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "mock"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// newTestOIDCService 建立只設定 mock 提供者的單一登入服務
+func newTestOIDCService(provider *mockOIDCProvider) (*OIDCService, *fakeUserRepo, *fakeOIDCStateRepo) {
+	userRepo := &fakeUserRepo{}
+	stateRepo := &fakeOIDCStateRepo{}
+	service := &OIDCService{
+		userRepo:     userRepo,
+		identityRepo: &fakeIdentityRepo{},
+		stateRepo:    stateRepo,
+		codeRepo:     &fakeOIDCCodeRepo{},
+		roleRepo:     &fakeRoleRepo{},
+		configs: map[string]OIDCProviderConfig{"mock": {
+			Name:         "mock",
+			IssuerURL:    provider.server.URL,
+			ClientID:     provider.clientID,
+			ClientSecret: "secret",
+			RedirectURL:  "http://localhost:8000/api/auth/oidc/mock/callback",
+			Scopes:       []string{"openid", "email", "profile"},
+			DefaultLevel: "user",
+			GroupsClaim:  "groups",
+		}},
+		clients: make(map[string]*oidcClient),
+	}
+	return service, userRepo, stateRepo
+}
+
+// startOIDCLogin 取得授權網址並跟隨身分提供者的跳轉，回傳回呼收到的 state 與授權碼
+func startOIDCLogin(t *testing.T, service *OIDCService) (authURL *url.URL, state, code string) {
+	t.Helper()
+	rawURL, _, err := service.AuthorizationURL(context.Background(), "mock")
+	if err != nil {
+		t.Fatalf("AuthorizationURL: %v", err)
+	}
+	authURL, err = url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d", response.StatusCode)
+	}
+	callback, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authURL, callback.Query().Get("state"), callback.Query().Get("code")
+}
+
+func TestOIDCAuthorizationURLUsesStatePKCEAndNonce(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	service, _, stateRepo := newTestOIDCService(provider)
+
+	authURL, state, _ := startOIDCLogin(t, service)
+	query := authURL.Query()
+	if query.Get("state") == "" || query.Get("nonce") == "" {
+		t.Fatalf("authorization URL is missing state or nonce: %s", authURL)
+	}
+	if query.Get("code_challenge_method") != "S256" || len(query.Get("code_challenge")) != 43 {
+		t.Fatalf("authorization URL does not use an S256 PKCE challenge: %s", authURL)
+	}
+
+	// 只儲存 state 的雜湊值，verifier 與 nonce 不會出現在網址中
+	stored, ok := stateRepo.states[hashToken(state)]
+	if !ok {
+		t.Fatal("state was not stored by hash")
+	}
+	if _, ok := stateRepo.states[state]; ok {
+		t.Fatal("raw state must not be stored")
+	}
+	digest := sha256.Sum256([]byte(stored.CodeVerifier))
+	if query.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(digest[:]) {
+		t.Fatal("code_challenge does not match the stored verifier")
+	}
+	if stored.Nonce != query.Get("nonce") || stored.Provider != "mock" {
+		t.Fatalf("stored state = %+v", stored)
+	}
+}
+
+func TestOIDCAuthenticateLinksVerifiedEmail(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	service, userRepo, _ := newTestOIDCService(provider)
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+
+	_, state, code := startOIDCLogin(t, service)
+	user, err := service.Authenticate(context.Background(), "mock", state, state, code)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.Username != "alice" {
+		t.Fatalf("user = %s, want alice", user.Username)
+	}
+
+	// 第二次登入以外部身分對應，即使電子郵件已變更
+	provider.claims["email"] = "alice@new.example.com"
+	_, state, code = startOIDCLogin(t, service)
+	user, err = service.Authenticate(context.Background(), "mock", state, state, code)
+	if err != nil {
+		t.Fatalf("second Authenticate: %v", err)
+	}
+	if user.Username != "alice" {
+		t.Fatalf("user = %s, want alice", user.Username)
+	}
+}
+
+func TestOIDCAuthenticateRejectsInvalidState(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	service, userRepo, stateRepo := newTestOIDCService(provider)
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+
+	_, state, code := startOIDCLogin(t, service)
+	if _, err := service.Authenticate(context.Background(), "mock", "forged-state", "forged-state", code); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("unknown state: err = %v, want ErrOIDCInvalidState", err)
+	}
+	if _, err := service.Authenticate(context.Background(), "mock", state, state, code); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	// state 只能使用一次
+	if _, err := service.Authenticate(context.Background(), "mock", state, state, code); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("replayed state: err = %v, want ErrOIDCInvalidState", err)
+	}
+
+	// 其他提供者的回呼不可使用此 state
+	_, state, code = startOIDCLogin(t, service)
+	if _, err := service.Authenticate(context.Background(), "other", state, state, code); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("state for another provider: err = %v, want ErrOIDCInvalidState", err)
+	}
+
+	// 過期的 state
+	_, state, code = startOIDCLogin(t, service)
+	stateRepo.states[hashToken(state)].ExpiresAt = time.Now().Add(-time.Second)
+	if _, err := service.Authenticate(context.Background(), "mock", state, state, code); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("expired state: err = %v, want ErrOIDCInvalidState", err)
+	}
+}
+
+func TestOIDCAuthenticateRejectsWrongCodeVerifier(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	service, userRepo, stateRepo := newTestOIDCService(provider)
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+
+	// 攔截授權碼的攻擊者沒有 verifier，無法交換 token
+	_, state, code := startOIDCLogin(t, service)
+	stateRepo.states[hashToken(state)].CodeVerifier = "attacker-verifier-attacker-verifier-attacker"
+	if _, err := service.Authenticate(context.Background(), "mock", state, state, code); !errors.Is(err, ErrOIDCAuthFailed) {
+		t.Fatalf("err = %v, want ErrOIDCAuthFailed", err)
+	}
+}
+
+func TestOIDCAuthenticateRejectsNonceMismatch(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	service, userRepo, _ := newTestOIDCService(provider)
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+
+	// 重放其他授權流程的 ID token
+	replayed := "nonce-from-another-login"
+	provider.nonce = &replayed
+	_, state, code := startOIDCLogin(t, service)
+	if _, err := service.Authenticate(context.Background(), "mock", state, state, code); !errors.Is(err, ErrOIDCAuthFailed) {
+		t.Fatalf("err = %v, want ErrOIDCAuthFailed", err)
+	}
+}
+
+func TestOIDCAuthenticateRequiresVerifiedEmail(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	service, userRepo, _ := newTestOIDCService(provider)
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+
+	// 未驗證的電子郵件不可對應到既有帳號
+	provider.claims["email_verified"] = false
+	_, state, code := startOIDCLogin(t, service)
+	if _, err := service.Authenticate(context.Background(), "mock", state, state, code); !errors.Is(err, ErrOIDCEmailRequired) {
+		t.Fatalf("err = %v, want ErrOIDCEmailRequired", err)
+	}
+}
+
+func TestOIDCAuthenticateRejectsServiceAccounts(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	service, userRepo, _ := newTestOIDCService(provider)
+	userRepo.Create(&models.User{Username: "ci-bot", Email: "alice@example.com", Level: "user", ServiceAccount: true})
+
+	// 已驗證的電子郵件對應到服務帳號
+	_, state, code := startOIDCLogin(t, service)
+	if _, err := service.Authenticate(context.Background(), "mock", state, state, code); !errors.Is(err, ErrOIDCServiceAccount) {
+		t.Fatalf("email of a service account: err = %v, want ErrOIDCServiceAccount", err)
+	}
+	identityRepo := service.identityRepo.(*fakeIdentityRepo)
+	if len(identityRepo.identities) != 0 {
+		t.Fatalf("service account was linked to %d identities", len(identityRepo.identities))
+	}
+
+	// 先前已連結的帳號之後改為服務帳號
+	identityRepo.Create(&models.UserIdentity{UserID: 1, Provider: "mock", Subject: "alice-subject"})
+	_, state, code = startOIDCLogin(t, service)
+	if _, err := service.Authenticate(context.Background(), "mock", state, state, code); !errors.Is(err, ErrOIDCServiceAccount) {
+		t.Fatalf("linked service account: err = %v, want ErrOIDCServiceAccount", err)
+	}
+}
+
+func TestOIDCAuthenticateRequiresStateFromSameBrowser(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	service, userRepo, _ := newTestOIDCService(provider)
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+
+	// 攻擊者完成自己的登入後，把回呼網址交給沒有 state cookie 或正在進行其他登入的受害者
+	_, attackerState, attackerCode := startOIDCLogin(t, service)
+	_, victimState, _ := startOIDCLogin(t, service)
+	if _, err := service.Authenticate(context.Background(), "mock", attackerState, "", attackerCode); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("without browser state: err = %v, want ErrOIDCInvalidState", err)
+	}
+	if _, err := service.Authenticate(context.Background(), "mock", attackerState, victimState, attackerCode); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Fatalf("another login's browser state: err = %v, want ErrOIDCInvalidState", err)
+	}
+
+	// 發起登入的瀏覽器仍可完成登入
+	if _, err := service.Authenticate(context.Background(), "mock", attackerState, attackerState, attackerCode); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+}
+
+func TestOIDCLoginCodeIsSingleUse(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	service, userRepo, _ := newTestOIDCService(provider)
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+	user, _ := userRepo.GetByUsername("alice")
+
+	code, err := service.IssueLoginCode(user, "mock")
+	if err != nil {
+		t.Fatalf("IssueLoginCode: %v", err)
+	}
+	if _, ok := service.codeRepo.(*fakeOIDCCodeRepo).codes[code]; ok {
+		t.Fatal("raw exchange code must not be stored")
+	}
+
+	// 交換碼放在 fragment，不會送到前端伺服器
+	redirect, err := url.Parse(service.FrontendURL(url.Values{"code": {code}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect.RawQuery != "" || redirect.Fragment != "code="+code {
+		t.Fatalf("frontend redirect = %s", redirect)
+	}
+
+	exchanged, err := service.ExchangeLoginCode(code)
+	if err != nil {
+		t.Fatalf("ExchangeLoginCode: %v", err)
+	}
+	if exchanged.ID != user.ID {
+		t.Fatalf("user = %d, want %d", exchanged.ID, user.ID)
+	}
+	if _, err := service.ExchangeLoginCode(code); !errors.Is(err, ErrOIDCInvalidLoginCode) {
+		t.Fatalf("reused code: err = %v, want ErrOIDCInvalidLoginCode", err)
+	}
+
+	// 過期的交換碼
+	code, err = service.IssueLoginCode(user, "mock")
+	if err != nil {
+		t.Fatalf("IssueLoginCode: %v", err)
+	}
+	service.codeRepo.(*fakeOIDCCodeRepo).codes[hashToken(code)].ExpiresAt = time.Now().Add(-time.Second)
+	if _, err := service.ExchangeLoginCode(code); !errors.Is(err, ErrOIDCInvalidLoginCode) {
+		t.Fatalf("expired code: err = %v, want ErrOIDCInvalidLoginCode", err)
+	}
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	_ "embed"
+	"erp/db"
+	"erp/models"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 密碼政策的預設值，可由環境變數覆寫
+const (
+	defaultPasswordMinLength   = 8                   // PASSWORD_MIN_LENGTH
+	defaultPasswordClasses     = "upper,lower,digit" // PASSWORD_REQUIRED_CLASSES: upper, lower, digit, symbol
+	defaultPasswordHistorySize = 5                   // PASSWORD_HISTORY_SIZE: 不可重複使用最近幾組密碼
+	minUserInfoSubstringLength = 3                   // 使用者名稱或信箱片段至少幾個字元才檢查
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords 內建的常見弱密碼清單 (小寫)
+var commonPasswords = parseCommonPasswords(commonPasswordsFile)
+
+// PasswordViolation 單一密碼規則的違反項目
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError 密碼不符合政策，包含所有違反的規則
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+// Error 實作 error 介面
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return "密碼不符合安全政策: " + strings.Join(messages, "；")
+}
+
+// PasswordPolicy 密碼政策設定
+type PasswordPolicy struct {
+	MinLength       int           `json:"min_length"`
+	RequireUpper    bool          `json:"require_upper"`
+	RequireLower    bool          `json:"require_lower"`
+	RequireDigit    bool          `json:"require_digit"`
+	RequireSymbol   bool          `json:"require_symbol"`
+	HistorySize     int           `json:"history_size"`
+	MaxAge          time.Duration `json:"-"`
+	MaxAgeDays      int           `json:"max_age_days"` // 0 代表不強制定期變更
+	DisallowCommon  bool          `json:"disallow_common"`
+	DisallowUserRef bool          `json:"disallow_user_info"`
+}
+
+// PasswordPolicyService 密碼政策服務，負責驗證、雜湊與密碼歷史
+type PasswordPolicyService struct {
+	historyRepo db.PasswordHistoryRepository
+	policy      PasswordPolicy
+}
+
+// NewPasswordPolicyService 建立密碼政策服務實例
+// PASSWORD_MAX_AGE 設定密碼有效期限 (例如 "2160h")，未設定則不強制定期變更
+func NewPasswordPolicyService(historyRepo db.PasswordHistoryRepository) *PasswordPolicyService {
+	classes := os.Getenv("PASSWORD_REQUIRED_CLASSES")
+	if classes == "" {
+		classes = defaultPasswordClasses
+	}
+	required := make(map[string]bool)
+	for _, class := range strings.Split(classes, ",") {
+		required[strings.TrimSpace(class)] = true
+	}
+
+	// PASSWORD_HISTORY_SIZE=0 代表不檢查密碼歷史
+	historySize := intFromEnv("PASSWORD_HISTORY_SIZE", defaultPasswordHistorySize)
+	if os.Getenv("PASSWORD_HISTORY_SIZE") == "0" {
+		historySize = 0
+	}
+
+	maxAge := durationFromEnv("PASSWORD_MAX_AGE", 0)
+
+	return &PasswordPolicyService{
+		historyRepo: historyRepo,
+		policy: PasswordPolicy{
+			MinLength:       intFromEnv("PASSWORD_MIN_LENGTH", defaultPasswordMinLength),
+			RequireUpper:    required["upper"],
+			RequireLower:    required["lower"],
+			RequireDigit:    required["digit"],
+			RequireSymbol:   required["symbol"],
+			HistorySize:     historySize,
+			MaxAge:          maxAge,
+			MaxAgeDays:      int(maxAge.Hours() / 24),
+			DisallowCommon:  true,
+			DisallowUserRef: true,
+		},
+	}
+}
+
+// Policy 取得目前的密碼政策設定 (供前端顯示規則)
+func (s *PasswordPolicyService) Policy() PasswordPolicy {
+	return s.policy
+}
+
+// Validate 檢查密碼是否符合政策，回傳所有違反的規則
+func (s *PasswordPolicyService) Validate(password, username, email string) []PasswordViolation {
+	var violations []PasswordViolation
+	add := func(rule, message string) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: message})
+	}
+
+	if len([]rune(password)) < s.policy.MinLength {
+		add("min_length", fmt.Sprintf("密碼長度至少需要 %d 個字元", s.policy.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if s.policy.RequireUpper && !hasUpper {
+		add("require_upper", "密碼需包含至少一個大寫英文字母")
+	}
+	if s.policy.RequireLower && !hasLower {
+		add("require_lower", "密碼需包含至少一個小寫英文字母")
+	}
+	if s.policy.RequireDigit && !hasDigit {
+		add("require_digit", "密碼需包含至少一個數字")
+	}
+	if s.policy.RequireSymbol && !hasSymbol {
+		add("require_symbol", "密碼需包含至少一個特殊符號")
+	}
+
+	lowered := strings.ToLower(password)
+	if s.policy.DisallowUserRef {
+		if containsUserInfo(lowered, strings.ToLower(username)) {
+			add("contains_username", "密碼不可包含使用者名稱")
+		}
+		localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+		if containsUserInfo(lowered, localPart) {
+			add("contains_email", "密碼不可包含電子郵件帳號")
+		}
+	}
+
+	if s.policy.DisallowCommon && commonPasswords[lowered] {
+		add("common_password", "密碼過於常見，請使用其他密碼")
+	}
+
+	return violations
+}
+
+// SetPassword 驗證新密碼並寫入使用者的雜湊密碼 (尚未儲存)
+// 會檢查是否與目前密碼或最近使用過的密碼相同；儲存使用者後需呼叫 RecordHistory
+func (s *PasswordPolicyService) SetPassword(user *models.User, password string) error {
+	if violations := s.Validate(password, user.Username, user.Email); len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+
+	reused, err := s.isReused(user, password)
+	if err != nil {
+		return err
+	}
+	if reused {
+		return &PasswordPolicyError{Violations: []PasswordViolation{{
+			Rule:    "password_history",
+			Message: fmt.Sprintf("不可重複使用最近 %d 次使用過的密碼", s.policy.HistorySize),
+		}}}
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user.Password = string(hashedPassword)
+	user.PasswordChangedAt = &now
+	return nil
+}
+
+// RecordHistory 將使用者目前的密碼雜湊加入歷史紀錄，並只保留政策要求的筆數
+func (s *PasswordPolicyService) RecordHistory(user *models.User) error {
+	if s.policy.HistorySize <= 0 {
+		return nil
+	}
+	err := s.historyRepo.Create(&models.PasswordHistory{
+		UserID:       user.ID,
+		PasswordHash: user.Password,
+	})
+	if err != nil {
+		return err
+	}
+	return s.historyRepo.PruneByUserID(user.ID, s.policy.HistorySize)
+}
+
+// IsExpired 檢查使用者密碼是否已超過有效期限
+func (s *PasswordPolicyService) IsExpired(user *models.User, now time.Time) bool {
+	if s.policy.MaxAge <= 0 {
+		return false
+	}
+	changedAt := user.CreatedAt
+	if user.PasswordChangedAt != nil {
+		changedAt = *user.PasswordChangedAt
+	}
+	return now.Sub(changedAt) > s.policy.MaxAge
+}
+
+// isReused 檢查新密碼是否與目前密碼或歷史密碼相同
+func (s *PasswordPolicyService) isReused(user *models.User, password string) (bool, error) {
+	if s.policy.HistorySize <= 0 || user.ID == 0 {
+		return false, nil
+	}
+
+	if user.Password != "" && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil {
+		return true, nil
+	}
+
+	history, err := s.historyRepo.GetRecentByUserID(user.ID, s.policy.HistorySize)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range history {
+		if bcrypt.CompareHashAndPassword([]byte(entry.PasswordHash), []byte(password)) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// containsUserInfo 檢查密碼是否包含使用者資訊 (過短的片段不檢查以避免誤判)
+func containsUserInfo(password, info string) bool {
+	info = strings.TrimSpace(info)
+	return len(info) >= minUserInfoSubstringLength && strings.Contains(password, info)
+}
+
+// parseCommonPasswords 解析內建弱密碼清單，忽略空行與 # 開頭的註解
+func parseCommonPasswords(content string) map[string]bool {
+	passwords := make(map[string]bool)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = true
+	}
+	return passwords
+}
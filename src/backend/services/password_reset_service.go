@@ -0,0 +1,165 @@
+package services
+
+import (
+	"erp/db"
+	"erp/mail"
+	"erp/models"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidResetToken 密碼重設令牌不存在、已使用或已過期
+var ErrInvalidResetToken = errors.New("無效或已過期的密碼重設令牌")
+
+// 密碼重設令牌的預設有效期限，可由環境變數 PASSWORD_RESET_TTL 覆寫
+const defaultPasswordResetTTL = 30 * time.Minute
+
+// PasswordResetService 密碼重設服務
+type PasswordResetService struct {
+	userRepo     db.UserRepository
+	resetRepo    db.PasswordResetTokenRepository
+	tokenService *TokenService
+	policy       *PasswordPolicyService
+	sender       mail.Sender
+	ttl          time.Duration
+	resetURL     string
+}
+
+// NewPasswordResetService 建立密碼重設服務實例
+func NewPasswordResetService(userRepo db.UserRepository, resetRepo db.PasswordResetTokenRepository, tokenService *TokenService, policy *PasswordPolicyService, sender mail.Sender) *PasswordResetService {
+	return &PasswordResetService{
+		userRepo:     userRepo,
+		resetRepo:    resetRepo,
+		tokenService: tokenService,
+		policy:       policy,
+		sender:       sender,
+		ttl:          durationFromEnv("PASSWORD_RESET_TTL", defaultPasswordResetTTL),
+		resetURL:     os.Getenv("PASSWORD_RESET_URL"),
+	}
+}
+
+// RequestReset 為電子郵件對應的使用者產生重設令牌並寄出重設連結
+// 查無使用者時直接回傳 nil，避免洩漏帳號是否存在；尚未接受邀請的使用者須使用邀請連結
+func (s *PasswordResetService) RequestReset(email, clientIP string) error {
+	user, err := s.userRepo.GetByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.IsEmailVerified() {
+		return nil
+	}
+
+	rawToken, err := s.IssueResetToken(user, clientIP)
+	if err != nil {
+		return err
+	}
+
+	return s.sender.Send(mail.Message{
+		To:      user.Email,
+		Subject: "重設您的密碼",
+		Body: fmt.Sprintf("%s 您好：\n\n我們收到了重設密碼的申請，請在 %d 分鐘內開啟以下連結設定新密碼：\n\n%s\n\n若您沒有提出申請，請忽略此郵件，您的密碼不會變更。\n",
+			user.Username, int(s.ttl.Minutes()), s.resetLink(rawToken)),
+	})
+}
+
+// IssueResetToken 為使用者產生新的重設令牌並回傳明文，舊令牌全部失效
+// 也用於登入時密碼已過期、需強制變更密碼的情況
+func (s *PasswordResetService) IssueResetToken(user *models.User, clientIP string) (string, error) {
+	// 同一時間只保留最新的一組重設令牌
+	now := time.Now()
+	if err := s.resetRepo.InvalidateByUserID(user.ID, now); err != nil {
+		return "", err
+	}
+
+	rawToken, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = s.resetRepo.Create(&models.PasswordResetToken{
+		UserID:      user.ID,
+		TokenHash:   hashToken(rawToken),
+		ExpiresAt:   now.Add(s.ttl),
+		RequestedIP: clientIP,
+	})
+	if err != nil {
+		return "", err
+	}
+	return rawToken, nil
+}
+
+// TTL 取得重設令牌的有效期限
+func (s *PasswordResetService) TTL() time.Duration {
+	return s.ttl
+}
+
+// ResetPassword 驗證重設令牌並設定新密碼，成功後使用者所有既有令牌失效
+func (s *PasswordResetService) ResetPassword(rawToken, newPassword string) error {
+	stored, err := s.resetRepo.GetByHash(hashToken(rawToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if stored.UsedAt != nil || now.After(stored.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	user, err := s.userRepo.GetByID(stored.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	// 新密碼不符合政策時不消耗令牌，讓使用者可以重新輸入
+	if err := s.policy.SetPassword(user, newPassword); err != nil {
+		return err
+	}
+
+	// 條件更新確保令牌只能使用一次
+	marked, err := s.resetRepo.MarkUsed(stored.ID, now)
+	if err != nil {
+		return err
+	}
+	if !marked {
+		return ErrInvalidResetToken
+	}
+
+	if err := s.userRepo.Update(user, "password", "password_changed_at"); err != nil {
+		return err
+	}
+	if err := s.policy.RecordHistory(user); err != nil {
+		return err
+	}
+
+	return s.tokenService.InvalidateUserTokens(user.ID)
+}
+
+// resetLink 組合前端的密碼重設連結
+func (s *PasswordResetService) resetLink(rawToken string) string {
+	base := s.resetURL
+	if base == "" {
+		base = "http://localhost:3000/reset-password"
+	}
+
+	link, err := url.Parse(base)
+	if err != nil {
+		return base + "?token=" + url.QueryEscape(rawToken)
+	}
+	query := link.Query()
+	query.Set("token", rawToken)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"erp/db"
+	"erp/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PermissionDefinition 路由宣告的權限定義
+type PermissionDefinition struct {
+	ModuleName  string
+	Resource    string
+	Action      string
+	Code        string
+	DisplayName string
+	Description string
+}
+
+// PermissionSyncResult 權限同步結果
+type PermissionSyncResult struct {
+	Created     []string // 新建立的權限代碼
+	Updated     []string // 已存在並標記為自動註冊的權限代碼
+	Reactivated []string // 由 stale 恢復為 active 的權限代碼
+	Stale       []string // 不再出現在任何路由中的權限代碼
+}
+
+// 路由宣告的權限註冊表
+var (
+	registryMu         sync.RWMutex
+	permissionRegistry = make(map[string]PermissionDefinition)
+)
+
+// ParsePermissionCode 解析權限代碼 (module.resource.action)
+func ParsePermissionCode(code string) (module, resource, action string, err error) {
+	parts := strings.Split(code, ".")
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("權限代碼格式錯誤，應為 module.resource.action: %q", code)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return "", "", "", fmt.Errorf("權限代碼格式錯誤，應為 module.resource.action: %q", code)
+		}
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// RegisterPermission 在路由註冊時宣告權限代碼，格式錯誤時回傳錯誤
+func RegisterPermission(code string) error {
+	module, resource, action, err := ParsePermissionCode(code)
+	if err != nil {
+		return err
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := permissionRegistry[code]; exists {
+		return nil
+	}
+	permissionRegistry[code] = PermissionDefinition{
+		ModuleName:  module,
+		Resource:    resource,
+		Action:      action,
+		Code:        code,
+		DisplayName: code, // 預設以代碼作為顯示名稱，可於後台修改
+	}
+	return nil
+}
+
+// RegisteredPermissions 取得所有已宣告的權限定義（依代碼排序）
+func RegisteredPermissions() []PermissionDefinition {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	definitions := make([]PermissionDefinition, 0, len(permissionRegistry))
+	for _, definition := range permissionRegistry {
+		definitions = append(definitions, definition)
+	}
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Code < definitions[j].Code
+	})
+	return definitions
+}
+
+// SyncRegisteredPermissions 將路由宣告的權限同步到 permissions 資料表
+// 1. 新代碼建立為 auto_registered 權限
+// 2. 既有代碼標記為 auto_registered 並更新 registered_at，保留人工編輯的顯示名稱、描述與狀態 (stale 恢復為 active)
+// 3. 曾經自動註冊但已不在任何路由中的代碼標記為 stale
+func SyncRegisteredPermissions(permissionRepo db.PermissionRepository) (*PermissionSyncResult, error) {
+	definitions := RegisteredPermissions()
+	now := time.Now()
+	result := &PermissionSyncResult{}
+
+	declared := make(map[string]bool, len(definitions))
+	for _, definition := range definitions {
+		declared[definition.Code] = true
+
+		permission, err := permissionRepo.GetByCode(definition.Code)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			permission = &models.Permission{
+				ModuleName:     definition.ModuleName,
+				Resource:       definition.Resource,
+				Action:         definition.Action,
+				Code:           definition.Code,
+				DisplayName:    definition.DisplayName,
+				Description:    definition.Description,
+				Status:         "active",
+				AutoRegistered: true,
+				RegisteredAt:   &now,
+			}
+			if err := permissionRepo.Create(permission); err != nil {
+				return nil, fmt.Errorf("無法建立權限 %s: %v", definition.Code, err)
+			}
+			result.Created = append(result.Created, definition.Code)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("無法查詢權限 %s: %v", definition.Code, err)
+		}
+
+		// 只恢復因路由移除而標記為 stale 的權限，管理員手動停用的狀態保持不變
+		if permission.Status == "stale" {
+			permission.Status = "active"
+			result.Reactivated = append(result.Reactivated, definition.Code)
+		} else {
+			result.Updated = append(result.Updated, definition.Code)
+		}
+		permission.AutoRegistered = true
+		permission.RegisteredAt = &now
+		if err := permissionRepo.Update(permission); err != nil {
+			return nil, fmt.Errorf("無法更新權限 %s: %v", definition.Code, err)
+		}
+	}
+
+	// 標記不再被任何路由宣告的自動註冊權限
+	permissions, err := permissionRepo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("無法獲取權限列表: %v", err)
+	}
+	for _, permission := range permissions {
+		// registered_at 為空代表從未經由路由註冊（例如 seed 建立），不視為 stale
+		if declared[permission.Code] || !permission.AutoRegistered || permission.RegisteredAt == nil {
+			continue
+		}
+		if permission.Status == "stale" {
+			result.Stale = append(result.Stale, permission.Code)
+			continue
+		}
+
+		permission.Status = "stale"
+		if err := permissionRepo.Update(&permission); err != nil {
+			return nil, fmt.Errorf("無法標記權限 %s 為 stale: %v", permission.Code, err)
+		}
+		result.Stale = append(result.Stale, permission.Code)
+	}
+
+	return result, nil
+}
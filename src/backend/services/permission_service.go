@@ -1,23 +1,42 @@
 package services
 
 import (
+	"erp/db"
 	"erp/models"
+	"errors"
 	"strings"
 )
 
+// ErrRoleAlreadyAssigned 角色已分配給使用者
+var ErrRoleAlreadyAssigned = errors.New("角色已分配給該使用者")
+
+// ErrPermissionAlreadyAssigned 權限已分配給角色
+var ErrPermissionAlreadyAssigned = errors.New("權限已分配給該角色")
+
 // PermissionService 權限服務
 type PermissionService struct {
+	userRepo           db.UserRepository
+	userRoleRepo       db.UserRoleRepository
+	rolePermissionRepo db.RolePermissionRepository
 }
 
 // NewPermissionService 建立權限服務實例
-func NewPermissionService() *PermissionService {
-	return &PermissionService{}
+func NewPermissionService(userRepo db.UserRepository, userRoleRepo db.UserRoleRepository, rolePermissionRepo db.RolePermissionRepository) *PermissionService {
+	return &PermissionService{
+		userRepo:           userRepo,
+		userRoleRepo:       userRoleRepo,
+		rolePermissionRepo: rolePermissionRepo,
+	}
 }
 
-// HasPermission 檢查使用者是否擁有特定權限
-func (s *PermissionService) HasPermission(userID uint, permissionCode string) bool {
+// HasPermission 檢查使用者從指定來源 IP 是否擁有特定權限
+// 角色設有來源網路限制且 clientIP 不在範圍內時，該角色的權限不計入
+func (s *PermissionService) HasPermission(userID uint, permissionCode, clientIP string) bool {
 	// 1. 獲取使用者等級
 	userLevel := s.getUserLevel(userID)
+	if userLevel == "" {
+		return false
+	}
 
 	// 2. 超級管理員檢查：擁有所有權限
 	if userLevel == "super_admin" {
@@ -26,7 +45,7 @@ func (s *PermissionService) HasPermission(userID uint, permissionCode string) bo
 
 	// 3. 管理員權限檢查：可以訪問分配給他的模組權限
 	if userLevel == "admin" {
-		userModules := s.getUserAssignedModules(userID)
+		userModules := s.getUserAssignedModules(userID, clientIP)
 		for _, module := range userModules {
 			if strings.HasPrefix(permissionCode, module+".") {
 				return true
@@ -35,64 +54,187 @@ func (s *PermissionService) HasPermission(userID uint, permissionCode string) bo
 	}
 
 	// 4. 一般使用者權限檢查：根據其所屬角色的權限進行判斷
-	return s.checkUserRolePermissions(userID, permissionCode)
+	return s.checkUserRolePermissions(userID, permissionCode, clientIP)
 }
 
-// getUserLevel 獲取使用者等級
+// getUserLevel 獲取使用者等級，查無使用者時回傳空字串
 func (s *PermissionService) getUserLevel(userID uint) string {
-	// TODO: 實作從資料庫獲取使用者等級的邏輯
-	// 這裡應該查詢 users 表中的 level 欄位
-	return "user" // 臨時返回值
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return ""
+	}
+	return user.Level
 }
 
 // getUserAssignedModules 獲取管理員被分配的模組
-func (s *PermissionService) getUserAssignedModules(userID uint) []string {
-	// TODO: 實作從資料庫獲取使用者分配模組的邏輯
-	// 這裡應該查詢使用者的角色，然後獲取角色對應的權限模組
-	return []string{"hr", "finance"} // 臨時返回值
+// 模組由使用者所屬角色擁有的權限推導而來
+func (s *PermissionService) getUserAssignedModules(userID uint, clientIP string) []string {
+	permissions, err := s.GetUserPermissions(userID, clientIP)
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var modules []string
+	for _, permission := range permissions {
+		if permission.ModuleName == "" || seen[permission.ModuleName] {
+			continue
+		}
+		seen[permission.ModuleName] = true
+		modules = append(modules, permission.ModuleName)
+	}
+	return modules
 }
 
 // checkUserRolePermissions 檢查使用者角色權限
-func (s *PermissionService) checkUserRolePermissions(userID uint, permissionCode string) bool {
-	// TODO: 實作檢查使用者角色權限的邏輯
-	// 1. 查詢使用者的所有角色
-	// 2. 查詢每個角色的所有權限
-	// 3. 檢查權限代碼是否匹配
-	return false // 臨時返回值
+func (s *PermissionService) checkUserRolePermissions(userID uint, permissionCode, clientIP string) bool {
+	permissions, err := s.GetUserPermissions(userID, clientIP)
+	if err != nil {
+		return false
+	}
+
+	for _, permission := range permissions {
+		if permission.Code == permissionCode {
+			return true
+		}
+	}
+	return false
 }
 
-// GetUserPermissions 獲取使用者的所有權限
-func (s *PermissionService) GetUserPermissions(userID uint) ([]models.Permission, error) {
-	// TODO: 實作獲取使用者權限列表的邏輯
-	return []models.Permission{}, nil
+// GetUserPermissions 獲取使用者從指定來源 IP 可使用的所有角色權限
+// 僅計入狀態為 active 且來源網路符合限制的角色，以及 active 的權限，結果依權限 ID 去重
+func (s *PermissionService) GetUserPermissions(userID uint, clientIP string) ([]models.Permission, error) {
+	roles, err := s.GetAvailableRoles(userID, clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint]bool)
+	permissions := []models.Permission{}
+	for _, role := range roles {
+		rolePermissions, err := s.rolePermissionRepo.GetPermissionsByRoleID(role.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, permission := range rolePermissions {
+			if seen[permission.ID] || !isActive(permission.Status) {
+				continue
+			}
+			seen[permission.ID] = true
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions, nil
+}
+
+// GetEffectivePermissions 取得使用者從指定來源 IP 實際可使用的權限 (與 HasPermission 的判斷一致)
+// 超級管理員擁有全部權限，管理員擁有所屬模組的全部權限，其餘依角色；all 為系統中所有權限
+func (s *PermissionService) GetEffectivePermissions(userID uint, clientIP string, all []models.Permission) ([]models.Permission, error) {
+	userLevel := s.getUserLevel(userID)
+	if userLevel == "" {
+		return []models.Permission{}, nil
+	}
+
+	rolePermissions, err := s.GetUserPermissions(userID, clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	granted := make(map[string]bool)
+	modules := make(map[string]bool)
+	for _, permission := range rolePermissions {
+		granted[permission.Code] = true
+		if userLevel == "admin" && permission.ModuleName != "" {
+			modules[permission.ModuleName] = true
+		}
+	}
+
+	permissions := []models.Permission{}
+	for _, permission := range all {
+		if !isActive(permission.Status) {
+			continue
+		}
+		allowed := userLevel == "super_admin" || granted[permission.Code]
+		for module := range modules {
+			if allowed {
+				break
+			}
+			allowed = strings.HasPrefix(permission.Code, module+".")
+		}
+		if allowed {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions, nil
 }
 
-// GetUserRoles 獲取使用者的所有角色
+// GetUserRoles 獲取使用者的所有角色（僅包含 active 角色）
 func (s *PermissionService) GetUserRoles(userID uint) ([]models.Role, error) {
-	// TODO: 實作獲取使用者角色列表的邏輯
-	return []models.Role{}, nil
+	roles, err := s.userRoleRepo.GetRolesByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	activeRoles := []models.Role{}
+	for _, role := range roles {
+		if isActive(role.Status) {
+			activeRoles = append(activeRoles, role)
+		}
+	}
+	return activeRoles, nil
+}
+
+// GetAvailableRoles 獲取使用者從指定來源 IP 可使用的角色 (active 且來源網路符合角色限制)
+func (s *PermissionService) GetAvailableRoles(userID uint, clientIP string) ([]models.Role, error) {
+	roles, err := s.GetUserRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	available := []models.Role{}
+	for _, role := range roles {
+		if role.AllowedNetworks.Allows(clientIP) {
+			available = append(available, role)
+		}
+	}
+	return available, nil
 }
 
 // AssignRoleToUser 為使用者分配角色
 func (s *PermissionService) AssignRoleToUser(userID, roleID uint) error {
-	// TODO: 實作為使用者分配角色的邏輯
-	return nil
+	exists, err := s.userRoleRepo.Exists(userID, roleID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrRoleAlreadyAssigned
+	}
+	return s.userRoleRepo.Create(&models.UserRole{UserID: userID, RoleID: roleID})
 }
 
 // RemoveRoleFromUser 從使用者移除角色
 func (s *PermissionService) RemoveRoleFromUser(userID, roleID uint) error {
-	// TODO: 實作從使用者移除角色的邏輯
-	return nil
+	return s.userRoleRepo.Delete(userID, roleID)
 }
 
 // AssignPermissionToRole 為角色分配權限
 func (s *PermissionService) AssignPermissionToRole(roleID, permissionID uint) error {
-	// TODO: 實作為角色分配權限的邏輯
-	return nil
+	exists, err := s.rolePermissionRepo.Exists(roleID, permissionID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrPermissionAlreadyAssigned
+	}
+	return s.rolePermissionRepo.Create(&models.RolePermission{RoleID: roleID, PermissionID: permissionID})
 }
 
 // RemovePermissionFromRole 從角色移除權限
 func (s *PermissionService) RemovePermissionFromRole(roleID, permissionID uint) error {
-	// TODO: 實作從角色移除權限的邏輯
-	return nil
+	return s.rolePermissionRepo.Delete(roleID, permissionID)
+}
+
+// isActive 判斷狀態欄位是否為啟用（空值視為預設的 active）
+func isActive(status string) bool {
+	return status == "" || status == "active"
 }
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"erp/db"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// unusablePasswordHash 外部目錄自動建立的帳號使用隨機密碼雜湊，無法以本地密碼登入
+func unusablePasswordHash() (string, error) {
+	randomPassword, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// syncMappedRoles 依外部目錄 (OIDC、LDAP) 的群組同步使用者角色
+// 只管理對應表中出現的角色，管理員手動指派的其他角色不受影響
+func syncMappedRoles(roleRepo db.RoleRepository, permissionService *PermissionService, userID uint, groupRoles map[string][]string, groups []string) error {
+	if len(groupRoles) == 0 {
+		return nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, group := range groups {
+		for _, roleName := range groupRoles[group] {
+			wanted[roleName] = true
+		}
+	}
+
+	managed := make(map[string]bool)
+	for _, roleNames := range groupRoles {
+		for _, roleName := range roleNames {
+			managed[roleName] = true
+		}
+	}
+
+	for roleName := range managed {
+		role, err := roleRepo.GetByName(roleName)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			fmt.Fprintf(os.Stderr, "群組對應的角色不存在: %s\n", roleName)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if wanted[roleName] {
+			err = permissionService.AssignRoleToUser(userID, role.ID)
+			if errors.Is(err, ErrRoleAlreadyAssigned) {
+				err = nil
+			}
+		} else {
+			err = permissionService.RemoveRoleFromUser(userID, role.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
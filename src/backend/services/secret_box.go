@@ -0,0 +1,69 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// secretKey 取得加密敏感資料 (例如 TOTP 金鑰) 的金鑰
+// 優先使用 DATA_ENCRYPTION_KEY，未設定時退回 JWT_SECRET
+func secretKey() []byte {
+	source := os.Getenv("DATA_ENCRYPTION_KEY")
+	if source == "" {
+		source = os.Getenv("JWT_SECRET")
+	}
+	sum := sha256.Sum256([]byte(source))
+	return sum[:]
+}
+
+// encryptSecret 以 AES-GCM 加密字串，輸出 base64 (nonce 置於密文前)
+func encryptSecret(plaintext string) (string, error) {
+	block, err := aes.NewCipher(secretKey())
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("無法產生加密 nonce: %v", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret 解密 encryptSecret 產生的字串
+func decryptSecret(ciphertext string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(secretKey())
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("密文長度錯誤")
+	}
+
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("無法解密: %v", err)
+	}
+	return string(plaintext), nil
+}
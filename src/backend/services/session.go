@@ -0,0 +1,156 @@
+package services
+
+import (
+	"erp/models"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// ErrSessionNotFound 工作階段不存在、已結束或不屬於該使用者
+var ErrSessionNotFound = errors.New("找不到工作階段")
+
+// 工作階段結束原因
+const (
+	SessionEndedLogout      = "logout"
+	SessionEndedTokenReused = "refresh_token_reused"
+	SessionEndedInvalidated = "invalidated" // 密碼變更、等級變更、強制登出或使用者刪除
+	SessionEndedByUser      = "terminated_by_user"
+	SessionEndedByAdmin     = "terminated_by_admin"
+)
+
+// sessionTouchInterval 最後使用時間的更新間隔，避免每個請求都寫入資料庫
+const sessionTouchInterval = time.Minute
+
+// ListSessions 取得使用者尚未結束的工作階段
+func (s *TokenService) ListSessions(userID uint) ([]models.Session, error) {
+	return s.sessionRepo.GetActiveByUserID(userID, time.Now())
+}
+
+// TerminateSession 結束使用者的單一工作階段，其 refresh token 與 access token 立即失效
+func (s *TokenService) TerminateSession(userID uint, sessionID, reason string) error {
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if session.UserID != userID || !session.IsActive(now) {
+		return ErrSessionNotFound
+	}
+	return s.endSession(session.ID, now, reason)
+}
+
+// TerminateOtherSessions 結束使用者除了 keepID 以外的所有工作階段，回傳結束的數量
+func (s *TokenService) TerminateOtherSessions(userID uint, keepID, reason string) (int, error) {
+	now := time.Now()
+	sessions, err := s.sessionRepo.GetActiveByUserID(userID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	terminated := 0
+	for _, session := range sessions {
+		if session.ID == keepID {
+			continue
+		}
+		if err := s.endSession(session.ID, now, reason); err != nil {
+			return terminated, err
+		}
+		terminated++
+	}
+	return terminated, nil
+}
+
+// PruneSessions 清除 refresh token 已過期的工作階段
+func (s *TokenService) PruneSessions() error {
+	return s.sessionRepo.DeleteExpired(time.Now())
+}
+
+// endSession 撤銷令牌家族並結束對應的工作階段
+func (s *TokenService) endSession(sessionID string, now time.Time, reason string) error {
+	if err := s.refreshTokenRepo.RevokeFamily(sessionID, now); err != nil {
+		return err
+	}
+	if err := s.sessionRepo.Revoke(sessionID, now, reason); err != nil {
+		return err
+	}
+	s.cache.invalidateSession(sessionID)
+	return nil
+}
+
+// recordRefresh 刷新令牌後更新工作階段；升級前建立的令牌家族沒有工作階段紀錄時補建
+func (s *TokenService) recordRefresh(stored *models.RefreshToken, clientIP, userAgent string, now, expiresAt time.Time) error {
+	userAgent = truncateUserAgent(userAgent)
+	updated, err := s.sessionRepo.UpdateActivity(stored.FamilyID, clientIP, userAgent, now, expiresAt)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		err = s.sessionRepo.Create(&models.Session{
+			ID:          stored.FamilyID,
+			UserID:      stored.UserID,
+			IPAddress:   clientIP,
+			UserAgent:   userAgent,
+			MFAVerified: stored.MFAVerified,
+			AuthMethod:  stored.AuthMethod,
+			LastSeenAt:  now,
+			ExpiresAt:   expiresAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.cache.invalidateSession(stored.FamilyID)
+	return nil
+}
+
+// sessionState 取得工作階段狀態，快取過期時重新查詢資料庫，並定期更新最後使用時間
+func (s *TokenService) sessionState(sessionID string, now time.Time) (sessionTokenState, error) {
+	state, ok := s.cache.getSession(sessionID, now)
+	if !ok {
+		state = sessionTokenState{fetchedAt: now}
+		session, err := s.sessionRepo.GetByID(sessionID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return sessionTokenState{}, err
+		}
+		if err == nil {
+			state.active = session.IsActive(now)
+			state.lastSeenAt = session.LastSeenAt
+		}
+	}
+
+	if state.active && now.Sub(state.lastSeenAt) > sessionTouchInterval {
+		if err := s.sessionRepo.Touch(sessionID, now); err != nil {
+			return sessionTokenState{}, err
+		}
+		state.lastSeenAt = now
+		ok = false
+	}
+	if !ok {
+		s.cache.setSession(sessionID, state)
+	}
+	return state, nil
+}
+
+// truncateUserAgent 限制 User-Agent 長度以符合資料表欄位
+func truncateUserAgent(userAgent string) string {
+	return truncateUTF8(userAgent, 255)
+}
+
+// truncateUTF8 將字串截斷到最多 max 位元組，不會切在多位元組字元中間 (避免寫入無效的 UTF-8)
+func truncateUTF8(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	return value[:cut]
+}
@@ -0,0 +1,548 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"erp/db"
+	"erp/models"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidRefreshToken 刷新令牌不存在、已過期或已撤銷
+var ErrInvalidRefreshToken = errors.New("無效的刷新令牌")
+
+// ErrRefreshTokenReused 已輪替過的刷新令牌被再次使用，整個令牌家族已撤銷
+var ErrRefreshTokenReused = errors.New("刷新令牌已被使用，請重新登入")
+
+// ErrInvalidAccessToken access token 簽章、格式或有效期限錯誤
+var ErrInvalidAccessToken = errors.New("無效的 token")
+
+// ErrAccessTokenRevoked access token 已被撤銷（使用者已刪除、令牌版本變更或已登出）
+var ErrAccessTokenRevoked = errors.New("token 已失效，請重新登入")
+
+// 預設的令牌有效期限，可由環境變數 ACCESS_TOKEN_TTL / REFRESH_TOKEN_TTL 覆寫 (例如 "15m", "168h")
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 7 * 24 * time.Hour
+	mfaChallengeTTL        = 5 * time.Minute
+)
+
+// JWT 的 typ 聲明，用於區分 access token 與登入流程中的暫時令牌
+const (
+	tokenTypeAccess       = "access"
+	tokenTypeMFAChallenge = "mfa_challenge"
+)
+
+// 登入方式，記錄在令牌中 (auth_method 聲明)
+const (
+	AuthMethodPassword      = "password"
+	AuthMethodOIDC          = "oidc"
+	AuthMethodPasskey       = "passkey"
+	AuthMethodImpersonation = "impersonation"
+)
+
+// TokenPair 登入或刷新後回傳的令牌組
+type TokenPair struct {
+	AccessToken      string    `json:"token"`
+	RefreshToken     string    `json:"refresh_token"`
+	ExpiresIn        int64     `json:"expires_in"` // access token 有效秒數
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// AccessClaims access token 攜帶的聲明
+type AccessClaims struct {
+	UserID       uint        `json:"user_id"`
+	Username     string      `json:"username"`
+	Level        string      `json:"level"`
+	TokenVersion uint        `json:"ver"`
+	TokenType    string      `json:"typ"`
+	MFAVerified  bool        `json:"mfa,omitempty"`         // 本次登入是否通過兩步驟驗證
+	AuthMethod   string      `json:"auth_method,omitempty"` // 本次登入方式 (password, oidc, passkey, impersonation)
+	SessionID    string      `json:"sid,omitempty"`         // 所屬的登入工作階段
+	Actor        *TokenActor `json:"act,omitempty"`         // 模擬登入時實際操作的管理員
+	jwt.RegisteredClaims
+}
+
+// TokenActor 模擬登入令牌中實際操作的使用者 (參考 RFC 8693 的 act 聲明)
+type TokenActor struct {
+	UserID       uint   `json:"user_id"`
+	Username     string `json:"username"`
+	TokenVersion uint   `json:"ver"`
+}
+
+// TokenOptions 簽發令牌時的附加選項
+type TokenOptions struct {
+	MFAVerified bool
+	AuthMethod  string
+	SessionID   string // 由 IssueTokenPair / Refresh 設定為令牌家族 ID
+}
+
+// TokenService 令牌服務，負責簽發 access token 與輪替 refresh token
+type TokenService struct {
+	userRepo         db.UserRepository
+	refreshTokenRepo db.RefreshTokenRepository
+	revokedTokenRepo db.RevokedTokenRepository
+	sessionRepo      db.SessionRepository
+	keyRing          *KeyRingService
+	issuer           string
+	accessTokenTTL   time.Duration
+	refreshTokenTTL  time.Duration
+	cache            *tokenStateCache
+}
+
+// NewTokenService 建立令牌服務實例
+// JWT_ISSUER 設定時寫入 iss 聲明並在驗證時檢查，供其他服務以 JWKS 驗證令牌來源
+func NewTokenService(userRepo db.UserRepository, refreshTokenRepo db.RefreshTokenRepository, revokedTokenRepo db.RevokedTokenRepository, sessionRepo db.SessionRepository, keyRing *KeyRingService) *TokenService {
+	return &TokenService{
+		userRepo:         userRepo,
+		refreshTokenRepo: refreshTokenRepo,
+		revokedTokenRepo: revokedTokenRepo,
+		sessionRepo:      sessionRepo,
+		keyRing:          keyRing,
+		issuer:           os.Getenv("JWT_ISSUER"),
+		accessTokenTTL:   durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL),
+		refreshTokenTTL:  durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL),
+		cache:            newTokenStateCache(durationFromEnv("TOKEN_STATE_CACHE_TTL", defaultTokenStateCacheTTL)),
+	}
+}
+
+// GenerateAccessToken 為使用者簽發短效期的 access token，包含 jti 與令牌版本
+func (s *TokenService) GenerateAccessToken(user *models.User, opts TokenOptions) (string, error) {
+	return s.sign(&AccessClaims{
+		UserID:       user.ID,
+		Username:     user.Username,
+		Level:        user.Level,
+		TokenVersion: user.TokenVersion,
+		TokenType:    tokenTypeAccess,
+		MFAVerified:  opts.MFAVerified,
+		AuthMethod:   opts.AuthMethod,
+		SessionID:    opts.SessionID,
+	}, s.accessTokenTTL)
+}
+
+// GenerateImpersonationToken 簽發以 user 身分存取、由 actor 實際操作的短效 access token
+// 令牌不附帶 refresh token，actor 的令牌版本變更 (例如強制登出或等級變更) 時一併失效
+func (s *TokenService) GenerateImpersonationToken(user, actor *models.User, mfaVerified bool, ttl time.Duration) (string, *AccessClaims, error) {
+	claims := &AccessClaims{
+		UserID:       user.ID,
+		Username:     user.Username,
+		Level:        user.Level,
+		TokenVersion: user.TokenVersion,
+		TokenType:    tokenTypeAccess,
+		MFAVerified:  mfaVerified,
+		AuthMethod:   AuthMethodImpersonation,
+		Actor: &TokenActor{
+			UserID:       actor.ID,
+			Username:     actor.Username,
+			TokenVersion: actor.TokenVersion,
+		},
+	}
+	token, err := s.sign(claims, ttl)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// ParseAccessToken 驗證 access token 的簽章與有效期限並取出聲明（不檢查撤銷狀態）
+func (s *TokenService) ParseAccessToken(tokenString string) (*AccessClaims, error) {
+	claims, err := s.parse(tokenString, tokenTypeAccess)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	return claims, nil
+}
+
+// GenerateMFAChallenge 第一步驗證通過但尚需兩步驟驗證時，簽發僅能用於完成登入的暫時令牌
+func (s *TokenService) GenerateMFAChallenge(user *models.User, opts TokenOptions) (string, time.Duration, error) {
+	token, err := s.sign(&AccessClaims{
+		UserID:       user.ID,
+		Username:     user.Username,
+		TokenVersion: user.TokenVersion,
+		TokenType:    tokenTypeMFAChallenge,
+		AuthMethod:   opts.AuthMethod,
+	}, mfaChallengeTTL)
+	return token, mfaChallengeTTL, err
+}
+
+// ParseMFAChallenge 驗證兩步驟驗證暫時令牌，已使用過的令牌視為無效
+func (s *TokenService) ParseMFAChallenge(tokenString string) (*AccessClaims, error) {
+	claims, err := s.parse(tokenString, tokenTypeMFAChallenge)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	now := time.Now()
+	if err := s.refreshRevoked(now); err != nil {
+		return nil, err
+	}
+	if s.cache.isRevoked(claims.ID, now) {
+		return nil, ErrInvalidAccessToken
+	}
+	return claims, nil
+}
+
+// sign 補上 jti 與有效期限後簽署令牌
+func (s *TokenService) sign(claims *AccessClaims, ttl time.Duration) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	kid, algorithm, signer, err := s.keyRing.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        jti,
+		Issuer:    s.issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(algorithm), claims)
+	token.Header["kid"] = kid
+	return token.SignedString(signer)
+}
+
+// parse 驗證簽章 (kid 對應的金鑰)、有效期限與令牌類型
+func (s *TokenService) parse(tokenString, tokenType string) (*AccessClaims, error) {
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{SigningAlgorithmRS256, SigningAlgorithmES256})}
+	if s.issuer != "" {
+		options = append(options, jwt.WithIssuer(s.issuer))
+	}
+
+	claims := &AccessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// 依 kid 取得金鑰環中的公開金鑰，演算法必須與金鑰相符
+		kid, _ := token.Header["kid"].(string)
+		return s.keyRing.VerificationKey(kid, token.Method.Alg())
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.UserID == 0 || claims.TokenType != tokenType {
+		return nil, ErrInvalidAccessToken
+	}
+	return claims, nil
+}
+
+// ValidateAccessToken 驗證 access token 並檢查撤銷狀態
+// 使用者已軟刪除、令牌版本已變更、工作階段已結束或 jti 在撤銷清單中時回傳 ErrAccessTokenRevoked
+func (s *TokenService) ValidateAccessToken(tokenString string) (*AccessClaims, error) {
+	claims, err := s.ParseAccessToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	state, err := s.userState(claims.UserID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !state.exists || state.tokenVersion != claims.TokenVersion {
+		return nil, ErrAccessTokenRevoked
+	}
+
+	// 模擬登入令牌在實際操作者被刪除或令牌版本變更時失效
+	if claims.Actor != nil {
+		actor, err := s.userState(claims.Actor.UserID, now)
+		if err != nil {
+			return nil, err
+		}
+		if !actor.exists || actor.tokenVersion != claims.Actor.TokenVersion {
+			return nil, ErrAccessTokenRevoked
+		}
+	}
+
+	if claims.SessionID != "" {
+		session, err := s.sessionState(claims.SessionID, now)
+		if err != nil {
+			return nil, err
+		}
+		if !session.active {
+			return nil, ErrAccessTokenRevoked
+		}
+	}
+
+	if claims.ID != "" {
+		if err := s.refreshRevoked(now); err != nil {
+			return nil, err
+		}
+		if s.cache.isRevoked(claims.ID, now) {
+			return nil, ErrAccessTokenRevoked
+		}
+	}
+
+	return claims, nil
+}
+
+// RevokeAccessToken 將單一 access token 加入撤銷清單（例如登出）
+func (s *TokenService) RevokeAccessToken(claims *AccessClaims, reason string) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+
+	err := s.revokedTokenRepo.Create(&models.RevokedToken{
+		JTI:       claims.ID,
+		UserID:    claims.UserID,
+		ExpiresAt: claims.ExpiresAt.Time,
+		Reason:    reason,
+	})
+	if err != nil {
+		return err
+	}
+	s.cache.addRevoked(claims.ID, claims.ExpiresAt.Time)
+	return nil
+}
+
+// InvalidateUserTokens 遞增使用者令牌版本並撤銷所有 refresh token 與工作階段
+// 用於密碼變更、等級變更與強制登出，先前簽發的所有令牌立即失效
+func (s *TokenService) InvalidateUserTokens(userID uint) error {
+	if err := s.userRepo.IncrementTokenVersion(userID); err != nil {
+		return err
+	}
+	s.cache.invalidateUser(userID)
+
+	now := time.Now()
+	if err := s.refreshTokenRepo.RevokeAllByUserID(userID, now); err != nil {
+		return err
+	}
+	return s.sessionRepo.RevokeAllByUserID(userID, now, SessionEndedInvalidated)
+}
+
+// AllowsNetwork 檢查來源 IP 是否符合使用者的來源網路限制 (使用令牌狀態快取)
+func (s *TokenService) AllowsNetwork(userID uint, clientIP string) (bool, error) {
+	state, err := s.userState(userID, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return state.allowedNetworks.Allows(clientIP), nil
+}
+
+// AccountStatus 取得使用者目前實際的帳號狀態 (使用令牌狀態快取)
+func (s *TokenService) AccountStatus(userID uint) (string, error) {
+	now := time.Now()
+	state, err := s.userState(userID, now)
+	if err != nil {
+		return "", err
+	}
+	user := models.User{Status: state.status, SuspendedUntil: state.suspendedUntil}
+	return user.EffectiveStatus(now), nil
+}
+
+// RefreshUserState 清除快取的使用者狀態，使用者的來源網路限制或帳號狀態變更後呼叫使其立即生效
+func (s *TokenService) RefreshUserState(userID uint) {
+	s.cache.invalidateUser(userID)
+}
+
+// PruneRevokedTokens 清除已過期的撤銷紀錄
+func (s *TokenService) PruneRevokedTokens() error {
+	return s.revokedTokenRepo.DeleteExpired(time.Now())
+}
+
+// IssueTokenPair 登入成功後簽發令牌組，refresh token 開啟新的令牌家族並建立對應的工作階段
+func (s *TokenService) IssueTokenPair(user *models.User, clientIP, userAgent string, opts TokenOptions) (*TokenPair, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.sessionRepo.Create(&models.Session{
+		ID:          familyID,
+		UserID:      user.ID,
+		IPAddress:   clientIP,
+		UserAgent:   truncateUserAgent(userAgent),
+		MFAVerified: opts.MFAVerified,
+		AuthMethod:  opts.AuthMethod,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(s.refreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(user, familyID, clientIP, userAgent, opts)
+}
+
+// Refresh 以 refresh token 換發新的令牌組，舊令牌隨即失效
+// 若提交的是已輪替過的令牌，視為令牌外洩並撤銷整個家族
+func (s *TokenService) Refresh(rawToken, clientIP, userAgent string) (*models.User, *TokenPair, error) {
+	stored, err := s.refreshTokenRepo.GetByHash(hashToken(rawToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if stored.UsedAt != nil {
+		if err := s.endSession(stored.FamilyID, now, SessionEndedTokenReused); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrRefreshTokenReused
+	}
+	if stored.RevokedAt != nil || now.After(stored.ExpiresAt) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	// 條件更新失敗代表另一個請求已搶先輪替同一令牌，同樣視為重複使用
+	marked, err := s.refreshTokenRepo.MarkUsed(stored.ID, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !marked {
+		if err := s.endSession(stored.FamilyID, now, SessionEndedTokenReused); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	// 使用者已刪除或帳號已停權、鎖定、封存時結束工作階段
+	user, err := s.userRepo.GetByID(stored.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !user.IsActive(now)) {
+		if err := s.endSession(stored.FamilyID, now, SessionEndedInvalidated); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 輪替後沿用原登入的兩步驟驗證狀態與登入方式
+	pair, err := s.issue(user, stored.FamilyID, clientIP, userAgent, TokenOptions{MFAVerified: stored.MFAVerified, AuthMethod: stored.AuthMethod})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.recordRefresh(stored, clientIP, userAgent, now, pair.RefreshExpiresAt); err != nil {
+		return nil, nil, err
+	}
+	return user, pair, nil
+}
+
+// Revoke 撤銷 refresh token 所屬的整個令牌家族並結束工作階段（登出）
+func (s *TokenService) Revoke(rawToken string) error {
+	stored, err := s.refreshTokenRepo.GetByHash(hashToken(rawToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+	return s.endSession(stored.FamilyID, time.Now(), SessionEndedLogout)
+}
+
+// userState 取得使用者令牌狀態，快取過期時重新查詢資料庫
+func (s *TokenService) userState(userID uint, now time.Time) (userTokenState, error) {
+	if state, ok := s.cache.getUser(userID, now); ok {
+		return state, nil
+	}
+
+	state := userTokenState{fetchedAt: now}
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return userTokenState{}, err
+	}
+	if err == nil {
+		state.exists = true
+		state.tokenVersion = user.TokenVersion
+		state.allowedNetworks = user.AllowedNetworks
+		state.status = user.Status
+		state.suspendedUntil = user.SuspendedUntil
+	}
+	s.cache.setUser(userID, state)
+	return state, nil
+}
+
+// refreshRevoked 撤銷清單快取過期時從資料庫重新載入
+func (s *TokenService) refreshRevoked(now time.Time) error {
+	if !s.cache.revokedStale(now) {
+		return nil
+	}
+
+	tokens, err := s.revokedTokenRepo.GetActive(now)
+	if err != nil {
+		return err
+	}
+	revoked := make(map[string]time.Time, len(tokens))
+	for _, token := range tokens {
+		revoked[token.JTI] = token.ExpiresAt
+	}
+	s.cache.replaceRevoked(revoked, now)
+	return nil
+}
+
+// issue 簽發 access token 並在指定家族中建立新的 refresh token
+func (s *TokenService) issue(user *models.User, familyID, clientIP, userAgent string, opts TokenOptions) (*TokenPair, error) {
+	opts.SessionID = familyID
+	accessToken, err := s.GenerateAccessToken(user, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rawRefreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(s.refreshTokenTTL)
+	err = s.refreshTokenRepo.Create(&models.RefreshToken{
+		UserID:      user.ID,
+		FamilyID:    familyID,
+		TokenHash:   hashToken(rawRefreshToken),
+		ExpiresAt:   expiresAt,
+		UserAgent:   truncateUserAgent(userAgent),
+		IPAddress:   clientIP,
+		MFAVerified: opts.MFAVerified,
+		AuthMethod:  opts.AuthMethod,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     rawRefreshToken,
+		ExpiresIn:        int64(s.accessTokenTTL.Seconds()),
+		RefreshExpiresAt: expiresAt,
+	}, nil
+}
+
+// randomToken 產生指定位元組長度的隨機字串 (base64url)
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("無法產生隨機令牌: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken 計算令牌的 SHA-256 雜湊值，資料庫只保存雜湊
+func hashToken(rawToken string) string {
+	sum := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// durationFromEnv 從環境變數讀取時間長度，未設定或格式錯誤時使用預設值
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return duration
+}
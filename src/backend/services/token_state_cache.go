@@ -0,0 +1,145 @@
+package services
+
+import (
+	"erp/models"
+	"sync"
+	"time"
+)
+
+// 令牌狀態快取的預設存活時間，可由環境變數 TOKEN_STATE_CACHE_TTL 覆寫
+const defaultTokenStateCacheTTL = 30 * time.Second
+
+// userTokenState 快取的使用者令牌狀態
+type userTokenState struct {
+	exists          bool // false 代表使用者不存在或已軟刪除
+	tokenVersion    uint
+	allowedNetworks models.NetworkList // 使用者的來源網路限制
+	status          string             // 帳號狀態，搭配 suspendedUntil 計算實際狀態
+	suspendedUntil  *time.Time
+	fetchedAt       time.Time
+}
+
+// sessionTokenState 快取的工作階段狀態
+type sessionTokenState struct {
+	active     bool // false 代表工作階段不存在、已結束或已過期
+	lastSeenAt time.Time
+	fetchedAt  time.Time
+}
+
+// tokenStateCache 行程內的令牌狀態快取，避免 AuthMiddleware 每次請求都查詢資料庫
+// 本行程內的變更會立即生效；多個實例之間最多延遲一個 TTL
+type tokenStateCache struct {
+	mu          sync.RWMutex
+	ttl         time.Duration
+	users       map[uint]userTokenState
+	sessions    map[string]sessionTokenState
+	revoked     map[string]time.Time // jti -> token 過期時間
+	revokedAt   time.Time            // 撤銷清單最後載入時間
+	revokedInit bool
+}
+
+// newTokenStateCache 建立令牌狀態快取
+func newTokenStateCache(ttl time.Duration) *tokenStateCache {
+	return &tokenStateCache{
+		ttl:      ttl,
+		users:    make(map[uint]userTokenState),
+		sessions: make(map[string]sessionTokenState),
+		revoked:  make(map[string]time.Time),
+	}
+}
+
+// getUser 取得未過期的使用者狀態
+func (c *tokenStateCache) getUser(userID uint, now time.Time) (userTokenState, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	state, ok := c.users[userID]
+	if !ok || now.Sub(state.fetchedAt) > c.ttl {
+		return userTokenState{}, false
+	}
+	return state, true
+}
+
+// setUser 寫入使用者狀態
+func (c *tokenStateCache) setUser(userID uint, state userTokenState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users[userID] = state
+}
+
+// invalidateUser 移除使用者狀態，下一次請求會重新查詢資料庫
+func (c *tokenStateCache) invalidateUser(userID uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.users, userID)
+}
+
+// getSession 取得未過期的工作階段狀態
+func (c *tokenStateCache) getSession(sessionID string, now time.Time) (sessionTokenState, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	state, ok := c.sessions[sessionID]
+	if !ok || now.Sub(state.fetchedAt) > c.ttl {
+		return sessionTokenState{}, false
+	}
+	return state, true
+}
+
+// setSession 寫入工作階段狀態
+func (c *tokenStateCache) setSession(sessionID string, state sessionTokenState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions[sessionID] = state
+}
+
+// invalidateSession 移除工作階段狀態，下一次請求會重新查詢資料庫
+func (c *tokenStateCache) invalidateSession(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sessions, sessionID)
+}
+
+// revokedStale 判斷撤銷清單是否需要重新載入
+func (c *tokenStateCache) revokedStale(now time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !c.revokedInit || now.Sub(c.revokedAt) > c.ttl
+}
+
+// replaceRevoked 以資料庫內容取代撤銷清單
+// 撤銷清單每個 TTL 重新載入一次，同時清除超過 TTL 的使用者與工作階段狀態，避免已不再使用的項目持續累積
+func (c *tokenStateCache) replaceRevoked(revoked map[string]time.Time, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revoked = revoked
+	c.revokedAt = now
+	c.revokedInit = true
+
+	for userID, state := range c.users {
+		if now.Sub(state.fetchedAt) > c.ttl {
+			delete(c.users, userID)
+		}
+	}
+	for sessionID, state := range c.sessions {
+		if now.Sub(state.fetchedAt) > c.ttl {
+			delete(c.sessions, sessionID)
+		}
+	}
+}
+
+// addRevoked 將 jti 加入撤銷清單
+func (c *tokenStateCache) addRevoked(jti string, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revoked[jti] = expiresAt
+}
+
+// isRevoked 檢查 jti 是否已撤銷
+func (c *tokenStateCache) isRevoked(jti string, now time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	expiresAt, ok := c.revoked[jti]
+	return ok && now.Before(expiresAt)
+}
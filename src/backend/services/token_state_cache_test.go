@@ -0,0 +1,31 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenStateCacheSweepsExpiredEntries(t *testing.T) {
+	cache := newTokenStateCache(30 * time.Second)
+	now := time.Now()
+	cache.setUser(1, userTokenState{exists: true, fetchedAt: now.Add(-time.Minute)})
+	cache.setUser(2, userTokenState{exists: true, fetchedAt: now})
+	cache.setSession("old", sessionTokenState{active: true, fetchedAt: now.Add(-time.Minute)})
+	cache.setSession("new", sessionTokenState{active: true, fetchedAt: now})
+
+	// 撤銷清單重新載入時清除超過 TTL 的項目
+	cache.replaceRevoked(map[string]time.Time{}, now)
+
+	if _, ok := cache.users[1]; ok {
+		t.Fatal("expired user state was kept")
+	}
+	if _, ok := cache.sessions["old"]; ok {
+		t.Fatal("expired session state was kept")
+	}
+	if _, ok := cache.getUser(2, now); !ok {
+		t.Fatal("fresh user state was removed")
+	}
+	if _, ok := cache.getSession("new", now); !ok {
+		t.Fatal("fresh session state was removed")
+	}
+}
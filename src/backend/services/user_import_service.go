@@ -0,0 +1,408 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"erp/db"
+	"erp/models"
+	"errors"
+	"fmt"
+	"io"
+	netmail "net/mail"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// ErrUnsupportedUserFileFormat 匯入匯出只支援 CSV 與 XLSX
+var ErrUnsupportedUserFileFormat = errors.New("不支援的檔案格式，只接受 csv 或 xlsx")
+
+// ErrInvalidUserFile 匯入檔案無法解析
+var ErrInvalidUserFile = errors.New("無法讀取匯入檔案")
+
+// ErrUserImportInvalid 匯入資料未通過驗證，未建立任何使用者
+var ErrUserImportInvalid = errors.New("匯入資料有誤，未建立任何使用者")
+
+// 單次匯入的資料筆數上限
+const maxUserImportRows = 1000
+
+// XLSX 解壓縮後的大小上限，避免壓縮炸彈
+const maxUserImportUnzipSize = 64 << 20
+
+// utf8BOM Excel 開啟 CSV 時需要 BOM 才會以 UTF-8 解讀
+const utf8BOM = "\ufeff"
+
+// UserImportService 使用者批次匯入與匯出 (CSV、XLSX)
+// 匯入的使用者以邀請方式建立，受邀者自行設定密碼後才能登入
+type UserImportService struct {
+	database     *db.DB
+	userRepo     db.UserRepository
+	roleRepo     db.RoleRepository
+	userRoleRepo db.UserRoleRepository
+	policy       *UserPolicyService
+	invitations  *InvitationService
+}
+
+// NewUserImportService 建立使用者匯入匯出服務實例
+func NewUserImportService(database *db.DB, userRepo db.UserRepository, roleRepo db.RoleRepository, userRoleRepo db.UserRoleRepository, policy *UserPolicyService, invitations *InvitationService) *UserImportService {
+	return &UserImportService{
+		database:     database,
+		userRepo:     userRepo,
+		roleRepo:     roleRepo,
+		userRoleRepo: userRoleRepo,
+		policy:       policy,
+		invitations:  invitations,
+	}
+}
+
+// Import 解析並驗證匯入檔案；dryRun 時只回報結果，否則在同一個交易中建立全部使用者、分配角色與邀請，提交後才寄出邀請郵件
+// 有任何驗證錯誤時回傳 ErrUserImportInvalid 與逐列錯誤；canAssignRoles 為 false 時不可指定角色
+func (s *UserImportService) Import(format string, file io.Reader, actor *models.User, canAssignRoles, dryRun bool) (*models.UserImportResult, error) {
+	records, err := readUserFile(format, file)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.UserImportResult{
+		DryRun: dryRun,
+		Errors: []models.UserImportError{},
+		Users:  []models.UserResponse{},
+	}
+	rows := parseUserRecords(records, result)
+	result.Total = len(rows)
+	if len(result.Errors) > 0 {
+		return result, ErrUserImportInvalid
+	}
+
+	roles, err := s.validate(rows, actor, canAssignRoles, result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Errors) > 0 {
+		return result, ErrUserImportInvalid
+	}
+
+	if dryRun {
+		for _, row := range rows {
+			user := models.User{Username: row.Username, Email: row.Email, Level: row.Level}
+			result.Users = append(result.Users, user.ToResponse())
+		}
+		return result, nil
+	}
+
+	// 接受邀請前無法登入，同一批次共用一組無法使用的密碼雜湊，避免逐筆 bcrypt
+	password, err := unusablePasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	type invited struct {
+		user     *models.User
+		rawToken string
+	}
+	var created []invited
+	err = s.database.WithTransaction(func(tx *db.DB) error {
+		userRepo := db.NewUserRepository(tx)
+		userRoleRepo := db.NewUserRoleRepository(tx)
+		invitationRepo := db.NewUserInvitationRepository(tx)
+
+		for _, row := range rows {
+			user := &models.User{
+				Username: row.Username,
+				Email:    row.Email,
+				Password: password,
+				Level:    row.Level,
+			}
+			if err := userRepo.Create(user); err != nil {
+				return fmt.Errorf("第 %d 列: %w", row.Row, err)
+			}
+			for _, name := range row.Roles {
+				if err := userRoleRepo.Create(&models.UserRole{UserID: user.ID, RoleID: roles[name].ID}); err != nil {
+					return fmt.Errorf("第 %d 列: %w", row.Row, err)
+				}
+			}
+			_, rawToken, err := s.invitations.create(invitationRepo, user, actor, models.InvitationPurposeInvite)
+			if err != nil {
+				return fmt.Errorf("第 %d 列: %w", row.Row, err)
+			}
+			created = append(created, invited{user: user, rawToken: rawToken})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 郵件寄送失敗時帳號與邀請仍會保留，由管理員重新寄送
+	for _, item := range created {
+		if err := s.invitations.sendMail(item.user, actor, item.rawToken); err != nil {
+			fmt.Fprintf(os.Stderr, "匯入使用者 %s: %v\n", item.user.Username, err)
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: 邀請郵件寄送失敗，請重新寄送邀請", item.user.Username))
+		}
+		result.Users = append(result.Users, item.user.ToResponse())
+	}
+	return result, nil
+}
+
+// Export 依列表條件匯出全部符合的使用者 (忽略分頁)，格式與匯入相同
+func (s *UserImportService) Export(query models.UserListQuery, format string, w io.Writer) error {
+	if format != models.UserFileFormatCSV && format != models.UserFileFormatXLSX {
+		return ErrUnsupportedUserFileFormat
+	}
+
+	records := [][]string{models.UserFileColumns}
+	query.Page = 0
+	query.Cursor = ""
+	query.PageSize = models.MaxPageSize
+	for {
+		users, page, err := s.userRepo.List(query)
+		if err != nil {
+			return err
+		}
+
+		userIDs := make([]uint, 0, len(users))
+		for _, user := range users {
+			userIDs = append(userIDs, user.ID)
+		}
+		roleNames, err := s.userRoleRepo.GetRoleNamesByUserIDs(userIDs)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			records = append(records, []string{user.Username, user.Email, user.Level, strings.Join(roleNames[user.ID], ";")})
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	return writeUserFile(format, w, records)
+}
+
+// validate 逐列檢查帳號、電子郵件、等級與角色，錯誤寫入 result；回傳檔案中用到的角色 (名稱 → 角色)
+func (s *UserImportService) validate(rows []models.UserImportRow, actor *models.User, canAssignRoles bool, result *models.UserImportResult) (map[string]*models.Role, error) {
+	addError := func(row int, field, message string) {
+		result.Errors = append(result.Errors, models.UserImportError{Row: row, Field: field, Message: message})
+	}
+
+	roles := make(map[string]*models.Role)
+	usernames := make(map[string]int)
+	emails := make(map[string]int)
+	for i := range rows {
+		row := &rows[i]
+
+		switch {
+		case row.Username == "":
+			addError(row.Row, "username", "帳號為必填")
+		case utf8.RuneCountInString(row.Username) > 50:
+			addError(row.Row, "username", "帳號長度不可超過 50 字元")
+		case usernames[row.Username] > 0:
+			addError(row.Row, "username", fmt.Sprintf("帳號與第 %d 列重複", usernames[row.Username]))
+		default:
+			usernames[row.Username] = row.Row
+			taken, err := userExists(s.userRepo.GetByUsername(row.Username))
+			if err != nil {
+				return nil, err
+			}
+			if taken {
+				addError(row.Row, "username", "帳號已存在")
+			}
+		}
+
+		emailKey := strings.ToLower(row.Email)
+		switch {
+		case row.Email == "":
+			addError(row.Row, "email", "電子郵件為必填")
+		case len(row.Email) > 100 || !validEmail(row.Email):
+			addError(row.Row, "email", "無效的電子郵件")
+		case emails[emailKey] > 0:
+			addError(row.Row, "email", fmt.Sprintf("電子郵件與第 %d 列重複", emails[emailKey]))
+		default:
+			emails[emailKey] = row.Row
+			taken, err := userExists(s.userRepo.GetByEmail(row.Email))
+			if err != nil {
+				return nil, err
+			}
+			if taken {
+				addError(row.Row, "email", "電子郵件已被使用")
+			}
+		}
+
+		if row.Level == "" {
+			row.Level = "user"
+		}
+		if err := s.policy.CheckAssignableLevel(actor, row.Level); err != nil {
+			addError(row.Row, "level", err.Error())
+		}
+
+		if len(row.Roles) > 0 && !canAssignRoles {
+			addError(row.Row, "roles", "指定角色需要 system.roles.manage 權限")
+			continue
+		}
+		for _, name := range row.Roles {
+			if _, ok := roles[name]; !ok {
+				role, err := s.roleRepo.GetByName(name)
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, err
+				}
+				roles[name] = role
+			}
+			if roles[name] == nil {
+				addError(row.Row, "roles", fmt.Sprintf("%s: %s", ErrInvitationRoleNotFound.Error(), name))
+			}
+		}
+	}
+	return roles, nil
+}
+
+// readUserFile 讀取 CSV 或 XLSX (第一個工作表) 的所有列
+func readUserFile(format string, file io.Reader) ([][]string, error) {
+	switch format {
+	case models.UserFileFormatCSV:
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidUserFile, err)
+		}
+		if len(records) > 0 && len(records[0]) > 0 {
+			records[0][0] = strings.TrimPrefix(records[0][0], utf8BOM)
+		}
+		return records, nil
+	case models.UserFileFormatXLSX:
+		workbook, err := excelize.OpenReader(file, excelize.Options{UnzipSizeLimit: maxUserImportUnzipSize})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidUserFile, err)
+		}
+		defer workbook.Close()
+
+		sheets := workbook.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, ErrInvalidUserFile
+		}
+		records, err := workbook.GetRows(sheets[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidUserFile, err)
+		}
+		return records, nil
+	default:
+		return nil, ErrUnsupportedUserFileFormat
+	}
+}
+
+// parseUserRecords 依標題列對應欄位 (不分大小寫，忽略未知欄位與空白列)，檔案層級的錯誤寫入 result
+func parseUserRecords(records [][]string, result *models.UserImportResult) []models.UserImportRow {
+	if len(records) == 0 {
+		result.Errors = append(result.Errors, models.UserImportError{Message: "檔案沒有標題列"})
+		return nil
+	}
+
+	columns := make(map[string]int)
+	for i, header := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(header))] = i
+	}
+	for _, required := range []string{"username", "email"} {
+		if _, ok := columns[required]; !ok {
+			result.Errors = append(result.Errors, models.UserImportError{Field: required, Message: "缺少必要欄位: " + required})
+		}
+	}
+	if len(result.Errors) > 0 {
+		return nil
+	}
+
+	cell := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []models.UserImportRow
+	for i, record := range records[1:] {
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		rows = append(rows, models.UserImportRow{
+			Row:      i + 2,
+			Username: cell(record, "username"),
+			Email:    cell(record, "email"),
+			Level:    cell(record, "level"),
+			Roles:    splitRoleNames(cell(record, "roles")),
+		})
+	}
+
+	switch {
+	case len(rows) == 0:
+		result.Errors = append(result.Errors, models.UserImportError{Message: "檔案沒有任何使用者資料"})
+	case len(rows) > maxUserImportRows:
+		result.Errors = append(result.Errors, models.UserImportError{Message: fmt.Sprintf("單次最多匯入 %d 筆使用者", maxUserImportRows)})
+	}
+	return rows
+}
+
+// writeUserFile 將資料列寫成 CSV (含 BOM) 或 XLSX
+func writeUserFile(format string, w io.Writer, records [][]string) error {
+	if format == models.UserFileFormatCSV {
+		var buf bytes.Buffer
+		buf.WriteString(utf8BOM)
+		writer := csv.NewWriter(&buf)
+		if err := writer.WriteAll(records); err != nil {
+			return err
+		}
+		_, err := buf.WriteTo(w)
+		return err
+	}
+
+	workbook := excelize.NewFile()
+	defer workbook.Close()
+
+	sheet := "Users"
+	if err := workbook.SetSheetName(workbook.GetSheetName(0), sheet); err != nil {
+		return err
+	}
+	for i, record := range records {
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			return err
+		}
+		if err := workbook.SetSheetRow(sheet, cell, &record); err != nil {
+			return err
+		}
+	}
+	return workbook.Write(w)
+}
+
+// splitRoleNames 解析以分號分隔的角色名稱，忽略空白與重複項目
+func splitRoleNames(value string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ";") {
+		name = strings.TrimSpace(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// validEmail 檢查是否為單純的電子郵件地址 (不含顯示名稱)
+func validEmail(email string) bool {
+	address, err := netmail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+// userExists 依查詢結果判斷帳號或電子郵件是否已被使用
+func userExists(_ *models.User, err error) (bool, error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"erp/db"
+	"erp/models"
+	"errors"
+	"time"
+)
+
+// ErrUserManagementForbidden 只有管理員可以管理其他使用者，且管理員不可管理超級管理員
+var ErrUserManagementForbidden = errors.New("無權管理此使用者")
+
+// ErrInvalidLevel 等級值無效
+var ErrInvalidLevel = errors.New("無效的等級值，只能是 'super_admin', 'admin' 或 'user'")
+
+// ErrLevelEscalation 不可將使用者等級設為高於自己的等級
+var ErrLevelEscalation = errors.New("無法將使用者等級設為高於自己的等級")
+
+// ErrLastSuperAdmin 系統至少需要保留一位可登入的超級管理員
+var ErrLastSuperAdmin = errors.New("無法刪除、降級或停用最後一位超級管理員")
+
+// ErrOwnStatusChange 不可變更自己的帳號狀態，避免管理員把自己停用
+var ErrOwnStatusChange = errors.New("無法變更自己的帳號狀態")
+
+// ErrImpersonatedUpdate 模擬登入期間不可變更登入憑證與權限相關欄位
+var ErrImpersonatedUpdate = errors.New("模擬登入期間無法變更密碼、電子郵件、等級或登入驗證方式")
+
+// UserPolicyService 使用者管理政策
+// 一般使用者只能修改自己的部分欄位；管理員可以管理等級不高於自己的使用者，但不可將任何人升級到高於自己的等級；
+// 最後一位可登入的超級管理員不可被刪除、降級或停用 (Check* 先行檢查，Update/Delete 寫入時再以交易確認)
+type UserPolicyService struct {
+	userRepo db.UserRepository
+}
+
+// NewUserPolicyService 建立使用者管理政策服務實例
+func NewUserPolicyService(userRepo db.UserRepository) *UserPolicyService {
+	return &UserPolicyService{userRepo: userRepo}
+}
+
+// CanManage 檢查 actor 是否可以以管理員身分管理 target (包含自己)
+func (s *UserPolicyService) CanManage(actor, target *models.User) error {
+	if models.LevelRank(actor.Level) < models.LevelRank("admin") {
+		return ErrUserManagementForbidden
+	}
+	if models.LevelRank(target.Level) > models.LevelRank(actor.Level) {
+		return ErrUserManagementForbidden
+	}
+	return nil
+}
+
+// CheckAssignableLevel 檢查 actor 可以為使用者設定的等級 (建立、邀請或變更等級時)
+func (s *UserPolicyService) CheckAssignableLevel(actor *models.User, level string) error {
+	if models.LevelRank(level) == 0 {
+		return ErrInvalidLevel
+	}
+	if models.LevelRank(actor.Level) < models.LevelRank("admin") {
+		return ErrUserManagementForbidden
+	}
+	if models.LevelRank(level) > models.LevelRank(actor.Level) {
+		return ErrLevelEscalation
+	}
+	return nil
+}
+
+// CheckLevelChange 檢查 actor 是否可以將 target 的等級變更為 level
+func (s *UserPolicyService) CheckLevelChange(actor, target *models.User, level string) error {
+	if err := s.CanManage(actor, target); err != nil {
+		return err
+	}
+	if err := s.CheckAssignableLevel(actor, level); err != nil {
+		return err
+	}
+	if level != "super_admin" {
+		return s.ensureNotLastSuperAdmin(target)
+	}
+	return nil
+}
+
+// CheckDelete 檢查 actor 是否可以刪除 target
+func (s *UserPolicyService) CheckDelete(actor, target *models.User) error {
+	if err := s.CanManage(actor, target); err != nil {
+		return err
+	}
+	return s.ensureNotLastSuperAdmin(target)
+}
+
+// CheckStatusChange 檢查 actor 是否可以將 target 的帳號狀態變更為 status
+func (s *UserPolicyService) CheckStatusChange(actor, target *models.User, status string) error {
+	if actor.ID == target.ID {
+		return ErrOwnStatusChange
+	}
+	if err := s.CanManage(actor, target); err != nil {
+		return err
+	}
+	if status != models.UserStatusActive {
+		return s.ensureNotLastSuperAdmin(target)
+	}
+	return nil
+}
+
+// CheckImpersonatedUpdate 檢查模擬登入期間的使用者更新：只能修改使用者名稱
+// 模擬者可以被模擬的管理員身分操作，若允許變更等級便能以他人名義提升權限
+func (s *UserPolicyService) CheckImpersonatedUpdate(input *models.UpdateUserInput) error {
+	if input.Password != nil || input.Email != nil || input.Level != nil || input.AuthBackends != nil {
+		return ErrImpersonatedUpdate
+	}
+	return nil
+}
+
+// Update 寫入可能讓 target 不再是可登入超級管理員的變更 (降級或停用)
+// 最後一位超級管理員的檢查與寫入在同一個交易中完成，兩位超級管理員同時互相降級或停用時只有一個會成功
+func (s *UserPolicyService) Update(target *models.User, columns ...string) error {
+	updated, err := s.userRepo.UpdateUnlessLastActive(target, "super_admin", time.Now(), columns...)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrLastSuperAdmin
+	}
+	return nil
+}
+
+// Delete 刪除 target，與 Update 相同不會刪除最後一位可登入的超級管理員
+func (s *UserPolicyService) Delete(target *models.User) error {
+	deleted, err := s.userRepo.DeleteUnlessLastActive(target.ID, "super_admin", time.Now())
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrLastSuperAdmin
+	}
+	return nil
+}
+
+// ensureNotLastSuperAdmin 目標是可登入的超級管理員時，確認除了目標外仍有其他可登入的超級管理員
+func (s *UserPolicyService) ensureNotLastSuperAdmin(target *models.User) error {
+	now := time.Now()
+	if target.Level != "super_admin" || !target.IsActive(now) {
+		return nil
+	}
+	count, err := s.userRepo.CountActiveByLevel("super_admin", now)
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		return ErrLastSuperAdmin
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"erp/models"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentDemotionsKeepOneSuperAdmin(t *testing.T) {
+	userRepo := &fakeUserRepo{}
+	userRepo.Create(&models.User{Username: "alice", Level: "super_admin"})
+	userRepo.Create(&models.User{Username: "bob", Level: "super_admin"})
+	alice, _ := userRepo.GetByUsername("alice")
+	bob, _ := userRepo.GetByUsername("bob")
+	policy := NewUserPolicyService(userRepo)
+
+	// 兩位超級管理員同時互相降級：兩邊的先行檢查都在對方寫入前通過
+	var checked, done sync.WaitGroup
+	checked.Add(2)
+	errs := make([]error, 2)
+	for i, pair := range [][2]*models.User{{alice, bob}, {bob, alice}} {
+		done.Add(1)
+		go func(i int, actor, target models.User) {
+			defer done.Done()
+			err := policy.CheckLevelChange(&actor, &target, "admin")
+			checked.Done()
+			checked.Wait()
+			if err == nil {
+				target.Level = "admin"
+				err = policy.Update(&target, "level")
+			}
+			errs[i] = err
+		}(i, *pair[0], *pair[1])
+	}
+	done.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, ErrLastSuperAdmin):
+			failed++
+		case err != nil:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("%d of 2 demotions were refused, want exactly 1", failed)
+	}
+	if count, _ := userRepo.CountActiveByLevel("super_admin", time.Now()); count != 1 {
+		t.Fatalf("%d active super admins remain, want 1", count)
+	}
+}
+
+func TestUpdateRefusesDemotingLastActiveSuperAdmin(t *testing.T) {
+	userRepo := &fakeUserRepo{}
+	userRepo.Create(&models.User{Username: "alice", Level: "super_admin"})
+	userRepo.Create(&models.User{Username: "bob", Level: "super_admin", Status: models.UserStatusSuspended})
+	alice, _ := userRepo.GetByUsername("alice")
+	policy := NewUserPolicyService(userRepo)
+
+	// 被停權的超級管理員不算可登入，alice 是最後一位
+	alice.Level = "admin"
+	if err := policy.Update(alice, "level"); !errors.Is(err, ErrLastSuperAdmin) {
+		t.Fatalf("demoting the last active super admin: err = %v, want ErrLastSuperAdmin", err)
+	}
+}
+
+func TestImpersonatedUpdateCannotChangeLevel(t *testing.T) {
+	policy := NewUserPolicyService(&fakeUserRepo{})
+	username, level := "alice2", "admin"
+
+	if err := policy.CheckImpersonatedUpdate(&models.UpdateUserInput{Username: &username}); err != nil {
+		t.Fatalf("username change: err = %v, want nil", err)
+	}
+	if err := policy.CheckImpersonatedUpdate(&models.UpdateUserInput{Level: &level}); !errors.Is(err, ErrImpersonatedUpdate) {
+		t.Fatalf("level change: err = %v, want ErrImpersonatedUpdate", err)
+	}
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"bytes"
+	"erp/db"
+	"erp/models"
+	"erp/storage"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaxAvatarSize 頭像檔案大小上限
+const MaxAvatarSize = 2 << 20
+
+// maxManagerDepth 檢查主管循環時最多往上追溯的層數
+const maxManagerDepth = 100
+
+// 個人資料錯誤
+var (
+	ErrEmployeeFieldsForbidden = errors.New("只有管理員可以修改職稱、主管與員工編號")
+	ErrInvalidPhone            = errors.New("無效的電話號碼")
+	ErrInvalidLocale           = errors.New("無效的語言代碼，例如 zh-TW 或 en")
+	ErrInvalidTimezone         = errors.New("無效的時區，例如 Asia/Taipei")
+	ErrInvalidManager          = errors.New("無效的主管：找不到使用者、指定自己或形成循環的主管關係")
+	ErrEmployeeNumberTaken     = errors.New("員工編號已被使用")
+	ErrUnsupportedAvatar       = errors.New("頭像必須是 PNG、JPEG、GIF 或 WebP 圖片")
+	ErrAvatarTooLarge          = errors.New("頭像檔案不可超過 2MB")
+	ErrAvatarNotFound          = errors.New("使用者未設定頭像")
+)
+
+// avatarExtensions 接受的頭像格式 (依檔案內容判斷) 與儲存時使用的副檔名
+var avatarExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// UserProfileService 使用者個人資料與頭像管理
+// 頭像透過可替換的檔案儲存保存，每次上傳使用新的鍵值，成功後才刪除舊檔
+type UserProfileService struct {
+	userRepo    db.UserRepository
+	profileRepo db.UserProfileRepository
+	storage     storage.Storage
+}
+
+// NewUserProfileService 建立使用者個人資料服務實例
+func NewUserProfileService(userRepo db.UserRepository, profileRepo db.UserProfileRepository, store storage.Storage) *UserProfileService {
+	return &UserProfileService{
+		userRepo:    userRepo,
+		profileRepo: profileRepo,
+		storage:     store,
+	}
+}
+
+// Get 取得使用者的個人資料，尚未建立時回傳空白的個人資料
+func (s *UserProfileService) Get(userID uint) (*models.UserProfile, error) {
+	profile, err := s.profileRepo.GetByUserID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.UserProfile{UserID: userID}, nil
+	}
+	return profile, err
+}
+
+// GetMany 批次取得多位使用者的個人資料，尚未建立的使用者不會出現在結果中
+func (s *UserProfileService) GetMany(userIDs []uint) (map[uint]*models.UserProfile, error) {
+	return s.profileRepo.GetByUserIDs(userIDs)
+}
+
+// Update 更新個人資料；canEditEmployee 為 false 時不可修改職稱、主管與員工編號
+// 語言與時區寫入使用者的偏好設定
+func (s *UserProfileService) Update(user *models.User, input models.UpdateUserProfileInput, canEditEmployee bool) (*models.UserProfile, error) {
+	if input.HasEmployeeFields() && !canEditEmployee {
+		return nil, ErrEmployeeFieldsForbidden
+	}
+	if input.Phone != nil && !models.ValidPhone(strings.TrimSpace(*input.Phone)) {
+		return nil, ErrInvalidPhone
+	}
+	if input.Locale != nil && !models.ValidLanguageTag(*input.Locale) {
+		return nil, ErrInvalidLocale
+	}
+	if input.Timezone != nil && !models.ValidTimezone(*input.Timezone) {
+		return nil, ErrInvalidTimezone
+	}
+
+	profile, err := s.Get(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.ManagerID != nil {
+		profile.ManagerID = nil
+		if *input.ManagerID != 0 {
+			if err := s.checkManager(user.ID, *input.ManagerID); err != nil {
+				return nil, err
+			}
+			profile.ManagerID = input.ManagerID
+		}
+	}
+	if input.EmployeeNumber != nil {
+		profile.EmployeeNumber = nil
+		if number := strings.TrimSpace(*input.EmployeeNumber); number != "" {
+			existing, err := s.profileRepo.GetByEmployeeNumber(number)
+			switch {
+			case err == nil && existing.UserID != user.ID:
+				return nil, ErrEmployeeNumberTaken
+			case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+				return nil, err
+			}
+			profile.EmployeeNumber = &number
+		}
+	}
+	if input.DisplayNameZh != nil {
+		profile.DisplayNameZh = strings.TrimSpace(*input.DisplayNameZh)
+	}
+	if input.DisplayNameEn != nil {
+		profile.DisplayNameEn = strings.TrimSpace(*input.DisplayNameEn)
+	}
+	if input.Phone != nil {
+		profile.Phone = strings.TrimSpace(*input.Phone)
+	}
+	if input.JobTitle != nil {
+		profile.JobTitle = strings.TrimSpace(*input.JobTitle)
+	}
+
+	if err := s.profileRepo.Save(profile); err != nil {
+		return nil, err
+	}
+
+	if input.Locale != nil || input.Timezone != nil {
+		if input.Locale != nil {
+			user.Language = *input.Locale
+		}
+		if input.Timezone != nil {
+			user.Timezone = *input.Timezone
+		}
+		if err := s.userRepo.Update(user, "language", "timezone"); err != nil {
+			return nil, err
+		}
+	}
+	return profile, nil
+}
+
+// SetAvatar 上傳頭像，依檔案內容判斷格式
+func (s *UserProfileService) SetAvatar(userID uint, file io.Reader) (*models.UserProfile, error) {
+	data, err := io.ReadAll(io.LimitReader(file, MaxAvatarSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxAvatarSize {
+		return nil, ErrAvatarTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	ext, ok := avatarExtensions[contentType]
+	if !ok {
+		return nil, ErrUnsupportedAvatar
+	}
+
+	profile, err := s.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("avatars/%d/%s%s", userID, name, ext)
+	if err := s.storage.Put(key, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	previousKey := profile.AvatarKey
+	now := time.Now()
+	profile.AvatarKey = key
+	profile.AvatarContentType = contentType
+	profile.AvatarUpdatedAt = &now
+	if err := s.profileRepo.Save(profile); err != nil {
+		s.removeFile(key)
+		return nil, err
+	}
+
+	if previousKey != "" {
+		s.removeFile(previousKey)
+	}
+	return profile, nil
+}
+
+// DeleteAvatar 移除頭像，未設定頭像時不做任何事
+func (s *UserProfileService) DeleteAvatar(userID uint) (*models.UserProfile, error) {
+	profile, err := s.Get(userID)
+	if err != nil || profile.AvatarKey == "" {
+		return profile, err
+	}
+
+	previousKey := profile.AvatarKey
+	profile.AvatarKey = ""
+	profile.AvatarContentType = ""
+	profile.AvatarUpdatedAt = nil
+	if err := s.profileRepo.Save(profile); err != nil {
+		return nil, err
+	}
+	s.removeFile(previousKey)
+	return profile, nil
+}
+
+// OpenAvatar 開啟使用者的頭像檔案，回傳內容與格式
+func (s *UserProfileService) OpenAvatar(userID uint) (io.ReadCloser, string, error) {
+	profile, err := s.Get(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if profile.AvatarKey == "" {
+		return nil, "", ErrAvatarNotFound
+	}
+
+	file, err := s.storage.Open(profile.AvatarKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, "", ErrAvatarNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return file, profile.AvatarContentType, nil
+}
+
+// checkManager 檢查主管存在，且不是使用者自己或其下屬 (避免形成循環)
+func (s *UserProfileService) checkManager(userID, managerID uint) error {
+	if managerID == userID {
+		return ErrInvalidManager
+	}
+	if _, err := s.userRepo.GetByID(managerID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidManager
+		}
+		return err
+	}
+
+	current := managerID
+	for i := 0; i < maxManagerDepth; i++ {
+		next, err := s.profileRepo.GetManagerID(current)
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			return nil
+		}
+		if *next == userID {
+			return ErrInvalidManager
+		}
+		current = *next
+	}
+	return ErrInvalidManager
+}
+
+// removeFile 刪除不再使用的檔案，失敗時只記錄錯誤 (檔案成為孤兒不影響功能)
+func (s *UserProfileService) removeFile(key string) {
+	if err := s.storage.Delete(key); err != nil {
+		fmt.Fprintf(os.Stderr, "無法刪除檔案 %s: %v\n", key, err)
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"erp/db"
+	"erp/models"
+	"errors"
+	"slices"
+	"time"
+)
+
+// ErrInvalidStatusTransition 目前的帳號狀態無法變更為指定狀態
+var ErrInvalidStatusTransition = errors.New("目前的帳號狀態無法執行此操作")
+
+// ErrInvalidSuspensionEnd 停權到期時間必須晚於現在
+var ErrInvalidSuspensionEnd = errors.New("停權到期時間必須晚於現在")
+
+// userStatusTransitions 各目標狀態允許的目前狀態 (以實際狀態判斷，停權已到期視為 active)
+// 重新停權可更新原因與到期時間；封存的帳號需先恢復才能停權或鎖定
+var userStatusTransitions = map[string][]string{
+	models.UserStatusSuspended: {models.UserStatusActive, models.UserStatusSuspended},
+	models.UserStatusLocked:    {models.UserStatusActive, models.UserStatusSuspended},
+	models.UserStatusArchived:  {models.UserStatusActive, models.UserStatusSuspended, models.UserStatusLocked},
+	models.UserStatusActive:    {models.UserStatusSuspended, models.UserStatusLocked, models.UserStatusArchived},
+}
+
+// UserStatusService 使用者帳號狀態管理：停權、鎖定、封存、恢復與還原已刪除的使用者
+// 停用帳號時撤銷所有令牌與工作階段，登入與 AuthMiddleware 另依帳號狀態拒絕存取
+type UserStatusService struct {
+	userRepo     db.UserRepository
+	policy       *UserPolicyService
+	tokenService *TokenService
+}
+
+// NewUserStatusService 建立使用者帳號狀態服務實例
+func NewUserStatusService(userRepo db.UserRepository, policy *UserPolicyService, tokenService *TokenService) *UserStatusService {
+	return &UserStatusService{
+		userRepo:     userRepo,
+		policy:       policy,
+		tokenService: tokenService,
+	}
+}
+
+// Suspend 停權使用者，until 為空值代表直到手動恢復
+func (s *UserStatusService) Suspend(actor, target *models.User, reason string, until *time.Time) error {
+	if until != nil && !until.After(time.Now()) {
+		return ErrInvalidSuspensionEnd
+	}
+	return s.change(actor, target, models.UserStatusSuspended, reason, until)
+}
+
+// Lock 因安全疑慮鎖定使用者，需管理員恢復
+func (s *UserStatusService) Lock(actor, target *models.User, reason string) error {
+	return s.change(actor, target, models.UserStatusLocked, reason, nil)
+}
+
+// Archive 封存使用者 (例如離職)，保留資料但不可登入
+func (s *UserStatusService) Archive(actor, target *models.User, reason string) error {
+	return s.change(actor, target, models.UserStatusArchived, reason, nil)
+}
+
+// Reactivate 恢復停權、鎖定或封存的使用者
+func (s *UserStatusService) Reactivate(actor, target *models.User, reason string) error {
+	return s.change(actor, target, models.UserStatusActive, reason, nil)
+}
+
+// Restore 還原已刪除的使用者，帳號狀態維持刪除前的狀態
+func (s *UserStatusService) Restore(actor, target *models.User) error {
+	if err := s.policy.CanManage(actor, target); err != nil {
+		return err
+	}
+	if err := s.userRepo.Restore(target.ID); err != nil {
+		return err
+	}
+	s.tokenService.RefreshUserState(target.ID)
+	return nil
+}
+
+// change 檢查權限與狀態轉換後更新帳號狀態；停用時撤銷使用者所有令牌
+func (s *UserStatusService) change(actor, target *models.User, status, reason string, until *time.Time) error {
+	if err := s.policy.CheckStatusChange(actor, target, status); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if !slices.Contains(userStatusTransitions[status], target.EffectiveStatus(now)) {
+		return ErrInvalidStatusTransition
+	}
+
+	target.Status = status
+	target.StatusReason = reason
+	target.StatusChangedAt = &now
+	target.StatusChangedBy = &actor.ID
+	target.SuspendedUntil = until
+	columns := []string{"status", "status_reason", "status_changed_at", "status_changed_by", "suspended_until"}
+	update := s.userRepo.Update
+	if status != models.UserStatusActive {
+		update = s.policy.Update
+	}
+	if err := update(target, columns...); err != nil {
+		return err
+	}
+
+	if status == models.UserStatusActive {
+		s.tokenService.RefreshUserState(target.ID)
+		return nil
+	}
+	return s.tokenService.InvalidateUserTokens(target.ID)
+}
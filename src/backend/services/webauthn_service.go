@@ -0,0 +1,531 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"erp/db"
+	"erp/models"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrPasskeyUnavailable 通行金鑰設定錯誤 (WEBAUTHN_RP_*)，無法進行 WebAuthn 儀式
+var ErrPasskeyUnavailable = errors.New("通行金鑰功能尚未正確設定")
+
+// ErrInvalidPasskeyCeremony 儀式令牌無效、已使用或已過期
+var ErrInvalidPasskeyCeremony = errors.New("通行金鑰驗證已逾時，請重新開始")
+
+// ErrInvalidPasskey 通行金鑰驗證失敗 (簽章、挑戰值或來源不符，或金鑰不屬於該使用者)
+var ErrInvalidPasskey = errors.New("通行金鑰驗證失敗")
+
+// ErrPasskeyCloneDetected 簽章計數器倒退，疑似複製的驗證器，該金鑰已停止接受
+var ErrPasskeyCloneDetected = errors.New("偵測到疑似複製的通行金鑰，已停用此金鑰")
+
+// ErrPasskeyAlreadyRegistered 通行金鑰已綁定過
+var ErrPasskeyAlreadyRegistered = errors.New("此通行金鑰已綁定")
+
+// ErrPasskeyNotFound 找不到使用者的通行金鑰
+var ErrPasskeyNotFound = errors.New("找不到通行金鑰")
+
+// ErrPasskeyRequired 使用者等級強制要求通行金鑰，不可刪除最後一把
+var ErrPasskeyRequired = errors.New("此等級的使用者必須保留至少一把通行金鑰")
+
+// WebAuthnService 通行金鑰 (WebAuthn) 綁定與登入服務
+type WebAuthnService struct {
+	userRepo       db.UserRepository
+	credentialRepo db.WebAuthnCredentialRepository
+	ceremonyRepo   db.WebAuthnCeremonyRepository
+	webauthn       *webauthn.WebAuthn // 設定錯誤時為 nil
+	ceremonyTTL    time.Duration
+	requiredLevels map[string]bool
+}
+
+// NewWebAuthnService 建立通行金鑰服務實例
+// WEBAUTHN_RP_ID 為網站網域；WEBAUTHN_RP_ORIGINS 以逗號分隔前端來源；WEBAUTHN_REQUIRED_LEVELS 以逗號分隔強制以通行金鑰作為第二步驟的等級
+func NewWebAuthnService(userRepo db.UserRepository, credentialRepo db.WebAuthnCredentialRepository, ceremonyRepo db.WebAuthnCeremonyRepository) *WebAuthnService {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = "JasonTech ERP"
+	}
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{"http://localhost:3000"}
+	}
+
+	requiredLevels := make(map[string]bool)
+	for _, level := range strings.Split(os.Getenv("WEBAUTHN_REQUIRED_LEVELS"), ",") {
+		if level = strings.TrimSpace(level); level != "" {
+			requiredLevels[level] = true
+		}
+	}
+
+	ceremonyTTL := durationFromEnv("WEBAUTHN_CEREMONY_TTL", 5*time.Minute)
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyTTL},
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "通行金鑰設定錯誤: %v\n", err)
+		w = nil
+	}
+
+	return &WebAuthnService{
+		userRepo:       userRepo,
+		credentialRepo: credentialRepo,
+		ceremonyRepo:   ceremonyRepo,
+		webauthn:       w,
+		ceremonyTTL:    ceremonyTTL,
+		requiredLevels: requiredLevels,
+	}
+}
+
+// RequiredForLevel 檢查該等級是否強制以通行金鑰作為登入第二步驟
+func (s *WebAuthnService) RequiredForLevel(level string) bool {
+	return s.requiredLevels[level]
+}
+
+// TTL 回傳儀式令牌的有效時間
+func (s *WebAuthnService) TTL() time.Duration {
+	return s.ceremonyTTL
+}
+
+// HasPasskeys 檢查使用者是否已綁定通行金鑰
+func (s *WebAuthnService) HasPasskeys(userID uint) (bool, error) {
+	count, err := s.credentialRepo.CountByUserID(userID)
+	return count > 0, err
+}
+
+// List 列出使用者的通行金鑰
+func (s *WebAuthnService) List(userID uint) ([]models.WebAuthnCredential, error) {
+	return s.credentialRepo.GetByUserID(userID)
+}
+
+// Rename 修改通行金鑰的顯示名稱
+func (s *WebAuthnService) Rename(userID, id uint, name string) error {
+	err := s.credentialRepo.Rename(userID, id, strings.TrimSpace(name))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrPasskeyNotFound
+	}
+	return err
+}
+
+// Delete 刪除使用者的通行金鑰；等級強制要求通行金鑰時不可刪除最後一把
+func (s *WebAuthnService) Delete(user *models.User, id uint) error {
+	if s.RequiredForLevel(user.Level) {
+		count, err := s.credentialRepo.CountByUserID(user.ID)
+		if err != nil {
+			return err
+		}
+		if count <= 1 {
+			return ErrPasskeyRequired
+		}
+	}
+
+	err := s.credentialRepo.Delete(user.ID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrPasskeyNotFound
+	}
+	return err
+}
+
+// DeleteAll 刪除使用者所有通行金鑰 (管理員重設)
+func (s *WebAuthnService) DeleteAll(userID uint) error {
+	return s.credentialRepo.DeleteByUserID(userID)
+}
+
+// BeginRegistration 開始綁定通行金鑰，回傳瀏覽器 navigator.credentials.create() 的參數與儀式令牌
+func (s *WebAuthnService) BeginRegistration(user *models.User) (*protocol.CredentialCreation, string, error) {
+	if s.webauthn == nil {
+		return nil, "", ErrPasskeyUnavailable
+	}
+
+	account, err := s.loadUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 要求可探索憑證 (resident key) 才能免輸入帳號登入；已綁定的金鑰不可重複綁定
+	creation, session, err := s.webauthn.BeginRegistration(account,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(webauthn.Credentials(account.credentials).CredentialDescriptors()),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.saveCeremony(&user.ID, models.WebAuthnCeremonyRegistration, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, token, nil
+}
+
+// FinishRegistration 驗證瀏覽器回傳的憑證並儲存通行金鑰
+func (s *WebAuthnService) FinishRegistration(user *models.User, ceremonyToken, name string, response []byte) (*models.WebAuthnCredential, error) {
+	if s.webauthn == nil {
+		return nil, ErrPasskeyUnavailable
+	}
+
+	session, err := s.consumeCeremony(ceremonyToken, models.WebAuthnCeremonyRegistration, &user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	account, err := s.loadUser(user)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := s.webauthn.CreateCredential(account, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(credential.ID)
+	_, err = s.credentialRepo.GetByCredentialID(credentialID)
+	if err == nil {
+		return nil, ErrPasskeyAlreadyRegistered
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if name = strings.TrimSpace(name); name == "" {
+		name = fmt.Sprintf("通行金鑰 %d", len(account.stored)+1)
+	}
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	stored := &models.WebAuthnCredential{
+		UserID:          user.ID,
+		CredentialID:    credentialID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          formatAAGUID(credential.Authenticator.AAGUID),
+		Transports:      strings.Join(transports, ","),
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Name:            name,
+	}
+	if err := s.credentialRepo.Create(stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// BeginLogin 開始免密碼登入，不指定帳號，由驗證器提供可探索憑證
+func (s *WebAuthnService) BeginLogin() (*protocol.CredentialAssertion, string, error) {
+	if s.webauthn == nil {
+		return nil, "", ErrPasskeyUnavailable
+	}
+
+	// 免密碼登入必須在驗證器上驗證使用者本人 (生物辨識或 PIN)
+	assertion, session, err := s.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.saveCeremony(nil, models.WebAuthnCeremonyLogin, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, token, nil
+}
+
+// FinishLogin 驗證免密碼登入的簽章，回傳對應的使用者
+// 驗證失敗但已由 user handle 識別出使用者時仍回傳該使用者，供記錄登入事件
+func (s *WebAuthnService) FinishLogin(ceremonyToken string, response []byte) (*models.User, error) {
+	if s.webauthn == nil {
+		return nil, ErrPasskeyUnavailable
+	}
+
+	session, err := s.consumeCeremony(ceremonyToken, models.WebAuthnCeremonyLogin, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	// 依驗證器回傳的 user handle 找出使用者
+	var account *webAuthnUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != 8 {
+			return nil, ErrInvalidPasskey
+		}
+		user, err := s.userRepo.GetByID(uint(binary.BigEndian.Uint64(userHandle)))
+		if err != nil {
+			return nil, ErrInvalidPasskey
+		}
+		account, err = s.loadUser(user)
+		if err != nil {
+			return nil, err
+		}
+		return account, nil
+	}
+
+	_, credential, err := s.webauthn.ValidatePasskeyLogin(handler, *session, parsed)
+	if account == nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	if err != nil {
+		return account.user, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	return account.user, s.recordUse(account, credential)
+}
+
+// BeginMFA 開始以通行金鑰完成登入第二步驟 (已通過密碼或單一登入)
+func (s *WebAuthnService) BeginMFA(user *models.User) (*protocol.CredentialAssertion, string, error) {
+	if s.webauthn == nil {
+		return nil, "", ErrPasskeyUnavailable
+	}
+
+	account, err := s.loadUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(account.credentials) == 0 {
+		return nil, "", ErrPasskeyNotFound
+	}
+
+	assertion, session, err := s.webauthn.BeginLogin(account)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.saveCeremony(&user.ID, models.WebAuthnCeremonyMFA, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, token, nil
+}
+
+// FinishMFA 驗證登入第二步驟的通行金鑰簽章
+func (s *WebAuthnService) FinishMFA(user *models.User, ceremonyToken string, response []byte) error {
+	if s.webauthn == nil {
+		return ErrPasskeyUnavailable
+	}
+
+	session, err := s.consumeCeremony(ceremonyToken, models.WebAuthnCeremonyMFA, &user.ID)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	account, err := s.loadUser(user)
+	if err != nil {
+		return err
+	}
+	credential, err := s.webauthn.ValidateLogin(account, *session, parsed)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	return s.recordUse(account, credential)
+}
+
+// recordUse 更新簽章計數器與最後使用時間；計數器倒退時標記金鑰疑似遭複製並拒絕
+func (s *WebAuthnService) recordUse(account *webAuthnUser, credential *webauthn.Credential) error {
+	stored := account.find(credential.ID)
+	if stored == nil {
+		return ErrInvalidPasskey
+	}
+
+	if credential.Authenticator.CloneWarning {
+		if err := s.credentialRepo.MarkCloned(stored.ID); err != nil {
+			return err
+		}
+		return ErrPasskeyCloneDetected
+	}
+
+	updated, err := s.credentialRepo.RecordUse(stored.ID, stored.SignCount, credential.Authenticator.SignCount, credential.Flags.BackupState, time.Now())
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrInvalidPasskey
+	}
+	return nil
+}
+
+// saveCeremony 儲存儀式狀態，回傳交給前端的一次性儀式令牌
+func (s *WebAuthnService) saveCeremony(userID *uint, ceremony string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if err := s.ceremonyRepo.DeleteExpired(now); err != nil {
+		return "", err
+	}
+	err = s.ceremonyRepo.Create(&models.WebAuthnCeremony{
+		TokenHash: hashToken(token),
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Data:      string(data),
+		ExpiresAt: now.Add(s.ceremonyTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeCeremony 取出儀式狀態並確認類型、使用者與有效期限
+func (s *WebAuthnService) consumeCeremony(token, ceremony string, userID *uint) (*webauthn.SessionData, error) {
+	stored, err := s.ceremonyRepo.Consume(hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.Ceremony != ceremony || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+	if (userID == nil) != (stored.UserID == nil) || (userID != nil && *userID != *stored.UserID) {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(stored.Data), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// loadUser 載入使用者與其通行金鑰，轉換為 WebAuthn 函式庫使用的型別
+func (s *WebAuthnService) loadUser(user *models.User) (*webAuthnUser, error) {
+	stored, err := s.credentialRepo.GetByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &webAuthnUser{user: user, stored: stored}
+	for _, credential := range stored {
+		// 疑似遭複製的金鑰不再接受
+		if credential.CloneWarning {
+			continue
+		}
+		id, err := base64.RawURLEncoding.DecodeString(credential.CredentialID)
+		if err != nil {
+			return nil, err
+		}
+		var transports []protocol.AuthenticatorTransport
+		if credential.Transports != "" {
+			for _, transport := range strings.Split(credential.Transports, ",") {
+				transports = append(transports, protocol.AuthenticatorTransport(transport))
+			}
+		}
+		var aaguid []byte
+		if parsed, err := uuid.Parse(credential.AAGUID); err == nil {
+			aaguid = parsed[:]
+		}
+
+		account.credentials = append(account.credentials, webauthn.Credential{
+			ID:              id,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: credential.BackupEligible,
+				BackupState:    credential.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    aaguid,
+				SignCount: credential.SignCount,
+			},
+		})
+	}
+	return account, nil
+}
+
+// webAuthnUser 實作 webauthn.User，user handle 為 8 位元組的使用者 ID
+type webAuthnUser struct {
+	user        *models.User
+	stored      []models.WebAuthnCredential
+	credentials []webauthn.Credential
+}
+
+// WebAuthnID 回傳 user handle
+func (u *webAuthnUser) WebAuthnID() []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(u.user.ID))
+	return handle
+}
+
+// WebAuthnName 回傳帳號名稱
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+// WebAuthnDisplayName 回傳顯示名稱
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Username
+}
+
+// WebAuthnCredentials 回傳可用的通行金鑰
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// find 依憑證 ID 找出儲存的通行金鑰
+func (u *webAuthnUser) find(credentialID []byte) *models.WebAuthnCredential {
+	encoded := base64.RawURLEncoding.EncodeToString(credentialID)
+	for i := range u.stored {
+		if u.stored[i].CredentialID == encoded {
+			return &u.stored[i]
+		}
+	}
+	return nil
+}
+
+// formatAAGUID 將驗證器 AAGUID 格式化為 UUID 字串，全為零 (未提供) 時回傳空字串
+func formatAAGUID(aaguid []byte) string {
+	parsed, err := uuid.FromBytes(aaguid)
+	if err != nil || bytes.Equal(aaguid, make([]byte, len(aaguid))) {
+		return ""
+	}
+	return parsed.String()
+}
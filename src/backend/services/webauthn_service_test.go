@@ -0,0 +1,315 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"erp/models"
+	"errors"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	testWebAuthnRPID   = "erp.example.com"
+	testWebAuthnOrigin = "https://erp.example.com"
+)
+
+// authenticator data 的旗標
+const (
+	authenticatorFlagUserPresent  = 0x01
+	authenticatorFlagUserVerified = 0x04
+	authenticatorFlagAttested     = 0x40
+)
+
+// softAuthenticator 軟體驗證器：以 P-256 金鑰產生 none 格式的證明與 ES256 斷言簽章
+type softAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	origin       string
+	flags        byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+	return &softAuthenticator{
+		t:            t,
+		key:          key,
+		credentialID: credentialID,
+		origin:       testWebAuthnOrigin,
+		flags:        authenticatorFlagUserPresent | authenticatorFlagUserVerified,
+	}
+}
+
+// create 回應 navigator.credentials.create()，回傳瀏覽器送出的 JSON
+func (a *softAuthenticator) create(creation *protocol.CredentialCreation) []byte {
+	a.t.Helper()
+	options := creation.Response
+	a.userHandle = options.User.ID.(protocol.URLEncodedBase64)
+
+	ecdh, err := a.key.PublicKey.ECDH()
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	point := ecdh.Bytes()
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: point[1:33],
+		YCoord: point[33:],
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	// attested credential data：AAGUID (未提供) + 憑證 ID 長度 + 憑證 ID + COSE 公開金鑰
+	attested := make([]byte, 16, 16+2+len(a.credentialID)+len(publicKey))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+	authData := append(a.authenticatorData(options.RelyingParty.ID, a.flags|authenticatorFlagAttested), attested...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.credentialJSON(map[string]string{
+		"clientDataJSON":    encodeSegment(a.clientData("webauthn.create", options.Challenge)),
+		"attestationObject": encodeSegment(attestation),
+	})
+}
+
+// get 回應 navigator.credentials.get()，簽章前先遞增計數器
+func (a *softAuthenticator) get(assertion *protocol.CredentialAssertion) []byte {
+	a.t.Helper()
+	options := assertion.Response
+	a.signCount++
+
+	authData := a.authenticatorData(options.RelyingPartyID, a.flags)
+	clientData := a.clientData("webauthn.get", options.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.credentialJSON(map[string]string{
+		"clientDataJSON":    encodeSegment(clientData),
+		"authenticatorData": encodeSegment(authData),
+		"signature":         encodeSegment(signature),
+		"userHandle":        encodeSegment(a.userHandle),
+	})
+}
+
+// authenticatorData RP ID 雜湊 + 旗標 + 簽章計數器
+func (a *softAuthenticator) authenticatorData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   encodeSegment(challenge),
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) credentialJSON(response map[string]string) []byte {
+	data, err := json.Marshal(map[string]interface{}{
+		"id":                     encodeSegment(a.credentialID),
+		"rawId":                  encodeSegment(a.credentialID),
+		"type":                   "public-key",
+		"response":               response,
+		"clientExtensionResults": map[string]interface{}{},
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return data
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// newTestWebAuthnService 建立通行金鑰服務與一位使用者
+func newTestWebAuthnService(t *testing.T) (*WebAuthnService, *fakeCredentialRepo, *models.User) {
+	t.Helper()
+	t.Setenv("WEBAUTHN_RP_ID", testWebAuthnRPID)
+	t.Setenv("WEBAUTHN_RP_ORIGINS", testWebAuthnOrigin)
+	t.Setenv("WEBAUTHN_REQUIRED_LEVELS", "")
+
+	userRepo := &fakeUserRepo{}
+	userRepo.Create(&models.User{Username: "alice", Email: "alice@example.com", Level: "user"})
+	user, _ := userRepo.GetByUsername("alice")
+	credentialRepo := &fakeCredentialRepo{}
+	return NewWebAuthnService(userRepo, credentialRepo, &fakeCeremonyRepo{}), credentialRepo, user
+}
+
+// registerPasskey 完成一次綁定儀式
+func registerPasskey(t *testing.T, service *WebAuthnService, user *models.User, authenticator *softAuthenticator) (*models.WebAuthnCredential, error) {
+	t.Helper()
+	creation, token, err := service.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	return service.FinishRegistration(user, token, "", authenticator.create(creation))
+}
+
+// loginWithPasskey 完成一次免密碼登入儀式
+func loginWithPasskey(t *testing.T, service *WebAuthnService, authenticator *softAuthenticator) (*models.User, error) {
+	t.Helper()
+	assertion, token, err := service.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	return service.FinishLogin(token, authenticator.get(assertion))
+}
+
+func TestWebAuthnRegistrationVerifiesAttestation(t *testing.T) {
+	service, credentialRepo, user := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+	authenticator.signCount = 1
+
+	stored, err := registerPasskey(t, service, user, authenticator)
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	if stored.CredentialID != encodeSegment(authenticator.credentialID) || stored.SignCount != 1 || stored.AttestationType != "none" {
+		t.Fatalf("stored credential = %+v", stored)
+	}
+	if stored.Name != "通行金鑰 1" || len(credentialRepo.credentials) != 1 {
+		t.Fatalf("stored credential = %+v", stored)
+	}
+
+	// 同一把金鑰不可重複綁定
+	if _, err := registerPasskey(t, service, user, authenticator); !errors.Is(err, ErrPasskeyAlreadyRegistered) {
+		t.Fatalf("duplicate registration: err = %v, want ErrPasskeyAlreadyRegistered", err)
+	}
+}
+
+func TestWebAuthnRegistrationRejectsForeignCeremony(t *testing.T) {
+	service, _, user := newTestWebAuthnService(t)
+
+	// 其他網站來源產生的憑證
+	phished := newSoftAuthenticator(t)
+	phished.origin = "https://erp.example.com.evil.test"
+	if _, err := registerPasskey(t, service, user, phished); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("foreign origin: err = %v, want ErrInvalidPasskey", err)
+	}
+
+	// 回應其他儀式的挑戰值
+	authenticator := newSoftAuthenticator(t)
+	creation, token, err := service.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := service.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishRegistration(user, token, "", authenticator.create(other)); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("mismatched challenge: err = %v, want ErrInvalidPasskey", err)
+	}
+
+	// 儀式令牌只能使用一次
+	if _, err := service.FinishRegistration(user, token, "", authenticator.create(creation)); !errors.Is(err, ErrInvalidPasskeyCeremony) {
+		t.Fatalf("reused ceremony: err = %v, want ErrInvalidPasskeyCeremony", err)
+	}
+}
+
+func TestWebAuthnLoginVerifiesAssertion(t *testing.T) {
+	service, _, user := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+	if _, err := registerPasskey(t, service, user, authenticator); err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+
+	loggedIn, err := loginWithPasskey(t, service, authenticator)
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if loggedIn.ID != user.ID {
+		t.Fatalf("user = %d, want %d", loggedIn.ID, user.ID)
+	}
+
+	// 同一個憑證 ID 但以其他私鑰簽章
+	forged := newSoftAuthenticator(t)
+	forged.credentialID = authenticator.credentialID
+	forged.userHandle = authenticator.userHandle
+	forged.signCount = 100
+	if loggedIn, err := loginWithPasskey(t, service, forged); !errors.Is(err, ErrInvalidPasskey) || loggedIn == nil {
+		t.Fatalf("forged signature: user = %v, err = %v, want the identified user and ErrInvalidPasskey", loggedIn, err)
+	}
+
+	// 免密碼登入必須驗證使用者本人
+	authenticator.flags = authenticatorFlagUserPresent
+	if _, err := loginWithPasskey(t, service, authenticator); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("without user verification: err = %v, want ErrInvalidPasskey", err)
+	}
+}
+
+func TestWebAuthnSignCountDetectsClonedAuthenticator(t *testing.T) {
+	service, credentialRepo, user := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+	authenticator.signCount = 1
+	if _, err := registerPasskey(t, service, user, authenticator); err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+
+	// 計數器遞增時接受並記錄
+	authenticator.signCount = 4
+	if _, err := loginWithPasskey(t, service, authenticator); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if got := credentialRepo.credentials[0].SignCount; got != 5 {
+		t.Fatalf("stored sign count = %d, want 5", got)
+	}
+
+	// 複製的驗證器送出未遞增的計數器
+	clone := *authenticator
+	clone.signCount = 4
+	if _, err := loginWithPasskey(t, service, &clone); !errors.Is(err, ErrPasskeyCloneDetected) {
+		t.Fatalf("cloned authenticator: err = %v, want ErrPasskeyCloneDetected", err)
+	}
+	if !credentialRepo.credentials[0].CloneWarning {
+		t.Fatal("credential was not marked as cloned")
+	}
+
+	// 標記後原本的驗證器也不再被接受
+	if _, err := loginWithPasskey(t, service, authenticator); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("after clone warning: err = %v, want ErrInvalidPasskey", err)
+	}
+}
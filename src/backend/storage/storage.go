@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNotFound 找不到指定的檔案
+var ErrNotFound = errors.New("找不到檔案")
+
+// ErrInvalidKey 檔案鍵值格式錯誤 (空值、絕對路徑或包含 ..)
+var ErrInvalidKey = errors.New("無效的檔案鍵值")
+
+// Storage 檔案儲存介面，可依環境替換不同實作 (本機磁碟、物件儲存等)
+// key 為以 / 分隔的相對路徑，例如 avatars/1/abc.png
+type Storage interface {
+	Put(key string, r io.Reader) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// NewFromEnv 依環境變數 STORAGE_DRIVER 建立檔案儲存
+// local (預設): 儲存在 STORAGE_LOCAL_DIR 目錄 (預設 ./uploads)
+func NewFromEnv() (Storage, error) {
+	switch driver := os.Getenv("STORAGE_DRIVER"); driver {
+	case "", "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "./uploads"
+		}
+		return NewLocalStorage(dir)
+	default:
+		return nil, fmt.Errorf("不支援的檔案儲存方式: %s", driver)
+	}
+}